@@ -0,0 +1,175 @@
+package mgr
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/reader"
+)
+
+const (
+	defaultAckMaxPending = 1000
+	ackWaitInterval      = 100 * time.Millisecond
+)
+
+// AckConfig 开启后 reader 的读取位置只在之前读取的数据全部被 sender 确认送达后才保存
+type AckConfig struct {
+	Enable bool `json:"enable"`
+	// MaxPending 等待确认的 batch 数达到该值时暂停读取，默认为 1000
+	MaxPending int `json:"max_pending,omitempty"`
+}
+
+var (
+	errAckReader      = errors.New("ack is not supported by reader")
+	errAckIngestQueue = errors.New("ack is not supported when ingest queue is enabled")
+	errAckSendRaw     = errors.New("ack is not supported when send_raw is enabled")
+)
+
+func enableAck(r reader.Reader) error {
+	ar, ok := r.(reader.AckReader)
+	if !ok {
+		return errAckReader
+	}
+	return ar.EnableAck()
+}
+
+// ackBatch 记录一个 batch 读取完成时 reader 的读取位置，引用全部释放后该 batch 被确认
+// 处理 batch 的 goroutine 持有一个引用，每次调用 AckSender 持有一个引用
+type ackBatch struct {
+	tracker *ackTracker
+	pos     reader.Position
+	refs    int32
+	acked   bool // 由 tracker.mu 保护
+}
+
+// add 增加一个引用，返回的函数释放该引用，多次调用只释放一次
+func (b *ackBatch) add() func() {
+	atomic.AddInt32(&b.refs, 1)
+	var once sync.Once
+	return func() {
+		once.Do(b.done)
+	}
+}
+
+// done 释放一个引用，b 为 nil 时忽略
+func (b *ackBatch) done() {
+	if b == nil {
+		return
+	}
+	if atomic.AddInt32(&b.refs, -1) == 0 {
+		b.tracker.ack(b)
+	}
+}
+
+// ackTracker 按读取顺序跟踪等待确认的 batch，一个 batch 之前的 batch 全部被确认后才能保存它的读取位置
+type ackTracker struct {
+	maxPending int
+
+	mu          sync.Mutex
+	batches     []*ackBatch
+	committable reader.Position
+	acked       int // committable 对应的尚未保存的 batch 数
+}
+
+func newAckTracker(conf AckConfig) *ackTracker {
+	if conf.MaxPending <= 0 {
+		conf.MaxPending = defaultAckMaxPending
+	}
+	return &ackTracker{maxPending: conf.MaxPending}
+}
+
+// wait 等待确认的 batch 数达到上限时阻塞，runner 停止时返回 false
+func (t *ackTracker) wait(stopped func() bool) bool {
+	for !stopped() {
+		t.mu.Lock()
+		full := len(t.batches) >= t.maxPending
+		t.mu.Unlock()
+		if !full {
+			return true
+		}
+		time.Sleep(ackWaitInterval)
+	}
+	return false
+}
+
+// begin 记录一个新读取的 batch，返回的 batch 持有一个引用
+func (t *ackTracker) begin(pos reader.Position) *ackBatch {
+	b := &ackBatch{tracker: t, pos: pos, refs: 1}
+	t.mu.Lock()
+	t.batches = append(t.batches, b)
+	t.mu.Unlock()
+	return b
+}
+
+func (t *ackTracker) ack(b *ackBatch) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b.acked = true
+	for len(t.batches) > 0 && t.batches[0].acked {
+		t.committable = t.batches[0].pos
+		t.acked++
+		t.batches[0] = nil
+		t.batches = t.batches[1:]
+	}
+}
+
+// take 确认的 batch 数达到 syncEvery 时返回可以保存的读取位置，drained 表示该位置之后没有已读取但尚未确认的 batch
+func (t *ackTracker) take(syncEvery int) (pos reader.Position, drained, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.acked == 0 || t.acked < syncEvery {
+		return nil, false, false
+	}
+	pos = t.committable
+	t.committable, t.acked = nil, 0
+	return pos, len(t.batches) == 0, true
+}
+
+// beginAck 开启确认时记录当前 batch 的读取位置，需要在读取数据的 goroutine 中调用
+func (r *LogExportRunner) beginAck(batchLen int64) *ackBatch {
+	if r.ack == nil || batchLen <= 0 {
+		return nil
+	}
+	return r.ack.begin(r.reader.(reader.AckReader).Position())
+}
+
+// batchPosition 返回读取完当前 batch 后 reader 的位置，用于死信记录，需要在读取数据的 goroutine 中调用
+// 开启 ack 时使用 ab 记录的位置，ingest queue 中的数据与 reader 当前的位置无关，不记录
+func (r *LogExportRunner) batchPosition(ab *ackBatch) reader.Position {
+	if ab != nil {
+		return ab.pos
+	}
+	if r.ingest != nil {
+		return nil
+	}
+	if ar, ok := r.reader.(reader.AckReader); ok {
+		return ar.Position()
+	}
+	return nil
+}
+
+// commitAck 保存已确认的读取位置，force 为 true 时不考虑 sync_every
+// 保存的位置之前读取的数据都已转换并确认时，同时保存 transformer 的状态
+func (r *LogExportRunner) commitAck(force bool) {
+	syncEvery := r.SyncEvery
+	if force {
+		syncEvery = 1
+	} else if syncEvery <= 0 {
+		return
+	}
+	pos, drained, ok := r.ack.take(syncEvery)
+	if !ok {
+		return
+	}
+	if err := r.reader.(reader.AckReader).Commit(pos); err != nil {
+		log.Errorf("Runner[%v] commit reader %v position error: %v", r.Name(), r.reader.Name(), err)
+		return
+	}
+	if drained {
+		r.saveTransformState(force)
+	}
+}
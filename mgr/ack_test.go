@@ -0,0 +1,192 @@
+package mgr
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/cleaner"
+	"github.com/qiniu/logkit/parser"
+	"github.com/qiniu/logkit/reader"
+	"github.com/qiniu/logkit/sender"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// ackSender 记录收到的数据，由测试控制确认的时机
+type ackSender struct {
+	linesSender
+	acks []func()
+}
+
+func (s *ackSender) SendWithAck(datas []Data, ack func()) error {
+	if err := s.Send(datas); err != nil {
+		ack()
+		return err
+	}
+	s.mux.Lock()
+	s.acks = append(s.acks, ack)
+	s.mux.Unlock()
+	return nil
+}
+
+func (s *ackSender) ack(i int) {
+	s.mux.Lock()
+	ack := s.acks[i]
+	s.mux.Unlock()
+	ack()
+}
+
+// errSender 总是发送失败
+type errSender struct{}
+
+func (s *errSender) Name() string { return "err_sender" }
+
+func (s *errSender) Send([]Data) error { return errors.New("send failed") }
+
+func (s *errSender) Close() error { return nil }
+
+func TestAckTracker(t *testing.T) {
+	tracker := newAckTracker(AckConfig{MaxPending: 2})
+	assert.True(t, tracker.wait(func() bool { return false }))
+	b1 := tracker.begin(1)
+	release := b1.add()
+	b2 := tracker.begin(2)
+	assert.False(t, tracker.wait(func() bool { return true }))
+
+	// 之前的 batch 没有确认时不能保存
+	b2.done()
+	_, _, ok := tracker.take(1)
+	assert.False(t, ok)
+	b1.done()
+	_, _, ok = tracker.take(1)
+	assert.False(t, ok)
+	release()
+	release()
+	pos, drained, ok := tracker.take(1)
+	assert.True(t, ok)
+	assert.True(t, drained)
+	assert.Equal(t, 2, pos)
+
+	b3 := tracker.begin(3)
+	b4 := tracker.begin(4)
+	b3.done()
+	_, _, ok = tracker.take(2)
+	assert.False(t, ok)
+	// 之后还有没有确认的 batch
+	pos, drained, ok = tracker.take(1)
+	assert.True(t, ok)
+	assert.False(t, drained)
+	assert.Equal(t, 3, pos)
+	_, _, ok = tracker.take(1)
+	assert.False(t, ok)
+	b4.done()
+	pos, drained, ok = tracker.take(1)
+	assert.True(t, ok)
+	assert.True(t, drained)
+	assert.Equal(t, 4, pos)
+
+	var nilBatch *ackBatch
+	nilBatch.done()
+}
+
+func TestRunWithAck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestRunWithAck")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "test.log")
+	assert.NoError(t, ioutil.WriteFile(logPath, []byte("a\nb\nc\n"), DefaultFilePerm))
+
+	config := `{
+			"name":"TestRunWithAck",
+			"batch_len":1,
+			"batch_interval":1,
+			"ack":{
+				"enable":true
+			},
+			"reader":{
+				"mode":"file",
+				"meta_path":"` + filepath.Join(dir, "meta") + `",
+				"log_path":"` + logPath + `",
+				"read_from":"oldest"
+			},
+			"parser":{
+				"name":"testraw",
+				"type":"raw",
+				"timestamp":"false"
+			},
+			"senders":[{
+				"name":"discard_sender",
+				"sender_type":"discard"
+			}]
+		}`
+	rc := RunnerConfig{}
+	assert.NoError(t, jsoniter.Unmarshal([]byte(config), &rc))
+	newRunner := func() (*LogExportRunner, *ackSender) {
+		rr, err := NewLogExportRunner(rc, make(chan cleaner.CleanSignal), reader.NewRegistry(), parser.NewRegistry(), sender.NewRegistry())
+		assert.NoError(t, err)
+		assert.NotNil(t, rr.ack)
+		s := &ackSender{}
+		rr.senders = []sender.Sender{s}
+		return rr, s
+	}
+	rr, s := newRunner()
+	go rr.Run()
+
+	exp := []interface{}{"a\n", "b\n", "c\n"}
+	for i := 0; i < 50 && len(s.Lines()) < len(exp); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, exp, s.Lines())
+	// 没有确认时不保存读取位置，后面的 batch 先确认也不保存
+	time.Sleep(1500 * time.Millisecond)
+	assert.True(t, rr.meta.IsNotExist())
+	s.ack(1)
+	time.Sleep(1500 * time.Millisecond)
+	assert.True(t, rr.meta.IsNotExist())
+	s.ack(0)
+	for i := 0; i < 30 && rr.meta.IsNotExist(); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.False(t, rr.meta.IsNotExist())
+	rr.Stop()
+
+	// 重启后从已确认的位置继续读取，没有确认的数据重新发送
+	rr, s = newRunner()
+	go rr.Run()
+	for i := 0; i < 50 && len(s.Lines()) < 1; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, []interface{}{"c\n"}, s.Lines())
+	rr.Stop()
+
+	// 开启 ack 时放弃发送的数据只有写入死信后才返回 true，否则读取位置会越过丢失的数据
+	failing := &dataSender{name: "failing", failOn: "x"}
+	datas := []Data{{"a": "x"}}
+	assert.False(t, rr.trySendAck(failing, datas, 1, rr.ack.begin(nil), nil))
+	rr.setDeadLetter(&errSender{})
+	assert.False(t, rr.trySendAck(failing, datas, 1, rr.ack.begin(nil), nil))
+	dl := &dataSender{name: "dead_letter"}
+	rr.setDeadLetter(dl)
+	assert.True(t, rr.trySendAck(failing, datas, 1, rr.ack.begin(nil), nil))
+	assert.Len(t, dl.Datas(), 1)
+	rr.deadLetter = nil
+	assert.True(t, rr.trySend(failing, datas, 1))
+
+	info := rc.RunnerInfo
+	info.SendRaw = true
+	_, err = NewLogExportRunnerWithService(info, rr.reader, nil, rr.parser, nil, rr.senders, nil, rr.meta)
+	assert.Equal(t, errAckSendRaw, err)
+	info.SendRaw = false
+	info.IngestQueue = &IngestQueueConfig{Enable: true}
+	_, err = NewLogExportRunnerWithService(info, rr.reader, nil, rr.parser, nil, rr.senders, nil, rr.meta)
+	assert.Equal(t, errAckIngestQueue, err)
+	info.IngestQueue = nil
+	_, err = NewLogExportRunnerWithService(info, &mockDataReader{}, nil, rr.parser, nil, rr.senders, nil, rr.meta)
+	assert.Equal(t, errAckReader, err)
+}
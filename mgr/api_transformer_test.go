@@ -5,6 +5,7 @@ import (
 
 	"github.com/qiniu/logkit/transforms"
 	_ "github.com/qiniu/logkit/transforms/date"
+	_ "github.com/qiniu/logkit/transforms/mutate"
 	. "github.com/qiniu/logkit/utils/models"
 
 	"github.com/json-iterator/go"
@@ -71,4 +72,18 @@ func transformerAPITest(p *testParam) {
 		t.Fatalf("respBody %v unmarshal failed, error is %v", respBody, err)
 	}
 	assert.Equal(t, "", got4.Message)
+
+	// Test transformer/check with lua transformer compile error
+	var got5 respDataMessage
+	var luaTransformerConfig = `{
+		"type":"lua",
+		"script":"function process(data"
+	}`
+	respCode, respBody, err = makeRequest(url, http.MethodPost, []byte(luaTransformerConfig))
+	assert.NoError(t, err, string(respBody))
+	assert.Equal(t, http.StatusBadRequest, respCode)
+	if err = jsoniter.Unmarshal(respBody, &got5); err != nil {
+		t.Fatalf("respBody %v unmarshal failed, error is %v", respBody, err)
+	}
+	assert.Contains(t, got5.Message, "compile script error")
 }
@@ -0,0 +1,206 @@
+package mgr
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/qiniu/log"
+	"golang.org/x/crypto/bcrypt"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// 角色权限由低到高，高权限角色可以访问所有低权限角色可访问的接口
+const (
+	// RoleReadOnly 只能调用 GET 类型的查询接口
+	RoleReadOnly = "readonly"
+	// RoleOperator 可以启动、停止、重置 runner，以及调用解析、检查等不改变配置的接口
+	RoleOperator = "operator"
+	// RoleAdmin 可以增删改 runner 配置，调用 read、transform、send 等会执行用户配置的接口
+	RoleAdmin = "admin"
+)
+
+const (
+	AuthorizationHeader = "Authorization"
+	bearerPrefix        = "Bearer "
+	basicRealm          = `Basic realm="logkit"`
+)
+
+var roleLevel = map[string]int{
+	RoleReadOnly: 1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// AuthToken 静态 bearer token 及其对应的角色
+type AuthToken struct {
+	Token string `json:"token"`
+	Role  string `json:"role"`
+}
+
+// AuthConfig logkit REST API 的认证配置，三种方式可以同时开启，都不配置时不做认证
+type AuthConfig struct {
+	// Tokens 通过 "Authorization: Bearer <token>" 认证
+	Tokens []AuthToken `json:"tokens"`
+	// UserFile 通过 HTTP basic auth 认证，文件每行格式为 "user:<bcrypt hash>:role"，可以使用 htpasswd -nB 生成
+	UserFile string `json:"user_file"`
+	// CertRoles 通过 TLS 客户端证书认证，key 为证书的 CommonName，"*" 表示任意通过校验的证书
+	CertRoles map[string]string `json:"cert_roles"`
+}
+
+type authUser struct {
+	hash []byte
+	role string
+}
+
+type Authenticator struct {
+	tokens    map[string]string
+	users     map[string]authUser
+	certRoles map[string]string
+}
+
+// NewAuthenticator 根据配置构造认证器，clusterToken 不为空时作为集群内部通信的 admin token
+func NewAuthenticator(conf AuthConfig, clusterToken string) (*Authenticator, error) {
+	au := &Authenticator{
+		tokens:    make(map[string]string),
+		users:     make(map[string]authUser),
+		certRoles: make(map[string]string),
+	}
+	for _, t := range conf.Tokens {
+		if t.Token == "" {
+			return nil, errors.New("auth token can not be empty")
+		}
+		if _, ok := roleLevel[t.Role]; !ok {
+			return nil, fmt.Errorf("role %q of auth token is invalid", t.Role)
+		}
+		au.tokens[t.Token] = t.Role
+	}
+	if clusterToken != "" {
+		au.tokens[clusterToken] = RoleAdmin
+	}
+	if conf.UserFile != "" {
+		users, err := loadUserFile(conf.UserFile)
+		if err != nil {
+			return nil, err
+		}
+		au.users = users
+	}
+	for cn, role := range conf.CertRoles {
+		if _, ok := roleLevel[role]; !ok {
+			return nil, fmt.Errorf("role %q of cert %v is invalid", role, cn)
+		}
+		au.certRoles[cn] = role
+	}
+	return au, nil
+}
+
+func loadUserFile(path string) (map[string]authUser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open auth user file %v error %v", path, err)
+	}
+	defer f.Close()
+
+	users := make(map[string]authUser)
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Split(line, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("auth user file %v line %d: expect user:hash:role", path, lineNum)
+		}
+		if _, err = bcrypt.Cost([]byte(parts[1])); err != nil {
+			return nil, fmt.Errorf("auth user file %v line %d: password hash is not bcrypt: %v", path, lineNum, err)
+		}
+		if _, ok := roleLevel[parts[2]]; !ok {
+			return nil, fmt.Errorf("auth user file %v line %d: role %q is invalid", path, lineNum, parts[2])
+		}
+		users[parts[0]] = authUser{hash: []byte(parts[1]), role: parts[2]}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read auth user file %v error %v", path, err)
+	}
+	return users, nil
+}
+
+// HashPassword 生成 user_file 中使用的 bcrypt 密码哈希
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Enabled 是否配置了任意一种认证方式
+func (au *Authenticator) Enabled() bool {
+	return au != nil && (len(au.tokens) > 0 || len(au.users) > 0 || len(au.certRoles) > 0)
+}
+
+// Authenticate 返回请求对应的角色，ok 为 false 表示请求没有携带有效的凭证
+func (au *Authenticator) Authenticate(req *http.Request) (role string, ok bool) {
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 && len(au.certRoles) > 0 {
+		cn := req.TLS.VerifiedChains[0][0].Subject.CommonName
+		if role, ok = au.certRoles[cn]; ok {
+			return role, true
+		}
+		if role, ok = au.certRoles["*"]; ok {
+			return role, true
+		}
+	}
+	authHeader := req.Header.Get(AuthorizationHeader)
+	if strings.HasPrefix(authHeader, bearerPrefix) {
+		token := strings.TrimSpace(strings.TrimPrefix(authHeader, bearerPrefix))
+		for t, r := range au.tokens {
+			if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+				return r, true
+			}
+		}
+		return "", false
+	}
+	if name, password, hasBasic := req.BasicAuth(); hasBasic {
+		user, exist := au.users[name]
+		if !exist {
+			return "", false
+		}
+		if bcrypt.CompareHashAndPassword(user.hash, []byte(password)) == nil {
+			return user.role, true
+		}
+	}
+	return "", false
+}
+
+// Require 返回 echo 中间件，只有角色不低于 role 的请求才能访问
+func (au *Authenticator) Require(role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !au.Enabled() {
+				return next(c)
+			}
+			req := c.Request()
+			userRole, ok := au.Authenticate(req)
+			if !ok {
+				if len(au.users) > 0 {
+					c.Response().Header().Set("WWW-Authenticate", basicRealm)
+				}
+				log.Warnf("unauthorized request %v %v from %v", req.Method, req.URL.Path, c.RealIP())
+				return RespError(c, http.StatusUnauthorized, ErrUnauthorized, "authentication required")
+			}
+			if roleLevel[userRole] < roleLevel[role] {
+				return RespError(c, http.StatusForbidden, ErrForbidden, fmt.Sprintf("role %v is not allowed, need %v", userRole, role))
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package mgr
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+func newAuthTestRouter(au *Authenticator) *echo.Echo {
+	e := echo.New()
+	ok := func(c echo.Context) error {
+		return RespSuccess(c, nil)
+	}
+	e.GET("/read", ok, au.Require(RoleReadOnly))
+	e.POST("/operate", ok, au.Require(RoleOperator))
+	e.POST("/admin", ok, au.Require(RoleAdmin))
+	return e
+}
+
+func doAuthRequest(e *echo.Echo, method, path string, setAuth func(*http.Request)) int {
+	req := httptest.NewRequest(method, path, nil)
+	if setAuth != nil {
+		setAuth(req)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestAuthDisabled(t *testing.T) {
+	au, err := NewAuthenticator(AuthConfig{}, "")
+	assert.NoError(t, err)
+	assert.False(t, au.Enabled())
+	e := newAuthTestRouter(au)
+	assert.Equal(t, http.StatusOK, doAuthRequest(e, http.MethodPost, "/admin", nil))
+}
+
+func TestAuthToken(t *testing.T) {
+	au, err := NewAuthenticator(AuthConfig{
+		Tokens: []AuthToken{{Token: "viewer-token", Role: RoleReadOnly}, {Token: "op-token", Role: RoleOperator}},
+	}, "cluster-token")
+	assert.NoError(t, err)
+	e := newAuthTestRouter(au)
+	bearer := func(token string) func(*http.Request) {
+		return func(req *http.Request) {
+			req.Header.Set(AuthorizationHeader, bearerPrefix+token)
+		}
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, doAuthRequest(e, http.MethodGet, "/read", nil))
+	assert.Equal(t, http.StatusUnauthorized, doAuthRequest(e, http.MethodGet, "/read", bearer("wrong")))
+	assert.Equal(t, http.StatusOK, doAuthRequest(e, http.MethodGet, "/read", bearer("viewer-token")))
+	assert.Equal(t, http.StatusForbidden, doAuthRequest(e, http.MethodPost, "/operate", bearer("viewer-token")))
+	assert.Equal(t, http.StatusOK, doAuthRequest(e, http.MethodPost, "/operate", bearer("op-token")))
+	assert.Equal(t, http.StatusForbidden, doAuthRequest(e, http.MethodPost, "/admin", bearer("op-token")))
+	assert.Equal(t, http.StatusOK, doAuthRequest(e, http.MethodPost, "/admin", bearer("cluster-token")))
+
+	_, err = NewAuthenticator(AuthConfig{Tokens: []AuthToken{{Token: "x", Role: "root"}}}, "")
+	assert.Error(t, err)
+}
+
+func TestAuthUserFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestAuthUserFile")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	userFile := filepath.Join(dir, "users")
+	aliceHash, err := HashPassword("alice-pwd")
+	assert.NoError(t, err)
+	bobHash, err := HashPassword("bob-pwd")
+	assert.NoError(t, err)
+	content := "# logkit users\n" +
+		"alice:" + aliceHash + ":admin\n" +
+		"bob:" + bobHash + ":readonly\n"
+	assert.NoError(t, ioutil.WriteFile(userFile, []byte(content), 0600))
+
+	au, err := NewAuthenticator(AuthConfig{UserFile: userFile}, "")
+	assert.NoError(t, err)
+	e := newAuthTestRouter(au)
+	basic := func(user, pwd string) func(*http.Request) {
+		return func(req *http.Request) {
+			req.SetBasicAuth(user, pwd)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/read", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, basicRealm, rec.Header().Get("WWW-Authenticate"))
+
+	assert.Equal(t, http.StatusUnauthorized, doAuthRequest(e, http.MethodGet, "/read", basic("alice", "bob-pwd")))
+	assert.Equal(t, http.StatusOK, doAuthRequest(e, http.MethodGet, "/read", basic("bob", "bob-pwd")))
+	assert.Equal(t, http.StatusForbidden, doAuthRequest(e, http.MethodPost, "/admin", basic("bob", "bob-pwd")))
+	assert.Equal(t, http.StatusOK, doAuthRequest(e, http.MethodPost, "/admin", basic("alice", "alice-pwd")))
+
+	// 不再接受明文和单轮 sha256 的密码哈希
+	for _, hash := range []string{"plain", "$sha256$s1$9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"} {
+		assert.NoError(t, ioutil.WriteFile(userFile, []byte("carol:"+hash+":admin\n"), 0600))
+		_, err = NewAuthenticator(AuthConfig{UserFile: userFile}, "")
+		assert.Error(t, err, hash)
+	}
+}
@@ -23,6 +23,8 @@ type ClusterConfig struct {
 	Enable    bool     `json:"enable"`
 	Address   string   `json:"address"`
 	Tag       string   `json:"tag"`
+	// AuthToken master 与 slave 之间互相调用时携带的 bearer token，同时作为 admin token 被接受
+	AuthToken string `json:"auth_token"`
 }
 
 type Cluster struct {
@@ -30,6 +32,10 @@ type Cluster struct {
 	slaves       []Slave
 	mutex        *sync.RWMutex
 	statusUpdate time.Time
+
+	// client 集群内部通信使用，开启 TLS 后证书更新时会被替换
+	clientLock sync.RWMutex
+	client     *http.Client
 }
 
 type Slave struct {
@@ -91,13 +97,13 @@ func NewCluster(cc *ClusterConfig) *Cluster {
 }
 
 func (cc *Cluster) RunRegisterLoop() error {
-	if err := Register(cc.MasterUrl, cc.Address, cc.Tag); err != nil {
+	if err := cc.Register(cc.Tag); err != nil {
 		return fmt.Errorf("master %v is unavaliable", cc.MasterUrl)
 	}
 	go func() {
 		for {
 			time.Sleep(15 * time.Second)
-			if err := Register(cc.MasterUrl, cc.Address, cc.Tag); err != nil {
+			if err := cc.Register(cc.Tag); err != nil {
 				log.Errorf("master %v is unavaliable", cc.MasterUrl)
 			}
 		}
@@ -198,7 +204,7 @@ func (rs *RestService) GetClusterRunners() echo.HandlerFunc {
 				defer wg.Done()
 				var respRss respRunnersNameList
 				url := fmt.Sprintf("%v/logkit/runners", v.Url)
-				respCode, respBody, err := executeToOneCluster(url, http.MethodGet, []byte{})
+				respCode, respBody, err := rs.cluster.executeToOneCluster(url, http.MethodGet, []byte{})
 				if err != nil || respCode != http.StatusOK {
 					log.Errorf("get slave(tag='%v', url='%v') runner name list failed, resp is %v, error is %v", v.Tag, v.Url, string(respBody), err.Error())
 					return
@@ -248,7 +254,7 @@ func (rs *RestService) ClusterStatus() echo.HandlerFunc {
 					return
 				}
 				url := fmt.Sprintf("%v/logkit/status", v.Url)
-				respCode, respBody, err := executeToOneCluster(url, http.MethodGet, []byte{})
+				respCode, respBody, err := rs.cluster.executeToOneCluster(url, http.MethodGet, []byte{})
 				if err != nil || respCode != http.StatusOK {
 					errInfo := fmt.Errorf("%v %v", string(respBody), err)
 					cs.Err = errInfo.Error()
@@ -289,7 +295,7 @@ func (rs *RestService) GetClusterConfig() echo.HandlerFunc {
 				continue
 			}
 			url := fmt.Sprintf("%v/logkit/configs/"+runnerName, v.Url)
-			respCode, respBody, err := executeToOneCluster(url, http.MethodGet, []byte{})
+			respCode, respBody, err := rs.cluster.executeToOneCluster(url, http.MethodGet, []byte{})
 			if err != nil || respCode != http.StatusOK {
 				lastErrMsg = fmt.Sprintf("get slave(tag = '%v'', url = '%v') config failed resp is %v, error is %v", tag, url, string(respBody), err)
 				continue
@@ -336,7 +342,7 @@ func (rs *RestService) GetClusterConfigs() echo.HandlerFunc {
 					return
 				}
 				url := fmt.Sprintf("%v/logkit/configs", v.Url)
-				respCode, respBody, err := executeToOneCluster(url, http.MethodGet, []byte{})
+				respCode, respBody, err := rs.cluster.executeToOneCluster(url, http.MethodGet, []byte{})
 				if err != nil || respCode != http.StatusOK {
 					errInfo := fmt.Errorf("%v %v", string(respBody), err)
 					sc.Err = errInfo.Error()
@@ -357,6 +363,18 @@ func (rs *RestService) GetClusterConfigs() echo.HandlerFunc {
 	}
 }
 
+// requireClusterPeer 开启 TLS 后集群接口只接受携带 cluster CA 签发的客户端证书的请求
+func (rs *RestService) requireClusterPeer(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if rs.certs != nil {
+			if err := rs.certs.VerifyClusterPeer(c.Request()); err != nil {
+				return RespError(c, http.StatusForbidden, ErrForbidden, err.Error())
+			}
+		}
+		return next(c)
+	}
+}
+
 type RegisterReq struct {
 	Url string `json:"url"`
 	Tag string `json:"tag"`
@@ -375,6 +393,9 @@ func (rs *RestService) PostRegister() echo.HandlerFunc {
 			errMsg := "this is not master"
 			return RespError(c, http.StatusBadRequest, ErrClusterRegister, errMsg)
 		}
+		if rs.certs != nil {
+			req.Url = clusterPeerURL(req.Url)
+		}
 		rs.cluster.AddSlave(req.Url, req.Tag)
 		return RespSuccess(c, nil)
 	}
@@ -396,7 +417,7 @@ func (rs *RestService) PostTag() echo.HandlerFunc {
 			errMsg := "cluster function not configed"
 			return RespError(c, http.StatusBadRequest, ErrClusterTag, errMsg)
 		}
-		if err := Register(rs.cluster.MasterUrl, rs.cluster.Address, req.Tag); err != nil {
+		if err := rs.cluster.Register(req.Tag); err != nil {
 			return RespError(c, http.StatusServiceUnavailable, ErrClusterTag, err.Error())
 		}
 		rs.cluster.mutex.Lock()
@@ -422,7 +443,7 @@ func (rs *RestService) PostClusterConfig() echo.HandlerFunc {
 		method := http.MethodPost
 		mgrType := "add runner " + configName
 		urlPattern := "%v" + PREFIX + "/configs/" + configName
-		if err := executeToClusters(slaves, urlPattern, method, mgrType, configBytes); err != nil {
+		if err := rs.cluster.executeToClusters(slaves, urlPattern, method, mgrType, configBytes); err != nil {
 			return RespError(c, http.StatusServiceUnavailable, ErrClusterRunnerAdd, err.Error())
 		}
 		return RespSuccess(c, nil)
@@ -445,7 +466,7 @@ func (rs *RestService) PutClusterConfig() echo.HandlerFunc {
 		method := http.MethodPut
 		mgrType := "update runner " + configName
 		urlPattern := "%v" + PREFIX + "/configs/" + configName
-		if err := executeToClusters(slaves, urlPattern, method, mgrType, configBytes); err != nil {
+		if err := rs.cluster.executeToClusters(slaves, urlPattern, method, mgrType, configBytes); err != nil {
 			return RespError(c, http.StatusServiceUnavailable, ErrClusterRunnerUpdate, err.Error())
 		}
 		return RespSuccess(c, nil)
@@ -468,7 +489,7 @@ func (rs *RestService) DeleteClusterConfig() echo.HandlerFunc {
 		method := http.MethodDelete
 		mgrType := "delete runner " + configName
 		urlPattern := "%v" + PREFIX + "/configs/" + configName
-		if err := executeToClusters(slaves, urlPattern, method, mgrType, configBytes); err != nil {
+		if err := rs.cluster.executeToClusters(slaves, urlPattern, method, mgrType, configBytes); err != nil {
 			return RespError(c, http.StatusServiceUnavailable, ErrClusterRunnerDelete, err.Error())
 		}
 		return RespSuccess(c, nil)
@@ -491,7 +512,7 @@ func (rs *RestService) PostClusterConfigStop() echo.HandlerFunc {
 		method := http.MethodPost
 		mgrType := "stop runner " + configName
 		urlPattern := "%v" + PREFIX + "/configs/" + configName + "/stop"
-		if err := executeToClusters(slaves, urlPattern, method, mgrType, configBytes); err != nil {
+		if err := rs.cluster.executeToClusters(slaves, urlPattern, method, mgrType, configBytes); err != nil {
 			return RespError(c, http.StatusServiceUnavailable, ErrClusterRunnerStop, err.Error())
 		}
 		return RespSuccess(c, nil)
@@ -514,7 +535,7 @@ func (rs *RestService) PostClusterConfigStart() echo.HandlerFunc {
 		method := http.MethodPost
 		mgrType := "start runner " + configName
 		urlPattern := "%v" + PREFIX + "/configs/" + configName + "/start"
-		if err := executeToClusters(slaves, urlPattern, method, mgrType, configBytes); err != nil {
+		if err := rs.cluster.executeToClusters(slaves, urlPattern, method, mgrType, configBytes); err != nil {
 			return RespError(c, http.StatusServiceUnavailable, ErrClusterRunnerStart, err.Error())
 		}
 		return RespSuccess(c, nil)
@@ -537,7 +558,7 @@ func (rs *RestService) PostClusterConfigReset() echo.HandlerFunc {
 		method := http.MethodPost
 		mgrType := "reset runner " + configName
 		urlPattern := "%v" + PREFIX + "/configs/" + configName + "/reset"
-		if err := executeToClusters(slaves, urlPattern, method, mgrType, configBytes); err != nil {
+		if err := rs.cluster.executeToClusters(slaves, urlPattern, method, mgrType, configBytes); err != nil {
 			return RespError(c, http.StatusServiceUnavailable, ErrClusterRunnerReset, err.Error())
 		}
 		return RespSuccess(c, nil)
@@ -583,7 +604,7 @@ func (rs *RestService) PostSlaveTag() echo.HandlerFunc {
 		mgrType := "change tag"
 		method := http.MethodPost
 		urlPattern := "%v" + PREFIX + "/cluster/tag"
-		if err := executeToClusters(slaves, urlPattern, method, mgrType, configBytes); err != nil {
+		if err := rs.cluster.executeToClusters(slaves, urlPattern, method, mgrType, configBytes); err != nil {
 			return RespError(c, http.StatusServiceUnavailable, ErrClusterSlavesTag, err.Error())
 		}
 		return RespSuccess(c, nil)
@@ -635,7 +656,7 @@ func (rs *RestService) checkClusterRequest(c echo.Context) (name, tag, url strin
 	return
 }
 
-func executeToClusters(slaves []Slave, urlP, method, mgr string, reqBd []byte) (err error) {
+func (cc *Cluster) executeToClusters(slaves []Slave, urlP, method, mgr string, reqBd []byte) (err error) {
 	mutex := new(sync.Mutex)
 	wg := new(sync.WaitGroup)
 	errInfo := make([]string, 0)
@@ -644,7 +665,7 @@ func executeToClusters(slaves []Slave, urlP, method, mgr string, reqBd []byte) (
 		go func(v Slave) {
 			defer wg.Done()
 			url := fmt.Sprintf(urlP, v.Url)
-			respCode, respBody, err := executeToOneCluster(url, method, reqBd)
+			respCode, respBody, err := cc.executeToOneCluster(url, method, reqBd)
 			if respCode != http.StatusOK || err != nil {
 				log.Errorf("url %v %v occurred an error, code %v resp is %v, err is %v", v.Url, mgr, respCode, string(respBody), err)
 				errMsg := fmt.Sprintf("url %v %v occurred an error, resp is %v, err is %v", v.Url, mgr, string(respBody), err)
@@ -661,14 +682,15 @@ func executeToClusters(slaves []Slave, urlP, method, mgr string, reqBd []byte) (
 	return errors.New(strings.Join(errInfo, "\n"))
 }
 
-func executeToOneCluster(url, method string, configBytes []byte) (respCode int, respBody []byte, err error) {
+func (cc *Cluster) executeToOneCluster(url, method string, configBytes []byte) (respCode int, respBody []byte, err error) {
 	config := bytes.NewReader(configBytes)
 	req, err := http.NewRequest(method, url, config)
 	if err != nil {
 		return
 	}
 	req.Header.Set(ContentTypeHeader, ApplicationJson)
-	resp, err := http.DefaultClient.Do(req)
+	cc.setAuthHeader(req)
+	resp, err := cc.httpClient().Do(req)
 	if err != nil {
 		return
 	}
@@ -681,11 +703,18 @@ func executeToOneCluster(url, method string, configBytes []byte) (respCode int,
 	return
 }
 
+// Register 将 myhost 以 tag 注册到 masters，使用默认的 http client 且不携带认证信息
 func Register(masters []string, myhost, tag string) error {
+	cc := &Cluster{ClusterConfig: ClusterConfig{MasterUrl: masters, Address: myhost}}
+	return cc.Register(tag)
+}
+
+// Register 将本机以 tag 注册到所有 master，只要有一个 master 注册成功即返回成功
+func (cc *Cluster) Register(tag string) error {
 	var msg string
 	hasSuccess := false
-	for _, master := range masters {
-		err := registerOne(master, myhost, tag)
+	for _, master := range cc.MasterUrl {
+		err := cc.registerOne(master, tag)
 		if err != nil {
 			msg += "register " + master + " error " + err.Error()
 		} else {
@@ -702,16 +731,22 @@ func Register(masters []string, myhost, tag string) error {
 	return nil
 }
 
-func registerOne(master, myhost, tag string) error {
+func (cc *Cluster) registerOne(master, tag string) error {
 	if master == "" {
 		return errors.New("master host is not configed")
 	}
-	req := RegisterReq{Url: myhost, Tag: tag}
+	req := RegisterReq{Url: cc.Address, Tag: tag}
 	data, err := jsoniter.Marshal(req)
 	if err != nil {
 		return err
 	}
-	resp, err := http.Post(master+"/logkit/cluster/register", ApplicationJson, bytes.NewReader(data))
+	httpReq, err := http.NewRequest(http.MethodPost, master+"/logkit/cluster/register", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set(ContentTypeHeader, ApplicationJson)
+	cc.setAuthHeader(httpReq)
+	resp, err := cc.httpClient().Do(httpReq)
 	if err != nil {
 		return err
 	}
@@ -725,3 +760,30 @@ func registerOne(master, myhost, tag string) error {
 	}
 	return errors.New(string(bd))
 }
+
+func (cc *Cluster) httpClient() *http.Client {
+	cc.clientLock.RLock()
+	defer cc.clientLock.RUnlock()
+	if cc.client == nil {
+		return http.DefaultClient
+	}
+	return cc.client
+}
+
+func (cc *Cluster) setHTTPClient(client *http.Client) {
+	cc.clientLock.Lock()
+	old := cc.client
+	cc.client = client
+	cc.clientLock.Unlock()
+	if old != nil {
+		if tr, ok := old.Transport.(*http.Transport); ok {
+			tr.CloseIdleConnections()
+		}
+	}
+}
+
+func (cc *Cluster) setAuthHeader(req *http.Request) {
+	if cc.AuthToken != "" {
+		req.Header.Set(AuthorizationHeader, bearerPrefix+cc.AuthToken)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -96,7 +97,7 @@ func TestClusterRest(t *testing.T) {
 		time.Sleep(time.Second)
 		if i != 0 {
 			r.cluster.Tag = tag[i]
-			if err = Register(r.cluster.MasterUrl, r.cluster.Address, tag[i]); err != nil {
+			if err = r.cluster.Register(tag[i]); err != nil {
 				t.Fatalf("register master failed error is %v", err)
 			}
 		}
@@ -118,7 +119,7 @@ func TestClusterRest(t *testing.T) {
 	clusterSlavesDeleteTest(&testCluParam{testRoot, t, rs})
 	// 重新注册
 	for i := 1; i < logkitCount; i++ {
-		if err = Register(rs[i].cluster.MasterUrl, rs[i].cluster.Address, rs[i].cluster.Tag); err != nil {
+		if err = rs[i].cluster.Register(rs[i].cluster.Tag); err != nil {
 			t.Fatalf("register master failed, error is %v", err)
 		}
 	}
@@ -127,7 +128,7 @@ func TestClusterRest(t *testing.T) {
 	// 重新注册
 	for i := 1; i < logkitCount; i++ {
 		rs[i].cluster.Tag = tag[i]
-		if err = Register(rs[i].cluster.MasterUrl, rs[i].cluster.Address, rs[i].cluster.Tag); err != nil {
+		if err = rs[i].cluster.Register(rs[i].cluster.Tag); err != nil {
 			t.Fatalf("register master failed, error is %v", err)
 		}
 	}
@@ -889,7 +890,7 @@ func clusterSlavesDeleteTest(p *testCluParam) {
 
 	// 重新注册所有 slave
 	for i := 1; i < logkitCount; i++ {
-		if err = Register(rs[i].cluster.MasterUrl, rs[i].cluster.Address, rs[i].cluster.Tag); err != nil {
+		if err = rs[i].cluster.Register(rs[i].cluster.Tag); err != nil {
 			t.Fatalf("register master failed error is %v", err)
 		}
 	}
@@ -1098,3 +1099,16 @@ func TestJsoniter(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, respGotConfigs1, respGotConfigs2)
 }
+
+func TestRegisterFunc(t *testing.T) {
+	var got RegisterReq
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/logkit/cluster/register", req.URL.Path)
+		assert.NoError(t, jsoniter.NewDecoder(req.Body).Decode(&got))
+	}))
+	defer srv.Close()
+
+	assert.NoError(t, Register([]string{srv.URL}, "http://127.0.0.1:3000", "tag1"))
+	assert.Equal(t, RegisterReq{Url: "http://127.0.0.1:3000", Tag: "tag1"}, got)
+	assert.Error(t, Register([]string{""}, "http://127.0.0.1:3000", "tag1"))
+}
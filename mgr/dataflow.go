@@ -167,9 +167,16 @@ func TransformData(transformerConfig map[string]interface{}) ([]Data, error) {
 	if err != nil {
 		return nil, err
 	}
+	cond, err := newTransformCondition(transformerConfig)
+	if err != nil {
+		return nil, err
+	}
 
 	// Transform data
-	transformedData, transErr := transformer.Transform(data)
+	transformedData, _, transErr := transformIf(cond, data, func(datas []Data) ([]Data, []int, error) {
+		datas, err := transformer.Transform(datas)
+		return datas, nil, err
+	})
 	se, ok := transErr.(*StatsError)
 	if ok {
 		transErr = errors.New(se.LastError)
@@ -450,6 +457,9 @@ func getTransformer(transConfig map[string]interface{}, create transforms.Creato
 			return nil, err
 		}
 	}
+	if _, err := newTransformCondition(transConfig); err != nil {
+		return nil, err
+	}
 	return trans, nil
 }
 
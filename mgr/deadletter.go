@@ -0,0 +1,405 @@
+package mgr
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/parser"
+	"github.com/qiniu/logkit/parser/config"
+	"github.com/qiniu/logkit/reader"
+	"github.com/qiniu/logkit/sender"
+	senderConf "github.com/qiniu/logkit/sender/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	DeadLetterStageParse     = "parse"
+	DeadLetterStageTransform = "transform"
+	DeadLetterStageSend      = "send"
+
+	deadLetterDir = "dead_letter"
+)
+
+// 死信数据中的字段
+const (
+	deadLetterKeyStage            = "stage"
+	deadLetterKeyError            = "error"
+	deadLetterKeyRunner           = "runner"
+	deadLetterKeySource           = "source"
+	deadLetterKeyOffset           = "offset"
+	deadLetterKeyTimestamp        = "timestamp"
+	deadLetterKeyRaw              = "raw"
+	deadLetterKeyData             = "data"
+	deadLetterKeyTransformer      = "transformer"
+	deadLetterKeyTransformerIndex = "transformer_index"
+	deadLetterKeySender           = "sender"
+)
+
+// ReplayResult 重放死信数据的结果，按照重新进入的阶段计数
+type ReplayResult struct {
+	Parse     int `json:"parse"`
+	Transform int `json:"transform"`
+	Send      int `json:"send"`
+	Skipped   int `json:"skipped"`
+}
+
+// Replayable 支持重放死信数据的 runner
+type Replayable interface {
+	Replay(records []Data) (ReplayResult, error)
+}
+
+// deadLetter 将处理失败的数据写入专门的 sender，多个阶段可能同时写入
+type deadLetter struct {
+	sender sender.Sender
+	mux    sync.Mutex
+}
+
+// newDeadLetterSender 创建死信 sender，默认使用独立的 ft 目录，避免与 runner 的 sender 共用队列
+func newDeadLetterSender(rc RunnerConfig, sr *sender.Registry, ftSaveLogPath string) (sender.Sender, error) {
+	senderConfig := make(conf.MapConf, len(rc.DeadLetter)+1)
+	for k, v := range rc.DeadLetter {
+		senderConfig[k] = v
+	}
+	senderConfig[KeyRunnerName] = rc.RunnerName
+	if _, ok := senderConfig[senderConf.KeyFtSaveLogPath]; !ok {
+		senderConfig[senderConf.KeyFtSaveLogPath] = filepath.Join(ftSaveLogPath, deadLetterDir)
+	}
+	s, err := sr.NewSender(senderConfig, ftSaveLogPath)
+	if err != nil {
+		return nil, fmt.Errorf("runner %v create dead letter sender error, %v", rc.RunnerName, err)
+	}
+	return s, nil
+}
+
+// setDeadLetter 设置死信 sender，并接收 sender 自行丢弃的数据
+func (r *LogExportRunner) setDeadLetter(dlSender sender.Sender) {
+	r.deadLetter = &deadLetter{sender: dlSender}
+	for _, s := range r.senders {
+		dls, ok := s.(sender.DeadLetterSender)
+		if !ok {
+			continue
+		}
+		name := s.Name()
+		dls.SetDeadLetter(func(datas []Data, raws []string, err error) {
+			r.deadLetterSend(name, datas, raws, err, nil)
+		})
+	}
+}
+
+// newDeadLetterRecord 生成一条死信数据，reader 无法提供每条数据的读取位置，pos 为读取完该数据所在 batch 后的位置
+func (r *LogExportRunner) newDeadLetterRecord(stage string, err error, source string, pos reader.Position) Data {
+	record := Data{
+		deadLetterKeyStage:     stage,
+		deadLetterKeyRunner:    r.RunnerName,
+		deadLetterKeyTimestamp: time.Now().Format(time.RFC3339Nano),
+	}
+	if err != nil {
+		record[deadLetterKeyError] = TruncateStrSize(err.Error(), DefaultTruncateMaxSize)
+	}
+	if source == "" {
+		source = r.meta.LogPath()
+	}
+	if source != "" {
+		record[deadLetterKeySource] = source
+	}
+	if pos != nil {
+		record[deadLetterKeyOffset] = fmt.Sprint(pos)
+	}
+	return record
+}
+
+// dataSource 获取数据中的 datasource 字段
+func dataSource(data Data, dataSourceTag string) string {
+	if dataSourceTag == "" {
+		return ""
+	}
+	source, _ := data[dataSourceTag].(string)
+	return source
+}
+
+// writeDeadLetter 返回数据是否都已写入死信
+func (r *LogExportRunner) writeDeadLetter(records []Data) bool {
+	if len(records) <= 0 {
+		return true
+	}
+	r.deadLetter.mux.Lock()
+	defer r.deadLetter.mux.Unlock()
+	err := r.deadLetter.sender.Send(records)
+	if se, ok := err.(*StatsError); ok && se.Errors == 0 {
+		err = nil
+	}
+	if err != nil {
+		log.Errorf("Runner[%v] write %d records to dead letter sender %v error %v", r.Name(), len(records), r.deadLetter.sender.Name(), err)
+		return false
+	}
+	log.Debugf("Runner[%v] write %d records to dead letter sender %v", r.Name(), len(records), r.deadLetter.sender.Name())
+	return true
+}
+
+// deadLetterParse 将解析失败的数据写入死信，并从 datas 中移除 parser 放入 pandora_stash 的数据
+// 失败的数据包括 DatasourceSkipIndex 中不为空的行，以及带有 pandora_stash 字段的数据
+func (r *LogExportRunner) deadLetterParse(lines, froms []string, se *StatsError, err error, datas []Data, dataSourceTag string, pos reader.Position) []Data {
+	if r.deadLetter == nil || err == nil {
+		return datas
+	}
+	var (
+		records  []Data
+		isBinary = isBinaryParser(r.parser)
+	)
+	addLine := func(idx int, line string) {
+		if line == config.PandoraParseFlushSignal || (!isBinary && strings.TrimSpace(line) == "") {
+			return
+		}
+		// 二进制消息与 pandora_stash 中的一样以 base64 编码记录
+		if isBinary {
+			line = base64.StdEncoding.EncodeToString([]byte(line))
+		}
+		var source string
+		if idx < len(froms) {
+			source = froms[idx]
+		}
+		record := r.newDeadLetterRecord(DeadLetterStageParse, err, source, pos)
+		record[deadLetterKeyRaw] = line
+		records = append(records, record)
+	}
+	if se != nil {
+		for _, idx := range se.DatasourceSkipIndex {
+			if idx >= 0 && idx < len(lines) {
+				addLine(idx, lines[idx])
+			}
+		}
+	} else if len(datas) <= 0 {
+		// 不是 StatsError 时无法区分出错的行，没有解析出数据则认为全部失败
+		for idx, line := range lines {
+			addLine(idx, line)
+		}
+	}
+
+	remain := datas[:0]
+	for _, data := range datas {
+		raw, ok := data[KeyPandoraStash].(string)
+		if !ok {
+			remain = append(remain, data)
+			continue
+		}
+		record := r.newDeadLetterRecord(DeadLetterStageParse, err, dataSource(data, dataSourceTag), pos)
+		record[deadLetterKeyRaw] = raw
+		records = append(records, record)
+	}
+	r.writeDeadLetter(records)
+	return remain
+}
+
+// deadLetterTransform 将 transformer 处理出错的数据写入死信并从 datas 中移除，重放时从该 transformer 开始处理
+func (r *LogExportRunner) deadLetterTransform(idx int, errIndex []int, err error, datas []Data, pos reader.Position) []Data {
+	if r.deadLetter == nil || err == nil || len(errIndex) <= 0 {
+		return datas
+	}
+	var (
+		records       []Data
+		failed        = make(map[int]bool, len(errIndex))
+		dataSourceTag = r.meta.GetDataSourceTag()
+		name          = formatTransformName(r.transformers[idx].Type(), idx)
+	)
+	for _, i := range errIndex {
+		if i < 0 || i >= len(datas) || failed[i] {
+			continue
+		}
+		failed[i] = true
+		record := r.newDeadLetterRecord(DeadLetterStageTransform, err, dataSource(datas[i], dataSourceTag), pos)
+		record[deadLetterKeyData] = datas[i]
+		record[deadLetterKeyTransformer] = name
+		record[deadLetterKeyTransformerIndex] = idx
+		records = append(records, record)
+	}
+	remain := make([]Data, 0, len(datas)-len(failed))
+	for i, data := range datas {
+		if !failed[i] {
+			remain = append(remain, data)
+		}
+	}
+	r.writeDeadLetter(records)
+	return remain
+}
+
+// deadLetterSend 将 sender 最终放弃发送的数据写入死信，重放时只发送给该 sender，返回数据是否都已写入死信
+// sender 自行丢弃的数据已经与 batch 无关，pos 为 nil
+func (r *LogExportRunner) deadLetterSend(senderName string, datas []Data, raws []string, err error, pos reader.Position) bool {
+	if len(datas) <= 0 && len(raws) <= 0 {
+		return true
+	}
+	if r.deadLetter == nil {
+		return false
+	}
+	var (
+		records       = make([]Data, 0, len(datas)+len(raws))
+		dataSourceTag = r.meta.GetDataSourceTag()
+	)
+	for _, data := range datas {
+		record := r.newDeadLetterRecord(DeadLetterStageSend, err, dataSource(data, dataSourceTag), pos)
+		record[deadLetterKeyData] = data
+		record[deadLetterKeySender] = senderName
+		records = append(records, record)
+	}
+	for _, raw := range raws {
+		record := r.newDeadLetterRecord(DeadLetterStageSend, err, "", pos)
+		record[deadLetterKeyRaw] = raw
+		record[deadLetterKeySender] = senderName
+		records = append(records, record)
+	}
+	return r.writeDeadLetter(records)
+}
+
+var (
+	errReplayStopped        = errors.New("runner is stopped")
+	errReplayStatefulParser = errors.New("records of parse stage can not be replayed, the parser keeps state between lines")
+)
+
+// Replay 将死信数据重新送入 runner：parse 阶段的数据从解析开始，transform 阶段的数据从出错的 transformer 开始，
+// send 阶段的数据只发送给出错的 sender。字段缺失或无法匹配的数据会被跳过
+// 重放与 runner 同时使用 parser，在多行之间保存状态的 parser 不能并发调用，此时拒绝重放 parse 阶段的数据
+func (r *LogExportRunner) Replay(records []Data) (result ReplayResult, err error) {
+	if atomic.LoadInt32(&r.stopped) > 0 {
+		return result, errReplayStopped
+	}
+	var (
+		lines, froms []string
+		transformIn  = make(map[int][]Data)
+		sendIn       = make(map[string][]Data)
+		rawSendIn    = make(map[string][]string)
+		stateful     = parser.IsStateful(r.parser)
+		isBinary     = isBinaryParser(r.parser)
+	)
+	for _, record := range records {
+		stage, _ := record[deadLetterKeyStage].(string)
+		raw, hasRaw := record[deadLetterKeyRaw].(string)
+		data, hasData := toData(record[deadLetterKeyData])
+		if stage == DeadLetterStageParse && hasRaw && stateful && !r.SendRaw {
+			return ReplayResult{}, errReplayStatefulParser
+		}
+		switch {
+		case stage == DeadLetterStageParse && hasRaw && !r.SendRaw:
+			if isBinary {
+				b, err := base64.StdEncoding.DecodeString(raw)
+				if err != nil {
+					result.Skipped++
+					continue
+				}
+				raw = string(b)
+			}
+			source, _ := record[deadLetterKeySource].(string)
+			lines = append(lines, raw)
+			froms = append(froms, source)
+			result.Parse++
+		case stage == DeadLetterStageTransform && hasData:
+			idx, ok := toInt(record[deadLetterKeyTransformerIndex])
+			if !ok || idx < 0 || idx >= len(r.transformers) {
+				result.Skipped++
+				continue
+			}
+			transformIn[idx] = append(transformIn[idx], data)
+			result.Transform++
+		case stage == DeadLetterStageSend && (hasData || hasRaw):
+			name, _ := record[deadLetterKeySender].(string)
+			if r.getSender(name) == nil {
+				result.Skipped++
+				continue
+			}
+			if hasData {
+				sendIn[name] = append(sendIn[name], data)
+			} else {
+				rawSendIn[name] = append(rawSendIn[name], raw)
+			}
+			result.Send++
+		default:
+			result.Skipped++
+		}
+	}
+
+	if len(lines) > 0 {
+		// 死信中记录的是经过 StageBeforeParser transformer 处理后的数据，直接解析
+		datas := r.parseLines(lines, froms, r.meta.GetDataSourceTag(), nil)
+		if len(datas) > 0 && !r.replaySend(r.transform(datas, nil)) {
+			return result, errReplayStopped
+		}
+	}
+	for idx, datas := range transformIn {
+		if datas = r.transformFrom(datas, idx, nil); len(datas) > 0 && !r.replaySend(datas) {
+			return result, errReplayStopped
+		}
+	}
+	for name, datas := range sendIn {
+		if !r.trySend(r.getSender(name), datas, r.MaxBatchTryTimes) {
+			return result, errReplayStopped
+		}
+	}
+	for name, raws := range rawSendIn {
+		if !r.tryRawSend(r.getSender(name), raws, r.MaxBatchTryTimes) {
+			return result, errReplayStopped
+		}
+	}
+	log.Infof("Runner[%v] replay dead letter records, parse %d, transform %d, send %d, skipped %d",
+		r.Name(), result.Parse, result.Transform, result.Send, result.Skipped)
+	return result, nil
+}
+
+func (r *LogExportRunner) replaySend(datas []Data) bool {
+	senderDataList := classifySenderData(r.senders, datas, r.router)
+	for index, s := range r.senders {
+		if !r.trySend(s, senderDataList[index], r.MaxBatchTryTimes) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *LogExportRunner) getSender(name string) sender.Sender {
+	if name == "" {
+		return nil
+	}
+	for _, s := range r.senders {
+		if s.Name() == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func toData(v interface{}) (Data, bool) {
+	switch data := v.(type) {
+	case Data:
+		return data, true
+	case map[string]interface{}:
+		return Data(data), true
+	}
+	return nil, false
+}
+
+func toInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int64:
+		return int(n), true
+	case float64:
+		return int(n), true
+	case json.Number:
+		i, err := n.Int64()
+		return int(i), err == nil
+	case string:
+		i, err := strconv.Atoi(n)
+		return i, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,276 @@
+package mgr
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/cleaner"
+	"github.com/qiniu/logkit/parser"
+	"github.com/qiniu/logkit/reader"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/transforms"
+	"github.com/qiniu/logkit/transforms/mutate"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// dataSender 记录收到的数据，字段 a 的值为 failOn 的数据发送失败
+type dataSender struct {
+	name   string
+	mux    sync.Mutex
+	failOn string
+	datas  []Data
+}
+
+func (s *dataSender) Name() string { return s.name }
+
+func (s *dataSender) Send(datas []Data) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, d := range datas {
+		if s.failOn != "" && d["a"] == s.failOn {
+			return errors.New("send failed")
+		}
+	}
+	for _, d := range datas {
+		cp := make(Data, len(d))
+		for k, v := range d {
+			cp[k] = v
+		}
+		s.datas = append(s.datas, cp)
+	}
+	return nil
+}
+
+func (s *dataSender) Close() error { return nil }
+
+func (s *dataSender) SkipDeepCopy() bool { return true }
+
+func (s *dataSender) setFailOn(failOn string) {
+	s.mux.Lock()
+	s.failOn = failOn
+	s.mux.Unlock()
+}
+
+func (s *dataSender) Datas() []Data {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return append([]Data{}, s.datas...)
+}
+
+func waitDatas(s *dataSender, n int) []Data {
+	for i := 0; i < 50 && len(s.Datas()) < n; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	return s.Datas()
+}
+
+func TestDeadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestDeadLetter")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "test.log")
+	content := `{"a":"1","b":"x"}
+not json
+{"a":"2"}
+{"a":"fail","b":"x"}
+`
+	assert.NoError(t, ioutil.WriteFile(logPath, []byte(content), DefaultFilePerm))
+
+	config := `{
+			"name":"TestDeadLetter",
+			"batch_len":1,
+			"batch_try_times":1,
+			"reader":{
+				"mode":"file",
+				"meta_path":"` + filepath.Join(dir, "meta") + `",
+				"log_path":"` + logPath + `",
+				"read_from":"oldest"
+			},
+			"parser":{
+				"name":"testjson",
+				"type":"json"
+			},
+			"senders":[{
+				"name":"discard_sender",
+				"sender_type":"discard"
+			}],
+			"dead_letter":{
+				"sender_type":"discard"
+			}
+		}`
+	rc := RunnerConfig{}
+	assert.NoError(t, jsoniter.Unmarshal([]byte(config), &rc))
+	rr, err := NewLogExportRunner(rc, make(chan cleaner.CleanSignal), reader.NewRegistry(), parser.NewRegistry(), sender.NewRegistry())
+	assert.NoError(t, err)
+	assert.NotNil(t, rr.deadLetter)
+	assert.NoError(t, rr.deadLetter.sender.Close())
+
+	replacer := &mutate.Replacer{StageTime: transforms.StageAfterParser, Key: "b", Old: "x", New: "y"}
+	assert.NoError(t, replacer.Init())
+	rr.transformers = []transforms.Transformer{replacer}
+	s := &dataSender{name: "data_sender", failOn: "fail"}
+	rr.senders = []sender.Sender{s}
+	dl := &dataSender{name: "dead_letter"}
+	rr.setDeadLetter(dl)
+	go rr.Run()
+
+	records := waitDatas(dl, 3)
+	assert.Len(t, records, 3)
+	datas := waitDatas(s, 1)
+	assert.Len(t, datas, 1)
+	assert.Equal(t, "y", datas[0]["b"])
+	for _, record := range records {
+		assert.Equal(t, "TestDeadLetter", record[deadLetterKeyRunner])
+		assert.Equal(t, logPath, record[deadLetterKeySource])
+		assert.NotEmpty(t, record[deadLetterKeyError])
+		// 记录读取完数据所在 batch 后的位置
+		assert.Contains(t, record[deadLetterKeyOffset], logPath+":")
+	}
+	assert.Equal(t, DeadLetterStageParse, records[0][deadLetterKeyStage])
+	assert.Equal(t, "not json", records[0][deadLetterKeyRaw])
+	assert.Equal(t, logPath+":27", records[0][deadLetterKeyOffset])
+	assert.Equal(t, DeadLetterStageTransform, records[1][deadLetterKeyStage])
+	assert.Equal(t, "2", records[1][deadLetterKeyData].(Data)["a"])
+	assert.Equal(t, "replace-0", records[1][deadLetterKeyTransformer])
+	assert.Equal(t, 0, records[1][deadLetterKeyTransformerIndex])
+	assert.Equal(t, DeadLetterStageSend, records[2][deadLetterKeyStage])
+	assert.Equal(t, "fail", records[2][deadLetterKeyData].(Data)["a"])
+	assert.Equal(t, "data_sender", records[2][deadLetterKeySender])
+
+	// 模拟通过 REST 接口提交修正后的死信数据
+	records[0][deadLetterKeyRaw] = `{"a":"3","b":"x"}`
+	records[1][deadLetterKeyData].(Data)["b"] = "x"
+	records = append(records, Data{deadLetterKeyStage: DeadLetterStageSend, deadLetterKeySender: "unknown", deadLetterKeyData: Data{"a": "4"}})
+	body, err := json.Marshal(records)
+	assert.NoError(t, err)
+	var replay []Data
+	assert.NoError(t, json.Unmarshal(body, &replay))
+	s.setFailOn("")
+	result, err := rr.Replay(replay)
+	assert.NoError(t, err)
+	assert.Equal(t, ReplayResult{Parse: 1, Transform: 1, Send: 1, Skipped: 1}, result)
+	datas = s.Datas()
+	assert.Len(t, datas, 4)
+	for i, exp := range []string{"1", "3", "2", "fail"} {
+		assert.Equal(t, exp, datas[i]["a"])
+		assert.Equal(t, "y", datas[i]["b"])
+	}
+	assert.Len(t, dl.Datas(), 3)
+
+	rr.Stop()
+	_, err = rr.Replay(replay)
+	assert.Equal(t, errReplayStopped, err)
+}
+
+func TestDeadLetterBinaryParser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestDeadLetterBinaryParser")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "test.log")
+	assert.NoError(t, ioutil.WriteFile(logPath, nil, DefaultFilePerm))
+
+	config := `{
+			"name":"TestDeadLetterBinaryParser",
+			"reader":{
+				"mode":"file",
+				"meta_path":"` + filepath.Join(dir, "meta") + `",
+				"log_path":"` + logPath + `"
+			},
+			"parser":{
+				"type":"msgpack",
+				"disable_record_errdata":"true"
+			},
+			"senders":[{
+				"sender_type":"discard"
+			}],
+			"dead_letter":{
+				"sender_type":"discard"
+			}
+		}`
+	rc := RunnerConfig{}
+	assert.NoError(t, jsoniter.Unmarshal([]byte(config), &rc))
+	rr, err := NewLogExportRunner(rc, make(chan cleaner.CleanSignal), reader.NewRegistry(), parser.NewRegistry(), sender.NewRegistry())
+	assert.NoError(t, err)
+	defer rr.Stop()
+	assert.NoError(t, rr.deadLetter.sender.Close())
+	s := &dataSender{name: "data_sender"}
+	rr.senders = []sender.Sender{s}
+	dl := &dataSender{name: "dead_letter"}
+	rr.setDeadLetter(dl)
+
+	// 二进制的死信数据以 base64 编码记录，重放时解码
+	lines := []string{"\x81\xa1a\xa11", "\xc1\n"}
+	datas, err := rr.parser.Parse(lines)
+	se, ok := err.(*StatsError)
+	assert.True(t, ok)
+	datas = rr.deadLetterParse(lines, []string{logPath, logPath}, se, errors.New(se.LastError), datas, "", nil)
+	assert.Equal(t, []Data{{"a": "1"}}, datas)
+	records := dl.Datas()
+	assert.Len(t, records, 1)
+	assert.Equal(t, "wQo=", records[0][deadLetterKeyRaw])
+
+	records[0][deadLetterKeyRaw] = "gaFhoTI="
+	result, err := rr.Replay(records)
+	assert.NoError(t, err)
+	assert.Equal(t, ReplayResult{Parse: 1}, result)
+	assert.Equal(t, "2", s.Datas()[0]["a"])
+}
+
+func TestReplayStatefulParser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestReplayStatefulParser")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "test.log")
+	assert.NoError(t, ioutil.WriteFile(logPath, nil, DefaultFilePerm))
+
+	config := `{
+			"name":"TestReplayStatefulParser",
+			"reader":{
+				"mode":"file",
+				"meta_path":"` + filepath.Join(dir, "meta") + `",
+				"log_path":"` + logPath + `"
+			},
+			"parser":{
+				"type":"json"
+			},
+			"senders":[{
+				"sender_type":"discard"
+			}],
+			"dead_letter":{
+				"sender_type":"discard"
+			}
+		}`
+	rc := RunnerConfig{}
+	assert.NoError(t, jsoniter.Unmarshal([]byte(config), &rc))
+	rr, err := NewLogExportRunner(rc, make(chan cleaner.CleanSignal), reader.NewRegistry(), parser.NewRegistry(), sender.NewRegistry())
+	assert.NoError(t, err)
+	defer rr.Stop()
+	assert.NoError(t, rr.deadLetter.sender.Close())
+	s := &dataSender{name: "data_sender"}
+	rr.senders = []sender.Sender{s}
+	rr.setDeadLetter(&dataSender{name: "dead_letter"})
+	rr.parser = &statefulParser{Parser: rr.parser}
+
+	// 有状态的 parser 不能与 runner 同时解析
+	records := []Data{
+		{deadLetterKeyStage: DeadLetterStageSend, deadLetterKeyData: Data{"a": "1"}, deadLetterKeySender: "data_sender"},
+		{deadLetterKeyStage: DeadLetterStageParse, deadLetterKeyRaw: `{"a":"2"}`},
+	}
+	_, err = rr.Replay(records)
+	assert.Equal(t, errReplayStatefulParser, err)
+	assert.Empty(t, s.Datas())
+
+	result, err := rr.Replay(records[:1])
+	assert.NoError(t, err)
+	assert.Equal(t, ReplayResult{Send: 1}, result)
+	assert.Equal(t, []Data{{"a": "1"}}, s.Datas())
+}
@@ -0,0 +1,275 @@
+package mgr
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/json-iterator/go"
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/queue"
+	"github.com/qiniu/logkit/reader"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	IngestOverflowBlock      = "block"
+	IngestOverflowDropOldest = "drop_oldest"
+	IngestOverflowDropNewest = "drop_newest"
+
+	ingestQueueDir               = "ingest_queue"
+	defaultIngestMaxDiskBytes    = 512 * MB
+	defaultIngestMaxMemoryBytes  = 64 * MB
+	defaultIngestMaxBytesPerFile = 64 * MB
+	ingestBlockRetryInterval     = 100 * time.Millisecond
+	ingestGetTimeout             = time.Second
+)
+
+// IngestQueueConfig reader 与 parser 之间的缓冲队列配置
+type IngestQueueConfig struct {
+	Enable bool `json:"enable"`
+	// MemoryBatches 大于 0 时使用内存队列，最多缓存该数量的 batch，runner 停止时剩余数据落盘
+	MemoryBatches int64 `json:"memory_batches,omitempty"`
+	// MaxMemoryBytes 内存队列最多占用的字节数，默认 64MB，与 MemoryBatches 任一达到上限即视为队列已满
+	MaxMemoryBytes int64 `json:"max_memory_bytes,omitempty"`
+	// MaxDiskBytes 磁盘队列最多占用的字节数，默认 512MB
+	MaxDiskBytes int64 `json:"max_disk_bytes,omitempty"`
+	// Overflow 队列满时的处理策略：block（默认）、drop_oldest、drop_newest
+	Overflow string `json:"overflow,omitempty"`
+}
+
+// ingestBatch 队列中的一个 batch，Len 和 Size 为 reader 读取的行数和字节数
+// 包含非 UTF-8 的二进制数据时 Lines 记录在 RawLines 中，json 序列化字符串时会替换非法的字节
+type ingestBatch struct {
+	Lines    []string `json:"lines"`
+	RawLines [][]byte `json:"raw_lines,omitempty"`
+	Froms    []string `json:"froms,omitempty"`
+	Len      int64    `json:"len"`
+	Size     int64    `json:"size"`
+}
+
+func (b ingestBatch) marshal() ([]byte, error) {
+	for _, line := range b.Lines {
+		if utf8.ValidString(line) {
+			continue
+		}
+		b.RawLines = make([][]byte, len(b.Lines))
+		for i, l := range b.Lines {
+			b.RawLines[i] = []byte(l)
+		}
+		b.Lines = nil
+		break
+	}
+	return jsoniter.Marshal(b)
+}
+
+func unmarshalIngestBatch(data []byte) (ingestBatch, error) {
+	var b ingestBatch
+	if err := jsoniter.Unmarshal(data, &b); err != nil {
+		return b, err
+	}
+	if len(b.RawLines) > 0 {
+		b.Lines = make([]string, len(b.RawLines))
+		for i, l := range b.RawLines {
+			b.Lines[i] = string(l)
+		}
+		b.RawLines = nil
+	}
+	return b, nil
+}
+
+type ingestQueue struct {
+	q        queue.BackendQueue
+	overflow string
+	dropped  int64
+	started  int32
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newIngestQueue(conf IngestQueueConfig, name, metaDir string) (*ingestQueue, error) {
+	switch conf.Overflow {
+	case "":
+		conf.Overflow = IngestOverflowBlock
+	case IngestOverflowBlock, IngestOverflowDropOldest, IngestOverflowDropNewest:
+	default:
+		return nil, fmt.Errorf("ingest queue overflow policy %q is not supported", conf.Overflow)
+	}
+	if conf.MaxDiskBytes <= 0 {
+		conf.MaxDiskBytes = defaultIngestMaxDiskBytes
+	}
+	if conf.MaxMemoryBytes <= 0 {
+		conf.MaxMemoryBytes = defaultIngestMaxMemoryBytes
+	}
+	dir := filepath.Join(metaDir, ingestQueueDir)
+	if err := CreateDirIfNotExist(dir); err != nil {
+		return nil, err
+	}
+	maxBytesPerFile := int64(defaultIngestMaxBytesPerFile)
+	if maxBytesPerFile > conf.MaxDiskBytes {
+		maxBytesPerFile = conf.MaxDiskBytes
+	}
+	q := queue.NewDiskQueue(queue.NewDiskQueueOptions{
+		Name:               "ingest_" + name,
+		DataPath:           dir,
+		MaxBytesPerFile:    maxBytesPerFile,
+		MaxMsgSize:         int32(maxBytesPerFile),
+		SyncEveryWrite:     1,
+		SyncEveryRead:      1,
+		SyncTimeout:        2 * time.Second,
+		EnableMemoryQueue:  conf.MemoryBatches > 0,
+		MemoryQueueSize:    conf.MemoryBatches,
+		MaxMemoryUsedBytes: conf.MaxMemoryBytes,
+		MaxDiskUsedBytes:   conf.MaxDiskBytes,
+	})
+	return &ingestQueue{q: q, overflow: conf.Overflow, done: make(chan struct{})}, nil
+}
+
+// put 按照溢出策略将 batch 放入队列，返回 batch 是否被队列接收（包括按策略丢弃的情况）
+// 当策略为 block 且 stopped 返回 true 时放弃写入并返回 false
+func (iq *ingestQueue) put(b ingestBatch, stopped func() bool) bool {
+	data, err := b.marshal()
+	if err != nil {
+		log.Errorf("marshal ingest batch error %v, drop %d lines", err, b.Len)
+		atomic.AddInt64(&iq.dropped, b.Len)
+		return true
+	}
+	for {
+		if err = iq.q.Put(data); err == nil {
+			return true
+		}
+		switch iq.overflow {
+		case IngestOverflowDropNewest:
+			log.Warnf("ingest queue %v is full(%v), drop newest %d lines", iq.q.Name(), err, b.Len)
+			atomic.AddInt64(&iq.dropped, b.Len)
+			return true
+		case IngestOverflowDropOldest:
+			if !iq.dropOldest() {
+				time.Sleep(ingestBlockRetryInterval)
+			}
+		default:
+			if stopped() {
+				return false
+			}
+			time.Sleep(ingestBlockRetryInterval)
+		}
+	}
+}
+
+func (iq *ingestQueue) dropOldest() bool {
+	select {
+	case data := <-iq.q.ReadChan():
+		b, err := unmarshalIngestBatch(data)
+		if err != nil {
+			log.Errorf("unmarshal ingest batch error %v", err)
+		}
+		log.Warnf("ingest queue %v is full, drop oldest %d lines", iq.q.Name(), b.Len)
+		atomic.AddInt64(&iq.dropped, b.Len)
+		return true
+	default:
+		return false
+	}
+}
+
+// get 从队列中取出一个 batch，超时后返回 false
+func (iq *ingestQueue) get(timeout time.Duration) (ingestBatch, bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case data := <-iq.q.ReadChan():
+		b, err := unmarshalIngestBatch(data)
+		if err != nil {
+			log.Errorf("unmarshal ingest batch error %v", err)
+			return b, false
+		}
+		return b, true
+	case <-timer.C:
+		return ingestBatch{}, false
+	}
+}
+
+func (iq *ingestQueue) Depth() int64 {
+	return iq.q.Depth()
+}
+
+func (iq *ingestQueue) Dropped() int64 {
+	return atomic.LoadInt64(&iq.dropped)
+}
+
+func (iq *ingestQueue) Close() error {
+	return iq.q.Close()
+}
+
+var errIngestQueueDataReader = errors.New("ingest queue is not supported by data reader")
+
+func isDataReader(r reader.Reader) bool {
+	_, ok := r.(reader.DataReader)
+	return ok
+}
+
+// ingestLoop 在独立的 goroutine 中读取数据放入队列，数据进入队列后即同步 reader 的 meta
+func (r *LogExportRunner) ingestLoop() {
+	defer close(r.ingest.done)
+	dataSourceTag := r.meta.GetDataSourceTag()
+	stopped := func() bool {
+		return atomic.LoadInt32(&r.stopped) > 0
+	}
+	for !stopped() {
+		lines, froms := r.rawReadLines(dataSourceTag)
+		batchLen, batchSize := r.batchLen, r.batchSize
+		r.addResetStat()
+		if len(lines) <= 0 {
+			continue
+		}
+		if !r.ingest.put(ingestBatch{Lines: lines, Froms: froms, Len: batchLen, Size: batchSize}, stopped) {
+			// 未写入队列的数据不同步 meta，重启后会重新读取
+			log.Warnf("Runner[%v] stopped while ingest queue is full, %d lines will be read again", r.Name(), batchLen)
+			return
+		}
+		r.syncMeta()
+	}
+	r.reader.SyncMeta()
+}
+
+func (r *LogExportRunner) startIngest() {
+	if r.ingest == nil {
+		return
+	}
+	atomic.StoreInt32(&r.ingest.started, 1)
+	go r.ingestLoop()
+}
+
+// nextLines 获取下一批原始数据，开启 ingest queue 时从队列中读取
+func (r *LogExportRunner) nextLines(dataSourceTag string) (lines, froms []string, batchLen, batchSize int64) {
+	if r.ingest != nil {
+		b, ok := r.ingest.get(ingestGetTimeout)
+		if !ok {
+			return nil, nil, 0, 0
+		}
+		return b.Lines, b.Froms, b.Len, b.Size
+	}
+	lines, froms = r.rawReadLines(dataSourceTag)
+	batchLen, batchSize = r.batchLen, r.batchSize
+	r.addResetStat()
+	return
+}
+
+// stopIngest 等待 ingestLoop 退出后关闭队列，队列中剩余的数据会保存在磁盘中
+func (r *LogExportRunner) stopIngest() {
+	if r.ingest == nil {
+		return
+	}
+	r.ingest.stopOnce.Do(func() {
+		if atomic.LoadInt32(&r.ingest.started) > 0 {
+			<-r.ingest.done
+		}
+		if err := r.ingest.Close(); err != nil {
+			log.Errorf("Runner[%v] close ingest queue error %v", r.Name(), err)
+		}
+	})
+}
@@ -0,0 +1,191 @@
+package mgr
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/cleaner"
+	"github.com/qiniu/logkit/parser"
+	"github.com/qiniu/logkit/reader"
+	"github.com/qiniu/logkit/sender"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestIngestQueueOverflow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestIngestQueueOverflow")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = newIngestQueue(IngestQueueConfig{Overflow: "unknown"}, "invalid", dir)
+	assert.Error(t, err)
+
+	notStopped := func() bool { return false }
+	stopped := func() bool { return true }
+	batch := func(line string) ingestBatch {
+		return ingestBatch{Lines: []string{line}, Len: 1, Size: int64(len(line))}
+	}
+	tests := []struct {
+		overflow string
+		accepted bool
+		exp      []string
+		dropped  int64
+	}{
+		{overflow: IngestOverflowDropNewest, accepted: true, exp: []string{"a", "b"}, dropped: 1},
+		{overflow: IngestOverflowDropOldest, accepted: true, exp: []string{"b", "c"}, dropped: 1},
+		{overflow: IngestOverflowBlock, accepted: false, exp: []string{"a", "b"}, dropped: 0},
+	}
+	data, err := batch("a").marshal()
+	assert.NoError(t, err)
+	// 分别由 batch 数和字节数触发队列已满
+	limits := []IngestQueueConfig{
+		{MemoryBatches: 2},
+		{MemoryBatches: 100, MaxMemoryBytes: int64(2 * len(data))},
+	}
+	for _, limit := range limits {
+		for _, ti := range tests {
+			conf := limit
+			conf.Overflow = ti.overflow
+			iq, err := newIngestQueue(conf, ti.overflow, dir)
+			assert.NoError(t, err)
+			assert.True(t, iq.put(batch("a"), notStopped))
+			assert.True(t, iq.put(batch("b"), notStopped))
+			assert.Equal(t, int64(2), iq.Depth())
+			assert.Equal(t, ti.accepted, iq.put(batch("c"), stopped), ti.overflow)
+			assert.Equal(t, ti.dropped, iq.Dropped(), ti.overflow)
+
+			var got []string
+			for i := 0; i < 2; i++ {
+				b, ok := iq.get(time.Second)
+				assert.True(t, ok, ti.overflow)
+				got = append(got, b.Lines...)
+			}
+			assert.Equal(t, ti.exp, got, ti.overflow)
+			_, ok := iq.get(10 * time.Millisecond)
+			assert.False(t, ok)
+			assert.NoError(t, iq.Close())
+		}
+	}
+}
+
+func TestIngestQueueBinaryLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestIngestQueueBinaryLines")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	iq, err := newIngestQueue(IngestQueueConfig{}, "binary", dir)
+	assert.NoError(t, err)
+	defer iq.Close()
+	notStopped := func() bool { return false }
+	lines := []string{"\x00\xff\xfe\n", "text"}
+	assert.True(t, iq.put(ingestBatch{Lines: lines, Froms: []string{"a", "b"}, Len: 2}, notStopped))
+	assert.True(t, iq.put(ingestBatch{Lines: []string{"中文"}, Len: 1}, notStopped))
+
+	b, ok := iq.get(time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, lines, b.Lines)
+	assert.Nil(t, b.RawLines)
+	assert.Equal(t, []string{"a", "b"}, b.Froms)
+	b, ok = iq.get(time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"中文"}, b.Lines)
+}
+
+// linesSender 记录收到的 raw 字段，Name 不序列化数据
+type linesSender struct {
+	mux   sync.Mutex
+	lines []interface{}
+}
+
+func (s *linesSender) Name() string { return "lines_sender" }
+
+func (s *linesSender) Send(datas []Data) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, d := range datas {
+		s.lines = append(s.lines, d["raw"])
+	}
+	return nil
+}
+
+func (s *linesSender) Close() error { return nil }
+
+func (s *linesSender) SkipDeepCopy() bool { return true }
+
+func (s *linesSender) Lines() []interface{} {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return append([]interface{}{}, s.lines...)
+}
+
+func TestRunWithIngestQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestRunWithIngestQueue")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "test.log")
+	assert.NoError(t, ioutil.WriteFile(logPath, []byte("a\nb\nc\n"), DefaultFilePerm))
+
+	config := `{
+			"name":"TestRunWithIngestQueue",
+			"batch_len":1,
+			"ingest_queue":{
+				"enable":true,
+				"max_disk_bytes":1048576
+			},
+			"reader":{
+				"mode":"file",
+				"meta_path":"` + filepath.Join(dir, "meta") + `",
+				"log_path":"` + logPath + `",
+				"read_from":"oldest"
+			},
+			"parser":{
+				"name":"testraw",
+				"type":"raw",
+				"timestamp":"false"
+			},
+			"senders":[{
+				"name":"discard_sender",
+				"sender_type":"discard"
+			}]
+		}`
+	rc := RunnerConfig{}
+	assert.NoError(t, jsoniter.Unmarshal([]byte(config), &rc))
+	rr, err := NewLogExportRunner(rc, make(chan cleaner.CleanSignal), reader.NewRegistry(), parser.NewRegistry(), sender.NewRegistry())
+	assert.NoError(t, err)
+	assert.NotNil(t, rr.ingest)
+	s := &linesSender{}
+	rr.senders = []sender.Sender{s}
+	go rr.Run()
+
+	exp := []interface{}{"a\n", "b\n", "c\n"}
+	for i := 0; i < 50 && len(s.Lines()) < len(exp); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, exp, s.Lines())
+	rs := rr.getRefreshStatus(1)
+	assert.Equal(t, int64(0), rs.IngestQueueDepth)
+	assert.Equal(t, int64(0), rs.IngestQueueDropped)
+	assert.Equal(t, int64(3), rs.ReadDataCount)
+	rr.Stop()
+
+	// DataReader 不支持 ingest queue
+	_, err = NewLogExportRunnerWithService(RunnerInfo{RunnerName: "data_reader", IngestQueue: &IngestQueueConfig{Enable: true}},
+		&mockDataReader{}, nil, rr.parser, nil, rr.senders, nil, rr.meta)
+	assert.Equal(t, errIngestQueueDataReader, err)
+}
+
+type mockDataReader struct{}
+
+func (*mockDataReader) Name() string                             { return "mock_data_reader" }
+func (*mockDataReader) SetMode(mode string, v interface{}) error { return nil }
+func (*mockDataReader) Source() string                           { return "mock" }
+func (*mockDataReader) ReadLine() (string, error)                { return "", nil }
+func (*mockDataReader) SyncMeta()                                {}
+func (*mockDataReader) Close() error                             { return nil }
+func (*mockDataReader) ReadData() (Data, int64, error)           { return nil, 0, nil }
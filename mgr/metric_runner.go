@@ -47,6 +47,7 @@ type MetricRunner struct {
 	senders      []sender.Sender
 	transformers map[string][]transforms.Transformer
 	commonTrans  []transforms.Transformer
+	commonConds  []*transforms.Condition
 
 	collectInterval time.Duration
 	rs              *RunnerStatus
@@ -177,6 +178,10 @@ func NewMetricRunner(rc RunnerConfig, sr *sender.Registry) (runner *MetricRunner
 	if err != nil {
 		return nil, err
 	}
+	commonConditions, err := createTransformConditions(rc, commonTransformers)
+	if err != nil {
+		return nil, err
+	}
 
 	senders := make([]sender.Sender, 0)
 	for _, senderConfig := range rc.SendersConfig {
@@ -222,6 +227,7 @@ func NewMetricRunner(rc RunnerConfig, sr *sender.Registry) (runner *MetricRunner
 		collectors:      collectors,
 		transformers:    transformers,
 		commonTrans:     commonTransformers,
+		commonConds:     commonConditions,
 		senders:         senders,
 		envTag:          rc.EnvTag,
 	}
@@ -286,8 +292,15 @@ func (r *MetricRunner) Run() {
 					}
 				}
 			}
-			for _, t := range r.commonTrans {
-				tmpDatas, err = t.Transform(tmpDatas)
+			for i, t := range r.commonTrans {
+				var cond *transforms.Condition
+				if i < len(r.commonConds) {
+					cond = r.commonConds[i]
+				}
+				tmpDatas, _, err = transformIf(cond, tmpDatas, func(datas []Data) ([]Data, []int, error) {
+					datas, err := t.Transform(datas)
+					return datas, nil, err
+				})
 				if err != nil {
 					log.Errorf("runner[%v]: error %v", r.RunnerName, err)
 				}
@@ -23,6 +23,7 @@ import (
 	. "github.com/qiniu/logkit/reader/config"
 	"github.com/qiniu/logkit/sender"
 	senderConf "github.com/qiniu/logkit/sender/config"
+	"github.com/qiniu/logkit/transforms"
 	"github.com/qiniu/logkit/utils"
 	. "github.com/qiniu/logkit/utils/models"
 	utilsos "github.com/qiniu/logkit/utils/os"
@@ -41,6 +42,8 @@ type ManagerConfig struct {
 	DisableWeb   bool          `json:"disable_web"`
 	ServerBackup bool          `json:"-"`
 	AuditDir     string        `json:"audit_dir"`
+	Auth         AuthConfig    `json:"auth"`
+	TLS          TLSConfig     `json:"tls"`
 }
 
 type cleanQueue struct {
@@ -769,6 +772,12 @@ func TrimSecretInfo(conf RunnerConfig, trimSk bool) RunnerConfig {
 		}
 		conf.SendersConfig[i] = sc
 	}
+	// mask transformer 只支持 key_path，去掉配置中直接填写的 key
+	for _, tc := range conf.Transforms {
+		if tc[transforms.KeyType] == "mask" {
+			delete(tc, "key")
+		}
+	}
 	return conf
 }
 
@@ -986,6 +995,23 @@ func (m *Manager) ResetRunner(name string) (err error) {
 	return
 }
 
+// ReplayDeadLetter 将死信数据交给正在运行的 runner 重新处理
+func (m *Manager) ReplayDeadLetter(name string, records []Data) (ReplayResult, error) {
+	filename, ok := m.GetRunnerPath(name)
+	if !ok {
+		return ReplayResult{}, fmt.Errorf("runner %v is not found", name)
+	}
+	r, ok := m.readRunners(filename)
+	if !ok {
+		return ReplayResult{}, fmt.Errorf("runner %v is not running", name)
+	}
+	replayable, ok := r.(Replayable)
+	if !ok {
+		return ReplayResult{}, fmt.Errorf("runner %v does not support replay", name)
+	}
+	return replayable.Replay(records)
+}
+
 func (m *Manager) readRunners(filename string) (Runner, bool) {
 	if filename == "" {
 		return nil, false
@@ -11,6 +11,8 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
 )
 
 var test1 = `{
@@ -461,3 +463,18 @@ func Test_Watch_LogDir(t *testing.T) {
 	assert.Equal(t, true, ok, fmt.Sprintf("runner of %v exp but not exsit in runners %v", confPathAbs, m.runners))
 	m.Stop()
 }
+
+func TestTrimSecretInfo(t *testing.T) {
+	rc := RunnerConfig{
+		SendersConfig: []conf.MapConf{{"pandora_sk": "sk"}},
+		Transforms: []map[string]interface{}{
+			{"type": "mask", "key": "secret", "key_path": "/path/to/key"},
+			{"type": "replace", "key": "a"},
+		},
+	}
+	rc = TrimSecretInfo(rc, true)
+	assert.Equal(t, conf.MapConf{}, rc.SendersConfig[0])
+	// mask 的密钥不通过接口返回，其他 transformer 的 key 为字段名，保持不变
+	assert.Equal(t, map[string]interface{}{"type": "mask", "key_path": "/path/to/key"}, rc.Transforms[0])
+	assert.Equal(t, "a", rc.Transforms[1]["key"])
+}
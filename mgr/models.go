@@ -32,6 +32,10 @@ type RunnerStatus struct {
 	Tag              string `json:"tag,omitempty"`
 	Url              string `json:"url,omitempty"`
 
+	// IngestQueueDepth 为 ingest queue 中等待解析的 batch 数，IngestQueueDropped 为按溢出策略丢弃的行数
+	IngestQueueDepth   int64 `json:"ingestQueueDepth"`
+	IngestQueueDropped int64 `json:"ingestQueueDropped"`
+
 	//仅作为将history error同步上传到服务端时使用
 	HistorySyncErrors CompatibleErrorResult `json:"history_errors"`
 }
@@ -68,6 +72,8 @@ func (src *RunnerStatus) Clone() RunnerStatus {
 	dst.RunningStatus = src.RunningStatus
 	dst.Tag = src.Tag
 	dst.Url = src.Url
+	dst.IngestQueueDepth = src.IngestQueueDepth
+	dst.IngestQueueDropped = src.IngestQueueDropped
 	return dst
 }
 
@@ -81,6 +87,7 @@ type RunnerConfig struct {
 	ParserConf    conf.MapConf             `json:"parser"`
 	Transforms    []map[string]interface{} `json:"transforms,omitempty"`
 	SendersConfig []conf.MapConf           `json:"senders"`
+	DeadLetter    conf.MapConf             `json:"dead_letter,omitempty"` // 死信 sender 的配置，处理失败的数据写入该 sender
 	Router        router.RouterConfig      `json:"router,omitempty"`
 	IsInWebFolder bool                     `json:"web_folder,omitempty"`
 	IsStopped     bool                     `json:"is_stopped,omitempty"`
@@ -98,12 +105,18 @@ type RunnerInfo struct {
 	MaxBatchTryTimes       int    `json:"batch_try_times,omitempty"`            // 最大发送次数，小于等于0代表无限重试
 	MaxReaderCloseWaitTime int    `json:"max_reader_close_wait_time,omitempty"` // runner 等待reader close时间，
 	ErrorsListCap          int    `json:"errors_list_cap"`                      // 记录错误信息的最大条数
-	SyncEvery              int    `json:"sync_every,omitempty"`                 // 每多少次sync一下，填小于的0数字表示stop时sync，正整数表示发送成功多少次以后同步，填0或1就是每次发送成功都同步，兼容原来不配置的逻辑
+	SyncEvery              int    `json:"sync_every,omitempty"`                 // 每多少次sync一下，填小于的0数字表示stop时sync，正整数表示发送成功多少次以后同步，填0或1就是每次发送成功都同步，兼容原来不配置的逻辑；开启 pipeline 时填0默认为 defaultPipelineSyncEvery(100)
 	CreateTime             string `json:"createtime"`
 	EnvTag                 string `json:"env_tag,omitempty"` // 用这个字段的值来获取环境变量, 作为 tag 添加到数据中
 	ExtraInfo              bool   `json:"extra_info"`
 	LogAudit               bool   `json:"log_audit"`
 	SendRaw                bool   `json:"send_raw"` //使用发送原始字符串的接口，而不是Data
+	// IngestQueue 在 reader 与 parser 之间加入缓冲队列，DataReader 不支持
+	IngestQueue *IngestQueueConfig `json:"ingest_queue,omitempty"`
+	// Pipeline 读取、解析、转换、发送并行执行，send_raw 时不支持
+	Pipeline *PipelineConfig `json:"pipeline,omitempty"`
+	// Ack 数据被所有 sender 确认送达后才保存读取位置，需要 reader 支持，send_raw 和 ingest_queue 时不支持
+	Ack *AckConfig `json:"ack,omitempty"`
 }
 
 type ErrorsList struct {
@@ -0,0 +1,299 @@
+package mgr
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/parser"
+	"github.com/qiniu/logkit/reader"
+	"github.com/qiniu/logkit/sender"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	defaultPipelineChannelSize = 4
+	// 不支持 ack 的 reader 同步 meta 时需要等待流水线清空，默认每 100 个 batch 同步一次
+	defaultPipelineSyncEvery = 100
+)
+
+// PipelineConfig 开启后 runner 的读取、解析、转换、发送在不同的 goroutine 中并行执行
+type PipelineConfig struct {
+	Enable bool `json:"enable"`
+	// ParseWorkers 并发解析的 goroutine 数，默认为 1，Flushable 或 Stateful 的 parser 只能为 1
+	ParseWorkers int `json:"parse_workers,omitempty"`
+	// TransformWorkers 并发执行 transformer 的 goroutine 数，默认为 1，同一个 transformer 同一时刻只处理一个 batch
+	TransformWorkers int `json:"transform_workers,omitempty"`
+	// ChannelSize 各阶段之间以及每个 sender 前缓冲的 batch 数，默认为 4
+	ChannelSize int `json:"channel_size,omitempty"`
+}
+
+// pipelineBatch 在各阶段之间传递的一个 batch
+type pipelineBatch struct {
+	lines     []string
+	froms     []string
+	datas     []Data
+	parsed    bool // DataReader 读出的数据无需解析
+	flush     bool // 没有读取到数据，转换阶段输出 transformer 中已经到期的缓存数据
+	batchLen  int64
+	batchSize int64
+	dataLen   int
+	ack       *ackBatch       // 开启 ack 时所有 sender 确认后释放
+	pos       reader.Position // 读取完该 batch 后的位置，用于死信记录
+
+	pending int32 // 尚未确认的 sender 数
+	failed  int32
+}
+
+type pipeline struct {
+	conf           PipelineConfig
+	transformLocks []sync.Mutex
+	syncEvery      int // reader 不支持 ack 时每多少个 batch 等待流水线清空后同步 meta
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	inflight int // 已读取但尚未被所有 sender 确认的 batch 数
+	acked    int // 上次同步 meta 之后所有 sender 都发送成功的 batch 数
+	failed   int // 上次同步 meta 之后最终发送失败的 batch 数
+}
+
+var errPipelineSendRaw = errors.New("pipeline is not supported when send_raw is enabled")
+
+// newPipeline 的 syncEvery 为 runner 的 sync_every，未配置时使用 defaultPipelineSyncEvery
+func newPipeline(conf PipelineConfig, p parser.Parser, transformerCnt, syncEvery int) *pipeline {
+	if conf.ParseWorkers <= 0 {
+		conf.ParseWorkers = 1
+	}
+	if parser.IsStateful(p) && conf.ParseWorkers > 1 {
+		log.Warnf("parser %v keeps state between lines, parse_workers is reset to 1", p.Name())
+		conf.ParseWorkers = 1
+	}
+	if conf.TransformWorkers <= 0 {
+		conf.TransformWorkers = 1
+	}
+	if conf.ChannelSize <= 0 {
+		conf.ChannelSize = defaultPipelineChannelSize
+	}
+	if syncEvery == 0 {
+		syncEvery = defaultPipelineSyncEvery
+	}
+	pl := &pipeline{conf: conf, transformLocks: make([]sync.Mutex, transformerCnt), syncEvery: syncEvery}
+	pl.cond = sync.NewCond(&pl.mu)
+	return pl
+}
+
+func (pl *pipeline) begin() {
+	pl.mu.Lock()
+	pl.inflight++
+	pl.mu.Unlock()
+}
+
+func (pl *pipeline) finish(success bool) {
+	pl.mu.Lock()
+	pl.inflight--
+	if success {
+		pl.acked++
+	} else {
+		pl.failed++
+	}
+	pl.cond.Broadcast()
+	pl.mu.Unlock()
+}
+
+// waitSync 成功确认的 batch 数达到 syncEvery 时，等待所有已读取的 batch 都被确认，返回是否需要同步 meta
+// 此时 reader 的读取位置之前的数据都已被所有 sender 确认
+func (pl *pipeline) waitSync(syncEvery int, stopped func() bool) bool {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	if syncEvery <= 0 || pl.acked < syncEvery {
+		return false
+	}
+	for pl.inflight > 0 && !stopped() {
+		pl.cond.Wait()
+	}
+	if pl.inflight > 0 {
+		return false
+	}
+	pl.acked, pl.failed = 0, 0
+	return true
+}
+
+// synced 返回已读取的数据是否都已发送成功，此时可以同步 reader 的读取位置
+func (pl *pipeline) synced() bool {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	return pl.inflight == 0 && pl.failed == 0
+}
+
+type orderedItem struct {
+	batch *pipelineBatch
+	done  chan struct{}
+}
+
+// runOrdered 使用 workers 个 goroutine 并发处理 batch，输出顺序与输入顺序一致
+func runOrdered(workers, size int, in <-chan *pipelineBatch, fn func(*pipelineBatch)) <-chan *pipelineBatch {
+	out := make(chan *pipelineBatch, size)
+	order := make(chan orderedItem, workers)
+	work := make(chan orderedItem)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for item := range work {
+				fn(item.batch)
+				close(item.done)
+			}
+		}()
+	}
+	go func() {
+		for b := range in {
+			item := orderedItem{batch: b, done: make(chan struct{})}
+			order <- item
+			work <- item
+		}
+		close(order)
+		close(work)
+	}()
+	go func() {
+		for item := range order {
+			<-item.done
+			out <- item.batch
+		}
+		close(out)
+	}()
+	return out
+}
+
+// pipelineRead 读取数据放入 out，并在所有已读取的数据都被确认后同步 meta
+func (r *LogExportRunner) pipelineRead(out chan<- *pipelineBatch) {
+	defer close(out)
+	dataSourceTag := r.meta.GetDataSourceTag()
+	_, flushable := r.parser.(parser.Flushable)
+	flushTransform := r.hasFlushableTransformer()
+	stopped := func() bool {
+		return atomic.LoadInt32(&r.stopped) > 0
+	}
+	for !stopped() {
+		// 开启 ingest queue 时由 ingestLoop 同步 meta，开启 ack 时只保存已确认的读取位置，无需等待流水线清空
+		if r.ack != nil {
+			r.commitAck(false)
+			if !r.ack.wait(stopped) {
+				continue
+			}
+		} else if r.ingest == nil && r.pipeline.waitSync(r.pipeline.syncEvery, stopped) {
+			r.reader.SyncMeta()
+			r.saveTransformState(false)
+		}
+		b := &pipelineBatch{}
+		if dr, ok := r.reader.(reader.DataReader); ok {
+			b.datas = r.readDatas(dr, dataSourceTag)
+			b.parsed = true
+			b.batchLen, b.batchSize = r.batchLen, r.batchSize
+			r.addResetStat()
+			if len(b.datas) <= 0 {
+				if !flushTransform {
+					continue
+				}
+				b.flush = true
+			}
+		} else {
+			b.lines, b.froms, b.batchLen, b.batchSize = r.nextLines(dataSourceTag)
+			if len(b.lines) <= 0 {
+				if !flushable && !flushTransform {
+					continue
+				}
+				b.flush = flushTransform
+			}
+			// StageBeforeParser 的 transformer 不支持并发调用，在读取的 goroutine 中执行
+			b.lines = r.rawTransform(b.lines)
+		}
+		b.ack = r.beginAck(b.batchLen)
+		b.pos = r.batchPosition(b.ack)
+		r.pipeline.begin()
+		out <- b
+	}
+}
+
+// runPipeline 以流水线的方式执行 读取 -> 解析 -> 转换 -> 发送，每个 sender 独立发送和重试
+// batch 只有在所有 sender 都确认后才算完成
+func (r *LogExportRunner) runPipeline() {
+	pl := r.pipeline
+	dataSourceTag := r.meta.GetDataSourceTag()
+
+	readChan := make(chan *pipelineBatch, pl.conf.ChannelSize)
+	go r.pipelineRead(readChan)
+	parsedChan := runOrdered(pl.conf.ParseWorkers, pl.conf.ChannelSize, readChan, func(b *pipelineBatch) {
+		if !b.parsed {
+			b.datas = r.parseLines(b.lines, b.froms, dataSourceTag, b.pos)
+			b.lines, b.froms = nil, nil
+		}
+	})
+	transformedChan := runOrdered(pl.conf.TransformWorkers, pl.conf.ChannelSize, parsedChan, func(b *pipelineBatch) {
+		if len(b.datas) > 0 {
+			b.datas = r.transform(b.datas, b.pos)
+		}
+		if b.flush {
+			b.datas = append(b.datas, r.flushTransformers(false)...)
+		}
+	})
+
+	commitChan := make(chan *pipelineBatch, pl.conf.ChannelSize)
+	commitDone := make(chan struct{})
+	go func() {
+		defer close(commitDone)
+		for b := range commitChan {
+			success := atomic.LoadInt32(&b.failed) == 0
+			if success {
+				r.auditLog(b.batchLen, b.batchSize, int64(b.dataLen))
+				b.ack.done()
+			}
+			pl.finish(success)
+		}
+	}()
+
+	senderChans := make([]chan senderJob, len(r.senders))
+	wg := new(sync.WaitGroup)
+	for i := range r.senders {
+		senderChans[i] = make(chan senderJob, pl.conf.ChannelSize)
+		wg.Add(1)
+		go func(s sender.Sender, jobs <-chan senderJob) {
+			defer wg.Done()
+			for job := range jobs {
+				if !r.trySendAck(s, job.datas, r.MaxBatchTryTimes, job.batch.ack, job.batch.pos) {
+					log.Errorf("Runner[%v] sender %v failed to send data finally", r.Name(), s.Name())
+					atomic.StoreInt32(&job.batch.failed, 1)
+				}
+				if atomic.AddInt32(&job.batch.pending, -1) == 0 {
+					commitChan <- job.batch
+				}
+			}
+		}(r.senders[i], senderChans[i])
+	}
+
+	for b := range transformedChan {
+		if len(b.datas) <= 0 {
+			// 解析失败或被过滤掉的数据无需发送
+			pl.finish(true)
+			b.ack.done()
+			continue
+		}
+		b.dataLen = len(b.datas)
+		senderDataList := classifySenderData(r.senders, b.datas, r.router)
+		b.datas = nil
+		atomic.StoreInt32(&b.pending, int32(len(r.senders)))
+		for i := range r.senders {
+			senderChans[i] <- senderJob{batch: b, datas: senderDataList[i]}
+		}
+	}
+	for i := range senderChans {
+		close(senderChans[i])
+	}
+	wg.Wait()
+	close(commitChan)
+	<-commitDone
+}
+
+type senderJob struct {
+	batch *pipelineBatch
+	datas []Data
+}
@@ -0,0 +1,175 @@
+package mgr
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/cleaner"
+	"github.com/qiniu/logkit/parser"
+	"github.com/qiniu/logkit/reader"
+	"github.com/qiniu/logkit/sender"
+	"github.com/qiniu/logkit/transforms"
+	"github.com/qiniu/logkit/transforms/mutate"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestRunOrdered(t *testing.T) {
+	in := make(chan *pipelineBatch)
+	out := runOrdered(4, 2, in, func(b *pipelineBatch) {
+		// 越早的 batch 处理越慢
+		time.Sleep(time.Duration(10-b.batchLen) * time.Millisecond)
+	})
+	go func() {
+		for i := int64(0); i < 10; i++ {
+			in <- &pipelineBatch{batchLen: i}
+		}
+		close(in)
+	}()
+	var got []int64
+	for b := range out {
+		got = append(got, b.batchLen)
+	}
+	assert.Equal(t, []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, got)
+}
+
+// statefulParser 在多次 Parse 之间保存状态
+type statefulParser struct {
+	parser.Parser
+}
+
+func (p *statefulParser) Name() string   { return "stateful" }
+func (p *statefulParser) Stateful() bool { return true }
+
+func TestNewPipeline(t *testing.T) {
+	pl := newPipeline(PipelineConfig{ParseWorkers: 4}, &statefulParser{}, 0, 0)
+	assert.Equal(t, 1, pl.conf.ParseWorkers)
+	assert.Equal(t, defaultPipelineSyncEvery, pl.syncEvery)
+
+	pl = newPipeline(PipelineConfig{ParseWorkers: 4}, nil, 0, 10)
+	assert.Equal(t, 4, pl.conf.ParseWorkers)
+	assert.Equal(t, 10, pl.syncEvery)
+}
+
+func TestPipelineSync(t *testing.T) {
+	pl := newPipeline(PipelineConfig{}, nil, 0, 2)
+	stopped := func() bool { return false }
+	pl.begin()
+	pl.begin()
+	assert.False(t, pl.synced())
+	pl.finish(true)
+	assert.False(t, pl.waitSync(pl.syncEvery, stopped))
+	// 发送失败的 batch 之后不能同步
+	pl.finish(false)
+	assert.False(t, pl.synced())
+
+	pl.begin()
+	pl.begin()
+	pl.finish(true)
+	// 等待流水线中剩余的 batch 发送完成
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		pl.finish(true)
+	}()
+	assert.True(t, pl.waitSync(pl.syncEvery, stopped))
+	assert.True(t, pl.synced())
+}
+
+// blockingSender 在 release 关闭前阻塞发送
+type blockingSender struct {
+	linesSender
+	release chan struct{}
+}
+
+func (s *blockingSender) Name() string { return "blocking_sender" }
+
+func (s *blockingSender) Send(datas []Data) error {
+	<-s.release
+	return s.linesSender.Send(datas)
+}
+
+func TestRunPipeline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestRunPipeline")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "test.log")
+	assert.NoError(t, ioutil.WriteFile(logPath, []byte("a\nb\nc\n"), DefaultFilePerm))
+
+	config := `{
+			"name":"TestRunPipeline",
+			"batch_len":1,
+			"batch_interval":1,
+			"pipeline":{
+				"enable":true,
+				"parse_workers":3,
+				"transform_workers":2
+			},
+			"reader":{
+				"mode":"file",
+				"meta_path":"` + filepath.Join(dir, "meta") + `",
+				"log_path":"` + logPath + `",
+				"read_from":"oldest"
+			},
+			"parser":{
+				"name":"testraw",
+				"type":"raw",
+				"timestamp":"false"
+			},
+			"senders":[{
+				"name":"discard_sender",
+				"sender_type":"discard"
+			}]
+		}`
+	rc := RunnerConfig{}
+	assert.NoError(t, jsoniter.Unmarshal([]byte(config), &rc))
+	rr, err := NewLogExportRunner(rc, make(chan cleaner.CleanSignal), reader.NewRegistry(), parser.NewRegistry(), sender.NewRegistry())
+	assert.NoError(t, err)
+	assert.NotNil(t, rr.pipeline)
+	// file reader 支持 ack，流水线只保存连续确认的读取位置
+	assert.NotNil(t, rr.ack)
+	replacer := &mutate.Replacer{StageTime: transforms.StageAfterParser, Key: "raw", Old: "\n", New: ""}
+	assert.NoError(t, replacer.Init())
+	// StageBeforeParser 的 transformer 在读取的 goroutine 中执行，不与多个解析 goroutine 并发
+	rawReplacer := &mutate.Replacer{StageTime: transforms.StageBeforeParser, Old: "b", New: "B"}
+	assert.NoError(t, rawReplacer.Init())
+	rr.transformers = []transforms.Transformer{rawReplacer, replacer}
+	rr.pipeline = newPipeline(*rc.Pipeline, rr.parser, len(rr.transformers), rc.SyncEvery)
+	fast := &linesSender{}
+	slow := &blockingSender{release: make(chan struct{})}
+	rr.senders = []sender.Sender{fast, slow}
+	go rr.Run()
+
+	exp := []interface{}{"a", "B", "c"}
+	for i := 0; i < 50 && len(fast.Lines()) < len(exp); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	// 慢的 sender 不影响其他 sender
+	assert.Equal(t, exp, fast.Lines())
+	assert.Empty(t, slow.Lines())
+	// 有 sender 没有确认时不同步 meta
+	time.Sleep(1500 * time.Millisecond)
+	_, offset, _ := rr.meta.ReadOffset()
+	assert.Equal(t, int64(0), offset)
+
+	close(slow.release)
+	for i := 0; i < 50 && len(slow.Lines()) < len(exp); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, exp, slow.Lines())
+	for i := 0; i < 30 && offset == 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+		_, offset, _ = rr.meta.ReadOffset()
+	}
+	assert.Equal(t, int64(6), offset)
+	rr.Stop()
+
+	info := rc.RunnerInfo
+	info.SendRaw = true
+	_, err = NewLogExportRunnerWithService(info, rr.reader, nil, rr.parser, nil, rr.senders, nil, rr.meta)
+	assert.Equal(t, errPipelineSendRaw, err)
+}
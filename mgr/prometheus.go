@@ -0,0 +1,228 @@
+package mgr
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/json-iterator/go"
+	"github.com/labstack/echo"
+	"github.com/qiniu/log"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	PrometheusPath        = "/metrics"
+	prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+	promCounter = "counter"
+	promGauge   = "gauge"
+)
+
+type promSample struct {
+	labels []string // k1, v1, k2, v2 ...
+	value  float64
+}
+
+type promMetric struct {
+	name    string
+	help    string
+	typ     string
+	samples []promSample
+}
+
+// promRegistry 按 Prometheus text format 输出指标，同名指标的所有样本会被写在一起
+type promRegistry struct {
+	metrics map[string]*promMetric
+	names   []string
+}
+
+func newPromRegistry() *promRegistry {
+	return &promRegistry{metrics: make(map[string]*promMetric)}
+}
+
+func (p *promRegistry) add(name, typ, help string, value float64, labels ...string) {
+	m, ok := p.metrics[name]
+	if !ok {
+		m = &promMetric{name: name, help: help, typ: typ}
+		p.metrics[name] = m
+		p.names = append(p.names, name)
+	}
+	m.samples = append(m.samples, promSample{labels: labels, value: value})
+}
+
+func (p *promRegistry) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	for _, name := range p.names {
+		m := p.metrics[name]
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		for _, s := range m.samples {
+			buf.WriteString(m.name)
+			if len(s.labels) > 0 {
+				buf.WriteByte('{')
+				for i := 0; i+1 < len(s.labels); i += 2 {
+					if i > 0 {
+						buf.WriteByte(',')
+					}
+					buf.WriteString(s.labels[i])
+					buf.WriteString(`="`)
+					buf.WriteString(escapePromLabel(s.labels[i+1]))
+					buf.WriteByte('"')
+				}
+				buf.WriteByte('}')
+			}
+			buf.WriteByte(' ')
+			buf.WriteString(strconv.FormatFloat(s.value, 'g', -1, 64))
+			buf.WriteByte('\n')
+		}
+	}
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+var promLabelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapePromLabel(v string) string {
+	return promLabelReplacer.Replace(v)
+}
+
+// withLabels 返回新的标签切片，避免多个样本共享底层数组
+func withLabels(labels []string, kv ...string) []string {
+	ret := make([]string, 0, len(labels)+len(kv))
+	ret = append(ret, labels...)
+	return append(ret, kv...)
+}
+
+func addStatsMetrics(p *promRegistry, component string, stats StatsInfo, labels []string) {
+	name := "logkit_" + component + "_records_total"
+	help := "Number of records processed by the " + component + "."
+	p.add(name, promCounter, help, float64(stats.Success), withLabels(labels, "result", "success")...)
+	p.add(name, promCounter, help, float64(stats.Errors), withLabels(labels, "result", "error")...)
+}
+
+// addRunnerMetrics 将 runner 的状态转换为 Prometheus 指标，extra 为所有样本额外附加的标签
+func addRunnerMetrics(p *promRegistry, rss map[string]RunnerStatus, extra ...string) {
+	names := make([]string, 0, len(rss))
+	for name := range rss {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rs := rss[name]
+		labels := withLabels([]string{"runner", name}, extra...)
+		running := 0.0
+		if rs.RunningStatus == RunnerRunning {
+			running = 1
+		}
+		p.add("logkit_runner_running", promGauge, "Whether the runner is running (1) or stopped (0).", running, labels...)
+		p.add("logkit_runner_read_bytes_total", promCounter, "Bytes read by the runner.", float64(rs.ReadDataSize), labels...)
+		p.add("logkit_runner_read_lines_total", promCounter, "Lines read by the runner.", float64(rs.ReadDataCount), labels...)
+		// ReadSpeedKB 由 ReadDataSize 计算，实际单位为字节
+		p.add("logkit_runner_read_speed_bytes", promGauge, "Recent read speed of the runner in bytes per second.", float64(rs.ReadSpeedKB), labels...)
+		p.add("logkit_runner_read_speed_lines", promGauge, "Recent read speed of the runner in lines per second.", float64(rs.ReadSpeed), labels...)
+		p.add("logkit_runner_elapsed_seconds_total", promCounter, "Seconds the runner has been running.", rs.Elaspedtime, labels...)
+		p.add("logkit_runner_lag", promGauge, "Data not yet read by the runner.", float64(rs.Lag.Size), withLabels(labels, "unit", rs.Lag.SizeUnit)...)
+		p.add("logkit_runner_lag_total", promGauge, "Total size of the data source of the runner.", float64(rs.Lag.Total), labels...)
+		p.add("logkit_runner_ft_queue_depth", promGauge, "Batches waiting in the fault tolerant sender queues.", float64(rs.Lag.Ftlags), labels...)
+		p.add("logkit_runner_ingest_queue_depth", promGauge, "Batches waiting in the ingest queue between reader and parser.", float64(rs.IngestQueueDepth), labels...)
+		p.add("logkit_runner_ingest_queue_dropped_lines_total", promCounter, "Lines dropped by the overflow policy of the ingest queue.", float64(rs.IngestQueueDropped), labels...)
+
+		addStatsMetrics(p, "reader", rs.ReaderStats, labels)
+		addStatsMetrics(p, "parser", rs.ParserStats, labels)
+
+		transformNames := make([]string, 0, len(rs.TransformStats))
+		for tn := range rs.TransformStats {
+			transformNames = append(transformNames, tn)
+		}
+		sort.Strings(transformNames)
+		for _, tn := range transformNames {
+			tp, idx := tn, ""
+			if i := strings.LastIndex(tn, "-"); i > 0 {
+				tp, idx = tn[:i], tn[i+1:]
+			}
+			addStatsMetrics(p, "transformer", rs.TransformStats[tn], withLabels(labels, "transformer", tp, "index", idx))
+		}
+
+		senderNames := make([]string, 0, len(rs.SenderStats))
+		for sn := range rs.SenderStats {
+			senderNames = append(senderNames, sn)
+		}
+		sort.Strings(senderNames)
+		for _, sn := range senderNames {
+			addStatsMetrics(p, "sender", rs.SenderStats[sn], withLabels(labels, "sender", sn))
+		}
+	}
+}
+
+// GET /metrics 以 Prometheus text format 暴露所有 runner 的统计数据，集群模式下 master 同时暴露所有 slave 的数据
+func (rs *RestService) GetPrometheusMetrics() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		p := newPromRegistry()
+		if rs.cluster.Enable {
+			addRunnerMetrics(p, rs.mgr.Status(), "url", rs.cluster.Address, "tag", rs.cluster.Tag)
+		} else {
+			addRunnerMetrics(p, rs.mgr.Status())
+		}
+		if rs.cluster.Enable && rs.cluster.IsMaster {
+			rs.addSlavesMetrics(p)
+		}
+		c.Response().Header().Set(echo.HeaderContentType, prometheusContentType)
+		c.Response().WriteHeader(http.StatusOK)
+		_, err := p.WriteTo(c.Response())
+		return err
+	}
+}
+
+func (rs *RestService) addSlavesMetrics(p *promRegistry) {
+	rs.cluster.UpdateSlaveStatus()
+	rs.cluster.mutex.RLock()
+	slaves, _ := getQualifySlaves(rs.cluster.slaves, "", "")
+	rs.cluster.mutex.RUnlock()
+
+	mutex := new(sync.Mutex)
+	wg := new(sync.WaitGroup)
+	allStatus := make(map[string]map[string]RunnerStatus)
+	for _, v := range slaves {
+		if v.Status != StatusOK || v.Url == rs.cluster.Address {
+			continue
+		}
+		wg.Add(1)
+		go func(v Slave) {
+			defer wg.Done()
+			url := fmt.Sprintf("%v%v/status", v.Url, PREFIX)
+			respCode, respBody, err := rs.cluster.executeToOneCluster(url, http.MethodGet, []byte{})
+			if err != nil || respCode != http.StatusOK {
+				log.Errorf("get slave(tag='%v', url='%v') status for metrics failed, resp is %v, error is %v", v.Tag, v.Url, string(respBody), err)
+				return
+			}
+			var respRss respRunnerStatus
+			if err = jsoniter.Unmarshal(respBody, &respRss); err != nil {
+				log.Errorf("unmarshal slave(tag='%v', url='%v') status for metrics failed, error is %v", v.Tag, v.Url, err)
+				return
+			}
+			mutex.Lock()
+			allStatus[v.Url] = respRss.Data
+			mutex.Unlock()
+		}(v)
+	}
+	wg.Wait()
+
+	urls := make([]string, 0, len(allStatus))
+	for url := range allStatus {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	tags := make(map[string]string, len(slaves))
+	for _, v := range slaves {
+		tags[v.Url] = v.Tag
+	}
+	for _, url := range urls {
+		addRunnerMetrics(p, allStatus[url], "url", url, "tag", tags[url])
+	}
+}
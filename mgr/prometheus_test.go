@@ -0,0 +1,100 @@
+package mgr
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/transforms"
+	"github.com/qiniu/logkit/transforms/mutate"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestAddRunnerMetrics(t *testing.T) {
+	rss := map[string]RunnerStatus{
+		"runner2": {
+			Name:          "runner2",
+			RunningStatus: RunnerStopped,
+		},
+		"runner1": {
+			Name:               "runner1",
+			RunningStatus:      RunnerRunning,
+			ReadDataSize:       1024,
+			ReadDataCount:      10,
+			ReadSpeedKB:        2048,
+			Lag:                LagInfo{Size: 20, SizeUnit: "bytes", Ftlags: 3},
+			IngestQueueDepth:   5,
+			IngestQueueDropped: 100,
+			ReaderStats:        StatsInfo{Success: 10},
+			ParserStats:        StatsInfo{Success: 9, Errors: 1},
+			TransformStats: map[string]StatsInfo{
+				"ip-0":      {Success: 8, Errors: 1},
+				"replace-1": {Success: 9},
+			},
+			SenderStats: map[string]StatsInfo{
+				"pandora_sender": {Success: 7, Errors: 2},
+			},
+		},
+	}
+	p := newPromRegistry()
+	addRunnerMetrics(p, rss, "tag", `a"b`)
+	var buf bytes.Buffer
+	_, err := p.WriteTo(&buf)
+	assert.NoError(t, err)
+	out := buf.String()
+
+	expLines := []string{
+		"# TYPE logkit_runner_running gauge",
+		`logkit_runner_running{runner="runner1",tag="a\"b"} 1`,
+		`logkit_runner_running{runner="runner2",tag="a\"b"} 0`,
+		"# TYPE logkit_runner_read_bytes_total counter",
+		`logkit_runner_read_bytes_total{runner="runner1",tag="a\"b"} 1024`,
+		"# HELP logkit_runner_read_speed_bytes Recent read speed of the runner in bytes per second.",
+		`logkit_runner_read_speed_bytes{runner="runner1",tag="a\"b"} 2048`,
+		`logkit_runner_lag{runner="runner1",tag="a\"b",unit="bytes"} 20`,
+		`logkit_runner_ft_queue_depth{runner="runner1",tag="a\"b"} 3`,
+		`logkit_runner_ingest_queue_depth{runner="runner1",tag="a\"b"} 5`,
+		`logkit_runner_ingest_queue_dropped_lines_total{runner="runner1",tag="a\"b"} 100`,
+		`logkit_parser_records_total{runner="runner1",tag="a\"b",result="error"} 1`,
+		`logkit_transformer_records_total{runner="runner1",tag="a\"b",transformer="ip",index="0",result="success"} 8`,
+		`logkit_transformer_records_total{runner="runner1",tag="a\"b",transformer="replace",index="1",result="success"} 9`,
+		`logkit_sender_records_total{runner="runner1",tag="a\"b",sender="pandora_sender",result="error"} 2`,
+	}
+	for _, line := range expLines {
+		assert.Contains(t, out, line+"\n")
+	}
+	// 同名指标只输出一次 HELP 和 TYPE
+	assert.Equal(t, 1, strings.Count(out, "# TYPE logkit_sender_records_total counter"))
+}
+
+func TestTransformerMetricsOnePerTransformer(t *testing.T) {
+	r1 := &mutate.Replacer{StageTime: transforms.StageAfterParser, Key: "a", Old: "x", New: "y"}
+	assert.NoError(t, r1.Init())
+	r2 := &mutate.Replacer{StageTime: transforms.StageAfterParser, Key: "a", Old: "y", New: "z"}
+	assert.NoError(t, r2.Init())
+	r := &LogExportRunner{
+		rsMutex:      new(sync.RWMutex),
+		rs:           &RunnerStatus{TransformStats: map[string]StatsInfo{}},
+		lastRs:       &RunnerStatus{TransformStats: map[string]StatsInfo{}},
+		historyError: &ErrorsList{},
+		transformers: []transforms.Transformer{r1, r2},
+	}
+	datas := r.transform([]Data{{"a": "x"}}, nil)
+	assert.Equal(t, []Data{{"a": "z"}}, datas)
+	assert.Len(t, r.rs.TransformStats, 2)
+
+	p := newPromRegistry()
+	addRunnerMetrics(p, map[string]RunnerStatus{"runner1": *r.rs})
+	var buf bytes.Buffer
+	_, err := p.WriteTo(&buf)
+	assert.NoError(t, err)
+	out := buf.String()
+	// 每个 transformer 只有一组 success/error 序列
+	assert.Equal(t, 4, strings.Count(out, "logkit_transformer_records_total{"))
+	assert.Contains(t, out, `logkit_transformer_records_total{runner="runner1",transformer="replace",index="0",result="success"} 1`+"\n")
+	assert.Contains(t, out, `logkit_transformer_records_total{runner="runner1",transformer="replace",index="1",result="success"} 1`+"\n")
+	assert.NotContains(t, out, `index=""`)
+}
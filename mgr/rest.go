@@ -1,6 +1,7 @@
 package mgr
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -34,110 +35,150 @@ type RestService struct {
 	mgr     *Manager
 	l       net.Listener
 	cluster *Cluster
+	auth    *Authenticator
+	certs   *certReloader
 	address string
 }
 
 func NewRestService(mgr *Manager, router *echo.Echo) *RestService {
+	var (
+		certs *certReloader
+		err   error
+	)
+	if mgr.TLS.Enabled() {
+		if certs, err = newCertReloader(mgr.TLS); err != nil {
+			log.Fatalf("init rest service tls error %v", err)
+		}
+	}
 	if mgr.Cluster.Enable {
 		if !mgr.Cluster.IsMaster && len(mgr.Cluster.MasterUrl) < 1 {
 			log.Fatalf("cluster is enabled but master url is empty")
 		}
 		for i := range mgr.Cluster.MasterUrl {
-			if !strings.HasPrefix(mgr.Cluster.MasterUrl[i], "http://") {
-				mgr.Cluster.MasterUrl[i] = "http://" + mgr.Cluster.MasterUrl[i]
+			if certs != nil {
+				mgr.Cluster.MasterUrl[i] = clusterPeerURL(mgr.Cluster.MasterUrl[i])
+				continue
 			}
+			if strings.HasPrefix(mgr.Cluster.MasterUrl[i], "http://") || strings.HasPrefix(mgr.Cluster.MasterUrl[i], "https://") {
+				continue
+			}
+			mgr.Cluster.MasterUrl[i] = "http://" + mgr.Cluster.MasterUrl[i]
+		}
+		if certs != nil && len(mgr.TLS.ClusterCAFile) < 1 {
+			log.Fatalf("cluster is enabled with tls but cluster_ca_file is empty")
 		}
 	}
 
+	auth, err := NewAuthenticator(mgr.Auth, mgr.Cluster.AuthToken)
+	if err != nil {
+		log.Fatalf("init rest service authenticator error %v", err)
+	}
 	rs := &RestService{
 		mgr:     mgr,
 		cluster: NewCluster(&mgr.Cluster),
+		auth:    auth,
+		certs:   certs,
 	}
 	rs.cluster.mutex = new(sync.RWMutex)
-	router.GET(PREFIX+"/status", rs.Status())
+	if certs != nil {
+		rs.cluster.setHTTPClient(certs.ClusterClient())
+		certs.onReload = func() {
+			rs.cluster.setHTTPClient(certs.ClusterClient())
+		}
+		go certs.Run(TLSReloadInterval)
+	}
+	readOnly := auth.Require(RoleReadOnly)
+	operator := auth.Require(RoleOperator)
+	admin := auth.Require(RoleAdmin)
+	clusterPeer := rs.requireClusterPeer
+
+	router.GET(PREFIX+"/status", rs.Status(), readOnly)
 
 	// 获取历史 errors API
-	router.GET(PREFIX+"/errors", rs.GetErrors())
-	router.GET(PREFIX+"/errors/:name", rs.GetError())
+	router.GET(PREFIX+"/errors", rs.GetErrors(), readOnly)
+	router.GET(PREFIX+"/errors/:name", rs.GetError(), readOnly)
 
 	// error code humanize
-	router.GET(PREFIX+"/errorcode", rs.GetErrorCodeHumanize())
+	router.GET(PREFIX+"/errorcode", rs.GetErrorCodeHumanize(), readOnly)
 
 	//configs API
-	router.GET(PREFIX+"/configs", rs.GetConfigs())
-	router.GET(PREFIX+"/configs/:name", rs.GetConfig())
-	router.POST(PREFIX+"/configs/:name", rs.PostConfig())
-	router.POST(PREFIX+"/configs/:name/stop", rs.PostConfigStop())
-	router.POST(PREFIX+"/configs/:name/start", rs.PostConfigStart())
-	router.POST(PREFIX+"/configs/:name/reset", rs.PostConfigReset())
-	router.PUT(PREFIX+"/configs/:name", rs.PutConfig())
-	router.DELETE(PREFIX+"/configs/:name", rs.DeleteConfig())
+	router.GET(PREFIX+"/configs", rs.GetConfigs(), readOnly)
+	router.GET(PREFIX+"/configs/:name", rs.GetConfig(), readOnly)
+	router.POST(PREFIX+"/configs/:name", rs.PostConfig(), admin)
+	router.POST(PREFIX+"/configs/:name/stop", rs.PostConfigStop(), operator)
+	router.POST(PREFIX+"/configs/:name/start", rs.PostConfigStart(), operator)
+	router.POST(PREFIX+"/configs/:name/reset", rs.PostConfigReset(), operator)
+	router.POST(PREFIX+"/configs/:name/replay", rs.PostConfigReplay(), admin)
+	router.PUT(PREFIX+"/configs/:name", rs.PutConfig(), admin)
+	router.DELETE(PREFIX+"/configs/:name", rs.DeleteConfig(), admin)
 
 	// runners API
-	router.GET(PREFIX+"/runners", rs.GetRunners())
+	router.GET(PREFIX+"/runners", rs.GetRunners(), readOnly)
 
 	//reader API
-	router.GET(PREFIX+"/reader/usages", rs.GetReaderUsages())
-	router.GET(PREFIX+"/reader/tooltips", rs.GetReaderTooltips())
-	router.GET(PREFIX+"/reader/options", rs.GetReaderKeyOptions())
-	router.POST(PREFIX+"/reader/read", rs.PostRead())
-	router.POST(PREFIX+"/reader/check", rs.PostReaderCheck())
+	router.GET(PREFIX+"/reader/usages", rs.GetReaderUsages(), readOnly)
+	router.GET(PREFIX+"/reader/tooltips", rs.GetReaderTooltips(), readOnly)
+	router.GET(PREFIX+"/reader/options", rs.GetReaderKeyOptions(), readOnly)
+	router.POST(PREFIX+"/reader/read", rs.PostRead(), admin)
+	router.POST(PREFIX+"/reader/check", rs.PostReaderCheck(), operator)
 
 	//parser API
-	router.GET(PREFIX+"/parser/usages", rs.GetParserUsages())
-	router.GET(PREFIX+"/parser/tooltips", rs.GetParserTooltips())
-	router.GET(PREFIX+"/parser/options", rs.GetParserKeyOptions())
-	router.POST(PREFIX+"/parser/parse", rs.PostParse())
-	router.GET(PREFIX+"/parser/samplelogs", rs.GetParserSampleLogs())
-	router.POST(PREFIX+"/parser/check", rs.PostParserCheck())
+	router.GET(PREFIX+"/parser/usages", rs.GetParserUsages(), readOnly)
+	router.GET(PREFIX+"/parser/tooltips", rs.GetParserTooltips(), readOnly)
+	router.GET(PREFIX+"/parser/options", rs.GetParserKeyOptions(), readOnly)
+	router.POST(PREFIX+"/parser/parse", rs.PostParse(), operator)
+	router.GET(PREFIX+"/parser/samplelogs", rs.GetParserSampleLogs(), readOnly)
+	router.POST(PREFIX+"/parser/check", rs.PostParserCheck(), operator)
 
 	//transformer API
-	router.GET(PREFIX+"/transformer/usages", rs.GetTransformerUsages())
-	router.GET(PREFIX+"/transformer/options", rs.GetTransformerOptions())
-	router.GET(PREFIX+"/transformer/sampleconfigs", rs.GetTransformerSampleConfigs())
-	router.POST(PREFIX+"/transformer/transform", rs.PostTransform())
-	router.POST(PREFIX+"/transformer/check", rs.PostTransformerCheck())
+	router.GET(PREFIX+"/transformer/usages", rs.GetTransformerUsages(), readOnly)
+	router.GET(PREFIX+"/transformer/options", rs.GetTransformerOptions(), readOnly)
+	router.GET(PREFIX+"/transformer/sampleconfigs", rs.GetTransformerSampleConfigs(), readOnly)
+	router.POST(PREFIX+"/transformer/transform", rs.PostTransform(), admin)
+	router.POST(PREFIX+"/transformer/check", rs.PostTransformerCheck(), operator)
 
 	//sender API
-	router.GET(PREFIX+"/sender/usages", rs.GetSenderUsages())
-	router.GET(PREFIX+"/sender/options", rs.GetSenderKeyOptions())
-	router.POST(PREFIX+"/sender/send", rs.PostSend())
-	router.POST(PREFIX+"/sender/check", rs.PostSenderCheck())
-	router.GET(PREFIX+"/sender/router/usage", rs.GetSenderRouterUsage())
-	router.GET(PREFIX+"/sender/router/option", rs.GetSenderRouterOption())
+	router.GET(PREFIX+"/sender/usages", rs.GetSenderUsages(), readOnly)
+	router.GET(PREFIX+"/sender/options", rs.GetSenderKeyOptions(), readOnly)
+	router.POST(PREFIX+"/sender/send", rs.PostSend(), admin)
+	router.POST(PREFIX+"/sender/check", rs.PostSenderCheck(), operator)
+	router.GET(PREFIX+"/sender/router/usage", rs.GetSenderRouterUsage(), readOnly)
+	router.GET(PREFIX+"/sender/router/option", rs.GetSenderRouterOption(), readOnly)
 
 	//metric API
-	router.GET(PREFIX+"/metric/keys", rs.GetMetricKeys())
-	router.GET(PREFIX+"/metric/usages", rs.GetMetricUsages())
-	router.GET(PREFIX+"/metric/options", rs.GetMetricOptions())
+	router.GET(PREFIX+"/metric/keys", rs.GetMetricKeys(), readOnly)
+	router.GET(PREFIX+"/metric/usages", rs.GetMetricUsages(), readOnly)
+	router.GET(PREFIX+"/metric/options", rs.GetMetricOptions(), readOnly)
 
 	//version
-	router.GET(PREFIX+"/version", rs.GetVersion())
+	router.GET(PREFIX+"/version", rs.GetVersion(), readOnly)
+
+	// prometheus metrics
+	router.GET(PrometheusPath, rs.GetPrometheusMetrics(), readOnly)
 
 	//cluster API
-	router.GET(PREFIX+"/cluster/ping", rs.Ping())
-	router.GET(PREFIX+"/cluster/ismaster", rs.IsMaster())
-	router.POST(PREFIX+"/cluster/register", rs.PostRegister())
-	router.POST(PREFIX+"/cluster/tag", rs.PostTag())
-	router.GET(PREFIX+"/cluster/slaves", rs.Slaves())
-	router.DELETE(PREFIX+"/cluster/slaves", rs.DeleteSlaves())
-	router.POST(PREFIX+"/cluster/slaves/tag", rs.PostSlaveTag())
-	router.GET(PREFIX+"/cluster/status", rs.ClusterStatus())
-	router.GET(PREFIX+"/cluster/runners", rs.GetClusterRunners())
-	router.GET(PREFIX+"/cluster/configs", rs.GetClusterConfigs())
-	router.GET(PREFIX+"/cluster/configs/:name", rs.GetClusterConfig())
-	router.POST(PREFIX+"/cluster/configs/:name", rs.PostClusterConfig())
-	router.PUT(PREFIX+"/cluster/configs/:name", rs.PutClusterConfig())
-	router.DELETE(PREFIX+"/cluster/configs/:name", rs.DeleteClusterConfig())
-	router.POST(PREFIX+"/cluster/configs/:name/stop", rs.PostClusterConfigStop())
-	router.POST(PREFIX+"/cluster/configs/:name/start", rs.PostClusterConfigStart())
-	router.POST(PREFIX+"/cluster/configs/:name/reset", rs.PostClusterConfigReset())
+	router.GET(PREFIX+"/cluster/ping", rs.Ping(), clusterPeer, readOnly)
+	router.GET(PREFIX+"/cluster/ismaster", rs.IsMaster(), clusterPeer, readOnly)
+	router.POST(PREFIX+"/cluster/register", rs.PostRegister(), clusterPeer, admin)
+	router.POST(PREFIX+"/cluster/tag", rs.PostTag(), clusterPeer, admin)
+	router.GET(PREFIX+"/cluster/slaves", rs.Slaves(), clusterPeer, readOnly)
+	router.DELETE(PREFIX+"/cluster/slaves", rs.DeleteSlaves(), clusterPeer, admin)
+	router.POST(PREFIX+"/cluster/slaves/tag", rs.PostSlaveTag(), clusterPeer, admin)
+	router.GET(PREFIX+"/cluster/status", rs.ClusterStatus(), clusterPeer, readOnly)
+	router.GET(PREFIX+"/cluster/runners", rs.GetClusterRunners(), clusterPeer, readOnly)
+	router.GET(PREFIX+"/cluster/configs", rs.GetClusterConfigs(), clusterPeer, readOnly)
+	router.GET(PREFIX+"/cluster/configs/:name", rs.GetClusterConfig(), clusterPeer, readOnly)
+	router.POST(PREFIX+"/cluster/configs/:name", rs.PostClusterConfig(), clusterPeer, admin)
+	router.PUT(PREFIX+"/cluster/configs/:name", rs.PutClusterConfig(), clusterPeer, admin)
+	router.DELETE(PREFIX+"/cluster/configs/:name", rs.DeleteClusterConfig(), clusterPeer, admin)
+	router.POST(PREFIX+"/cluster/configs/:name/stop", rs.PostClusterConfigStop(), clusterPeer, operator)
+	router.POST(PREFIX+"/cluster/configs/:name/start", rs.PostClusterConfigStart(), clusterPeer, operator)
+	router.POST(PREFIX+"/cluster/configs/:name/reset", rs.PostClusterConfigReset(), clusterPeer, operator)
 
 	var (
 		port       = DEFAULT_PORT
 		address    string
 		listener   net.Listener
-		err        error
 		httpschema = "http://"
 	)
 	if mgr.DisableWeb {
@@ -156,7 +197,12 @@ func NewRestService(mgr *Manager, router *echo.Echo) *RestService {
 		if mgr.BindHost != "" {
 			address, httpschema = RemoveHttpProtocal(mgr.BindHost)
 		}
-		listener, err = httpserve(address, router)
+		var tlsConf *tls.Config
+		if certs != nil {
+			tlsConf = certs.ServerConfig()
+			httpschema = "https://"
+		}
+		listener, err = httpserve(address, router, tlsConf)
 		if err != nil {
 			err = fmt.Errorf("bind address %v for RestService error %v", address, err)
 			if mgr.BindHost != "" {
@@ -172,7 +218,7 @@ func NewRestService(mgr *Manager, router *echo.Echo) *RestService {
 	rs.l = listener
 	log.Infof("successfully start RestService and bind address on %v", address)
 	if !mgr.DisableWeb {
-		err = generateStatsShell(address, PREFIX)
+		err = generateStatsShell(address, PREFIX, certs != nil)
 		if err != nil {
 			log.Warn(err)
 		}
@@ -203,11 +249,15 @@ func GetMySlaveUrl(address, schema string) (uri string, err error) {
 	return schema + host + ":" + port, nil
 }
 
-func generateStatsShell(address, prefix string) (err error) {
+func generateStatsShell(address, prefix string, useTLS bool) (err error) {
 	if strings.HasPrefix(address, ":") {
 		address = fmt.Sprintf("127.0.0.1%v", address)
 	}
 	sh := fmt.Sprintf("#!/bin/bash\ncurl %v%v/status", address, prefix)
+	if useTLS {
+		// 本机访问时地址与证书中的域名通常不一致，跳过证书校验
+		sh = fmt.Sprintf("#!/bin/bash\ncurl -k https://%v%v/status", address, prefix)
+	}
 	err = ioutil.WriteFile(StatsShell, []byte(sh), 0666)
 	if err != nil {
 		err = fmt.Errorf("writefile error %v, address: 127.0.0.1%v%v/status", err, address, prefix)
@@ -396,6 +446,26 @@ func (rs *RestService) PostConfigReset() echo.HandlerFunc {
 	}
 }
 
+// POST /logkit/configs/<name>/replay 将死信数据重新送入 runner
+func (rs *RestService) PostConfigReplay() echo.HandlerFunc {
+	return func(c echo.Context) (err error) {
+		var name string
+		if name = c.Param("name"); name == "" {
+			errMsg := "config name is empty"
+			return RespError(c, http.StatusBadRequest, ErrRunnerReplay, errMsg)
+		}
+		var records []Data
+		if err = c.Bind(&records); err != nil {
+			return RespError(c, http.StatusBadRequest, ErrRunnerReplay, err.Error())
+		}
+		result, err := rs.mgr.ReplayDeadLetter(name, records)
+		if err != nil {
+			return RespError(c, http.StatusBadRequest, ErrRunnerReplay, err.Error())
+		}
+		return RespSuccess(c, result)
+	}
+}
+
 // POST /logkit/configs/<name>/start
 func (rs *RestService) PostConfigStart() echo.HandlerFunc {
 	return func(c echo.Context) (err error) {
@@ -467,6 +537,9 @@ func (rs *RestService) Register() error {
 
 // Stop will stop RestService
 func (rs *RestService) Stop() {
+	if rs.certs != nil {
+		rs.certs.Stop()
+	}
 	if rs.l != nil {
 		if err := rs.l.Close(); err != nil {
 			log.Error("close reset service listener err: ", err)
@@ -492,7 +565,8 @@ func (ln tcpKeepAliveListener) Accept() (c net.Conn, err error) {
 	return tc, nil
 }
 
-func httpserve(addr string, mux http.Handler) (listener net.Listener, err error) {
+// httpserve 在 addr 上启动 http 服务，tlsConf 不为空时以 https 提供服务
+func httpserve(addr string, mux http.Handler, tlsConf *tls.Config) (listener net.Listener, err error) {
 	if addr == "" {
 		addr = ":http"
 	}
@@ -501,9 +575,13 @@ func httpserve(addr string, mux http.Handler) (listener net.Listener, err error)
 		return
 	}
 
-	srv := &http.Server{Addr: addr, Handler: mux}
+	srv := &http.Server{Addr: addr, Handler: mux, TLSConfig: tlsConf}
+	var ln net.Listener = tcpKeepAliveListener{listener.(*net.TCPListener)}
+	if tlsConf != nil {
+		ln = tls.NewListener(ln, tlsConf)
+	}
 	go func() {
-		log.Error(srv.Serve(tcpKeepAliveListener{listener.(*net.TCPListener)}))
+		log.Error(srv.Serve(ln))
 	}()
 	return
 }
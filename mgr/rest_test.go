@@ -334,7 +334,7 @@ func TestWebRest(t *testing.T) {
 }
 
 func Test_generateStatsShell(t *testing.T) {
-	err := generateStatsShell(":4001", "/logkit")
+	err := generateStatsShell(":4001", "/logkit", false)
 	if err != nil {
 		t.Errorf("Test_generateStatsShell fail %v", err)
 	}
@@ -343,6 +343,17 @@ func Test_generateStatsShell(t *testing.T) {
 		t.Error(StatsShell + " not found")
 	}
 	os.Remove(StatsShell)
+
+	err = generateStatsShell(":4001", "/logkit", true)
+	if err != nil {
+		t.Errorf("Test_generateStatsShell fail %v", err)
+	}
+	sh, err := ioutil.ReadFile(StatsShell)
+	if err != nil {
+		t.Error(StatsShell + " not found")
+	}
+	assert.Contains(t, string(sh), "https://127.0.0.1:4001/logkit/status")
+	os.Remove(StatsShell)
 }
 
 func restGetFailedDataStatusTest(p *testParam) {
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"runtime/debug"
 	"strings"
 	"sync"
@@ -50,6 +51,9 @@ const (
 
 	RunnerRunning = "running"
 	RunnerStopped = "stopped"
+
+	// transformStateDir 为 meta 目录下保存 transformer 状态的目录
+	transformStateDir = "transforms"
 )
 
 type Runner interface {
@@ -89,13 +93,21 @@ type LogExportRunner struct {
 	senders      []sender.Sender
 	router       *router.Router
 	transformers []transforms.Transformer
-	historyError *ErrorsList
+	// transformConditions 与 transformers 一一对应，为 nil 表示对所有数据执行
+	transformConditions []*transforms.Condition
+	historyError        *ErrorsList
 
 	rs      *RunnerStatus
 	lastRs  *RunnerStatus
 	rsMutex *sync.RWMutex
 
-	meta *reader.Meta
+	meta       *reader.Meta
+	ingest     *ingestQueue
+	pipeline   *pipeline
+	deadLetter *deadLetter
+	ack        *ackTracker
+
+	transformMux sync.Mutex // 未开启 pipeline 时，重放死信数据与 Run 不同时执行 transformer
 
 	batchLen  int64
 	batchSize int64
@@ -196,6 +208,41 @@ func NewLogExportRunnerWithService(info RunnerInfo, reader reader.Reader, cleane
 	}
 	runner.senders = senders
 	runner.router = router
+	if info.IngestQueue != nil && info.IngestQueue.Enable {
+		if isDataReader(reader) {
+			err = errIngestQueueDataReader
+			return
+		}
+		runner.ingest, err = newIngestQueue(*info.IngestQueue, info.RunnerName, meta.Dir)
+		if err != nil {
+			return
+		}
+	}
+	if info.Pipeline != nil && info.Pipeline.Enable {
+		if info.SendRaw {
+			err = errPipelineSendRaw
+			return
+		}
+		runner.pipeline = newPipeline(*info.Pipeline, parser, len(transformers), info.SyncEvery)
+	}
+	if info.Ack != nil && info.Ack.Enable {
+		if info.SendRaw {
+			err = errAckSendRaw
+			return
+		}
+		if runner.ingest != nil {
+			err = errAckIngestQueue
+			return
+		}
+		if err = enableAck(reader); err != nil {
+			return
+		}
+		runner.ack = newAckTracker(*info.Ack)
+	}
+	// 流水线中的 batch 乱序完成，reader 支持 ack 时记录每个 batch 的读取位置，只保存连续确认的位置，无需等待流水线清空
+	if runner.pipeline != nil && runner.ack == nil && runner.ingest == nil && enableAck(reader) == nil {
+		runner.ack = newAckTracker(AckConfig{})
+	}
 	runner.StatusRestore()
 	return runner, nil
 }
@@ -276,6 +323,13 @@ func NewLogExportRunner(rc RunnerConfig, cleanChan chan<- cleaner.CleanSignal, r
 	if err != nil {
 		return nil, err
 	}
+	conditions, err := createTransformConditions(rc, transformers)
+	if err != nil {
+		return nil, err
+	}
+	if err = setTransformStateDir(transformers, meta.Dir); err != nil {
+		return nil, err
+	}
 	var serverConfigs = make([]map[string]interface{}, 0, len(transformers))
 	for _, transform := range transformers {
 		if serverTransformer, ok := transform.(transforms.ServerTansformer); ok {
@@ -298,6 +352,10 @@ func NewLogExportRunner(rc RunnerConfig, cleanChan chan<- cleaner.CleanSignal, r
 				senderConfig[senderConf.KeyPandoraDescription] = LogkitAutoCreateDescription
 			}
 		}
+		if senderConfig[senderConf.KeySenderType] == senderConf.TypeParquet && rc.ParserConf[config.KeyParserType] == config.TypeCSV {
+			// parquet sender 推断 schema 时优先使用 csv 解析器的字段类型
+			senderConfig[senderConf.InnerParquetTypeHints] = rc.ParserConf[config.KeyCSVSchema]
+		}
 		senderConfig, err := setPandoraServerConfig(senderConfig, serverConfigs)
 		if err != nil {
 			return nil, err
@@ -310,6 +368,7 @@ func NewLogExportRunner(rc RunnerConfig, cleanChan chan<- cleaner.CleanSignal, r
 		delete(rc.SendersConfig[i], senderConf.InnerUserAgent)
 		delete(rc.SendersConfig[i], senderConf.KeyPandoraDescription)
 		delete(rc.SendersConfig[i], senderConf.InnerSendRaw)
+		delete(rc.SendersConfig[i], senderConf.InnerParquetTypeHints)
 	}
 
 	senderCnt := len(senders)
@@ -317,10 +376,24 @@ func NewLogExportRunner(rc RunnerConfig, cleanChan chan<- cleaner.CleanSignal, r
 	if err != nil {
 		return nil, fmt.Errorf("runner %v add sender router error, %v", rc.RunnerName, err)
 	}
+	var dlSender sender.Sender
+	if len(rc.DeadLetter) > 0 {
+		dlSender, err = newDeadLetterSender(rc, sr, meta.FtSaveLogPath())
+		if err != nil {
+			return nil, err
+		}
+	}
 	runner, err = NewLogExportRunnerWithService(runnerInfo, rd, cl, parser, transformers, senders, router, meta)
 	if err != nil {
+		if dlSender != nil {
+			dlSender.Close()
+		}
 		return runner, err
 	}
+	runner.transformConditions = conditions
+	if dlSender != nil {
+		runner.setDeadLetter(dlSender)
+	}
 	if runner.LogAudit {
 		if rc.AuditChan == nil {
 			runner.LogAudit = false
@@ -368,6 +441,55 @@ func createTransformers(rc RunnerConfig) ([]transforms.Transformer, error) {
 	return transformers, nil
 }
 
+// createTransformConditions 解析每个 transformer 配置中的执行条件，条件只能用于 parser 之后的 transformer
+func createTransformConditions(rc RunnerConfig, transformers []transforms.Transformer) ([]*transforms.Condition, error) {
+	conditions := make([]*transforms.Condition, len(transformers))
+	for idx, tConf := range rc.Transforms {
+		if idx >= len(transformers) {
+			break
+		}
+		cond, err := newTransformCondition(tConf)
+		if err != nil {
+			return nil, fmt.Errorf("type %v of transformer %v", transformers[idx].Type(), err)
+		}
+		if cond != nil && transformers[idx].Stage() != transforms.StageAfterParser {
+			return nil, fmt.Errorf("type %v of transformer %v can only be used in stage %v", transformers[idx].Type(), transforms.KeyIf, transforms.StageAfterParser)
+		}
+		conditions[idx] = cond
+	}
+	return conditions, nil
+}
+
+// setTransformStateDir 为需要持久化状态的 transformer 指定 meta 目录下各自的状态目录
+func setTransformStateDir(transformers []transforms.Transformer, metaDir string) error {
+	for idx, t := range transformers {
+		st, ok := t.(transforms.StatefulTransformer)
+		if !ok {
+			continue
+		}
+		dir := filepath.Join(metaDir, transformStateDir, formatTransformName(t.Type(), idx))
+		if err := st.SetStateDir(dir); err != nil {
+			return fmt.Errorf("type %v of transformer set state dir error %v", t.Type(), err)
+		}
+	}
+	return nil
+}
+
+func newTransformCondition(tConf map[string]interface{}) (*transforms.Condition, error) {
+	v, ok := tConf[transforms.KeyIf]
+	if !ok || v == nil {
+		return nil, nil
+	}
+	expr, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("%v %v is not string", transforms.KeyIf, v)
+	}
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+	return transforms.NewCondition(expr)
+}
+
 // trySend 尝试发送数据，如果此时runner退出返回false，其他情况无论是达到最大重试次数还是发送成功，都返回true
 func (r *LogExportRunner) tryRawSend(s sender.Sender, datas []string, times int) bool {
 	if len(datas) <= 0 {
@@ -423,6 +545,7 @@ func (r *LogExportRunner) tryRawSend(s sender.Sender, datas []string, times int)
 		}
 
 		info.LastError = TruncateStrSize(err.Error(), DefaultTruncateMaxSize)
+		r.rsMutex.Lock()
 		if r.historyError.SendErrors == nil {
 			r.historyError.SendErrors = make(map[string]*equeue.ErrorQueue)
 		}
@@ -430,6 +553,7 @@ func (r *LogExportRunner) tryRawSend(s sender.Sender, datas []string, times int)
 			r.historyError.SendErrors[s.Name()] = equeue.New(r.ErrorsListCap)
 		}
 		r.historyError.SendErrors[s.Name()].Put(equeue.NewError(info.LastError))
+		r.rsMutex.Unlock()
 
 		//FaultTolerant Sender 正常的错误会在backupqueue里面记录，自己重试，此处无需重试
 		if se != nil && se.Ft && se.FtNotRetry {
@@ -449,6 +573,7 @@ func (r *LogExportRunner) tryRawSend(s sender.Sender, datas []string, times int)
 
 		if err == ErrQueueClosed {
 			log.Errorf("Runner[%v] send to closed queue, discard datas, send error %v, failed datas (length %v): %v", r.RunnerName, se.Error(), cnt, datas)
+			r.deadLetterSend(s.Name(), nil, datas, err, nil)
 			break
 		}
 		if times <= 0 || cnt < times {
@@ -456,6 +581,7 @@ func (r *LogExportRunner) tryRawSend(s sender.Sender, datas []string, times int)
 			continue
 		}
 		log.Errorf("Runner[%v] retry send %v times, but still error %v, total %v data lines", r.RunnerName, cnt, err, len(datas))
+		r.deadLetterSend(s.Name(), nil, datas, err, nil)
 		break
 	}
 	info.Errors += originDatasLen - successDatasLen
@@ -468,6 +594,12 @@ func (r *LogExportRunner) tryRawSend(s sender.Sender, datas []string, times int)
 
 // trySend 尝试发送数据，如果此时runner退出返回false，其他情况无论是达到最大重试次数还是发送成功，都返回true
 func (r *LogExportRunner) trySend(s sender.Sender, datas []Data, times int) bool {
+	return r.trySendAck(s, datas, times, nil, nil)
+}
+
+// trySendAck 与 trySend 相同，ab 不为 nil 时 AckSender 发送的数据在送达后确认 ab，pos 为死信记录的读取位置
+// 开启 ack 时放弃发送的数据没有写入死信则返回 false，避免确认后读取位置越过丢失的数据
+func (r *LogExportRunner) trySendAck(s sender.Sender, datas []Data, times int, ab *ackBatch, pos reader.Position) bool {
 	if len(datas) <= 0 {
 		return true
 	}
@@ -483,6 +615,7 @@ func (r *LogExportRunner) trySend(s sender.Sender, datas []Data, times int) bool
 		successDatasLen int64
 		originDatasLen  = int64(len(datas))
 		cnt             = 1
+		dropped         bool
 	)
 
 	for {
@@ -490,7 +623,11 @@ func (r *LogExportRunner) trySend(s sender.Sender, datas []Data, times int) bool
 		if cnt > 1 && atomic.LoadInt32(&r.stopped) > 0 {
 			return false
 		}
-		err = s.Send(datas)
+		if as, ok := s.(sender.AckSender); ok && ab != nil {
+			err = as.SendWithAck(datas, ab.add())
+		} else {
+			err = s.Send(datas)
+		}
 		if err == nil {
 			successDatasLen += int64(len(datas))
 			break
@@ -515,6 +652,7 @@ func (r *LogExportRunner) trySend(s sender.Sender, datas []Data, times int) bool
 		}
 
 		info.LastError = TruncateStrSize(err.Error(), DefaultTruncateMaxSize)
+		r.rsMutex.Lock()
 		if r.historyError.SendErrors == nil {
 			r.historyError.SendErrors = make(map[string]*equeue.ErrorQueue)
 		}
@@ -522,6 +660,7 @@ func (r *LogExportRunner) trySend(s sender.Sender, datas []Data, times int) bool
 			r.historyError.SendErrors[s.Name()] = equeue.New(r.ErrorsListCap)
 		}
 		r.historyError.SendErrors[s.Name()].Put(equeue.NewError(info.LastError))
+		r.rsMutex.Unlock()
 
 		//FaultTolerant Sender 正常的错误会在backupqueue里面记录，自己重试，此处无需重试
 		if se != nil && se.Ft && se.FtNotRetry {
@@ -537,6 +676,7 @@ func (r *LogExportRunner) trySend(s sender.Sender, datas []Data, times int) bool
 			}
 			if sendError.ErrorType == sender.TypeMarshalError {
 				log.Errorf("Runner[%v] datas marshal failed, discard datas, send error %v, failed datas (length %v): %v", r.RunnerName, se.Error(), cnt, datas)
+				dropped = !r.deadLetterSend(s.Name(), datas, nil, err, pos)
 				break
 			}
 			log.Errorf("Runner[%v] send error %v for %v times, failed datas length %v will retry send it", r.RunnerName, se.Error(), cnt, len(datas))
@@ -546,6 +686,7 @@ func (r *LogExportRunner) trySend(s sender.Sender, datas []Data, times int) bool
 
 		if err == ErrQueueClosed {
 			log.Errorf("Runner[%v] send to closed queue, discard datas, send error %v, failed datas (length %v): %v", r.RunnerName, se.Error(), cnt, datas)
+			dropped = !r.deadLetterSend(s.Name(), datas, nil, err, pos)
 			break
 		}
 		if times <= 0 || cnt < times {
@@ -553,6 +694,7 @@ func (r *LogExportRunner) trySend(s sender.Sender, datas []Data, times int) bool
 			continue
 		}
 		log.Errorf("Runner[%v] retry send %v times, but still error %v, total %v data lines", r.RunnerName, cnt, err, len(datas))
+		dropped = !r.deadLetterSend(s.Name(), datas, nil, err, pos)
 		break
 	}
 
@@ -561,7 +703,7 @@ func (r *LogExportRunner) trySend(s sender.Sender, datas []Data, times int) bool
 	r.rsMutex.Lock()
 	r.rs.SenderStats[s.Name()] = info
 	r.rsMutex.Unlock()
-	return true
+	return ab == nil || !dropped
 }
 
 func getSampleContent(line string, maxBatchSize int) string {
@@ -615,6 +757,8 @@ func (r *LogExportRunner) readDatas(dr reader.DataReader, dataSourceTag string)
 func (r *LogExportRunner) rawReadLines(dataSourceTag string) (lines, froms []string) {
 	var line string
 	var err error
+	_, isSourceParser := r.parser.(parser.SourceParser)
+	isBinary := isBinaryParser(r.parser)
 	for !r.batchFullOrTimeout() {
 		line, err = r.reader.ReadLine()
 		if os.IsNotExist(err) {
@@ -632,11 +776,13 @@ func (r *LogExportRunner) rawReadLines(dataSourceTag string) (lines, froms []str
 			time.Sleep(1 * time.Second)
 			continue
 		}
-		if strings.TrimSpace(line) == "" {
+		// 二进制消息可能只包含空白字符
+		if !isBinary && strings.TrimSpace(line) == "" {
 			continue
 		}
 		lines = append(lines, line)
-		if dataSourceTag != "" {
+		// 死信数据和按来源解析的 parser 需要记录来源
+		if dataSourceTag != "" || r.deadLetter != nil || isSourceParser {
 			froms = append(froms, r.reader.Source())
 		}
 
@@ -661,10 +807,19 @@ func (r *LogExportRunner) rawReadLines(dataSourceTag string) (lines, froms []str
 	return lines, froms
 }
 
-func (r *LogExportRunner) readLines(dataSourceTag string) []Data {
+// isBinaryParser 返回 parser 是否解析二进制消息
+func isBinaryParser(p parser.Parser) bool {
+	bp, ok := p.(parser.BinaryParser)
+	return ok && bp.Binary()
+}
+
+func (r *LogExportRunner) readLines(lines, froms []string, dataSourceTag string, pos reader.Position) []Data {
+	return r.parseLines(r.rawTransform(lines), froms, dataSourceTag, pos)
+}
+
+// rawTransform 依次执行 StageBeforeParser 阶段的 transformer，开启 pipeline 时只在读取数据的 goroutine 中调用
+func (r *LogExportRunner) rawTransform(lines []string) []string {
 	var err error
-	lines, froms := r.rawReadLines(dataSourceTag)
-	r.tracker.Track("finish rawReadLines")
 	for i := range r.transformers {
 		if r.transformers[i].Stage() == transforms.StageBeforeParser {
 			lines, err = r.transformers[i].RawTransform(lines)
@@ -673,7 +828,12 @@ func (r *LogExportRunner) readLines(dataSourceTag string) []Data {
 			}
 		}
 	}
+	return lines
+}
 
+// parseLines 解析已经过 rawTransform 的数据，开启 pipeline 时由多个解析 goroutine 并发调用，pos 为死信记录的读取位置
+func (r *LogExportRunner) parseLines(lines, froms []string, dataSourceTag string, pos reader.Position) []Data {
+	var err error
 	curTimeStr := time.Now().Format("2006-01-02 15:04:05.999")
 
 	if len(lines) <= 0 {
@@ -688,13 +848,21 @@ func (r *LogExportRunner) readLines(dataSourceTag string) []Data {
 
 	// parse data
 	var numErrs int64
-	datas, err := r.parser.Parse(lines)
-	r.tracker.Track("finish parse data")
+	var datas []Data
+	if sp, ok := r.parser.(parser.SourceParser); ok && len(froms) == len(lines) {
+		datas, err = sp.ParseWithSource(lines, froms)
+	} else {
+		datas, err = r.parser.Parse(lines)
+	}
 	se, ok := err.(*StatsError)
 	r.rsMutex.Lock()
 	if ok {
 		numErrs = se.Errors
-		err = errors.New(se.LastError)
+		// SourceParser 没有错误时也会返回 StatsError 传递 DatasourceSkipIndex
+		err = nil
+		if se.Errors > 0 {
+			err = errors.New(se.LastError)
+		}
 		r.rs.ParserStats.Errors += se.Errors
 		r.rs.ParserStats.Success += se.Success
 	} else if err != nil {
@@ -719,11 +887,16 @@ func (r *LogExportRunner) readLines(dataSourceTag string) []Data {
 
 	// send data
 	if len(datas) <= 0 {
+		r.deadLetterParse(lines, froms, se, err, datas, dataSourceTag, pos)
 		log.Debugf("Runner[%v] received parsed data length = 0", r.Name())
 		return []Data{}
 	}
 
-	tags := r.meta.GetTags()
+	// 复制一份 tags，避免修改 meta 中的 tags
+	tags := make(map[string]interface{}, len(r.meta.GetTags())+1)
+	for k, v := range r.meta.GetTags() {
+		tags[k] = v
+	}
 	if r.ExtraInfo {
 		tags = MergeEnvTags(r.EnvTag, tags)
 	}
@@ -747,7 +920,7 @@ func (r *LogExportRunner) readLines(dataSourceTag string) []Data {
 			log.Errorf("Runner[%v] datasourcetag add error, datas(TOTAL %v), datasourceSkipIndex(TOTAL %v) not match with froms(TOTAL %v)", r.Name(), len(datas), selen, len(froms))
 		}
 	}
-	return datas
+	return r.deadLetterParse(lines, froms, se, err, datas, dataSourceTag, pos)
 }
 
 func (r *LogExportRunner) addResetStat() {
@@ -762,14 +935,45 @@ func (r *LogExportRunner) addResetStat() {
 	r.lastSend = time.Now()
 }
 
-func (r *LogExportRunner) syncAndLog(batchlen, batchSize, sendDataLen int64) {
+// syncMeta 每 sync_every 个 batch 同步一次 reader 的 meta，返回本次是否同步
+func (r *LogExportRunner) syncMeta() bool {
 	if r.SyncEvery > 0 {
 		r.syncInc = (r.syncInc + 1) % r.SyncEvery
 		if r.syncInc == 0 {
 			r.reader.SyncMeta()
+			return true
 		}
 	}
+	return false
+}
 
+func (r *LogExportRunner) syncAndLog(batchlen, batchSize, sendDataLen int64) {
+	// 开启 ingest queue 时由 ingestLoop 在数据进入队列后同步 meta，开启 ack 时由 commitAck 保存读取位置
+	if r.ingest == nil && r.ack == nil {
+		if r.syncMeta() {
+			r.saveTransformState(false)
+		}
+	} else if r.ingest != nil {
+		// 从队列中取出的数据不会再次读取，发送成功后即可保存 transformer 的状态
+		r.saveTransformState(false)
+	}
+	r.auditLog(batchlen, batchSize, sendDataLen)
+}
+
+// saveTransformState 保存 CheckpointTransformer 的状态，调用时已经转换的数据都已发送成功并保存了读取位置
+func (r *LogExportRunner) saveTransformState(force bool) {
+	for _, t := range r.transformers {
+		ct, ok := t.(transforms.CheckpointTransformer)
+		if !ok {
+			continue
+		}
+		if err := ct.SaveState(force); err != nil {
+			log.Errorf("Runner[%v] save transformer %v state error: %v", r.Name(), t.Type(), err)
+		}
+	}
+}
+
+func (r *LogExportRunner) auditLog(batchlen, batchSize, sendDataLen int64) {
 	//审计日志发送选项开启并且runner在运行
 	if r.LogAudit && atomic.LoadInt32(&r.stopped) <= 0 {
 		var lag int64
@@ -803,6 +1007,7 @@ func (r *LogExportRunner) Run() {
 	if r.cleaner != nil {
 		go r.cleaner.Run()
 	}
+	r.startIngest()
 	defer close(r.exitChan)
 	defer func() {
 		// recover when runner is stopped
@@ -814,21 +1019,29 @@ func (r *LogExportRunner) Run() {
 		}
 	}()
 
+	if r.pipeline != nil {
+		r.runPipeline()
+		r.exitRun()
+		return
+	}
+	stopped := func() bool {
+		return atomic.LoadInt32(&r.stopped) > 0
+	}
 	for {
-		if atomic.LoadInt32(&r.stopped) > 0 {
-			log.Debugf("Runner[%v] exited from run", r.Name())
-			r.reader.SyncMeta()
-			if atomic.LoadInt32(&r.stopped) < 2 {
-				r.exitChan <- struct{}{}
-			}
+		if stopped() {
+			r.exitRun()
 			return
 		}
+		if r.ack != nil {
+			r.commitAck(false)
+			if !r.ack.wait(stopped) {
+				continue
+			}
+		}
 		r.tracker.Reset()
 		if r.SendRaw {
-			lines, _ := r.rawReadLines(r.meta.GetDataSourceTag())
+			lines, _, batchLen, batchSize := r.nextLines(r.meta.GetDataSourceTag())
 			r.tracker.Track("finish rawReadLines")
-			batchLen, batchSize := r.batchLen, r.batchSize
-			r.addResetStat()
 			// send data
 			if len(lines) <= 0 {
 				log.Debugf("Runner[%v] received read data length = 0", r.Name())
@@ -853,70 +1066,41 @@ func (r *LogExportRunner) Run() {
 			continue
 		}
 		// read data
-		var err error
 		var datas []Data
+		var batchLen, batchSize int64
+		var ab *ackBatch
+		var pos reader.Position
 		if dr, ok := r.reader.(reader.DataReader); ok {
 			datas = r.readDatas(dr, r.meta.GetDataSourceTag())
 			r.tracker.Track("finish readDatas")
+			batchLen, batchSize = r.batchLen, r.batchSize
+			r.addResetStat()
+			ab = r.beginAck(batchLen)
+			pos = r.batchPosition(ab)
 		} else {
-			datas = r.readLines(r.meta.GetDataSourceTag())
+			var lines, froms []string
+			lines, froms, batchLen, batchSize = r.nextLines(r.meta.GetDataSourceTag())
+			r.tracker.Track("finish rawReadLines")
+			ab = r.beginAck(batchLen)
+			pos = r.batchPosition(ab)
+			datas = r.readLines(lines, froms, r.meta.GetDataSourceTag(), pos)
 			r.tracker.Track("finish readLines")
 		}
-		batchLen, batchSize := r.batchLen, r.batchSize
-		r.addResetStat()
 		if len(datas) <= 0 {
+			ab.done()
+			// 没有新数据时输出 transformer 中已经到期的缓存数据
+			r.sendFlushed(r.flushTransformers(false))
 			continue
 		}
 
-		for i := range r.transformers {
-			if r.transformers[i].Stage() != transforms.StageAfterParser {
-				continue
-			}
-			datas, err = r.transformers[i].Transform(datas)
-			tp := r.transformers[i].Type()
-			r.rsMutex.Lock()
-			tstats, ok := r.rs.TransformStats[formatTransformName(tp, i)]
-			if !ok {
-				tstats = StatsInfo{}
-			}
-			se, ok := err.(*StatsError)
-			if ok {
-				err = errors.New(se.LastError)
-				tstats.Errors += se.Errors
-				tstats.Success += se.Success
-			} else if err != nil {
-				tstats.Errors++
-			} else {
-				tstats.Success++
-			}
-			if err != nil {
-				statesTransformer, ok := r.transformers[i].(transforms.StatsTransformer)
-				if ok {
-					statesTransformer.SetStats(err.Error())
-				}
-				tstats.LastError = TruncateStrSize(err.Error(), DefaultTruncateMaxSize)
-				if r.historyError.TransformErrors == nil {
-					r.historyError.TransformErrors = make(map[string]*equeue.ErrorQueue)
-				}
-				if r.historyError.TransformErrors[tp] == nil {
-					r.historyError.TransformErrors[tp] = equeue.New(r.ErrorsListCap)
-				}
-				r.historyError.TransformErrors[tp].Put(equeue.NewError(tstats.LastError))
-			}
-
-			r.rs.TransformStats[tp] = tstats
-			r.rsMutex.Unlock()
-			if err != nil {
-				log.Errorf("runner[%v]: error %v", r.RunnerName, err)
-			}
-		}
+		datas = r.transform(datas, pos)
 		r.tracker.Track("finish transformers")
 		dataLen := len(datas)
 		log.Debugf("Runner[%v] reader %s start to send at: %v", r.Name(), r.reader.Name(), time.Now().Format(time.RFC3339))
 		success := true
 		senderDataList := classifySenderData(r.senders, datas, r.router)
 		for index, s := range r.senders {
-			if !r.trySend(s, senderDataList[index], r.MaxBatchTryTimes) {
+			if !r.trySendAck(s, senderDataList[index], r.MaxBatchTryTimes, ab, pos) {
 				success = false
 				log.Errorf("Runner[%v] failed to send data finally", r.Name())
 				break
@@ -925,6 +1109,7 @@ func (r *LogExportRunner) Run() {
 		r.tracker.Track("finish Sender")
 
 		if success {
+			ab.done()
 			r.syncAndLog(batchLen, batchSize, int64(dataLen))
 		}
 		log.Debugf("Runner[%v] send %s finish to send at: %v", r.Name(), r.reader.Name(), time.Now().Format(time.RFC3339))
@@ -932,6 +1117,211 @@ func (r *LogExportRunner) Run() {
 	}
 }
 
+// transform 依次执行 StageAfterParser 阶段的 transformer
+func (r *LogExportRunner) transform(datas []Data, pos reader.Position) []Data {
+	return r.transformFrom(datas, 0, pos)
+}
+
+// transformFrom 从第 start 个 transformer 开始执行，出错的数据在开启死信时写入死信，pos 为死信记录的读取位置
+func (r *LogExportRunner) transformFrom(datas []Data, start int, pos reader.Position) []Data {
+	var err error
+	for i := start; i < len(r.transformers); i++ {
+		if r.transformers[i].Stage() != transforms.StageAfterParser {
+			continue
+		}
+		if r.pipeline != nil {
+			r.pipeline.transformLocks[i].Lock()
+		} else {
+			r.transformMux.Lock()
+		}
+		var errIndex []int
+		datas, errIndex, err = transformIf(r.transformCondition(i), datas, func(datas []Data) ([]Data, []int, error) {
+			datas, err := r.transformers[i].Transform(datas)
+			var errIndex []int
+			if et, ok := r.transformers[i].(transforms.ErrorIndexTransformer); ok {
+				errIndex = et.TakeErrorIndex()
+			}
+			return datas, errIndex, err
+		})
+		tp := r.transformers[i].Type()
+		r.rsMutex.Lock()
+		tstats, ok := r.rs.TransformStats[formatTransformName(tp, i)]
+		if !ok {
+			tstats = StatsInfo{}
+		}
+		se, ok := err.(*StatsError)
+		if ok {
+			err = errors.New(se.LastError)
+			tstats.Errors += se.Errors
+			tstats.Success += se.Success
+		} else if err != nil {
+			tstats.Errors++
+		} else {
+			tstats.Success++
+		}
+		if err != nil {
+			statesTransformer, ok := r.transformers[i].(transforms.StatsTransformer)
+			if ok {
+				statesTransformer.SetStats(err.Error())
+			}
+			tstats.LastError = TruncateStrSize(err.Error(), DefaultTruncateMaxSize)
+			if r.historyError.TransformErrors == nil {
+				r.historyError.TransformErrors = make(map[string]*equeue.ErrorQueue)
+			}
+			if r.historyError.TransformErrors[tp] == nil {
+				r.historyError.TransformErrors[tp] = equeue.New(r.ErrorsListCap)
+			}
+			r.historyError.TransformErrors[tp].Put(equeue.NewError(tstats.LastError))
+		}
+
+		r.rs.TransformStats[formatTransformName(tp, i)] = tstats
+		r.rsMutex.Unlock()
+		if r.pipeline != nil {
+			r.pipeline.transformLocks[i].Unlock()
+		} else {
+			r.transformMux.Unlock()
+		}
+		if err != nil {
+			log.Errorf("runner[%v]: error %v", r.RunnerName, err)
+			datas = r.deadLetterTransform(i, errIndex, err, datas, pos)
+		}
+	}
+	return datas
+}
+
+// flushTransformers 调用 FlushableTransformer 的 Flush，输出的数据继续经过之后的 transformer，final 为 true 时输出全部缓存的数据
+func (r *LogExportRunner) flushTransformers(final bool) []Data {
+	var ret []Data
+	for i, t := range r.transformers {
+		ft, ok := t.(transforms.FlushableTransformer)
+		if !ok || t.Stage() != transforms.StageAfterParser {
+			continue
+		}
+		if r.pipeline != nil {
+			r.pipeline.transformLocks[i].Lock()
+		} else {
+			r.transformMux.Lock()
+		}
+		datas, err := ft.Flush(final)
+		if r.pipeline != nil {
+			r.pipeline.transformLocks[i].Unlock()
+		} else {
+			r.transformMux.Unlock()
+		}
+		if err != nil {
+			log.Errorf("Runner[%v] flush transformer %v error: %v", r.Name(), t.Type(), err)
+		}
+		if len(datas) > 0 {
+			ret = append(ret, r.transformFrom(datas, i+1, nil)...)
+		}
+	}
+	return ret
+}
+
+// hasFlushableTransformer 返回是否有需要在没有读取到数据时调用 Flush 的 transformer
+func (r *LogExportRunner) hasFlushableTransformer() bool {
+	for _, t := range r.transformers {
+		if _, ok := t.(transforms.FlushableTransformer); ok && t.Stage() == transforms.StageAfterParser {
+			return true
+		}
+	}
+	return false
+}
+
+// sendFlushed 发送 transformer Flush 输出的数据，这些数据不对应 reader 的读取位置，无需确认
+func (r *LogExportRunner) sendFlushed(datas []Data) {
+	if len(datas) <= 0 {
+		return
+	}
+	senderDataList := classifySenderData(r.senders, datas, r.router)
+	for index, s := range r.senders {
+		if !r.trySend(s, senderDataList[index], r.MaxBatchTryTimes) {
+			log.Errorf("Runner[%v] failed to send flushed data finally", r.Name())
+			return
+		}
+	}
+	r.auditLog(0, 0, int64(len(datas)))
+}
+
+func (r *LogExportRunner) transformCondition(i int) *transforms.Condition {
+	if i < len(r.transformConditions) {
+		return r.transformConditions[i]
+	}
+	return nil
+}
+
+// transformIf 只对满足条件的数据执行 transform，不满足条件的数据原样保留
+// 数据条数不变时处理后的数据放回原来的位置，否则不满足条件的数据排在后面，返回的 errIndex 为合并后的下标
+func transformIf(cond *transforms.Condition, datas []Data, transform func([]Data) ([]Data, []int, error)) ([]Data, []int, error) {
+	if cond == nil {
+		return transform(datas)
+	}
+	index := make([]int, 0, len(datas))
+	for i, data := range datas {
+		if cond.Match(data) {
+			index = append(index, i)
+		}
+	}
+	if len(index) == len(datas) {
+		return transform(datas)
+	}
+	if len(index) == 0 {
+		return datas, nil, nil
+	}
+	matched := make([]Data, len(index))
+	for i, idx := range index {
+		matched[i] = datas[idx]
+	}
+	transformed, errIndex, err := transform(matched)
+	if len(transformed) == len(matched) {
+		merged := make([]Data, len(datas))
+		copy(merged, datas)
+		for i, data := range transformed {
+			merged[index[i]] = data
+		}
+		mergedErrIndex := make([]int, 0, len(errIndex))
+		for _, i := range errIndex {
+			if i >= 0 && i < len(index) {
+				mergedErrIndex = append(mergedErrIndex, index[i])
+			}
+		}
+		return merged, mergedErrIndex, err
+	}
+	merged := make([]Data, 0, len(transformed)+len(datas)-len(index))
+	merged = append(merged, transformed...)
+	for i, j := 0, 0; i < len(datas); i++ {
+		if j < len(index) && index[j] == i {
+			j++
+			continue
+		}
+		merged = append(merged, datas[i])
+	}
+	return merged, errIndex, err
+}
+
+func (r *LogExportRunner) exitRun() {
+	log.Debugf("Runner[%v] exited from run", r.Name())
+	// sender 在 Run 退出之后才关闭，此时发送 transformer 中缓存的全部数据
+	r.sendFlushed(r.flushTransformers(true))
+	if r.ingest != nil {
+		r.stopIngest()
+		if r.pipeline == nil || r.pipeline.synced() {
+			r.saveTransformState(true)
+		}
+	} else if r.ack != nil {
+		r.commitAck(true)
+	} else if r.pipeline != nil && !r.pipeline.synced() {
+		// 有未发送完成或发送失败的 batch 时同步会跳过这些数据，保留上次同步的位置
+		log.Warnf("Runner[%v] pipeline has unsent batches, skip syncing reader meta", r.Name())
+	} else {
+		r.reader.SyncMeta()
+		r.saveTransformState(true)
+	}
+	if atomic.LoadInt32(&r.stopped) < 2 {
+		r.exitChan <- struct{}{}
+	}
+}
+
 func classifySenderData(senders []sender.Sender, datas []Data, router *router.Router) [][]Data {
 	// 只有一个或是最后一个 sender 的时候无所谓数据污染
 	skipCopyAll := len(senders) <= 1
@@ -961,10 +1351,22 @@ func classifySenderData(senders []sender.Sender, datas []Data, router *router.Ro
 		return senderDataList
 	}
 	for _, d := range datas {
-		senderIndex := router.GetSenderIndex(d)
-		senderData := senderDataList[senderIndex]
-		senderData = append(senderData, d)
-		senderDataList[senderIndex] = senderData
+		senderIndexes := router.GetSenderIndexes(d)
+		lastIndex := len(senderIndexes) - 1
+		for i, senderIndex := range senderIndexes {
+			data := d
+			skip := false
+			if ss, ok := senders[senderIndex].(sender.SkipDeepCopySender); ok {
+				skip = ss.SkipDeepCopy()
+			}
+			if !skip && i != lastIndex {
+				// 同一条数据发往多个 sender 时进行深度拷贝，防止数据污染
+				var copiedData Data
+				utils.DeepCopyByJSON(&copiedData, &d)
+				data = copiedData
+			}
+			senderDataList[senderIndex] = append(senderDataList[senderIndex], data)
+		}
 	}
 	return senderDataList
 }
@@ -1036,6 +1438,7 @@ func (r *LogExportRunner) Stop() {
 		log.Errorf("runner %v exited timeout, start to force stop", r.Name())
 		atomic.AddInt32(&r.stopped, 1)
 	}
+	r.stopIngest()
 
 	for _, t := range r.transformers {
 		if c, ok := t.(io.Closer); ok {
@@ -1054,6 +1457,12 @@ func (r *LogExportRunner) Stop() {
 			log.Warnf("Runner[%v] sender %v closed", r.Name(), s.Name())
 		}
 	}
+	// sender 关闭时可能仍有数据写入死信，最后关闭死信 sender
+	if r.deadLetter != nil {
+		if err := r.deadLetter.sender.Close(); err != nil {
+			log.Errorf("Runner[%v] cannot close dead letter sender name: %s, err: %v", r.Name(), r.deadLetter.sender.Name(), err)
+		}
+	}
 
 	if r.cleaner != nil {
 		r.cleaner.Close()
@@ -1192,13 +1601,17 @@ func (r *LogExportRunner) getRefreshStatus(elaspedtime float64) RunnerStatus {
 	if rl != nil {
 		r.rs.Lag = *rl
 	}
+	if r.ingest != nil {
+		r.rs.IngestQueueDepth = r.ingest.Depth()
+		r.rs.IngestQueueDropped = r.ingest.Dropped()
+	}
 
 	r.rs.Elaspedtime += elaspedtime
 	r.rs.lastState = now
 	for i := range r.transformers {
 		newtsts := r.transformers[i].Stats()
 		ttp := r.transformers[i].Type()
-		if oldtsts, ok := r.lastRs.TransformStats[ttp]; ok {
+		if oldtsts, ok := r.lastRs.TransformStats[formatTransformName(ttp, i)]; ok {
 			newtsts.Speed, newtsts.Trend = calcSpeedTrend(oldtsts, newtsts, elaspedtime)
 		} else {
 			newtsts.Speed, newtsts.Trend = calcSpeedTrend(StatsInfo{}, newtsts, elaspedtime)
@@ -1230,6 +1643,9 @@ func (r *LogExportRunner) getRefreshStatus(elaspedtime float64) RunnerStatus {
 	} else {
 		r.rs.ParserStats.Speed, r.rs.ParserStats.Trend = calcSpeedTrend(r.lastRs.ParserStats, r.rs.ParserStats, elaspedtime)
 	}
+	if sp, ok := r.parser.(parser.StatsParser); ok {
+		r.rs.ParserStats.Details = sp.Stats().Details
+	}
 
 	for i := range r.senders {
 		sts, ok := r.senders[i].(sender.StatsSender)
@@ -8,6 +8,7 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -36,6 +37,7 @@ import (
 	_ "github.com/qiniu/logkit/transforms/builtin"
 	"github.com/qiniu/logkit/transforms/ip"
 	"github.com/qiniu/logkit/transforms/mutate"
+	"github.com/qiniu/logkit/transforms/service"
 	"github.com/qiniu/logkit/utils/equeue"
 	. "github.com/qiniu/logkit/utils/models"
 )
@@ -719,7 +721,7 @@ func Test_QiniulogRun(t *testing.T) {
 	}
 	ls, err := runner.LagStats()
 	assert.NoError(t, err)
-	assert.Equal(t, &LagInfo{0, "bytes", 0, 0}, ls)
+	assert.Equal(t, &LagInfo{Size: 0, SizeUnit: "bytes"}, ls)
 }
 
 func TestCreateTransforms(t *testing.T) {
@@ -1254,6 +1256,42 @@ func TestAddDatasourceForErrData(t *testing.T) {
 	assert.Equal(t, exp, gots)
 }
 
+func TestAddDatasourceForContainerParser(t *testing.T) {
+	p, err := parser.NewRegistry().NewLogParser(conf.MapConf{parserConf.KeyParserType: parserConf.TypeContainer})
+	assert.NoError(t, err)
+	sp, ok := p.(parser.SourceParser)
+	assert.True(t, ok)
+
+	// tailx 读取的多个文件中被切分的行交错出现
+	podA := "/var/log/containers/web-1_default_nginx-aaa.log"
+	podB := "/var/log/containers/api-2_prod_app-bbb.log"
+	lines := []string{
+		"2018-01-02T03:04:05Z stdout P GET ",
+		"2018-01-02T03:04:05Z stderr P panic: ",
+		"2018-01-02T03:04:05Z stdout F /index",
+		"2018-01-02T03:04:05Z stderr F oops",
+	}
+	froms := []string{podA, podB, podA, podB}
+	datas, err := sp.ParseWithSource(lines, froms)
+	se, ok := err.(*StatsError)
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), se.Errors)
+	assert.Equal(t, []int{0, 1}, se.DatasourceSkipIndex)
+	datas = addSourceToData(froms, se, datas, "source", "runner1")
+
+	k8stag := &service.K8sTag{SourceFileKey: "source"}
+	datas, err = k8stag.Transform(datas)
+	assert.NoError(t, err)
+	assert.Len(t, datas, 2)
+	assert.Equal(t, "GET /index", datas[0]["log"])
+	assert.Equal(t, "web-1", datas[0][service.K8sPodName])
+	assert.Equal(t, "default", datas[0][service.K8sNamespace])
+	assert.Equal(t, "nginx", datas[0][service.K8sContainerName])
+	assert.Equal(t, "panic: oops", datas[1]["log"])
+	assert.Equal(t, "api-2", datas[1][service.K8sPodName])
+	assert.Equal(t, "prod", datas[1][service.K8sNamespace])
+}
+
 func TestAddDatasourceForRawData(t *testing.T) {
 	dir := "TestAddDatasource"
 	metaDir := filepath.Join(dir, "meta")
@@ -1663,6 +1701,21 @@ func TestClassifySenderData(t *testing.T) {
 		assert.Equal(t, 1, len(senderDataList[1]))
 		assert.Equal(t, 1, len(senderDataList[2]))
 
+		// 测试有序规则及一条数据发往多个 sender 的情况
+		routerConf.Rules = []router.RuleConfig{
+			{
+				Conditions: []router.ConditionConfig{{Key: "a", MatchType: "in", Values: []string{"B", "C"}}},
+				Senders:    []int{1, 2},
+			},
+		}
+		r, err = router.NewSenderRouter(routerConf, numSenders)
+		assert.NoError(t, err)
+		senderDataList = classifySenderData(senders, datas, r)
+		assert.Equal(t, 0, len(senderDataList[0]))
+		assert.Equal(t, 3, len(senderDataList[1]))
+		assert.Equal(t, 3, len(senderDataList[2]))
+		routerConf.Rules = nil
+
 		// 测试没有配置 router 的情况
 		routerConf.KeyName = ""
 		r, err = router.NewSenderRouter(routerConf, numSenders)
@@ -2237,3 +2290,218 @@ func TestBackupRestoreHistory(t *testing.T) {
 		assert.Equal(t, v.List(), r2.historyError.SendErrors[k].List())
 	}
 }
+
+func TestTransformIf(t *testing.T) {
+	cond, err := transforms.NewCondition(`type == "access"`)
+	assert.NoError(t, err)
+	discarder := &mutate.Discarder{Key: "tmp"}
+	assert.NoError(t, discarder.Init())
+	datas := []Data{
+		{"type": "access", "tmp": 1},
+		{"type": "error", "tmp": 2},
+		{"type": "access", "tmp": 3},
+	}
+	got, errIndex, err := transformIf(cond, datas, func(datas []Data) ([]Data, []int, error) {
+		datas, err := discarder.Transform(datas)
+		// 第二条满足条件的数据出错
+		return datas, []int{1}, err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{{"type": "access"}, {"type": "error", "tmp": 2}, {"type": "access"}}, got)
+	assert.Equal(t, []int{2}, errIndex)
+
+	// 条数变化时不满足条件的数据排在后面
+	lua := &mutate.Lua{Script: `function process(data) return {data, {copy = true}} end`}
+	assert.NoError(t, lua.Init())
+	defer lua.Close()
+	got, _, err = transformIf(cond, []Data{{"type": "error"}, {"type": "access"}}, func(datas []Data) ([]Data, []int, error) {
+		datas, err := lua.Transform(datas)
+		return datas, nil, err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{{"type": "access"}, {"copy": true}, {"type": "error"}}, got)
+
+	// 没有满足条件的数据时不执行 transform
+	called := false
+	got, _, err = transformIf(cond, []Data{{"type": "error"}}, func(datas []Data) ([]Data, []int, error) {
+		called = true
+		return datas, nil, nil
+	})
+	assert.NoError(t, err)
+	assert.False(t, called)
+	assert.Equal(t, []Data{{"type": "error"}}, got)
+}
+
+func TestCreateTransformConditions(t *testing.T) {
+	discarder := &mutate.Discarder{Key: "tmp"}
+	rc := RunnerConfig{Transforms: []map[string]interface{}{
+		{"type": "discard", "key": "tmp", transforms.KeyIf: `type == "access"`},
+		{"type": "discard", "key": "tmp"},
+	}}
+	conditions, err := createTransformConditions(rc, []transforms.Transformer{discarder, discarder})
+	assert.NoError(t, err)
+	assert.Len(t, conditions, 2)
+	assert.Equal(t, `type == "access"`, conditions[0].String())
+	assert.Nil(t, conditions[1])
+
+	rc.Transforms[1][transforms.KeyIf] = `type ==`
+	_, err = createTransformConditions(rc, []transforms.Transformer{discarder, discarder})
+	assert.Error(t, err)
+
+	rc.Transforms[1][transforms.KeyIf] = `type == "access"`
+	_, err = createTransformConditions(rc, []transforms.Transformer{discarder, &mutate.Discarder{Key: "tmp", StageTime: transforms.StageBeforeParser}})
+	assert.Error(t, err)
+}
+
+func TestSetTransformStateDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transform_state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	dedup := &mutate.Dedup{Keys: "id"}
+	assert.NoError(t, dedup.Init())
+	assert.NoError(t, setTransformStateDir([]transforms.Transformer{&mutate.Discarder{Key: "tmp"}, dedup}, dir))
+	_, err = dedup.Transform([]Data{{"id": 1}})
+	assert.NoError(t, err)
+	assert.NoError(t, dedup.SaveState(true))
+	_, err = os.Stat(filepath.Join(dir, transformStateDir, "dedup-1"))
+	assert.NoError(t, err)
+}
+
+// flushTransformer 在 Flush 时输出一条数据，final 为 true 时输出 final
+type flushTransformer struct {
+	*mutate.Replacer
+	mux     sync.Mutex
+	flushed bool
+}
+
+func (t *flushTransformer) Flush(final bool) ([]Data, error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if final {
+		return []Data{{"raw": "final"}}, nil
+	}
+	if t.flushed {
+		return nil, nil
+	}
+	t.flushed = true
+	return []Data{{"raw": "flush\n"}}, nil
+}
+
+func TestRunFlushTransformers(t *testing.T) {
+	for _, pipeline := range []bool{false, true} {
+		dir, err := ioutil.TempDir("", "TestRunFlushTransformers")
+		assert.NoError(t, err)
+		logPath := filepath.Join(dir, "test.log")
+		assert.NoError(t, ioutil.WriteFile(logPath, []byte("a\n"), DefaultFilePerm))
+
+		config := `{
+			"name":"TestRunFlushTransformers",
+			"batch_len":1,
+			"batch_interval":1,
+			"pipeline":{"enable":` + strconv.FormatBool(pipeline) + `},
+			"reader":{
+				"mode":"file",
+				"meta_path":"` + filepath.Join(dir, "meta") + `",
+				"log_path":"` + logPath + `",
+				"read_from":"oldest"
+			},
+			"parser":{
+				"name":"testraw",
+				"type":"raw",
+				"timestamp":"false"
+			},
+			"senders":[{
+				"name":"discard_sender",
+				"sender_type":"discard"
+			}]
+		}`
+		rc := RunnerConfig{}
+		assert.NoError(t, jsoniter.Unmarshal([]byte(config), &rc))
+		rr, err := NewLogExportRunner(rc, make(chan cleaner.CleanSignal), reader.NewRegistry(), parser.NewRegistry(), sender.NewRegistry())
+		assert.NoError(t, err)
+		ft := &flushTransformer{Replacer: &mutate.Replacer{StageTime: transforms.StageAfterParser, Key: "raw", Old: "\n", New: ""}}
+		assert.NoError(t, ft.Init())
+		replacer := &mutate.Replacer{StageTime: transforms.StageAfterParser, Key: "raw", Old: "\n", New: ""}
+		assert.NoError(t, replacer.Init())
+		// Flush 输出的数据继续经过之后的 transformer
+		rr.transformers = []transforms.Transformer{ft, replacer}
+		if pipeline {
+			rr.pipeline = newPipeline(*rc.Pipeline, rr.parser, len(rr.transformers), rc.SyncEvery)
+		}
+		s := &linesSender{}
+		rr.senders = []sender.Sender{s}
+		go rr.Run()
+
+		// 没有新数据时输出已经到期的缓存数据
+		exp := []interface{}{"a", "flush"}
+		for i := 0; i < 50 && len(s.Lines()) < len(exp); i++ {
+			time.Sleep(100 * time.Millisecond)
+		}
+		assert.Equal(t, exp, s.Lines(), "pipeline %v", pipeline)
+		// 停止时输出全部缓存的数据
+		rr.Stop()
+		assert.Equal(t, append(exp, "final"), s.Lines(), "pipeline %v", pipeline)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRunSaveTransformState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestRunSaveTransformState")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "test.log")
+	assert.NoError(t, ioutil.WriteFile(logPath, []byte("a\n"), DefaultFilePerm))
+
+	config := `{
+			"name":"TestRunSaveTransformState",
+			"batch_len":1,
+			"batch_interval":1,
+			"reader":{
+				"mode":"file",
+				"meta_path":"` + filepath.Join(dir, "meta") + `",
+				"log_path":"` + logPath + `",
+				"read_from":"oldest"
+			},
+			"parser":{
+				"name":"testraw",
+				"type":"raw",
+				"timestamp":"false"
+			},
+			"senders":[{
+				"name":"discard_sender",
+				"sender_type":"discard"
+			}]
+		}`
+	rc := RunnerConfig{}
+	assert.NoError(t, jsoniter.Unmarshal([]byte(config), &rc))
+	rr, err := NewLogExportRunner(rc, make(chan cleaner.CleanSignal), reader.NewRegistry(), parser.NewRegistry(), sender.NewRegistry())
+	assert.NoError(t, err)
+	dedup := &mutate.Dedup{Keys: "raw", SyncInterval: "0s"}
+	assert.NoError(t, dedup.Init())
+	stateDir := filepath.Join(dir, "state")
+	assert.NoError(t, dedup.SetStateDir(stateDir))
+	rr.transformers = []transforms.Transformer{dedup}
+	s := &blockingSender{release: make(chan struct{})}
+	rr.senders = []sender.Sender{s}
+	go rr.Run()
+
+	// 数据发送成功之前不保存 transformer 的状态
+	time.Sleep(1500 * time.Millisecond)
+	_, err = os.Stat(filepath.Join(stateDir, "dedup.state"))
+	assert.True(t, os.IsNotExist(err))
+
+	close(s.release)
+	for i := 0; i < 30 && len(s.Lines()) == 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, []interface{}{"a\n"}, s.Lines())
+	for i := 0; i < 30 && os.IsNotExist(err); i++ {
+		time.Sleep(100 * time.Millisecond)
+		_, err = os.Stat(filepath.Join(stateDir, "dedup.state"))
+	}
+	assert.NoError(t, err)
+	_, offset, _ := rr.meta.ReadOffset()
+	assert.Equal(t, int64(2), offset)
+	rr.Stop()
+}
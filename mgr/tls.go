@@ -0,0 +1,263 @@
+package mgr
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qiniu/log"
+)
+
+// TLSReloadInterval 检查证书文件是否变化的间隔
+var TLSReloadInterval = 30 * time.Second
+
+// TLSConfig logkit REST 服务及集群通信的 TLS 配置，cert_file 和 key_file 都配置时开启 TLS
+type TLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// ClientCAFile 用于校验访问 REST 服务的客户端证书
+	ClientCAFile string `json:"client_ca_file"`
+	// RequireClientCert 为 true 时拒绝没有携带有效客户端证书的连接
+	RequireClientCert bool `json:"require_client_cert"`
+	// ClusterCAFile master 与 slave 互相校验证书使用的 CA，slave 访问 master 以及 master 访问 slave 时都会携带本机证书，
+	// 开启集群时必须配置，所有集群接口都要求携带该 CA 签发的客户端证书
+	ClusterCAFile string `json:"cluster_ca_file"`
+}
+
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" && c.KeyFile != ""
+}
+
+// certReloader 持有当前生效的证书，定期检查文件修改时间，变化后重新加载，无需重启 logkit
+type certReloader struct {
+	conf TLSConfig
+
+	mu         sync.RWMutex
+	cert       *tls.Certificate
+	clientCAs  *x509.CertPool
+	clusterCAs *x509.CertPool
+	modTimes   map[string]time.Time
+
+	onReload func()
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func newCertReloader(conf TLSConfig) (*certReloader, error) {
+	if !conf.Enabled() {
+		return nil, errors.New("tls cert_file and key_file must be both configured")
+	}
+	r := &certReloader{
+		conf:     conf,
+		modTimes: make(map[string]time.Time),
+		stopped:  make(chan struct{}),
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.conf.CertFile, r.conf.KeyFile}
+	if r.conf.ClientCAFile != "" {
+		files = append(files, r.conf.ClientCAFile)
+	}
+	if r.conf.ClusterCAFile != "" {
+		files = append(files, r.conf.ClusterCAFile)
+	}
+	return files
+}
+
+func (r *certReloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			log.Warnf("stat tls file %v error %v", file, err)
+			continue
+		}
+		if !info.ModTime().Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *certReloader) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("stat tls file %v error %v", file, err)
+		}
+		modTimes[file] = info.ModTime()
+	}
+	cert, err := tls.LoadX509KeyPair(r.conf.CertFile, r.conf.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load tls cert %v and key %v error %v", r.conf.CertFile, r.conf.KeyFile, err)
+	}
+	var clientCAs, clusterCAs *x509.CertPool
+	if r.conf.ClientCAFile != "" || r.conf.ClusterCAFile != "" {
+		clientCAs = x509.NewCertPool()
+		if r.conf.ClientCAFile != "" {
+			if err = appendCertsFromFile(clientCAs, r.conf.ClientCAFile); err != nil {
+				return err
+			}
+		}
+		if r.conf.ClusterCAFile != "" {
+			if err = appendCertsFromFile(clientCAs, r.conf.ClusterCAFile); err != nil {
+				return err
+			}
+			clusterCAs = x509.NewCertPool()
+			if err = appendCertsFromFile(clusterCAs, r.conf.ClusterCAFile); err != nil {
+				return err
+			}
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.clusterCAs = clusterCAs
+	r.modTimes = modTimes
+	r.mu.Unlock()
+	return nil
+}
+
+func appendCertsFromFile(pool *x509.CertPool, file string) error {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("read ca file %v error %v", file, err)
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no valid certificate found in ca file %v", file)
+	}
+	return nil
+}
+
+// Run 定期检查证书文件，直到 Stop 被调用
+func (r *certReloader) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stopped:
+			return
+		case <-ticker.C:
+		}
+		if !r.changed() {
+			continue
+		}
+		if err := r.load(); err != nil {
+			log.Errorf("reload tls certificates error %v, keep using the old ones", err)
+			continue
+		}
+		log.Infof("tls certificates reloaded from %v", r.conf.CertFile)
+		if r.onReload != nil {
+			r.onReload()
+		}
+	}
+}
+
+func (r *certReloader) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopped)
+	})
+}
+
+func (r *certReloader) certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// ServerConfig 每个连接都使用当前生效的证书和客户端 CA
+func (r *certReloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			conf := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+			}
+			if r.clientCAs != nil {
+				conf.ClientCAs = r.clientCAs
+				conf.ClientAuth = tls.VerifyClientCertIfGiven
+				if r.conf.RequireClientCert {
+					conf.ClientAuth = tls.RequireAndVerifyClientCert
+				}
+			}
+			return conf, nil
+		},
+	}
+}
+
+// ClusterClient 返回集群内部通信使用的 client，使用 cluster CA 校验对端，并携带本机证书供对端校验
+func (r *certReloader) ClusterClient() *http.Client {
+	r.mu.RLock()
+	rootCAs := r.clusterCAs
+	r.mu.RUnlock()
+	tlsConf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    rootCAs,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.certificate(), nil
+		},
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSClientConfig:     tlsConf,
+			TLSHandshakeTimeout: 10 * time.Second,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// VerifyClusterPeer 校验请求是否携带了 cluster CA 签发的客户端证书
+func (r *certReloader) VerifyClusterPeer(req *http.Request) error {
+	r.mu.RLock()
+	clusterCAs := r.clusterCAs
+	r.mu.RUnlock()
+	if clusterCAs == nil {
+		return nil
+	}
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return errors.New("cluster peer certificate is required")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         clusterCAs,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, cert := range req.TLS.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := req.TLS.PeerCertificates[0].Verify(opts); err != nil {
+		return fmt.Errorf("verify cluster peer certificate error %v", err)
+	}
+	return nil
+}
+
+// clusterPeerURL 开启 TLS 后集群节点之间只使用 https，未带协议或 http:// 开头的地址都改为 https://
+func clusterPeerURL(url string) string {
+	if strings.HasPrefix(url, "https://") {
+		return url
+	}
+	if strings.HasPrefix(url, "http://") {
+		log.Warnf("cluster tls is enabled, upgrade peer url %v to https", url)
+		return "https://" + strings.TrimPrefix(url, "http://")
+	}
+	return "https://" + url
+}
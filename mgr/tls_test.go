@@ -0,0 +1,190 @@
+package mgr
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, cn string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) writeCA(t *testing.T, file string) {
+	assert.NoError(t, ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0600))
+}
+
+func (ca *testCA) issue(t *testing.T, cn string, serial int64, certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+}
+
+func TestTLSServeAndReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestTLSServeAndReload")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, "logkit-cluster-ca")
+	caFile := filepath.Join(dir, "ca.pem")
+	ca.writeCA(t, caFile)
+	serverCert, serverKey := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key")
+	ca.issue(t, "master", 2, serverCert, serverKey)
+	slaveCert, slaveKey := filepath.Join(dir, "slave.pem"), filepath.Join(dir, "slave.key")
+	ca.issue(t, "slave", 3, slaveCert, slaveKey)
+
+	serverCerts, err := newCertReloader(TLSConfig{CertFile: serverCert, KeyFile: serverKey, ClusterCAFile: caFile, RequireClientCert: true})
+	assert.NoError(t, err)
+	defer serverCerts.Stop()
+	slaveCerts, err := newCertReloader(TLSConfig{CertFile: slaveCert, KeyFile: slaveKey, ClusterCAFile: caFile})
+	assert.NoError(t, err)
+	defer slaveCerts.Stop()
+
+	e := echo.New()
+	e.GET("/peer", func(c echo.Context) error {
+		if err := serverCerts.VerifyClusterPeer(c.Request()); err != nil {
+			return RespError(c, http.StatusForbidden, ErrClusterRegister, err.Error())
+		}
+		return c.String(http.StatusOK, c.Request().TLS.PeerCertificates[0].Subject.CommonName)
+	})
+	listener, err := httpserve("127.0.0.1:0", e, serverCerts.ServerConfig())
+	assert.NoError(t, err)
+	defer listener.Close()
+	url := "https://" + listener.Addr().String() + "/peer"
+
+	resp, err := slaveCerts.ClusterClient().Get(url)
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "slave", string(body))
+
+	// 没有客户端证书时握手失败
+	noCertConf := slaveCerts.ClusterClient().Transport.(*http.Transport).TLSClientConfig.Clone()
+	noCertConf.GetClientCertificate = nil
+	_, err = (&http.Client{Transport: &http.Transport{TLSClientConfig: noCertConf}}).Get(url)
+	assert.Error(t, err)
+
+	// 证书更新后无需重启即生效
+	ca.issue(t, "master", 4, serverCert, serverKey)
+	future := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(serverCert, future, future))
+	assert.True(t, serverCerts.changed())
+	reloaded := make(chan struct{}, 1)
+	serverCerts.onReload = func() {
+		reloaded <- struct{}{}
+	}
+	go serverCerts.Run(10 * time.Millisecond)
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("certificates are not reloaded")
+	}
+	assert.False(t, serverCerts.changed())
+
+	resp, err = slaveCerts.ClusterClient().Get(url)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.NotNil(t, resp.TLS)
+	assert.Equal(t, int64(4), resp.TLS.PeerCertificates[0].SerialNumber.Int64())
+}
+
+func TestRequireClusterPeer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logkit_cluster_peer")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, "logkit-cluster-ca")
+	caFile := filepath.Join(dir, "ca.pem")
+	ca.writeCA(t, caFile)
+	serverCert, serverKey := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key")
+	ca.issue(t, "master", 2, serverCert, serverKey)
+	slaveCert, slaveKey := filepath.Join(dir, "slave.pem"), filepath.Join(dir, "slave.key")
+	ca.issue(t, "slave", 3, slaveCert, slaveKey)
+
+	serverCerts, err := newCertReloader(TLSConfig{CertFile: serverCert, KeyFile: serverKey, ClusterCAFile: caFile})
+	assert.NoError(t, err)
+	defer serverCerts.Stop()
+	slaveCerts, err := newCertReloader(TLSConfig{CertFile: slaveCert, KeyFile: slaveKey, ClusterCAFile: caFile})
+	assert.NoError(t, err)
+	defer slaveCerts.Stop()
+
+	rs := &RestService{certs: serverCerts}
+	e := echo.New()
+	e.GET("/logkit/cluster/ping", func(c echo.Context) error {
+		return c.String(http.StatusOK, "pong")
+	}, rs.requireClusterPeer)
+	listener, err := httpserve("127.0.0.1:0", e, serverCerts.ServerConfig())
+	assert.NoError(t, err)
+	defer listener.Close()
+	url := "https://" + listener.Addr().String() + "/logkit/cluster/ping"
+
+	resp, err := slaveCerts.ClusterClient().Get(url)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// 握手允许不带证书，但集群接口会拒绝
+	noCertConf := slaveCerts.ClusterClient().Transport.(*http.Transport).TLSClientConfig.Clone()
+	noCertConf.GetClientCertificate = nil
+	resp, err = (&http.Client{Transport: &http.Transport{TLSClientConfig: noCertConf}}).Get(url)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestClusterPeerURL(t *testing.T) {
+	assert.Equal(t, "https://10.0.0.1:3000", clusterPeerURL("10.0.0.1:3000"))
+	assert.Equal(t, "https://10.0.0.1:3000", clusterPeerURL("http://10.0.0.1:3000"))
+	assert.Equal(t, "https://10.0.0.1:3000", clusterPeerURL("https://10.0.0.1:3000"))
+}
@@ -0,0 +1,215 @@
+package apache
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/parser"
+	. "github.com/qiniu/logkit/parser/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var (
+	_ parser.Parser     = &Parser{}
+	_ parser.ParserType = &Parser{}
+)
+
+func init() {
+	parser.RegisterConstructor(TypeApache, NewParser)
+}
+
+// Parser 按 Apache 的 LogFormat 解析访问日志，字段名称和类型与 nginx parser 保持一致
+type Parser struct {
+	name                 string
+	regexp               *regexp.Regexp
+	fields               []field
+	labels               []GrokLabel
+	disableRecordErrData bool
+	keepRawData          bool
+	numRoutine           int
+}
+
+func NewParser(c conf.MapConf) (parser.Parser, error) {
+	name, _ := c.GetStringOr(KeyParserName, "")
+	format, _ := c.GetStringOr(KeyApacheLogFormat, DefaultApacheLogFormat)
+	labelList, _ := c.GetStringListOr(KeyLabels, []string{})
+	disableRecordErrData, _ := c.GetBoolOr(KeyDisableRecordErrData, false)
+	keepRawData, _ := c.GetBoolOr(KeyKeepRawData, false)
+	numRoutine := MaxProcs
+	if numRoutine == 0 {
+		numRoutine = 1
+	}
+
+	re, fields, err := compileFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	nameMap := make(map[string]struct{}, len(fields))
+	for _, f := range fields {
+		nameMap[f.name] = struct{}{}
+	}
+	return &Parser{
+		name:                 name,
+		regexp:               re,
+		fields:               fields,
+		labels:               GetGrokLabels(labelList, nameMap),
+		disableRecordErrData: disableRecordErrData,
+		keepRawData:          keepRawData,
+		numRoutine:           numRoutine,
+	}, nil
+}
+
+func (p *Parser) Name() string {
+	return p.name
+}
+
+func (p *Parser) Type() string {
+	return TypeApache
+}
+
+func (p *Parser) Parse(lines []string) ([]Data, error) {
+	var (
+		lineLen    = len(lines)
+		datas      = make([]Data, 0, lineLen)
+		se         = &StatsError{}
+		numRoutine = p.numRoutine
+
+		sendChan   = make(chan parser.ParseInfo)
+		resultChan = make(chan parser.ParseResult)
+		wg         = new(sync.WaitGroup)
+	)
+	if lineLen < numRoutine {
+		numRoutine = lineLen
+	}
+
+	for i := 0; i < numRoutine; i++ {
+		wg.Add(1)
+		go parser.ParseLine(sendChan, resultChan, wg, true, p.parse)
+	}
+
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
+
+	go func() {
+		for idx, line := range lines {
+			sendChan <- parser.ParseInfo{
+				Line:  line,
+				Index: idx,
+			}
+		}
+		close(sendChan)
+	}()
+	var parseResultSlice = make(parser.ParseResultSlice, lineLen)
+	for resultInfo := range resultChan {
+		parseResultSlice[resultInfo.Index] = resultInfo
+	}
+
+	se.DatasourceSkipIndex = make([]int, lineLen)
+	datasourceIndex := 0
+	for _, parseResult := range parseResultSlice {
+		if len(parseResult.Line) == 0 {
+			se.DatasourceSkipIndex[datasourceIndex] = parseResult.Index
+			datasourceIndex++
+			continue
+		}
+
+		if parseResult.Err != nil {
+			se.AddErrors()
+			se.LastError = parseResult.Err.Error()
+			errData := make(Data)
+			if !p.disableRecordErrData {
+				errData[KeyPandoraStash] = parseResult.Line
+			} else if !p.keepRawData {
+				se.DatasourceSkipIndex[datasourceIndex] = parseResult.Index
+				datasourceIndex++
+			}
+			if p.keepRawData {
+				errData[KeyRawData] = parseResult.Line
+			}
+			if !p.disableRecordErrData || p.keepRawData {
+				datas = append(datas, errData)
+			}
+			continue
+		}
+
+		se.AddSuccess()
+		if p.keepRawData {
+			parseResult.Data[KeyRawData] = parseResult.Line
+		}
+		datas = append(datas, parseResult.Data)
+	}
+	se.DatasourceSkipIndex = se.DatasourceSkipIndex[:datasourceIndex]
+
+	if se.Errors == 0 {
+		return datas, nil
+	}
+	return datas, se
+}
+
+func (p *Parser) parse(line string) (Data, error) {
+	line = strings.TrimRight(line, "\r\n")
+	match := p.regexp.FindStringSubmatch(line)
+	if match == nil {
+		return nil, fmt.Errorf("line does not match apache log format %v: %v", p.regexp, TruncateStrSize(line, DefaultTruncateMaxSize))
+	}
+	data := make(Data, len(p.fields)+len(p.labels))
+	for i, f := range p.fields {
+		v, err := makeValue(f, match[i+1])
+		if err != nil {
+			log.Warnf("Error %v, ignore this key %v ...", err, f.name)
+			continue
+		}
+		data[f.name] = v
+	}
+	for _, l := range p.labels {
+		data[l.Name] = l.Value
+	}
+	return data, nil
+}
+
+// makeValue 按字段类型转换，数字字段的 - 转换为 0，时间无法解析时保留原始的字符串
+func makeValue(f field, raw string) (interface{}, error) {
+	switch f.typ {
+	case fieldLong:
+		if raw == "-" || raw == "" {
+			return int64(0), nil
+		}
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("convert %q to int64 failed: %q", f.name, raw)
+		}
+		return v, nil
+	case fieldFloat:
+		if raw == "-" || raw == "" {
+			return 0.0, nil
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("convert %q to float64 failed: %q", f.name, raw)
+		}
+		return v * f.scale, nil
+	case fieldDate:
+		t, err := time.Parse(f.layout, raw)
+		if err != nil {
+			return raw, nil
+		}
+		return t.Format(time.RFC3339Nano), nil
+	case fieldEpoch:
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return raw, nil
+		}
+		nsec := int64(f.scale * 1e9)
+		return time.Unix(0, v*nsec).UTC().Format(time.RFC3339Nano), nil
+	}
+	return raw, nil
+}
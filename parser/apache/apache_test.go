@@ -0,0 +1,100 @@
+package apache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/parser/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestParse(t *testing.T) {
+	p, err := NewParser(conf.MapConf{KeyParserName: "apache", KeyLabels: "env prod"})
+	assert.NoError(t, err)
+	assert.Equal(t, "apache", p.Name())
+
+	datas, err := p.Parse([]string{
+		`127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08 [en] (Win98; I ;Nav)"` + "\n",
+		`::1 - - [10/Oct/2000:13:55:36 +0000] "GET /a\"b HTTP/1.1" 304 - "-" "curl/7.1"`,
+		"",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{
+		{
+			"remote_addr":     "127.0.0.1",
+			"remote_logname":  "-",
+			"remote_user":     "frank",
+			"time_local":      "2000-10-10T13:55:36-07:00",
+			"request":         "GET /apache_pb.gif HTTP/1.0",
+			"status":          int64(200),
+			"body_bytes_sent": int64(2326),
+			"http_referer":    "http://www.example.com/start.html",
+			"http_user_agent": "Mozilla/4.08 [en] (Win98; I ;Nav)",
+			"env":             "prod",
+		},
+		{
+			"remote_addr":     "::1",
+			"remote_logname":  "-",
+			"remote_user":     "-",
+			"time_local":      "2000-10-10T13:55:36Z",
+			"request":         `GET /a\"b HTTP/1.1`,
+			"status":          int64(304),
+			"body_bytes_sent": int64(0),
+			"http_referer":    "-",
+			"http_user_agent": "curl/7.1",
+			"env":             "prod",
+		},
+	}, datas)
+}
+
+func TestParseCustomFormat(t *testing.T) {
+	p, err := NewParser(conf.MapConf{
+		KeyApacheLogFormat: `LogFormat "%v:%p %a %{remote}p %>s %D %{ms}T %{%Y-%m-%d %H:%M:%S}t %{X-Forwarded-For}i %{Set-Cookie}o %%%I" custom`,
+		KeyKeepRawData:     "true",
+	})
+	assert.NoError(t, err)
+	line := "example.com:443 10.0.0.1 51234 500 1500000 1500 2018-01-02 03:04:05 1.1.1.1,2.2.2.2 a=b %100"
+	datas, err := p.Parse([]string{line, "bad line"})
+	se, ok := err.(*StatsError)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), se.Errors)
+	assert.Equal(t, []Data{
+		{
+			"server_name":          "example.com",
+			"server_port":          int64(443),
+			"remote_addr":          "10.0.0.1",
+			"remote_port":          int64(51234),
+			"status":               int64(500),
+			"request_time":         1.5,
+			"request_time_2":       1.5,
+			"time_local":           "2018-01-02T03:04:05Z",
+			"http_x_forwarded_for": "1.1.1.1,2.2.2.2",
+			"sent_http_set_cookie": "a=b",
+			"request_length":       int64(100),
+			KeyRawData:             line,
+		},
+		{KeyPandoraStash: "bad line", KeyRawData: "bad line"},
+	}, datas)
+}
+
+func TestCompileFormat(t *testing.T) {
+	_, fields, err := compileFormat("common")
+	assert.NoError(t, err)
+	assert.Len(t, fields, 7)
+
+	_, fields, err = compileFormat(`%h %{sec}t %{msec_frac}t %!200,304{Referer}i`)
+	assert.NoError(t, err)
+	assert.Equal(t, []field{
+		{name: "remote_addr", typ: fieldString},
+		{name: "time_local", typ: fieldEpoch, scale: 1},
+		{name: "time_msec_frac", typ: fieldString},
+		{name: "http_referer", typ: fieldString},
+	}, fields)
+
+	for _, format := range []string{"", "plain text", "%h %Z", "%{Referer", "%h %>", "%{min}T"} {
+		_, _, err = compileFormat(format)
+		assert.Error(t, err, format)
+	}
+}
@@ -0,0 +1,303 @@
+package apache
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// 字段的类型，float 类型的值会乘以 scale，用于将 %D 的微秒转换为秒
+const (
+	fieldString = "string"
+	fieldLong   = "long"
+	fieldFloat  = "float"
+	fieldDate   = "date"
+	fieldEpoch  = "epoch"
+)
+
+// 常用的 LogFormat 别名
+var nicknames = map[string]string{
+	"common":         `%h %l %u %t "%r" %>s %b`,
+	"combined":       `%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-agent}i"`,
+	"combinedio":     `%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-agent}i" %I %O`,
+	"vhost_combined": `%v:%p %h %l %u %t "%r" %>s %O "%{Referer}i" "%{User-Agent}i"`,
+}
+
+type field struct {
+	name   string
+	typ    string
+	scale  float64
+	layout string // date 类型的时间格式
+}
+
+// item 为 LogFormat 中的一段，literal 为普通字符，否则为 % 指令
+type item struct {
+	literal   string
+	directive byte
+	param     string
+}
+
+var logFormatRegexp = regexp.MustCompile(`^\s*LogFormat\s+"((?:[^"\\]|\\.)*)"`)
+
+// compileFormat 将 Apache 的 LogFormat 转换为正则表达式，每个 % 指令对应一个分组和一个字段
+// 字段名称与 nginx 的变量名保持一致，如 %h 为 remote_addr，%>s 为 status，%{Referer}i 为 http_referer
+func compileFormat(format string) (*regexp.Regexp, []field, error) {
+	if nick, ok := nicknames[strings.TrimSpace(format)]; ok {
+		format = nick
+	} else if m := logFormatRegexp.FindStringSubmatch(format); m != nil {
+		// 支持直接粘贴 httpd.conf 中的 LogFormat 指令
+		format = m[1]
+	}
+	items, err := tokenize(unescape(format))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		fields []field
+		names  = make(map[string]int)
+		expr   strings.Builder
+	)
+	expr.WriteString("^")
+	for i, it := range items {
+		if it.directive == 0 {
+			expr.WriteString(regexp.QuoteMeta(it.literal))
+			continue
+		}
+		f, err := directiveField(it.directive, it.param)
+		if err != nil {
+			return nil, nil, err
+		}
+		// 同一个名称出现多次时之后的字段增加序号，如 %h 和 %a 都对应 remote_addr
+		if names[f.name]++; names[f.name] > 1 {
+			f.name = fmt.Sprintf("%v_%d", f.name, names[f.name])
+		}
+		fields = append(fields, f)
+
+		var prev, next string
+		if i > 0 {
+			prev = items[i-1].literal
+		}
+		if i+1 < len(items) {
+			next = items[i+1].literal
+		}
+		switch {
+		case it.directive == 't' && it.param == "":
+			expr.WriteString(`\[([^\]]*)\]`)
+		case it.directive == 't' && next != "":
+			// 自定义的时间格式中可能包含空格
+			expr.WriteString(`(.+?)`)
+		case strings.HasSuffix(prev, `"`) && strings.HasPrefix(next, `"`):
+			// 引号中的内容可能包含 \" 转义的引号
+			expr.WriteString(`((?:[^"\\]|\\.)*)`)
+		case next != "":
+			expr.WriteString(`([^` + classChar(next[0]) + `]*)`)
+		case i+1 < len(items):
+			expr.WriteString(`(.*?)`)
+		default:
+			expr.WriteString(`(.*)`)
+		}
+	}
+	expr.WriteString("$")
+	if len(fields) == 0 {
+		return nil, nil, errors.New("no % directive found in apache log format")
+	}
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, nil, fmt.Errorf("compile apache log format error %v", err)
+	}
+	return re, fields, nil
+}
+
+// unescape 处理 httpd.conf 中 LogFormat 字符串的转义
+func unescape(s string) string {
+	return strings.NewReplacer(`\"`, `"`, `\t`, "\t", `\n`, "\n", `\\`, `\`).Replace(s)
+}
+
+func classChar(c byte) string {
+	switch c {
+	case '\\', ']', '^', '-', '[':
+		return `\` + string(c)
+	}
+	return string(c)
+}
+
+// tokenize 解析 %[条件][<>]{参数}[<>]字符 形式的指令，条件为 !200,304 形式的状态码列表
+func tokenize(format string) ([]item, error) {
+	var (
+		items   []item
+		literal strings.Builder
+	)
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			literal.WriteByte(format[i])
+			continue
+		}
+		i++
+		if i < len(format) && format[i] == '%' {
+			literal.WriteByte('%')
+			continue
+		}
+		for i < len(format) && strings.IndexByte("<>!,0123456789", format[i]) >= 0 {
+			i++
+		}
+		var param string
+		if i < len(format) && format[i] == '{' {
+			end := strings.IndexByte(format[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed { in apache log format %q", format)
+			}
+			param = format[i+1 : i+end]
+			i += end + 1
+		}
+		for i < len(format) && (format[i] == '<' || format[i] == '>') {
+			i++
+		}
+		if i >= len(format) {
+			return nil, fmt.Errorf("incomplete directive at the end of apache log format %q", format)
+		}
+		if literal.Len() > 0 {
+			items = append(items, item{literal: literal.String()})
+			literal.Reset()
+		}
+		items = append(items, item{directive: format[i], param: param})
+	}
+	if literal.Len() > 0 {
+		items = append(items, item{literal: literal.String()})
+	}
+	return items, nil
+}
+
+// headerName 将 http 头转换为 nginx 变量的形式，如 User-Agent 转换为 user_agent
+func headerName(header string) string {
+	return strings.Replace(strings.ToLower(header), "-", "_", -1)
+}
+
+func directiveField(directive byte, param string) (field, error) {
+	switch directive {
+	case 'a', 'h':
+		if param == "c" {
+			return field{name: "realip_remote_addr", typ: fieldString}, nil
+		}
+		return field{name: "remote_addr", typ: fieldString}, nil
+	case 'A':
+		return field{name: "server_addr", typ: fieldString}, nil
+	case 'b', 'B':
+		return field{name: "body_bytes_sent", typ: fieldLong}, nil
+	case 'C':
+		return field{name: "cookie_" + headerName(param), typ: fieldString}, nil
+	case 'D':
+		return field{name: "request_time", typ: fieldFloat, scale: 1e-6}, nil
+	case 'e':
+		return field{name: "env_" + headerName(param), typ: fieldString}, nil
+	case 'f':
+		return field{name: "request_filename", typ: fieldString}, nil
+	case 'H':
+		return field{name: "server_protocol", typ: fieldString}, nil
+	case 'i':
+		return field{name: "http_" + headerName(param), typ: fieldString}, nil
+	case 'I':
+		return field{name: "request_length", typ: fieldLong}, nil
+	case 'k':
+		return field{name: "keepalive_requests", typ: fieldLong}, nil
+	case 'l':
+		return field{name: "remote_logname", typ: fieldString}, nil
+	case 'L':
+		return field{name: "request_log_id", typ: fieldString}, nil
+	case 'm':
+		return field{name: "request_method", typ: fieldString}, nil
+	case 'n':
+		return field{name: "note_" + headerName(param), typ: fieldString}, nil
+	case 'o':
+		return field{name: "sent_http_" + headerName(param), typ: fieldString}, nil
+	case 'O':
+		return field{name: "bytes_sent", typ: fieldLong}, nil
+	case 'p':
+		if param == "remote" {
+			return field{name: "remote_port", typ: fieldLong}, nil
+		}
+		return field{name: "server_port", typ: fieldLong}, nil
+	case 'P':
+		return field{name: "pid", typ: fieldLong}, nil
+	case 'q':
+		return field{name: "query_string", typ: fieldString}, nil
+	case 'r':
+		return field{name: "request", typ: fieldString}, nil
+	case 'R':
+		return field{name: "handler", typ: fieldString}, nil
+	case 's':
+		return field{name: "status", typ: fieldLong}, nil
+	case 'S':
+		return field{name: "bytes_transferred", typ: fieldLong}, nil
+	case 't':
+		return timeField(param)
+	case 'T':
+		switch param {
+		case "", "s":
+			return field{name: "request_time", typ: fieldFloat, scale: 1}, nil
+		case "ms":
+			return field{name: "request_time", typ: fieldFloat, scale: 1e-3}, nil
+		case "us":
+			return field{name: "request_time", typ: fieldFloat, scale: 1e-6}, nil
+		}
+		return field{}, fmt.Errorf("unsupported unit %v of %%T", param)
+	case 'u':
+		return field{name: "remote_user", typ: fieldString}, nil
+	case 'U':
+		return field{name: "uri", typ: fieldString}, nil
+	case 'v', 'V':
+		return field{name: "server_name", typ: fieldString}, nil
+	case 'X':
+		return field{name: "connection_status", typ: fieldString}, nil
+	}
+	return field{}, fmt.Errorf("unsupported apache log format directive %%%c", directive)
+}
+
+// timeField 处理 %t 和 %{format}t，strftime 格式转换为 go 的时间格式，无法转换时按字符串记录
+func timeField(param string) (field, error) {
+	param = strings.TrimPrefix(strings.TrimPrefix(param, "begin:"), "end:")
+	switch param {
+	case "":
+		return field{name: "time_local", typ: fieldDate, layout: "02/Jan/2006:15:04:05 -0700"}, nil
+	case "sec":
+		return field{name: "time_local", typ: fieldEpoch, scale: 1}, nil
+	case "msec":
+		return field{name: "time_local", typ: fieldEpoch, scale: 1e-3}, nil
+	case "usec":
+		return field{name: "time_local", typ: fieldEpoch, scale: 1e-6}, nil
+	case "msec_frac", "usec_frac":
+		return field{name: "time_" + param, typ: fieldString}, nil
+	}
+	if layout, ok := strftimeLayout(param); ok {
+		return field{name: "time_local", typ: fieldDate, layout: layout}, nil
+	}
+	return field{name: "time_local", typ: fieldString}, nil
+}
+
+var strftimeLayouts = map[byte]string{
+	'a': "Mon", 'A': "Monday", 'b': "Jan", 'B': "January", 'h': "Jan",
+	'd': "02", 'e': "_2", 'H': "15", 'I': "03", 'm': "01", 'M': "04",
+	'p': "PM", 'S': "05", 'y': "06", 'Y': "2006", 'z': "-0700", 'Z': "MST",
+	'T': "15:04:05", 'F': "2006-01-02", 'D': "01/02/06", 'R': "15:04", '%': "%",
+}
+
+func strftimeLayout(format string) (string, bool) {
+	var layout strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			layout.WriteByte(format[i])
+			continue
+		}
+		i++
+		if i >= len(format) {
+			return "", false
+		}
+		l, ok := strftimeLayouts[format[i]]
+		if !ok {
+			return "", false
+		}
+		layout.WriteString(l)
+	}
+	return layout.String(), true
+}
@@ -0,0 +1,233 @@
+package auto
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/parser"
+	. "github.com/qiniu/logkit/parser/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	// statsFailed 为没有开启 raw 兜底时所有子 parser 都失败的行数
+	statsFailed = "failed"
+)
+
+var (
+	_ parser.Parser         = &Parser{}
+	_ parser.ParserType     = &Parser{}
+	_ parser.StatsParser    = &Parser{}
+	_ parser.StatefulParser = &Parser{}
+)
+
+func init() {
+	parser.RegisterConstructor(TypeAuto, NewParser)
+}
+
+type child struct {
+	name   string
+	parser parser.Parser
+}
+
+// Parser 对每一行按顺序尝试子 parser，使用第一个解析成功的结果，都失败时交给 raw parser
+// 子 parser 每次只解析一行，实现了 Flushable 的子 parser（如 syslog）在每行之后立即 Flush，因此不会合并多行
+type Parser struct {
+	name                 string
+	labels               []GrokLabel
+	matchedField         string
+	disableRecordErrData bool
+	children             []child
+	fallback             *child
+
+	lock  sync.Mutex
+	stats StatsInfo
+}
+
+func NewParser(c conf.MapConf) (parser.Parser, error) {
+	name, _ := c.GetStringOr(KeyParserName, "")
+	labelList, _ := c.GetStringListOr(KeyLabels, []string{})
+	// 显式配置为空时不记录匹配的子 parser，GetStringOr 会将空值当作未配置
+	matchedField, ok := c[KeyAutoMatchedField]
+	if !ok {
+		matchedField = DefaultAutoMatchedField
+	}
+	fallbackRaw, _ := c.GetBoolOr(KeyAutoFallbackRaw, true)
+	disableRecordErrData, _ := c.GetBoolOr(KeyDisableRecordErrData, false)
+	raw, err := c.GetString(KeyAutoParsers)
+	if err != nil {
+		return nil, err
+	}
+	var configs []map[string]interface{}
+	if err = json.Unmarshal([]byte(raw), &configs); err != nil {
+		return nil, fmt.Errorf("parse %v error %v", KeyAutoParsers, err)
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("%v is empty", KeyAutoParsers)
+	}
+
+	p := &Parser{
+		name:                 name,
+		labels:               GetGrokLabels(labelList, make(map[string]struct{})),
+		matchedField:         matchedField,
+		disableRecordErrData: disableRecordErrData,
+	}
+	registry := parser.NewRegistry()
+	names := make(map[string]bool, len(configs)+1)
+	for i, config := range configs {
+		childConf, err := parser.NewConfFromJSON(config)
+		if err != nil {
+			return nil, fmt.Errorf("%v[%d] %v", KeyAutoParsers, i, err)
+		}
+		typ := childConf[KeyParserType]
+		if typ == TypeAuto {
+			return nil, fmt.Errorf("%v[%d] can not be %v parser", KeyAutoParsers, i, TypeAuto)
+		}
+		childName := childConf[KeyParserName]
+		if childName == "" {
+			childName = typ
+		}
+		if names[childName] {
+			childName += "_" + strconv.Itoa(i)
+		}
+		names[childName] = true
+		childConf[KeyParserName] = childName
+		// 子 parser 解析失败的行会交给下一个 parser，不需要记录失败的数据
+		childConf[KeyDisableRecordErrData] = "true"
+		cp, err := registry.NewLogParser(childConf)
+		if err != nil {
+			return nil, fmt.Errorf("create %v[%d] parser %v error %v", KeyAutoParsers, i, typ, err)
+		}
+		p.children = append(p.children, child{name: childName, parser: cp})
+	}
+	if fallbackRaw {
+		rp, err := registry.NewLogParser(conf.MapConf{KeyParserType: TypeRaw, KeyParserName: TypeRaw})
+		if err != nil {
+			return nil, err
+		}
+		p.fallback = &child{name: TypeRaw, parser: rp}
+	}
+	return p, nil
+}
+
+func (p *Parser) Name() string {
+	return p.name
+}
+
+func (p *Parser) Type() string {
+	return TypeAuto
+}
+
+// try 使用子 parser 解析一行，返回是否解析成功
+func try(c *child, line string) ([]Data, bool) {
+	datas, err := c.parser.Parse([]string{line})
+	if err != nil {
+		return nil, false
+	}
+	if f, ok := c.parser.(parser.Flushable); ok {
+		data, err := f.Flush()
+		if err != nil {
+			return nil, false
+		}
+		if len(data) > 0 {
+			datas = append(datas, data)
+		}
+	}
+	return datas, len(datas) > 0
+}
+
+// Stateful 子 parser 中有 Flushable 或 Stateful 的 parser 时不能并发解析
+func (p *Parser) Stateful() bool {
+	for _, c := range p.children {
+		if parser.IsStateful(c.parser) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Parser) Parse(lines []string) ([]Data, error) {
+	var (
+		datas   = make([]Data, 0, len(lines))
+		se      = &StatsError{}
+		matched = make(map[string]int64, len(p.children)+1)
+	)
+	for idx, line := range lines {
+		if line == PandoraParseFlushSignal || len(strings.TrimSpace(line)) == 0 {
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+			continue
+		}
+
+		var (
+			result []Data
+			name   string
+			ok     bool
+		)
+		for i := range p.children {
+			if result, ok = try(&p.children[i], line); ok {
+				name = p.children[i].name
+				break
+			}
+		}
+		if !ok && p.fallback != nil {
+			if result, ok = try(p.fallback, line); ok {
+				name = p.fallback.name
+			}
+		}
+		if !ok {
+			matched[statsFailed]++
+			se.AddErrors()
+			se.LastError = fmt.Sprintf("no parser matched line: %v", TruncateStrSize(line, DefaultTruncateMaxSize))
+			if p.disableRecordErrData {
+				se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+			} else {
+				datas = append(datas, Data{KeyPandoraStash: line})
+			}
+			continue
+		}
+
+		matched[name]++
+		se.AddSuccess()
+		for _, data := range result {
+			if p.matchedField != "" {
+				data[p.matchedField] = name
+			}
+			for _, label := range p.labels {
+				data[label.Name] = label.Value
+			}
+			datas = append(datas, data)
+		}
+	}
+
+	p.lock.Lock()
+	details := make(map[string]int64, len(p.stats.Details)+len(matched))
+	for k, v := range p.stats.Details {
+		details[k] = v
+	}
+	for k, v := range matched {
+		details[k] += v
+	}
+	p.stats.Details = details
+	p.stats.Success += se.Success
+	p.stats.Errors += se.Errors
+	if se.LastError != "" {
+		p.stats.LastError = se.LastError
+	}
+	p.lock.Unlock()
+
+	if se.Errors == 0 {
+		return datas, nil
+	}
+	return datas, se
+}
+
+// Stats 返回的 Details 为每个子 parser 成功解析的行数，raw 为兜底解析的行数
+func (p *Parser) Stats() StatsInfo {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.stats
+}
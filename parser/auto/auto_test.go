@@ -0,0 +1,103 @@
+package auto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/parser"
+	. "github.com/qiniu/logkit/parser/config"
+	_ "github.com/qiniu/logkit/parser/grok"
+	_ "github.com/qiniu/logkit/parser/json"
+	_ "github.com/qiniu/logkit/parser/raw"
+	_ "github.com/qiniu/logkit/parser/syslog"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestParse(t *testing.T) {
+	p, err := NewParser(conf.MapConf{
+		KeyParserName: "test",
+		KeyLabels:     "env prod",
+		KeyAutoParsers: `[
+			{"type": "json"},
+			{"type": "grok", "name": "access", "grok_patterns": "%{IP:ip} %{WORD:method} %{NUMBER:code:long}"}
+		]`,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "test", p.Name())
+	assert.Equal(t, TypeAuto, p.(parser.ParserType).Type())
+
+	datas, err := p.Parse([]string{
+		`{"level":"error","msg":"boom"}`,
+		"",
+		"127.0.0.1 GET 200",
+		"	at com.example.Main.run(Main.java:10)",
+		`{"level":"info"}`,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, datas, 4)
+	assert.Equal(t, Data{"level": "error", "msg": "boom", DefaultAutoMatchedField: "json", "env": "prod"}, datas[0])
+	assert.Equal(t, Data{"ip": "127.0.0.1", "method": "GET", "code": int64(200), DefaultAutoMatchedField: "access", "env": "prod"}, datas[1])
+	assert.Equal(t, "	at com.example.Main.run(Main.java:10)", datas[2][KeyRaw])
+	assert.Equal(t, TypeRaw, datas[2][DefaultAutoMatchedField])
+	assert.Equal(t, "json", datas[3][DefaultAutoMatchedField])
+	assert.Equal(t, map[string]int64{"json": 2, "access": 1, "raw": 1}, p.(parser.StatsParser).Stats().Details)
+
+	_, err = p.Parse([]string{"10.0.0.1 POST 500"})
+	assert.NoError(t, err)
+	stats := p.(parser.StatsParser).Stats()
+	assert.Equal(t, map[string]int64{"json": 2, "access": 2, "raw": 1}, stats.Details)
+	assert.Equal(t, int64(5), stats.Success)
+	assert.False(t, parser.IsStateful(p))
+
+	// 子 parser 会缓存多行时不能并发解析
+	p, err = NewParser(conf.MapConf{KeyAutoParsers: `[{"type": "syslog"}]`})
+	assert.NoError(t, err)
+	assert.True(t, parser.IsStateful(p))
+}
+
+func TestParseWithoutFallback(t *testing.T) {
+	p, err := NewParser(conf.MapConf{
+		KeyAutoParsers:      `[{"type": "json"}, {"type": "json", "name": "json"}]`,
+		KeyAutoMatchedField: "",
+		KeyAutoFallbackRaw:  "false",
+	})
+	assert.NoError(t, err)
+	datas, err := p.Parse([]string{`{"a":"b"}`, "not json"})
+	se, ok := err.(*StatsError)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), se.Errors)
+	assert.Equal(t, int64(1), se.Success)
+	assert.Equal(t, []Data{{"a": "b"}, {KeyPandoraStash: "not json"}}, datas)
+	assert.Equal(t, map[string]int64{"json": 1, statsFailed: 1}, p.(parser.StatsParser).Stats().Details)
+	// 重名的子 parser 使用序号区分
+	assert.Equal(t, "json_1", p.(*Parser).children[1].name)
+
+	p, err = NewParser(conf.MapConf{
+		KeyAutoParsers:          `[{"type": "json"}]`,
+		KeyAutoFallbackRaw:      "false",
+		KeyDisableRecordErrData: "true",
+	})
+	assert.NoError(t, err)
+	datas, err = p.Parse([]string{"not json"})
+	se, ok = err.(*StatsError)
+	assert.True(t, ok)
+	assert.Equal(t, []int{0}, se.DatasourceSkipIndex)
+	assert.Empty(t, datas)
+}
+
+func TestNewParserError(t *testing.T) {
+	for _, c := range []conf.MapConf{
+		{},
+		{KeyAutoParsers: "json"},
+		{KeyAutoParsers: "[]"},
+		{KeyAutoParsers: `[{"name": "x"}]`},
+		{KeyAutoParsers: `[{"type": "unknown"}]`},
+		{KeyAutoParsers: `[{"type": "auto"}]`},
+		{KeyAutoParsers: `[{"type": "grok"}]`},
+	} {
+		_, err := NewParser(c)
+		assert.Error(t, err)
+	}
+}
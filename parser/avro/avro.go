@@ -0,0 +1,194 @@
+package avro
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/json-iterator/go"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/parser"
+	. "github.com/qiniu/logkit/parser/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// Confluent 的格式为 1 字节的 0、4 字节大端序的 schema ID，之后为 avro 数据
+const (
+	wireMagic      = 0
+	wireHeaderSize = 5
+)
+
+var (
+	_ parser.Parser       = &Parser{}
+	_ parser.ParserType   = &Parser{}
+	_ parser.BinaryParser = &Parser{}
+)
+
+func init() {
+	parser.RegisterConstructor(TypeAvro, NewParser)
+}
+
+// Parser 解析二进制的 avro 数据，每一行为一条顶层为 record 的数据
+// 配置了 schema registry 时按 Confluent 的格式解析，schema 按 ID 获取后缓存
+type Parser struct {
+	name                 string
+	labels               []GrokLabel
+	disableRecordErrData bool
+	schema               *schema
+	registryURL          string
+	client               *http.Client
+
+	lock    sync.RWMutex
+	schemas map[uint32]*schema
+}
+
+func NewParser(c conf.MapConf) (parser.Parser, error) {
+	name, _ := c.GetStringOr(KeyParserName, "")
+	labelList, _ := c.GetStringListOr(KeyLabels, []string{})
+	disableRecordErrData, _ := c.GetBoolOr(KeyDisableRecordErrData, false)
+	schemaFile, _ := c.GetStringOr(KeyAvroSchemaFile, "")
+	registryURL, _ := c.GetStringOr(KeyAvroSchemaRegistryURL, "")
+
+	p := &Parser{
+		name:                 name,
+		labels:               GetGrokLabels(labelList, make(map[string]struct{})),
+		disableRecordErrData: disableRecordErrData,
+		registryURL:          strings.TrimRight(registryURL, "/"),
+		schemas:              make(map[uint32]*schema),
+	}
+	switch {
+	case registryURL != "":
+		p.client = &http.Client{Timeout: 10 * time.Second}
+	case schemaFile != "":
+		raw, err := ioutil.ReadFile(schemaFile)
+		if err != nil {
+			return nil, fmt.Errorf("read %v error %v", KeyAvroSchemaFile, err)
+		}
+		if p.schema, err = parseSchema(raw); err != nil {
+			return nil, err
+		}
+		if p.schema.typ != "record" {
+			return nil, fmt.Errorf("avro schema in %v must be a record", schemaFile)
+		}
+	default:
+		return nil, fmt.Errorf("%v or %v is required", KeyAvroSchemaFile, KeyAvroSchemaRegistryURL)
+	}
+	return p, nil
+}
+
+func (p *Parser) Name() string {
+	return p.name
+}
+
+func (p *Parser) Type() string {
+	return TypeAvro
+}
+
+func (p *Parser) Binary() bool {
+	return true
+}
+
+func (p *Parser) Parse(lines []string) ([]Data, error) {
+	var (
+		datas = make([]Data, 0, len(lines))
+		se    = &StatsError{}
+	)
+	for idx, line := range lines {
+		if line == PandoraParseFlushSignal {
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+			continue
+		}
+		data, err := p.parse([]byte(line))
+		if err != nil {
+			se.AddErrors()
+			se.LastError = err.Error()
+			if p.disableRecordErrData {
+				se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+			} else {
+				datas = append(datas, Data{KeyPandoraStash: base64.StdEncoding.EncodeToString([]byte(line))})
+			}
+			continue
+		}
+		for _, l := range p.labels {
+			data[l.Name] = l.Value
+		}
+		se.AddSuccess()
+		datas = append(datas, data)
+	}
+	if se.Errors == 0 {
+		return datas, nil
+	}
+	return datas, se
+}
+
+func (p *Parser) parse(b []byte) (Data, error) {
+	s := p.schema
+	if p.registryURL != "" {
+		if len(b) < wireHeaderSize || b[0] != wireMagic {
+			return nil, errors.New("avro data is not in confluent wire format")
+		}
+		var err error
+		if s, err = p.getSchema(binary.BigEndian.Uint32(b[1:wireHeaderSize])); err != nil {
+			return nil, err
+		}
+		b = b[wireHeaderSize:]
+	}
+	d := &decoder{buf: b}
+	v, err := d.decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("decode avro data error %v", err)
+	}
+	if d.pos != len(b) {
+		return nil, fmt.Errorf("decode avro data error %d bytes left", len(b)-d.pos)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("avro data is not a record: %v", v)
+	}
+	return Data(m), nil
+}
+
+// getSchema 从 schema registry 获取 schema，获取失败时不缓存，下一条数据会重新获取
+func (p *Parser) getSchema(id uint32) (*schema, error) {
+	p.lock.RLock()
+	s, ok := p.schemas[id]
+	p.lock.RUnlock()
+	if ok {
+		return s, nil
+	}
+
+	url := fmt.Sprintf("%v/schemas/ids/%d", p.registryURL, id)
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("get avro schema %d error %v", id, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("get avro schema %d error %v", id, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get avro schema %d error: %v %v", id, resp.Status, TruncateStrSize(string(body), DefaultTruncateMaxSize))
+	}
+	var ret struct {
+		Schema string `json:"schema"`
+	}
+	if err = jsoniter.Unmarshal(body, &ret); err != nil {
+		return nil, fmt.Errorf("get avro schema %d error %v", id, err)
+	}
+	if s, err = parseSchema([]byte(ret.Schema)); err != nil {
+		return nil, fmt.Errorf("avro schema %d: %v", id, err)
+	}
+
+	p.lock.Lock()
+	p.schemas[id] = s
+	p.lock.Unlock()
+	return s, nil
+}
@@ -0,0 +1,153 @@
+package avro
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/parser/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const eventSchema = `{"type":"record","name":"Event","namespace":"log","fields":[
+	{"name":"id","type":"long"},
+	{"name":"msg","type":["null","string"]},
+	{"name":"ok","type":"boolean"},
+	{"name":"score","type":"double"},
+	{"name":"ratio","type":"float"},
+	{"name":"level","type":{"type":"enum","name":"Level","symbols":["INFO","ERROR"]}},
+	{"name":"tags","type":{"type":"map","values":"string"}},
+	{"name":"ids","type":{"type":"array","items":"int"}},
+	{"name":"host","type":{"type":"record","name":"Host","fields":[
+		{"name":"name","type":"string"},
+		{"name":"md5","type":{"type":"fixed","name":"MD5","size":2}}
+	]}},
+	{"name":"backup","type":["null","log.Host"]},
+	{"name":"ts","type":{"type":"long","logicalType":"timestamp-millis"}},
+	{"name":"raw","type":"bytes"}
+]}`
+
+func long(v int64) []byte {
+	b := make([]byte, binary.MaxVarintLen64)
+	return b[:binary.PutVarint(b, v)]
+}
+
+func str(s string) []byte {
+	return append(long(int64(len(s))), s...)
+}
+
+func encodeEvent() []byte {
+	score := make([]byte, 8)
+	binary.LittleEndian.PutUint64(score, math.Float64bits(1.5))
+	ratio := make([]byte, 4)
+	binary.LittleEndian.PutUint32(ratio, math.Float32bits(0.25))
+	ids := append(long(1), long(2)...)
+	return bytes.Join([][]byte{
+		long(-2),
+		long(1), str("hi"),
+		{1},
+		score,
+		ratio,
+		long(1),
+		long(1), str("k"), str("v"), long(0),
+		long(-2), long(int64(len(ids))), ids, long(0),
+		str("a"), {0, 0xff},
+		long(0),
+		long(1514764800000),
+		str("\n"),
+	}, nil)
+}
+
+func TestParse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "avro")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	schemaFile := filepath.Join(dir, "event.avsc")
+	assert.NoError(t, ioutil.WriteFile(schemaFile, []byte(eventSchema), 0644))
+
+	p, err := NewParser(conf.MapConf{KeyParserName: "avro", KeyAvroSchemaFile: schemaFile, KeyLabels: "env prod"})
+	assert.NoError(t, err)
+	assert.Equal(t, "avro", p.Name())
+	event := encodeEvent()
+	datas, err := p.Parse([]string{string(event), string(event[:len(event)-1]), string(append(event, 0))})
+	se, ok := err.(*StatsError)
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), se.Errors)
+	assert.Equal(t, int64(1), se.Success)
+	assert.Equal(t, Data{
+		"id":     int64(-2),
+		"msg":    "hi",
+		"ok":     true,
+		"score":  1.5,
+		"ratio":  0.25,
+		"level":  "ERROR",
+		"tags":   map[string]interface{}{"k": "v"},
+		"ids":    []interface{}{int64(1), int64(2)},
+		"host":   map[string]interface{}{"name": "a", "md5": "AP8="},
+		"backup": nil,
+		"ts":     "2018-01-01T00:00:00Z",
+		"raw":    "Cg==",
+		"env":    "prod",
+	}, datas[0])
+	assert.NotEmpty(t, datas[1][KeyPandoraStash])
+	assert.NotEmpty(t, datas[2][KeyPandoraStash])
+}
+
+func TestParseSchemaRegistry(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if r.URL.Path != "/schemas/ids/7" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"schema":"{\"type\":\"record\",\"name\":\"R\",\"fields\":[{\"name\":\"a\",\"type\":\"string\"},{\"name\":\"next\",\"type\":[\"null\",\"R\"]}]}"}`))
+	}))
+	defer server.Close()
+
+	p, err := NewParser(conf.MapConf{KeyAvroSchemaRegistryURL: server.URL + "/", KeyDisableRecordErrData: "true"})
+	assert.NoError(t, err)
+	record := bytes.Join([][]byte{{0, 0, 0, 0, 7}, str("x"), long(1), str("y"), long(0)}, nil)
+	datas, err := p.Parse([]string{string(record), string(record), "\x00\x00\x00\x00\x08", "plain"})
+	se, ok := err.(*StatsError)
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), se.Errors)
+	assert.Equal(t, []int{2, 3}, se.DatasourceSkipIndex)
+	assert.Equal(t, []Data{
+		{"a": "x", "next": map[string]interface{}{"a": "y", "next": nil}},
+		{"a": "x", "next": map[string]interface{}{"a": "y", "next": nil}},
+	}, datas)
+	// 获取成功的 schema 会被缓存
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+}
+
+func TestNewParserError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "avro")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	schemas := []string{
+		`"string"`,
+		`{"type":"record","name":"A","fields":[{"name":"b","type":"Unknown"}]}`,
+		`{"type":"record","name":"A","fields":[{"name":"b","type":{"type":"record","name":"A","fields":[]}}]}`,
+		`{"type":"record","fields":[]}`,
+		`not json`,
+	}
+	for i, s := range schemas {
+		schemaFile := filepath.Join(dir, "schema.avsc")
+		assert.NoError(t, ioutil.WriteFile(schemaFile, []byte(s), 0644))
+		_, err = NewParser(conf.MapConf{KeyAvroSchemaFile: schemaFile})
+		assert.Error(t, err, schemas[i])
+	}
+	_, err = NewParser(conf.MapConf{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,179 @@
+package avro
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+var errTruncated = errors.New("avro data is truncated")
+
+// decoder 按 avro 二进制编码读取数据
+type decoder struct {
+	buf []byte
+	pos int
+}
+
+func (d *decoder) long() (int64, error) {
+	v, n := binary.Varint(d.buf[d.pos:])
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	d.pos += n
+	return v, nil
+}
+
+func (d *decoder) next(n int64) ([]byte, error) {
+	if n < 0 || n > int64(len(d.buf)-d.pos) {
+		return nil, errTruncated
+	}
+	b := d.buf[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+func (d *decoder) bytes() ([]byte, error) {
+	n, err := d.long()
+	if err != nil {
+		return nil, err
+	}
+	return d.next(n)
+}
+
+// decode 按 schema 解析一个值，record 和 map 解析为 map，bytes 和 fixed 解析为 base64 编码的字符串
+// union 解析为实际分支的值，timestamp-millis、timestamp-micros 和 date 解析为时间字符串
+func (d *decoder) decode(s *schema) (interface{}, error) {
+	switch s.typ {
+	case "null":
+		return nil, nil
+	case "boolean":
+		b, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0] != 0, nil
+	case "int", "long":
+		v, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		switch s.logical {
+		case "timestamp-millis":
+			return time.Unix(0, v*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano), nil
+		case "timestamp-micros":
+			return time.Unix(0, v*int64(time.Microsecond)).UTC().Format(time.RFC3339Nano), nil
+		case "date":
+			return time.Unix(v*24*3600, 0).UTC().Format("2006-01-02"), nil
+		}
+		return v, nil
+	case "float":
+		b, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), nil
+	case "double":
+		b, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case "string":
+		b, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case "bytes":
+		b, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.EncodeToString(b), nil
+	case "fixed":
+		b, err := d.next(int64(s.size))
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.EncodeToString(b), nil
+	case "enum":
+		idx, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		if idx < 0 || idx >= int64(len(s.symbols)) {
+			return nil, fmt.Errorf("enum %v index %d out of range", s.name, idx)
+		}
+		return s.symbols[idx], nil
+	case "union":
+		idx, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		if idx < 0 || idx >= int64(len(s.union)) {
+			return nil, fmt.Errorf("union index %d out of range", idx)
+		}
+		return d.decode(s.union[idx])
+	case "record":
+		m := make(map[string]interface{}, len(s.fields))
+		for _, f := range s.fields {
+			v, err := d.decode(f.schema)
+			if err != nil {
+				return nil, fmt.Errorf("field %v of record %v: %v", f.name, s.name, err)
+			}
+			m[f.name] = v
+		}
+		return m, nil
+	case "array":
+		list := make([]interface{}, 0)
+		err := d.blocks(func() error {
+			v, err := d.decode(s.items)
+			list = append(list, v)
+			return err
+		})
+		return list, err
+	case "map":
+		m := make(map[string]interface{})
+		err := d.blocks(func() error {
+			k, err := d.bytes()
+			if err != nil {
+				return err
+			}
+			v, err := d.decode(s.values)
+			m[string(k)] = v
+			return err
+		})
+		return m, err
+	}
+	return nil, fmt.Errorf("unsupported avro type %v", s.typ)
+}
+
+// blocks 读取 array 和 map 的各个 block，数量为负数的 block 之后还有 block 的字节数
+func (d *decoder) blocks(fn func() error) error {
+	for {
+		count, err := d.long()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return nil
+		}
+		if count < 0 {
+			count = -count
+			if _, err = d.long(); err != nil {
+				return err
+			}
+		}
+		if count > int64(len(d.buf)-d.pos) {
+			return errTruncated
+		}
+		for i := int64(0); i < count; i++ {
+			if err = fn(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package avro
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// schema 为解析后的 avro schema，引用的命名类型会指向同一个 schema
+type schema struct {
+	typ     string
+	name    string
+	logical string
+	fields  []field   // record
+	symbols []string  // enum
+	items   *schema   // array
+	values  *schema   // map
+	union   []*schema // union
+	size    int       // fixed
+}
+
+type field struct {
+	name   string
+	schema *schema
+}
+
+var primitives = map[string]bool{
+	"null": true, "boolean": true, "int": true, "long": true,
+	"float": true, "double": true, "bytes": true, "string": true,
+}
+
+// parseSchema 解析 json 格式的 avro schema
+func parseSchema(raw []byte) (*schema, error) {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, fmt.Errorf("parse avro schema error %v", err)
+	}
+	s, err := (&schemaParser{names: make(map[string]*schema)}).parse(v, "")
+	if err != nil {
+		return nil, fmt.Errorf("parse avro schema error %v", err)
+	}
+	return s, nil
+}
+
+type schemaParser struct {
+	names map[string]*schema
+}
+
+func (sp *schemaParser) parse(v interface{}, namespace string) (*schema, error) {
+	switch t := v.(type) {
+	case string:
+		if primitives[t] {
+			return &schema{typ: t}, nil
+		}
+		return sp.lookup(t, namespace)
+	case []interface{}:
+		s := &schema{typ: "union"}
+		for _, b := range t {
+			branch, err := sp.parse(b, namespace)
+			if err != nil {
+				return nil, err
+			}
+			s.union = append(s.union, branch)
+		}
+		return s, nil
+	case map[string]interface{}:
+		return sp.parseComplex(t, namespace)
+	}
+	return nil, fmt.Errorf("invalid schema %v", v)
+}
+
+func (sp *schemaParser) parseComplex(m map[string]interface{}, namespace string) (*schema, error) {
+	typ, ok := m["type"].(string)
+	if !ok {
+		// type 本身也可以是一个 schema
+		if m["type"] == nil {
+			return nil, errors.New("schema without type")
+		}
+		return sp.parse(m["type"], namespace)
+	}
+	logical, _ := m["logicalType"].(string)
+	if primitives[typ] {
+		return &schema{typ: typ, logical: logical}, nil
+	}
+
+	s := &schema{typ: typ, logical: logical}
+	switch typ {
+	case "record", "error", "enum", "fixed":
+		if err := sp.define(s, m, namespace); err != nil {
+			return nil, err
+		}
+	}
+	switch typ {
+	case "record", "error":
+		s.typ = "record"
+		fields, ok := m["fields"].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("record %v without fields", s.name)
+		}
+		for _, f := range fields {
+			fm, ok := f.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid field %v of record %v", f, s.name)
+			}
+			name, _ := fm["name"].(string)
+			if name == "" {
+				return nil, fmt.Errorf("field without name in record %v", s.name)
+			}
+			fs, err := sp.parse(fm["type"], namespaceOf(s.name))
+			if err != nil {
+				return nil, fmt.Errorf("field %v of record %v: %v", name, s.name, err)
+			}
+			s.fields = append(s.fields, field{name: name, schema: fs})
+		}
+	case "enum":
+		symbols, _ := m["symbols"].([]interface{})
+		for _, sym := range symbols {
+			name, ok := sym.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid symbol %v of enum %v", sym, s.name)
+			}
+			s.symbols = append(s.symbols, name)
+		}
+	case "fixed":
+		size, ok := m["size"].(float64)
+		if !ok || size < 0 {
+			return nil, fmt.Errorf("invalid size of fixed %v", s.name)
+		}
+		s.size = int(size)
+	case "array":
+		items, err := sp.parse(m["items"], namespace)
+		if err != nil {
+			return nil, fmt.Errorf("array items: %v", err)
+		}
+		s.items = items
+	case "map":
+		values, err := sp.parse(m["values"], namespace)
+		if err != nil {
+			return nil, fmt.Errorf("map values: %v", err)
+		}
+		s.values = values
+	default:
+		return sp.lookup(typ, namespace)
+	}
+	return s, nil
+}
+
+// define 记录命名类型的完整名称，需要在解析 record 的字段之前完成，以支持递归引用
+func (sp *schemaParser) define(s *schema, m map[string]interface{}, namespace string) error {
+	name, _ := m["name"].(string)
+	if name == "" {
+		return fmt.Errorf("%v without name", s.typ)
+	}
+	if ns, ok := m["namespace"].(string); ok && !strings.Contains(name, ".") {
+		namespace = ns
+	}
+	if !strings.Contains(name, ".") && namespace != "" {
+		name = namespace + "." + name
+	}
+	if _, ok := sp.names[name]; ok {
+		return fmt.Errorf("duplicate type %v", name)
+	}
+	s.name = name
+	sp.names[name] = s
+	return nil
+}
+
+func (sp *schemaParser) lookup(name, namespace string) (*schema, error) {
+	if !strings.Contains(name, ".") && namespace != "" {
+		if s, ok := sp.names[namespace+"."+name]; ok {
+			return s, nil
+		}
+	}
+	if s, ok := sp.names[name]; ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("unknown type %v", name)
+}
+
+func namespaceOf(fullName string) string {
+	if idx := strings.LastIndex(fullName, "."); idx >= 0 {
+		return fullName[:idx]
+	}
+	return ""
+}
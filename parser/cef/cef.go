@@ -0,0 +1,400 @@
+package cef
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/parser"
+	. "github.com/qiniu/logkit/parser/config"
+	"github.com/qiniu/logkit/parser/syslog"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// 头部字段的名称，CEF 和 LEEF 共用厂商、产品和版本字段
+const (
+	FieldCEFVersion    = "cef_version"
+	FieldLEEFVersion   = "leef_version"
+	FieldDeviceVendor  = "device_vendor"
+	FieldDeviceProduct = "device_product"
+	FieldDeviceVersion = "device_version"
+	FieldSignatureID   = "signature_id"
+	FieldName          = "name"
+	FieldSeverity      = "severity"
+	FieldEventID       = "event_id"
+
+	// FieldSyslogPrefix 为 syslog 头部字段的前缀，无法按 RFC3164/RFC5424 解析的头部原样记录在 syslog_header 中
+	FieldSyslogPrefix = "syslog_"
+	FieldSyslogHeader = "syslog_header"
+)
+
+func init() {
+	parser.RegisterConstructor(TypeCEF, NewParser)
+	parser.RegisterConstructor(TypeLEEF, NewParser)
+}
+
+type Parser struct {
+	name                 string
+	typ                  string
+	labels               []GrokLabel
+	disableRecordErrData bool
+	keepRawData          bool
+	numRoutine           int
+
+	fullKeys       bool
+	convertTypes   bool
+	leefDelimiter  string
+	timeZoneOffset int
+}
+
+func NewParser(c conf.MapConf) (parser.Parser, error) {
+	typ, _ := c.GetStringOr(KeyParserType, TypeCEF)
+	name, _ := c.GetStringOr(KeyParserName, "")
+	labelList, _ := c.GetStringListOr(KeyLabels, []string{})
+	disableRecordErrData, _ := c.GetBoolOr(KeyDisableRecordErrData, false)
+	keepRawData, _ := c.GetBoolOr(KeyKeepRawData, false)
+	timeZoneOffsetRaw, _ := c.GetStringOr(KeyTimeZoneOffset, "")
+	numRoutine := MaxProcs
+	if numRoutine == 0 {
+		numRoutine = 1
+	}
+
+	p := &Parser{
+		name:                 name,
+		typ:                  typ,
+		labels:               GetGrokLabels(labelList, make(map[string]struct{})),
+		disableRecordErrData: disableRecordErrData,
+		keepRawData:          keepRawData,
+		numRoutine:           numRoutine,
+		timeZoneOffset:       ParseTimeZoneOffset(timeZoneOffsetRaw),
+	}
+	switch typ {
+	case TypeCEF:
+		p.fullKeys, _ = c.GetBoolOr(KeyCEFFullKeys, false)
+		p.convertTypes, _ = c.GetBoolOr(KeyCEFConvertTypes, true)
+	case TypeLEEF:
+		p.convertTypes, _ = c.GetBoolOr(KeyLEEFConvertTypes, true)
+		p.leefDelimiter = "\t"
+		if raw, _ := c.GetStringOr(KeyLEEFDelimiter, ""); raw != "" {
+			delimiter, ok := parseDelimiter(raw)
+			if !ok {
+				return nil, fmt.Errorf("invalid %v %q", KeyLEEFDelimiter, raw)
+			}
+			p.leefDelimiter = delimiter
+		}
+	default:
+		return nil, fmt.Errorf("parser type %v is not %v or %v", typ, TypeCEF, TypeLEEF)
+	}
+	return p, nil
+}
+
+func (p *Parser) Name() string {
+	return p.name
+}
+
+func (p *Parser) Type() string {
+	return p.typ
+}
+
+func (p *Parser) Parse(lines []string) ([]Data, error) {
+	var (
+		lineLen    = len(lines)
+		datas      = make([]Data, 0, lineLen)
+		se         = &StatsError{}
+		numRoutine = p.numRoutine
+
+		sendChan   = make(chan parser.ParseInfo)
+		resultChan = make(chan parser.ParseResult)
+		wg         = new(sync.WaitGroup)
+	)
+	if lineLen < numRoutine {
+		numRoutine = lineLen
+	}
+
+	for i := 0; i < numRoutine; i++ {
+		wg.Add(1)
+		go parser.ParseLine(sendChan, resultChan, wg, true, p.parse)
+	}
+
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
+
+	go func() {
+		for idx, line := range lines {
+			sendChan <- parser.ParseInfo{
+				Line:  line,
+				Index: idx,
+			}
+		}
+		close(sendChan)
+	}()
+	var parseResultSlice = make(parser.ParseResultSlice, lineLen)
+	for resultInfo := range resultChan {
+		parseResultSlice[resultInfo.Index] = resultInfo
+	}
+
+	se.DatasourceSkipIndex = make([]int, lineLen)
+	datasourceIndex := 0
+	for _, parseResult := range parseResultSlice {
+		if len(parseResult.Line) == 0 {
+			se.DatasourceSkipIndex[datasourceIndex] = parseResult.Index
+			datasourceIndex++
+			continue
+		}
+
+		if parseResult.Err != nil {
+			se.AddErrors()
+			se.LastError = parseResult.Err.Error()
+			errData := make(Data)
+			if !p.disableRecordErrData {
+				errData[KeyPandoraStash] = parseResult.Line
+			} else if !p.keepRawData {
+				se.DatasourceSkipIndex[datasourceIndex] = parseResult.Index
+				datasourceIndex++
+			}
+			if p.keepRawData {
+				errData[KeyRawData] = parseResult.Line
+			}
+			if !p.disableRecordErrData || p.keepRawData {
+				datas = append(datas, errData)
+			}
+			continue
+		}
+
+		se.AddSuccess()
+		if p.keepRawData {
+			parseResult.Data[KeyRawData] = parseResult.Line
+		}
+		datas = append(datas, parseResult.Data)
+	}
+	se.DatasourceSkipIndex = se.DatasourceSkipIndex[:datasourceIndex]
+
+	if se.Errors == 0 {
+		return datas, nil
+	}
+	return datas, se
+}
+
+func (p *Parser) parse(line string) (Data, error) {
+	magic := "CEF:"
+	if p.typ == TypeLEEF {
+		magic = "LEEF:"
+	}
+	idx := indexMagic(line, magic)
+	if idx < 0 {
+		return nil, fmt.Errorf("%v header not found in line: %v", magic, TruncateStrSize(line, DefaultTruncateMaxSize))
+	}
+
+	var (
+		data Data
+		err  error
+	)
+	if p.typ == TypeLEEF {
+		data, err = p.parseLEEF(line[idx+len(magic):])
+	} else {
+		data, err = p.parseCEF(line[idx+len(magic):])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v, line: %v", err, TruncateStrSize(line, DefaultTruncateMaxSize))
+	}
+	if idx > 0 {
+		parseSyslogHeader(data, line[:idx], line)
+	}
+	for _, l := range p.labels {
+		data[l.Name] = l.Value
+	}
+	return data, nil
+}
+
+// indexMagic 返回 magic 在 line 中第一次出现并且后面紧跟版本号的位置
+func indexMagic(line, magic string) int {
+	for offset := 0; ; {
+		idx := strings.Index(line[offset:], magic)
+		if idx < 0 {
+			return -1
+		}
+		idx += offset
+		if end := idx + len(magic); end < len(line) && line[end] >= '0' && line[end] <= '9' {
+			return idx
+		}
+		offset = idx + len(magic)
+	}
+}
+
+// parseSyslogHeader 解析 CEF/LEEF 之前的 syslog 头部，只保留头部的字段
+func parseSyslogHeader(data Data, header, line string) {
+	sp := (&syslog.Automatic{}).GetParser([]byte(line))
+	if err := sp.Parse(); err != nil && err.Error() != "No structured data" {
+		data[FieldSyslogHeader] = strings.TrimSpace(header)
+		return
+	}
+	for k, v := range sp.Dump() {
+		switch k {
+		case "tag", "content", "message", "structured_data":
+			continue
+		}
+		if s, ok := v.(string); ok && s == "" {
+			continue
+		}
+		data[FieldSyslogPrefix+k] = v
+	}
+}
+
+// splitHeader 按未转义的 | 切分出 n 个头部字段，返回字段和剩余的部分，头部字段中的 \| 和 \\ 会被反转义
+// 最后一个字段之后没有 | 时剩余部分为空
+func splitHeader(s string, n int) ([]string, string, error) {
+	var (
+		fields = make([]string, 0, n)
+		field  strings.Builder
+	)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && (s[i+1] == '|' || s[i+1] == '\\'):
+			field.WriteByte(s[i+1])
+			i++
+		case c == '|':
+			fields = append(fields, field.String())
+			field.Reset()
+			if len(fields) == n {
+				return fields, s[i+1:], nil
+			}
+		default:
+			field.WriteByte(c)
+		}
+	}
+	if len(fields) == n-1 {
+		return append(fields, field.String()), "", nil
+	}
+	return nil, "", fmt.Errorf("expect %d header fields, got %d", n, len(fields)+1)
+}
+
+// parseCEF 解析 Version|Device Vendor|Device Product|Device Version|Signature ID|Name|Severity|Extension
+func (p *Parser) parseCEF(s string) (Data, error) {
+	header, ext, err := splitHeader(s, 7)
+	if err != nil {
+		return nil, err
+	}
+	data := Data{
+		FieldCEFVersion:    header[0],
+		FieldDeviceVendor:  header[1],
+		FieldDeviceProduct: header[2],
+		FieldDeviceVersion: header[3],
+		FieldSignatureID:   header[4],
+		FieldName:          header[5],
+		FieldSeverity:      header[6],
+	}
+	if p.convertTypes {
+		for _, key := range []string{FieldCEFVersion, FieldSeverity} {
+			if v, err := strconv.ParseInt(strings.TrimSpace(data[key].(string)), 10, 64); err == nil {
+				data[key] = v
+			}
+		}
+	}
+
+	for _, kv := range parseExtension(ext) {
+		key, value := kv[0], unescapeValue(kv[1])
+		full := key
+		if f, ok := cefFullKeys[key]; ok {
+			full = f
+		}
+		if p.fullKeys {
+			key = full
+		}
+		if p.convertTypes {
+			data[key] = p.convert(cefFieldTypes[full], value, "")
+		} else {
+			data[key] = value
+		}
+	}
+	return data, nil
+}
+
+// parseExtension 解析空格分隔的 key=value，值中可以包含空格，以下一个 " key=" 作为值的结束
+func parseExtension(ext string) [][2]string {
+	type position struct {
+		key        string
+		start, end int
+	}
+	var positions []position
+	for i := 0; i < len(ext); i++ {
+		switch ext[i] {
+		case '\\':
+			i++
+		case '=':
+			start := strings.LastIndexByte(ext[:i], ' ') + 1
+			if start < i && validKey(ext[start:i]) {
+				positions = append(positions, position{ext[start:i], start, i + 1})
+			}
+		}
+	}
+
+	kvs := make([][2]string, 0, len(positions))
+	for i, pos := range positions {
+		end := len(ext)
+		if i+1 < len(positions) {
+			end = positions[i+1].start
+		}
+		kvs = append(kvs, [2]string{pos.key, strings.TrimRight(ext[pos.end:end], " \t\r\n")})
+	}
+	return kvs
+}
+
+func validKey(key string) bool {
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-' || c == '[' || c == ']') {
+			return false
+		}
+	}
+	return true
+}
+
+// unescapeValue 反转义扩展字段的值，支持 \\ \= \| \n \r
+func unescapeValue(v string) string {
+	if strings.IndexByte(v, '\\') < 0 {
+		return v
+	}
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] != '\\' || i+1 == len(v) {
+			b.WriteByte(v[i])
+			continue
+		}
+		switch v[i+1] {
+		case '\\', '=', '|':
+			b.WriteByte(v[i+1])
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			b.WriteByte(v[i])
+			b.WriteByte(v[i+1])
+		}
+		i++
+	}
+	return b.String()
+}
+
+// convert 将已知类型字段的值转换为对应的类型，转换失败时保留原始的字符串
+func (p *Parser) convert(typ fieldType, value, layout string) interface{} {
+	switch typ {
+	case fieldLong:
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return v
+		}
+	case fieldFloat:
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			return v
+		}
+	case fieldTime:
+		if v, err := parseTime(value, layout, p.timeZoneOffset); err == nil {
+			return v
+		}
+	}
+	return value
+}
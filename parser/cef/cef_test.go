@@ -0,0 +1,165 @@
+package cef
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/parser/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestParseCEF(t *testing.T) {
+	p, err := NewParser(conf.MapConf{KeyParserType: TypeCEF, KeyParserName: "cef", KeyLabels: "env prod"})
+	assert.NoError(t, err)
+	assert.Equal(t, "cef", p.Name())
+
+	datas, err := p.Parse([]string{
+		`CEF:0|Security|threat\|manager|1.0|100|worm \\ stopped|10|src=10.0.0.1 act=blocked a \= sign msg=line1\nline2  dpt=443 rt=1514764800000 end=Jan 01 2018 08:00:00 cs1Label=Rule Name cs1=my rule`,
+		"",
+		"<134>Feb  5 01:02:03 fw01 CEF:1|Vendor|Product|2.0|200|login|High|suser=bob request=http://x.com/?a=b&c=d",
+		"CEF:0|Vendor|Product|2.0|200|no extension|3",
+	})
+	assert.NoError(t, err)
+	assert.Len(t, datas, 3)
+	assert.Equal(t, Data{
+		FieldCEFVersion:    int64(0),
+		FieldDeviceVendor:  "Security",
+		FieldDeviceProduct: "threat|manager",
+		FieldDeviceVersion: "1.0",
+		FieldSignatureID:   "100",
+		FieldName:          `worm \ stopped`,
+		FieldSeverity:      int64(10),
+		"src":              "10.0.0.1",
+		"act":              "blocked a = sign",
+		"msg":              "line1\nline2",
+		"dpt":              int64(443),
+		"rt":               "2018-01-01T00:00:00Z",
+		"end":              "2018-01-01T08:00:00Z",
+		"cs1Label":         "Rule Name",
+		"cs1":              "my rule",
+		"env":              "prod",
+	}, datas[0])
+
+	assert.Equal(t, "High", datas[1][FieldSeverity])
+	assert.Equal(t, "bob", datas[1]["suser"])
+	assert.Equal(t, "http://x.com/?a=b&c=d", datas[1]["request"])
+	assert.Equal(t, "fw01", datas[1]["syslog_hostname"])
+	assert.Equal(t, 134, datas[1]["syslog_priority"])
+
+	assert.Equal(t, "no extension", datas[2][FieldName])
+	assert.Equal(t, int64(3), datas[2][FieldSeverity])
+}
+
+func TestParseCEFFullKeys(t *testing.T) {
+	p, err := NewParser(conf.MapConf{
+		KeyParserType:      TypeCEF,
+		KeyCEFFullKeys:     "true",
+		KeyCEFConvertTypes: "false",
+		KeyTimeZoneOffset:  "-8",
+	})
+	assert.NoError(t, err)
+	datas, err := p.Parse([]string{"Jan 05 10:00:00 host CEF:0|V|P|1|2|N|5|src=1.1.1.1 spt=80 cn1=7 unknown=x"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{{
+		FieldCEFVersion:       "0",
+		FieldDeviceVendor:     "V",
+		FieldDeviceProduct:    "P",
+		FieldDeviceVersion:    "1",
+		FieldSignatureID:      "2",
+		FieldName:             "N",
+		FieldSeverity:         "5",
+		"sourceAddress":       "1.1.1.1",
+		"sourcePort":          "80",
+		"deviceCustomNumber1": "7",
+		"unknown":             "x",
+		FieldSyslogHeader:     "Jan 05 10:00:00 host",
+	}}, datas)
+
+	p, err = NewParser(conf.MapConf{KeyParserType: TypeCEF, KeyCEFFullKeys: "true", KeyTimeZoneOffset: "-8"})
+	assert.NoError(t, err)
+	datas, err = p.Parse([]string{"CEF:0|V|P|1|2|N|5|spt=80 start=Jan 01 2018 08:00:00.123"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(80), datas[0]["sourcePort"])
+	assert.Equal(t, "2018-01-01T00:00:00.123Z", datas[0]["startTime"])
+}
+
+func TestParseLEEF(t *testing.T) {
+	p, err := NewParser(conf.MapConf{KeyParserType: TypeLEEF, KeyParserName: "leef"})
+	assert.NoError(t, err)
+	assert.Equal(t, TypeLEEF, p.(interface{ Type() string }).Type())
+	datas, err := p.Parse([]string{
+		"LEEF:1.0|Microsoft|MSExchange|4.0 SP1|15345|src=192.0.2.0\tdst=172.50.123.1\tsev=5\tcat=anomaly\tmsg=hello world\tdevTime=Jan 02 2018 03:04:05\tdevTimeFormat=MMM dd yyyy HH:mm:ss",
+		"<13>Jan  5 10:00:00 host LEEF:2.0|Lancope|StealthWatch|1.0|41|^|src=10.0.1.8^dst=10.0.0.5^srcPort=1234^devTime=1514764800",
+		"LEEF:2.0|Vendor|Product|1.0|41|x7C|a=1|b=2",
+		"LEEF:1.0|Vendor|Product|1.0|41|a=1 b=two words",
+	})
+	assert.NoError(t, err)
+	assert.Len(t, datas, 4)
+	assert.Equal(t, Data{
+		FieldLEEFVersion:   "1.0",
+		FieldDeviceVendor:  "Microsoft",
+		FieldDeviceProduct: "MSExchange",
+		FieldDeviceVersion: "4.0 SP1",
+		FieldEventID:       "15345",
+		"src":              "192.0.2.0",
+		"dst":              "172.50.123.1",
+		"sev":              int64(5),
+		"cat":              "anomaly",
+		"msg":              "hello world",
+		"devTime":          "2018-01-02T03:04:05Z",
+		"devTimeFormat":    "MMM dd yyyy HH:mm:ss",
+	}, datas[0])
+
+	assert.Equal(t, "10.0.0.5", datas[1]["dst"])
+	assert.Equal(t, int64(1234), datas[1]["srcPort"])
+	assert.Equal(t, "2018-01-01T00:00:00Z", datas[1]["devTime"])
+	assert.Equal(t, "host", datas[1]["syslog_hostname"])
+
+	assert.Equal(t, "1", datas[2]["a"])
+	assert.Equal(t, "2", datas[2]["b"])
+
+	assert.Equal(t, "two words", datas[3]["b"])
+}
+
+func TestParseError(t *testing.T) {
+	p, err := NewParser(conf.MapConf{KeyParserType: TypeCEF, KeyKeepRawData: "true"})
+	assert.NoError(t, err)
+	datas, err := p.Parse([]string{"not a cef line", "CEF:0|V|P|1", "CEF:0|V|P|1|2|N|5|a=b"})
+	se, ok := err.(*StatsError)
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), se.Errors)
+	assert.Equal(t, int64(1), se.Success)
+	assert.Equal(t, Data{KeyPandoraStash: "not a cef line", KeyRawData: "not a cef line"}, datas[0])
+	assert.Equal(t, "b", datas[2]["a"])
+	assert.Equal(t, "CEF:0|V|P|1|2|N|5|a=b", datas[2][KeyRawData])
+
+	p, err = NewParser(conf.MapConf{KeyParserType: TypeLEEF, KeyDisableRecordErrData: "true"})
+	assert.NoError(t, err)
+	datas, err = p.Parse([]string{"LEEF:1.0|V|P|1|2|no attributes"})
+	se, ok = err.(*StatsError)
+	assert.True(t, ok)
+	assert.Equal(t, []int{0}, se.DatasourceSkipIndex)
+	assert.Empty(t, datas)
+
+	_, err = NewParser(conf.MapConf{KeyParserType: TypeLEEF, KeyLEEFDelimiter: "xyz"})
+	assert.Error(t, err)
+	_, err = NewParser(conf.MapConf{KeyParserType: TypeJSON})
+	assert.Error(t, err)
+}
+
+func TestJavaLayout(t *testing.T) {
+	tests := map[string]string{
+		"MMM dd yyyy HH:mm:ss.SSS zzz": "Jan 02 2006 15:04:05.000 MST",
+		"yyyy-MM-dd'T'HH:mm:ssXXX":     "2006-01-02T15:04:05Z07:00",
+		"EEE, d MMM yyyy hh:mm a":      "Mon, 2 Jan 2006 03:04 PM",
+	}
+	for format, expect := range tests {
+		layout, ok := javaLayout(format)
+		assert.True(t, ok)
+		assert.Equal(t, expect, layout)
+	}
+	_, ok := javaLayout("yyyy-MM-dd G")
+	assert.False(t, ok)
+}
@@ -0,0 +1,274 @@
+package cef
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qiniu/logkit/times"
+)
+
+type fieldType int
+
+const (
+	fieldString fieldType = iota
+	fieldLong
+	fieldFloat
+	fieldTime
+)
+
+// cefFullKeys 为 CEF 扩展字段的短名称到全称的映射，参考 ArcSight Common Event Format 规范
+var cefFullKeys = map[string]string{
+	"act":     "deviceAction",
+	"app":     "applicationProtocol",
+	"art":     "agentReceiptTime",
+	"c6a1":    "deviceCustomIPv6Address1",
+	"c6a2":    "deviceCustomIPv6Address2",
+	"c6a3":    "deviceCustomIPv6Address3",
+	"c6a4":    "deviceCustomIPv6Address4",
+	"cat":     "deviceEventCategory",
+	"cfp1":    "deviceCustomFloatingPoint1",
+	"cfp2":    "deviceCustomFloatingPoint2",
+	"cfp3":    "deviceCustomFloatingPoint3",
+	"cfp4":    "deviceCustomFloatingPoint4",
+	"cn1":     "deviceCustomNumber1",
+	"cn2":     "deviceCustomNumber2",
+	"cn3":     "deviceCustomNumber3",
+	"cnt":     "baseEventCount",
+	"cs1":     "deviceCustomString1",
+	"cs2":     "deviceCustomString2",
+	"cs3":     "deviceCustomString3",
+	"cs4":     "deviceCustomString4",
+	"cs5":     "deviceCustomString5",
+	"cs6":     "deviceCustomString6",
+	"dhost":   "destinationHostName",
+	"dlat":    "destinationGeoLatitude",
+	"dlong":   "destinationGeoLongitude",
+	"dmac":    "destinationMacAddress",
+	"dntdom":  "destinationNtDomain",
+	"dpid":    "destinationProcessId",
+	"dpriv":   "destinationUserPrivileges",
+	"dproc":   "destinationProcessName",
+	"dpt":     "destinationPort",
+	"dst":     "destinationAddress",
+	"dtz":     "deviceTimeZone",
+	"duid":    "destinationUserId",
+	"duser":   "destinationUserName",
+	"dvc":     "deviceAddress",
+	"dvchost": "deviceHostName",
+	"dvcmac":  "deviceMacAddress",
+	"dvcpid":  "deviceProcessId",
+	"end":     "endTime",
+	"fname":   "fileName",
+	"fsize":   "fileSize",
+	"in":      "bytesIn",
+	"msg":     "message",
+	"out":     "bytesOut",
+	"outcome": "eventOutcome",
+	"proto":   "transportProtocol",
+	"request": "requestUrl",
+	"rt":      "receiptTime",
+	"shost":   "sourceHostName",
+	"slat":    "sourceGeoLatitude",
+	"slong":   "sourceGeoLongitude",
+	"smac":    "sourceMacAddress",
+	"sntdom":  "sourceNtDomain",
+	"spid":    "sourceProcessId",
+	"spriv":   "sourceUserPrivileges",
+	"sproc":   "sourceProcessName",
+	"spt":     "sourcePort",
+	"src":     "sourceAddress",
+	"start":   "startTime",
+	"suid":    "sourceUserId",
+	"suser":   "sourceUserName",
+
+	"c6a1Label": "deviceCustomIPv6Address1Label",
+	"c6a2Label": "deviceCustomIPv6Address2Label",
+	"c6a3Label": "deviceCustomIPv6Address3Label",
+	"c6a4Label": "deviceCustomIPv6Address4Label",
+	"cfp1Label": "deviceCustomFloatingPoint1Label",
+	"cfp2Label": "deviceCustomFloatingPoint2Label",
+	"cfp3Label": "deviceCustomFloatingPoint3Label",
+	"cfp4Label": "deviceCustomFloatingPoint4Label",
+	"cn1Label":  "deviceCustomNumber1Label",
+	"cn2Label":  "deviceCustomNumber2Label",
+	"cn3Label":  "deviceCustomNumber3Label",
+	"cs1Label":  "deviceCustomString1Label",
+	"cs2Label":  "deviceCustomString2Label",
+	"cs3Label":  "deviceCustomString3Label",
+	"cs4Label":  "deviceCustomString4Label",
+	"cs5Label":  "deviceCustomString5Label",
+	"cs6Label":  "deviceCustomString6Label",
+}
+
+// cefFieldTypes 为按全称索引的已知类型的 CEF 扩展字段，其他字段都是字符串
+var cefFieldTypes = map[string]fieldType{
+	"baseEventCount":             fieldLong,
+	"bytesIn":                    fieldLong,
+	"bytesOut":                   fieldLong,
+	"destinationPort":            fieldLong,
+	"destinationProcessId":       fieldLong,
+	"destinationTranslatedPort":  fieldLong,
+	"deviceCustomNumber1":        fieldLong,
+	"deviceCustomNumber2":        fieldLong,
+	"deviceCustomNumber3":        fieldLong,
+	"deviceDirection":            fieldLong,
+	"deviceProcessId":            fieldLong,
+	"fileSize":                   fieldLong,
+	"flexNumber1":                fieldLong,
+	"flexNumber2":                fieldLong,
+	"oldFileSize":                fieldLong,
+	"sourcePort":                 fieldLong,
+	"sourceProcessId":            fieldLong,
+	"sourceTranslatedPort":       fieldLong,
+	"type":                       fieldLong,
+	"destinationGeoLatitude":     fieldFloat,
+	"destinationGeoLongitude":    fieldFloat,
+	"deviceCustomFloatingPoint1": fieldFloat,
+	"deviceCustomFloatingPoint2": fieldFloat,
+	"deviceCustomFloatingPoint3": fieldFloat,
+	"deviceCustomFloatingPoint4": fieldFloat,
+	"sourceGeoLatitude":          fieldFloat,
+	"sourceGeoLongitude":         fieldFloat,
+	"agentReceiptTime":           fieldTime,
+	"deviceCustomDate1":          fieldTime,
+	"deviceCustomDate2":          fieldTime,
+	"endTime":                    fieldTime,
+	"fileCreateTime":             fieldTime,
+	"fileModificationTime":       fieldTime,
+	"flexDate1":                  fieldTime,
+	"oldFileCreateTime":          fieldTime,
+	"oldFileModificationTime":    fieldTime,
+	"receiptTime":                fieldTime,
+	"startTime":                  fieldTime,
+}
+
+// leefFieldTypes 为 LEEF 预定义属性中已知类型的属性
+var leefFieldTypes = map[string]fieldType{
+	"dstBytes":       fieldLong,
+	"dstPackets":     fieldLong,
+	"dstPort":        fieldLong,
+	"dstPostNATPort": fieldLong,
+	"dstPreNATPort":  fieldLong,
+	"sev":            fieldLong,
+	"srcBytes":       fieldLong,
+	"srcPackets":     fieldLong,
+	"srcPort":        fieldLong,
+	"srcPostNATPort": fieldLong,
+	"srcPreNATPort":  fieldLong,
+	"totalPackets":   fieldLong,
+	"devTime":        fieldTime,
+}
+
+// cefTimeLayouts 为 CEF 规范中的时间格式，没有年份的格式使用当前年份
+var cefTimeLayouts = []string{
+	"Jan _2 2006 15:04:05.000 MST",
+	"Jan _2 2006 15:04:05 MST",
+	"Jan _2 2006 15:04:05.000",
+	"Jan _2 2006 15:04:05",
+	"Jan _2 15:04:05.000 MST",
+	"Jan _2 15:04:05 MST",
+	"Jan _2 15:04:05.000",
+	"Jan _2 15:04:05",
+}
+
+// parseTime 将时间字符串转换为 RFC3339 格式，依次尝试 layout、毫秒或秒级时间戳、CEF 的时间格式和常见的时间格式
+func parseTime(value, layout string, timeZoneOffset int) (string, error) {
+	var (
+		ts  time.Time
+		err error
+	)
+	if layout != "" {
+		if ts, err = time.Parse(layout, value); err == nil {
+			return ts.Add(time.Duration(timeZoneOffset) * time.Hour).Format(time.RFC3339Nano), nil
+		}
+	}
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		// 时间戳不受时区偏移的影响
+		if len(value) > 10 {
+			return time.Unix(0, n*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano), nil
+		}
+		return time.Unix(n, 0).UTC().Format(time.RFC3339Nano), nil
+	}
+	for _, l := range cefTimeLayouts {
+		if ts, err = time.Parse(l, value); err == nil {
+			if ts.Year() == 0 {
+				ts = ts.AddDate(time.Now().Year(), 0, 0)
+			}
+			break
+		}
+	}
+	if err != nil {
+		if ts, err = times.StrToTime(value); err != nil {
+			return "", err
+		}
+	}
+	return ts.Add(time.Duration(timeZoneOffset) * time.Hour).Format(time.RFC3339Nano), nil
+}
+
+// javaLayouts 为 Java SimpleDateFormat 中的格式字符到 Go 时间格式的映射，key 为重复的格式字符
+var javaLayouts = map[string]string{
+	"yyyy": "2006",
+	"yy":   "06",
+	"MMMM": "January",
+	"MMM":  "Jan",
+	"MM":   "01",
+	"M":    "1",
+	"dd":   "02",
+	"d":    "2",
+	"EEEE": "Monday",
+	"EEE":  "Mon",
+	"HH":   "15",
+	"hh":   "03",
+	"h":    "3",
+	"mm":   "04",
+	"m":    "4",
+	"ss":   "05",
+	"s":    "5",
+	"SSS":  "000",
+	"SS":   "00",
+	"S":    "0",
+	"a":    "PM",
+	"zzz":  "MST",
+	"z":    "MST",
+	"Z":    "-0700",
+	"XXX":  "Z07:00",
+	"XX":   "Z0700",
+	"X":    "Z07",
+}
+
+// javaLayout 将 LEEF devTimeFormat 使用的 Java SimpleDateFormat 格式转换为 Go 的时间格式，不支持的格式返回 false
+func javaLayout(format string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(format); {
+		c := format[i]
+		switch {
+		case c == '\'':
+			end := strings.IndexByte(format[i+1:], '\'')
+			if end < 0 {
+				return "", false
+			}
+			b.WriteString(format[i+1 : i+1+end])
+			i += end + 2
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i + 1
+			for j < len(format) && format[j] == c {
+				j++
+			}
+			layout, ok := javaLayouts[format[i:j]]
+			if !ok {
+				return "", false
+			}
+			// Go 中秒的小数部分前面需要有小数点
+			if c == 'S' && (b.Len() == 0 || !strings.HasSuffix(b.String(), ".") && !strings.HasSuffix(b.String(), ",")) {
+				return "", false
+			}
+			b.WriteString(layout)
+			i = j
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String(), true
+}
@@ -0,0 +1,87 @@
+package cef
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// parseLEEF 解析 Version|Vendor|Product|Version|EventID|Attributes，LEEF 2.0 在 EventID 之后可以指定属性的分隔符
+func (p *Parser) parseLEEF(s string) (Data, error) {
+	header, attrs, err := splitHeader(s, 5)
+	if err != nil {
+		return nil, err
+	}
+	data := Data{
+		FieldLEEFVersion:   header[0],
+		FieldDeviceVendor:  header[1],
+		FieldDeviceProduct: header[2],
+		FieldDeviceVersion: header[3],
+		FieldEventID:       header[4],
+	}
+
+	delimiter := p.leefDelimiter
+	if strings.HasPrefix(header[0], "2") {
+		if idx := strings.IndexByte(attrs, '|'); idx >= 0 && !strings.Contains(attrs[:idx], "=") {
+			if d, ok := parseDelimiter(attrs[:idx]); ok {
+				delimiter = d
+			}
+			attrs = attrs[idx+1:]
+		}
+	}
+
+	var kvs [][2]string
+	if strings.Contains(attrs, delimiter) {
+		for _, attr := range strings.Split(attrs, delimiter) {
+			idx := strings.IndexByte(attr, '=')
+			if idx <= 0 {
+				continue
+			}
+			kvs = append(kvs, [2]string{strings.TrimSpace(attr[:idx]), strings.TrimSpace(attr[idx+1:])})
+		}
+	} else {
+		// 经过转发后分隔符可能被替换为空格，此时按 CEF 扩展字段的方式解析
+		kvs = parseExtension(attrs)
+	}
+	if len(kvs) == 0 && strings.TrimSpace(attrs) != "" {
+		return nil, errors.New("no LEEF attribute was parsed")
+	}
+
+	var layout string
+	for _, kv := range kvs {
+		if kv[0] == "devTimeFormat" {
+			layout, _ = javaLayout(kv[1])
+		}
+	}
+	for _, kv := range kvs {
+		if p.convertTypes {
+			data[kv[0]] = p.convert(leefFieldTypes[kv[0]], kv[1], layout)
+		} else {
+			data[kv[0]] = kv[1]
+		}
+	}
+	return data, nil
+}
+
+// parseDelimiter 解析单个字符或者 x09、0x09 形式的十六进制分隔符，\t 表示 tab
+func parseDelimiter(s string) (string, bool) {
+	switch {
+	case s == `\t`:
+		return "\t", true
+	case len(s) == 1:
+		return s, true
+	case len(s) > 1 && (s[0] == 'x' || s[0] == 'X'):
+		s = s[1:]
+	case len(s) > 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X'):
+		s = s[2:]
+	default:
+		return "", false
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil || v == 0 {
+		return "", false
+	}
+	return string(rune(v)), true
+}
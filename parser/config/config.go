@@ -57,6 +57,55 @@ const (
 	PandoraParseFlushSignal = "!@#pandora-EOF-line#@!"
 )
 
+// Constants for auto
+const (
+	KeyAutoParsers      = "auto_parsers"       // 按顺序尝试的子 parser 配置，为 json 数组
+	KeyAutoMatchedField = "auto_matched_field" // 记录匹配的子 parser 名称的字段
+	KeyAutoFallbackRaw  = "auto_fallback_raw"  // 所有子 parser 都失败时是否按 raw 解析
+
+	DefaultAutoMatchedField = "matched_parser"
+)
+
+// Constants for cef/leef
+const (
+	KeyCEFFullKeys      = "cef_full_keys"      // 是否将 CEF 扩展字段的短名称转换为全称，如 src 转换为 sourceAddress
+	KeyCEFConvertTypes  = "cef_convert_types"  // 是否转换已知的时间和数字字段
+	KeyLEEFConvertTypes = "leef_convert_types" // 是否转换已知的时间和数字字段
+	KeyLEEFDelimiter    = "leef_delimiter"     // LEEF 1.0 属性的分隔符，默认为 tab，LEEF 2.0 以头部指定的分隔符为准
+)
+
+// Constants for container
+const (
+	KeyContainerFormat       = "container_format"        // 容器日志格式，auto/docker/cri
+	KeyContainerNestedParser = "container_nested_parser" // 解析日志内容的 parser 配置，为 json 对象
+	KeyContainerMaxLineSize  = "container_max_line_size" // 拼接被切分的行的最大字节数
+
+	ContainerFormatAuto   = "auto"
+	ContainerFormatDocker = "docker"
+	ContainerFormatCRI    = "cri"
+
+	DefaultContainerMaxLineSize = 1024 * 1024
+)
+
+// Constants for protobuf/avro
+const (
+	KeyProtobufDescriptorFile = "protobuf_descriptor_file" // protoc --descriptor_set_out 生成的 FileDescriptorSet 文件
+	KeyProtobufMessageType    = "protobuf_message_type"    // 消息的完整类型名，如 pkg.Message
+	KeyAvroSchemaFile         = "avro_schema_file"         // avro schema 的 json 文件
+	KeyAvroSchemaRegistryURL  = "avro_schema_registry_url" // 配置后按 Confluent schema registry 的格式解析，schema 从该地址获取
+)
+
+// Constants for apache/w3c
+const (
+	KeyApacheLogFormat  = "apache_log_format"   // Apache 的 LogFormat 字符串或 common、combined 等别名
+	KeyW3CFields        = "w3c_fields"          // 文件中没有 #Fields 指令时使用的字段列表，以空格分隔
+	KeyW3CTimeTakenUnit = "w3c_time_taken_unit" // time-taken 字段的单位，IIS 为毫秒
+
+	DefaultApacheLogFormat = "combined"
+	W3CTimeTakenMillis     = "ms"
+	W3CTimeTakenSeconds    = "s"
+)
+
 // ModeUsages 和 ModeTooltips 用途说明
 var (
 	ModeUsages = KeyValueSlice{
@@ -71,6 +120,15 @@ var (
 		{TypeEmpty, "通过解析清空数据", ""},
 		{TypeMySQL, "按 mysql 慢请求日志解析", ""},
 		{TypeKeyValue, "key value 日志解析", ""},
+		{TypeAuto, "按顺序尝试多种格式解析", ""},
+		{TypeCEF, "按 ArcSight CEF 格式解析", ""},
+		{TypeLEEF, "按 IBM LEEF 格式解析", ""},
+		{TypeContainer, "按 Docker/CRI 容器日志解析", ""},
+		{TypeProtobuf, "按 Protobuf 格式解析", ""},
+		{TypeAvro, "按 Avro 格式解析", ""},
+		{TypeMsgpack, "按 MessagePack 格式解析", ""},
+		{TypeApache, "按 Apache 日志解析", ""},
+		{TypeW3C, "按 IIS/W3C 扩展日志解析", ""},
 	}
 
 	ModeToolTips = KeyValueSlice{
@@ -85,6 +143,15 @@ var (
 		{TypeEmpty, "通过解析清空数据", ""},
 		{TypeMySQL, "解析mysql的慢请求日志。", ""},
 		{TypeKeyValue, "按照key value解析日志", ""},
+		{TypeAuto, "每一行按顺序尝试多个子解析器，使用第一个解析成功的结果，都失败时按 raw 解析，适用于混合了多种格式的日志。", ""},
+		{TypeCEF, "解析 ArcSight CEF(Common Event Format) 格式的安全日志，解析头部的厂商、产品、版本、签名ID、名称、严重程度和转义后的扩展字段，支持带有 syslog 头部的日志。", ""},
+		{TypeLEEF, "解析 IBM LEEF(Log Event Extended Format) 1.0/2.0 格式的安全日志，解析头部的厂商、产品、版本、事件ID和属性字段，支持带有 syslog 头部的日志。", ""},
+		{TypeContainer, "解析 Docker json-file 和 Kubernetes CRI 格式的容器日志，自动识别格式，拼接被切分的行，提取 stream 和 time，可以使用嵌套的解析器解析日志内容。", ""},
+		{TypeProtobuf, "根据 protoc 生成的 descriptor 文件解析二进制的 Protobuf 消息，嵌套的消息解析为嵌套的字段，适用于 kafka 或 socket 等按消息读取的数据源。", ""},
+		{TypeAvro, "根据 schema 文件或 Confluent schema registry 解析二进制的 Avro 数据，嵌套的 record 和 map 解析为嵌套的字段，适用于 kafka 或 socket 等按消息读取的数据源。", ""},
+		{TypeMsgpack, "解析二进制的 MessagePack 数据，一条消息可以包含多个连续的 map，适用于 kafka 或 socket 等按消息读取的数据源。", ""},
+		{TypeApache, "根据 Apache 的 LogFormat 配置解析访问日志，支持 common、combined 等别名，字段名称与 nginx 解析器一致，如 %h 为 remote_addr，%>s 为 status，%D 转换为以秒为单位的 request_time。", ""},
+		{TypeW3C, "解析 IIS 等使用的 W3C 扩展日志格式，根据文件中的 #Fields 指令确定字段，指令变化时自动切换，字段名称与 nginx 解析器一致。", ""},
 	}
 )
 
@@ -373,6 +440,224 @@ var ModeKeyOptions = map[string][]Option{
 		OptionDisableRecordErrData,
 		OptionKeepRawData,
 	},
+	TypeAuto: {
+		{
+			KeyName:      KeyAutoParsers,
+			ChooseOnly:   false,
+			Default:      "",
+			Required:     true,
+			Placeholder:  `[{"type":"json"},{"type":"grok","grok_patterns":"%{COMMON_LOG_FORMAT}"}]`,
+			DefaultNoUse: true,
+			Description:  "子解析器配置(auto_parsers)",
+			ToolTip:      `json 数组，每个元素为一个解析器的配置，每一行按顺序交给第一个解析成功的解析器，name 为该解析器在匹配字段和统计中的名称，默认为 type`,
+		},
+		{
+			KeyName:      KeyAutoMatchedField,
+			ChooseOnly:   false,
+			Default:      DefaultAutoMatchedField,
+			DefaultNoUse: false,
+			Description:  "记录匹配的解析器名称的字段(auto_matched_field)",
+			ToolTip:      `为空表示不记录`,
+			Advance:      true,
+		},
+		{
+			KeyName:       KeyAutoFallbackRaw,
+			Element:       Radio,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"true", "false"},
+			Default:       "true",
+			DefaultNoUse:  false,
+			Description:   "都失败时按 raw 解析(auto_fallback_raw)",
+			Advance:       true,
+		},
+		OptionParserName,
+		OptionLabels,
+		OptionDisableRecordErrData,
+	},
+	TypeCEF: {
+		{
+			KeyName:       KeyCEFFullKeys,
+			Element:       Radio,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"false", "true"},
+			Default:       "false",
+			DefaultNoUse:  false,
+			Description:   "扩展字段使用全称(cef_full_keys)",
+			ToolTip:       `将扩展字段的短名称转换为全称，如 src 转换为 sourceAddress，spt 转换为 sourcePort`,
+		},
+		{
+			KeyName:       KeyCEFConvertTypes,
+			Element:       Radio,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"true", "false"},
+			Default:       "true",
+			DefaultNoUse:  false,
+			Description:   "转换时间和数字字段(cef_convert_types)",
+			ToolTip:       `将 rt、start、end 等时间字段转换为 RFC3339 格式，将 spt、dpt、cnt 等数字字段转换为数字`,
+			Advance:       true,
+		},
+		OptionTimezoneOffset,
+		OptionParserName,
+		OptionLabels,
+		OptionDisableRecordErrData,
+		OptionKeepRawData,
+	},
+	TypeLEEF: {
+		{
+			KeyName:       KeyLEEFConvertTypes,
+			Element:       Radio,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"true", "false"},
+			Default:       "true",
+			DefaultNoUse:  false,
+			Description:   "转换时间和数字字段(leef_convert_types)",
+			ToolTip:       `将 devTime 转换为 RFC3339 格式(支持 devTimeFormat)，将 srcPort、dstPort、sev 等数字字段转换为数字`,
+			Advance:       true,
+		},
+		{
+			KeyName:      KeyLEEFDelimiter,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "属性分隔符(leef_delimiter)",
+			ToolTip:      `LEEF 1.0 属性的分隔符，默认为 tab，支持 x09 形式的十六进制，LEEF 2.0 以头部指定的分隔符为准`,
+			Advance:      true,
+		},
+		OptionTimezoneOffset,
+		OptionParserName,
+		OptionLabels,
+		OptionDisableRecordErrData,
+		OptionKeepRawData,
+	},
+	TypeContainer: {
+		{
+			KeyName:       KeyContainerFormat,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{ContainerFormatAuto, ContainerFormatDocker, ContainerFormatCRI},
+			Default:       ContainerFormatAuto,
+			DefaultNoUse:  false,
+			Description:   "容器日志格式(container_format)",
+			ToolTip:       `auto 按行自动识别，docker 为 json-file 格式，cri 为 Kubernetes 容器运行时的 "时间 stream P|F 日志" 格式`,
+		},
+		{
+			KeyName:      KeyContainerNestedParser,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  `{"type":"json"}`,
+			DefaultNoUse: false,
+			Description:  "解析日志内容的解析器(container_nested_parser)",
+			ToolTip:      `json 对象，为解析拼接后的日志内容的解析器配置，为空时日志内容记录在 log 字段中`,
+			Advance:      true,
+		},
+		{
+			KeyName:      KeyContainerMaxLineSize,
+			ChooseOnly:   false,
+			Default:      "1048576",
+			DefaultNoUse: false,
+			Element:      InputNumber,
+			Description:  "拼接行的最大字节数(container_max_line_size)",
+			ToolTip:      `被切分的行拼接超过该大小时不再等待剩余的部分`,
+			Advance:      true,
+		},
+		OptionParserName,
+		OptionLabels,
+		OptionDisableRecordErrData,
+	},
+	TypeProtobuf: {
+		{
+			KeyName:      KeyProtobufDescriptorFile,
+			ChooseOnly:   false,
+			Default:      "",
+			Required:     true,
+			Placeholder:  "/home/users/john/log.desc",
+			DefaultNoUse: true,
+			Description:  "descriptor 文件路径(protobuf_descriptor_file)",
+			ToolTip:      `使用 protoc --include_imports --descriptor_set_out 生成的文件`,
+		},
+		{
+			KeyName:      KeyProtobufMessageType,
+			ChooseOnly:   false,
+			Default:      "",
+			Required:     true,
+			Placeholder:  "mypackage.LogEntry",
+			DefaultNoUse: true,
+			Description:  "消息类型(protobuf_message_type)",
+			ToolTip:      `包含 package 的完整消息类型名`,
+		},
+		OptionParserName,
+		OptionLabels,
+		OptionDisableRecordErrData,
+	},
+	TypeAvro: {
+		{
+			KeyName:      KeyAvroSchemaFile,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "/home/users/john/log.avsc",
+			DefaultNoUse: true,
+			Description:  "schema 文件路径(avro_schema_file)",
+			ToolTip:      `顶层为 record 的 avro schema json 文件，没有配置 schema registry 时必填`,
+		},
+		{
+			KeyName:      KeyAvroSchemaRegistryURL,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "http://127.0.0.1:8081",
+			DefaultNoUse: true,
+			Description:  "schema registry 地址(avro_schema_registry_url)",
+			ToolTip:      `配置后数据按 Confluent 的格式解析，即 1 字节 0、4 字节 schema ID 和 avro 数据，schema 按 ID 从该地址获取`,
+			Advance:      true,
+		},
+		OptionParserName,
+		OptionLabels,
+		OptionDisableRecordErrData,
+	},
+	TypeMsgpack: {
+		OptionParserName,
+		OptionLabels,
+		OptionDisableRecordErrData,
+	},
+	TypeApache: {
+		{
+			KeyName:      KeyApacheLogFormat,
+			ChooseOnly:   false,
+			Default:      DefaultApacheLogFormat,
+			Placeholder:  `%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-agent}i" %D`,
+			DefaultNoUse: false,
+			Description:  "日志格式(apache_log_format)",
+			ToolTip:      `httpd.conf 中 LogFormat 的格式字符串，也可以填写 common、combined、combinedio、vhost_combined 别名`,
+		},
+		OptionParserName,
+		OptionLabels,
+		OptionDisableRecordErrData,
+		OptionKeepRawData,
+	},
+	TypeW3C: {
+		{
+			KeyName:      KeyW3CFields,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "date time c-ip cs-method cs-uri-stem sc-status time-taken",
+			DefaultNoUse: false,
+			Description:  "默认字段(w3c_fields)",
+			ToolTip:      `从文件中间开始读取、还没有读到 #Fields 指令时使用的字段，读到 #Fields 指令后以指令为准`,
+			Advance:      true,
+		},
+		{
+			KeyName:       KeyW3CTimeTakenUnit,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{W3CTimeTakenMillis, W3CTimeTakenSeconds},
+			Default:       W3CTimeTakenMillis,
+			DefaultNoUse:  false,
+			Description:   "time-taken 的单位(w3c_time_taken_unit)",
+			ToolTip:       `IIS 为毫秒，time-taken 转换为以秒为单位的 request_time`,
+			Advance:       true,
+		},
+		OptionParserName,
+		OptionLabels,
+		OptionDisableRecordErrData,
+		OptionKeepRawData,
+	},
 }
 
 // SampleLogs 样例日志，用于前端界面试玩解析器
@@ -396,4 +681,15 @@ SELECT count(*) from mysql.rds_replication_status WHERE master_host IS NOT NULL
 #`,
 	TypeLogfmt: `ts=2018-01-02T03:04:05.123Z lvl=5 msg="error" log_id=123456abc
 method=PUT duration=1.23 log_id=123456abc`,
+	TypeAuto: `{"level":"error","msg":"request failed"}
+java.lang.NullPointerException: null`,
+	TypeCEF: `<134>Feb 05 01:02:03 fw01 CEF:0|Security|threatmanager|1.0|100|worm successfully stopped|10|src=10.0.0.1 dst=2.1.2.2 spt=1232 msg=Detected a threat. No action needed`,
+	TypeContainer: `{"log":"GET /healthz 200\n","stream":"stdout","time":"2018-01-02T03:04:05.123456789Z"}
+2018-01-02T03:04:05.123456789Z stderr P conn
+2018-01-02T03:04:05.123456789Z stderr F ection reset by peer`,
+	TypeApache: `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08 [en] (Win98; I ;Nav)"`,
+	TypeW3C: `#Software: Microsoft Internet Information Services 10.0
+#Fields: date time s-ip cs-method cs-uri-stem cs-uri-query s-port cs-username c-ip cs(User-Agent) cs(Referer) sc-status sc-substatus sc-win32-status time-taken
+2018-01-02 03:04:05 10.0.0.1 GET /index.html a=1 80 - 192.168.1.2 Mozilla/5.0+(Windows+NT+10.0) - 200 0 0 15`,
+	TypeLEEF: "LEEF:1.0|Microsoft|MSExchange|4.0 SP1|15345|src=192.0.2.0\tdst=172.50.123.1\tsev=5\tcat=anomaly\tsrcPort=81\tdstPort=21",
 }
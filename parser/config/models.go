@@ -30,6 +30,15 @@ const (
 	TypeMySQL      = "mysqllog"
 	TypeLogfmt     = "logfmt"
 	TypeKeyValue   = "KV"
+	TypeAuto       = "auto"
+	TypeCEF        = "cef"
+	TypeLEEF       = "leef"
+	TypeContainer  = "container"
+	TypeProtobuf   = "protobuf"
+	TypeAvro       = "avro"
+	TypeMsgpack    = "msgpack"
+	TypeApache     = "apache"
+	TypeW3C        = "w3c"
 )
 
 // 数据常量类型
@@ -0,0 +1,327 @@
+package container
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/json-iterator/go"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/parser"
+	. "github.com/qiniu/logkit/parser/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// 解析后的字段，配置了嵌套的 parser 时日志内容按嵌套的 parser 解析，不再记录在 log 字段中
+const (
+	FieldLog    = "log"
+	FieldStream = "stream"
+	FieldTime   = "time"
+	FieldAttrs  = "attrs"
+)
+
+var (
+	_ parser.Parser         = &Parser{}
+	_ parser.ParserType     = &Parser{}
+	_ parser.SourceParser   = &Parser{}
+	_ parser.StatefulParser = &Parser{}
+)
+
+func init() {
+	parser.RegisterConstructor(TypeContainer, NewParser)
+}
+
+// dockerLine 为 Docker json-file 日志驱动的一行，log 不以换行结尾时为被切分的行
+type dockerLine struct {
+	Log    string                 `json:"log"`
+	Stream string                 `json:"stream"`
+	Time   string                 `json:"time"`
+	Attrs  map[string]interface{} `json:"attrs"`
+}
+
+// entry 为解析后的一行，partial 表示该行被切分，需要与之后的行拼接
+type entry struct {
+	log     string
+	stream  string
+	time    string
+	attrs   map[string]interface{}
+	partial bool
+}
+
+// pending 为同一个来源和 stream 中尚未拼接完成的行
+type pending struct {
+	entry
+	buf strings.Builder
+}
+
+// Parser 解析 Docker json-file 和 CRI 格式的容器日志，按数据来源和 stream 拼接被切分的行
+// 被切分的行在拼接完成之前计入 DatasourceSkipIndex，拼接后的数据对应最后一行的来源
+type Parser struct {
+	name                 string
+	labels               []GrokLabel
+	disableRecordErrData bool
+	format               string
+	maxLineSize          int
+	nested               parser.Parser
+	jsontool             jsoniter.API
+
+	pendings map[string]*pending
+}
+
+func NewParser(c conf.MapConf) (parser.Parser, error) {
+	name, _ := c.GetStringOr(KeyParserName, "")
+	labelList, _ := c.GetStringListOr(KeyLabels, []string{})
+	disableRecordErrData, _ := c.GetBoolOr(KeyDisableRecordErrData, false)
+	format, _ := c.GetStringOr(KeyContainerFormat, ContainerFormatAuto)
+	switch format {
+	case ContainerFormatAuto, ContainerFormatDocker, ContainerFormatCRI:
+	default:
+		return nil, fmt.Errorf("unsupported %v %v", KeyContainerFormat, format)
+	}
+	maxLineSize := DefaultContainerMaxLineSize
+	if _, ok := c[KeyContainerMaxLineSize]; ok {
+		var err error
+		if maxLineSize, err = c.GetInt(KeyContainerMaxLineSize); err != nil {
+			return nil, err
+		}
+		if maxLineSize <= 0 {
+			return nil, fmt.Errorf("%v must be positive", KeyContainerMaxLineSize)
+		}
+	}
+
+	p := &Parser{
+		name:                 name,
+		labels:               GetGrokLabels(labelList, make(map[string]struct{})),
+		disableRecordErrData: disableRecordErrData,
+		format:               format,
+		maxLineSize:          maxLineSize,
+		jsontool:             jsoniter.ConfigCompatibleWithStandardLibrary,
+		pendings:             make(map[string]*pending),
+	}
+	if raw, _ := c.GetStringOr(KeyContainerNestedParser, ""); raw != "" {
+		var config map[string]interface{}
+		if err := json.Unmarshal([]byte(raw), &config); err != nil {
+			return nil, fmt.Errorf("parse %v error %v", KeyContainerNestedParser, err)
+		}
+		nestedConf, err := parser.NewConfFromJSON(config)
+		if err != nil {
+			return nil, fmt.Errorf("%v %v", KeyContainerNestedParser, err)
+		}
+		if nestedConf[KeyParserType] == TypeContainer {
+			return nil, fmt.Errorf("%v can not be %v parser", KeyContainerNestedParser, TypeContainer)
+		}
+		nestedConf[KeyDisableRecordErrData] = "true"
+		if p.nested, err = parser.NewRegistry().NewLogParser(nestedConf); err != nil {
+			return nil, fmt.Errorf("create %v error %v", KeyContainerNestedParser, err)
+		}
+	}
+	return p, nil
+}
+
+func (p *Parser) Name() string {
+	return p.name
+}
+
+func (p *Parser) Type() string {
+	return TypeContainer
+}
+
+// Stateful 被切分的行在拼接完成之前保存在 pendings 中
+func (p *Parser) Stateful() bool {
+	return true
+}
+
+// Parse 在没有数据来源时将所有行当作同一个来源拼接
+func (p *Parser) Parse(lines []string) ([]Data, error) {
+	datas, se := p.parse(lines, nil)
+	if se.Errors == 0 {
+		return datas, nil
+	}
+	return datas, se
+}
+
+// ParseWithSource 在有被切分的行时即使没有错误也返回 StatsError，使 runner 能按 DatasourceSkipIndex 为拼接后的数据填写来源
+func (p *Parser) ParseWithSource(lines, froms []string) ([]Data, error) {
+	datas, se := p.parse(lines, froms)
+	if se.Errors == 0 && len(se.DatasourceSkipIndex) == 0 {
+		return datas, nil
+	}
+	return datas, se
+}
+
+func (p *Parser) parse(lines, froms []string) ([]Data, *StatsError) {
+	var (
+		datas = make([]Data, 0, len(lines))
+		se    = &StatsError{}
+	)
+	for idx, line := range lines {
+		line = strings.TrimRight(line, "\r\n")
+		if line == PandoraParseFlushSignal || len(strings.TrimSpace(line)) == 0 {
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+			continue
+		}
+
+		e, err := p.parseLine(line)
+		if err != nil {
+			se.AddErrors()
+			se.LastError = err.Error()
+			if p.disableRecordErrData {
+				se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+			} else {
+				datas = append(datas, Data{KeyPandoraStash: line})
+			}
+			continue
+		}
+
+		var source string
+		if idx < len(froms) {
+			source = froms[idx]
+		}
+		if !p.join(source, &e) {
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+			continue
+		}
+
+		data, err := p.toData(e)
+		if err != nil {
+			se.AddErrors()
+			se.LastError = err.Error()
+			if p.disableRecordErrData {
+				se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+				continue
+			}
+		} else {
+			se.AddSuccess()
+		}
+		datas = append(datas, data)
+	}
+	return datas, se
+}
+
+// join 将被切分的行与同一个来源和 stream 中之前的部分拼接，返回是否拼接完成，完成时 e 为拼接后的行
+func (p *Parser) join(source string, e *entry) bool {
+	key := source + "\x00" + e.stream
+	pd, ok := p.pendings[key]
+	if !ok {
+		if !e.partial || len(e.log) >= p.maxLineSize {
+			return true
+		}
+		pd = &pending{entry: *e}
+		pd.buf.WriteString(e.log)
+		p.pendings[key] = pd
+		return false
+	}
+	pd.buf.WriteString(e.log)
+	// 超过最大长度时不再等待剩余的部分，剩余的部分作为新的一行
+	if e.partial && pd.buf.Len() < p.maxLineSize {
+		return false
+	}
+	pd.log = pd.buf.String()
+	*e = pd.entry
+	delete(p.pendings, key)
+	return true
+}
+
+// parseLine 按配置的格式解析一行，auto 时以 { 开头的行按 Docker json-file 格式解析，其他按 CRI 格式解析
+func (p *Parser) parseLine(line string) (entry, error) {
+	format := p.format
+	if format == ContainerFormatAuto {
+		format = ContainerFormatCRI
+		if strings.HasPrefix(line, "{") {
+			format = ContainerFormatDocker
+		}
+	}
+	if format == ContainerFormatDocker {
+		return p.parseDocker(line)
+	}
+	return parseCRI(line)
+}
+
+func (p *Parser) parseDocker(line string) (entry, error) {
+	var dl dockerLine
+	if err := p.jsontool.Unmarshal([]byte(line), &dl); err != nil {
+		return entry{}, fmt.Errorf("parse docker json-file line error %v, line: %v", err, TruncateStrSize(line, DefaultTruncateMaxSize))
+	}
+	if dl.Stream == "" && dl.Time == "" {
+		return entry{}, fmt.Errorf("docker json-file line without stream and time: %v", TruncateStrSize(line, DefaultTruncateMaxSize))
+	}
+	e := entry{
+		log:    dl.Log,
+		stream: dl.Stream,
+		time:   dl.Time,
+		attrs:  dl.Attrs,
+		// Docker 将超过 16K 的行切分为多行，只有最后一行以换行结尾
+		partial: !strings.HasSuffix(dl.Log, "\n"),
+	}
+	e.log = strings.TrimSuffix(strings.TrimSuffix(e.log, "\n"), "\r")
+	return e, nil
+}
+
+// parseCRI 解析 "时间 stream 标签 日志"，标签以 : 分隔，第一个标签为 P 表示被切分的行，F 表示完整的行
+// 没有标签的旧格式为 "时间 stream 日志"
+func parseCRI(line string) (entry, error) {
+	parts := strings.SplitN(line, " ", 4)
+	if len(parts) < 3 || (parts[1] != "stdout" && parts[1] != "stderr") {
+		return entry{}, errors.New("invalid CRI log line: " + TruncateStrSize(line, DefaultTruncateMaxSize))
+	}
+	e := entry{time: parts[0], stream: parts[1]}
+	switch tag := strings.SplitN(parts[2], ":", 2)[0]; tag {
+	case "P", "F":
+		e.partial = tag == "P"
+		if len(parts) == 4 {
+			e.log = parts[3]
+		}
+	default:
+		e.log = strings.SplitN(line, " ", 3)[2]
+	}
+	return e, nil
+}
+
+// toData 生成一条数据，配置了嵌套的 parser 时按嵌套的 parser 解析日志内容，解析失败时日志内容记录在 pandora_stash 中
+func (p *Parser) toData(e entry) (Data, error) {
+	var (
+		data Data
+		err  error
+	)
+	if p.nested != nil {
+		if data, err = p.parseNested(e.log); err != nil {
+			data = Data{KeyPandoraStash: e.log}
+		}
+	} else {
+		data = Data{FieldLog: e.log}
+	}
+	data[FieldStream] = e.stream
+	data[FieldTime] = e.time
+	if len(e.attrs) > 0 {
+		data[FieldAttrs] = e.attrs
+	}
+	for _, l := range p.labels {
+		data[l.Name] = l.Value
+	}
+	return data, err
+}
+
+func (p *Parser) parseNested(line string) (Data, error) {
+	datas, err := p.nested.Parse([]string{line})
+	if err != nil {
+		if se, ok := err.(*StatsError); ok {
+			err = errors.New(se.LastError)
+		}
+		return nil, fmt.Errorf("nested parser %v error %v", p.nested.Name(), err)
+	}
+	if f, ok := p.nested.(parser.Flushable); ok {
+		data, err := f.Flush()
+		if err != nil {
+			return nil, fmt.Errorf("nested parser %v error %v", p.nested.Name(), err)
+		}
+		if len(data) > 0 {
+			datas = append(datas, data)
+		}
+	}
+	if len(datas) != 1 {
+		return nil, fmt.Errorf("nested parser %v parsed %d records from one line", p.nested.Name(), len(datas))
+	}
+	return datas[0], nil
+}
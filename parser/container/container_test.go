@@ -0,0 +1,111 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/parser"
+	. "github.com/qiniu/logkit/parser/config"
+	_ "github.com/qiniu/logkit/parser/json"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestParseDocker(t *testing.T) {
+	p, err := NewParser(conf.MapConf{KeyParserName: "container", KeyLabels: "env prod"})
+	assert.NoError(t, err)
+	assert.Equal(t, "container", p.Name())
+	assert.Equal(t, TypeContainer, p.(parser.ParserType).Type())
+
+	datas, err := p.Parse([]string{
+		`{"log":"part one, ","stream":"stdout","time":"2018-01-02T03:04:05.000000001Z"}`,
+		`{"log":"error\n","stream":"stderr","time":"2018-01-02T03:04:05.000000002Z","attrs":{"tag":"web"}}`,
+		`{"log":"part two\n","stream":"stdout","time":"2018-01-02T03:04:05.000000003Z"}`,
+		"",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{
+		{FieldLog: "error", FieldStream: "stderr", FieldTime: "2018-01-02T03:04:05.000000002Z", FieldAttrs: map[string]interface{}{"tag": "web"}, "env": "prod"},
+		{FieldLog: "part one, part two", FieldStream: "stdout", FieldTime: "2018-01-02T03:04:05.000000001Z", "env": "prod"},
+	}, datas)
+}
+
+func TestParseCRIWithSource(t *testing.T) {
+	p, err := NewParser(conf.MapConf{KeyContainerFormat: ContainerFormatCRI})
+	assert.NoError(t, err)
+	sp := p.(parser.SourceParser)
+
+	datas, err := sp.ParseWithSource([]string{
+		"2018-01-02T03:04:05.1Z stdout P a1 ",
+		"2018-01-02T03:04:05.2Z stdout P b1 ",
+		"2018-01-02T03:04:05.3Z stdout F a2",
+		"2018-01-02T03:04:05.4Z stderr F:x plain",
+		"2018-01-02T03:04:05.5Z stdout P b2 ",
+	}, []string{"a.log", "b.log", "a.log", "b.log", "b.log"})
+	// 没有错误时也返回 StatsError 传递被切分的行
+	se, ok := err.(*StatsError)
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), se.Errors)
+	assert.Equal(t, []int{0, 1, 4}, se.DatasourceSkipIndex)
+	assert.Equal(t, []Data{
+		{FieldLog: "a1 a2", FieldStream: "stdout", FieldTime: "2018-01-02T03:04:05.1Z"},
+		{FieldLog: "plain", FieldStream: "stderr", FieldTime: "2018-01-02T03:04:05.4Z"},
+	}, datas)
+
+	// 被切分的行跨越多个批次，拼接完成的数据对应最后一行
+	datas, err = sp.ParseWithSource([]string{"2018-01-02T03:04:05.6Z stdout F b3", "2018-01-02T03:04:05.7Z stdout no tag"}, []string{"b.log", "b.log"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{
+		{FieldLog: "b1 b2 b3", FieldStream: "stdout", FieldTime: "2018-01-02T03:04:05.2Z"},
+		{FieldLog: "no tag", FieldStream: "stdout", FieldTime: "2018-01-02T03:04:05.7Z"},
+	}, datas)
+
+	datas, err = sp.ParseWithSource([]string{"2018-01-02T03:04:05Z stdout P x", "bad line", "2018-01-02T03:04:05Z stdout F y"}, []string{"a.log", "a.log", "a.log"})
+	se, ok = err.(*StatsError)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), se.Errors)
+	assert.Equal(t, int64(1), se.Success)
+	assert.Equal(t, []int{0}, se.DatasourceSkipIndex)
+	assert.Equal(t, []Data{{KeyPandoraStash: "bad line"}, {FieldLog: "xy", FieldStream: "stdout", FieldTime: "2018-01-02T03:04:05Z"}}, datas)
+}
+
+func TestParseNested(t *testing.T) {
+	p, err := NewParser(conf.MapConf{
+		KeyContainerNestedParser: `{"type":"json"}`,
+		KeyContainerMaxLineSize:  "8",
+	})
+	assert.NoError(t, err)
+	datas, err := p.Parse([]string{
+		`2018-01-02T03:04:05Z stdout F {"level":"info","code":1}`,
+		`2018-01-02T03:04:05Z stdout F not json`,
+		`2018-01-02T03:04:05Z stdout P {"a":`,
+		`2018-01-02T03:04:05Z stdout P "bc"`,
+		`2018-01-02T03:04:05Z stdout F }`,
+	})
+	se, ok := err.(*StatsError)
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), se.Errors)
+	assert.Len(t, datas, 4)
+	assert.Equal(t, "info", datas[0]["level"])
+	assert.Equal(t, "stdout", datas[0][FieldStream])
+	assert.Nil(t, datas[0][FieldLog])
+	assert.Equal(t, Data{KeyPandoraStash: "not json", FieldStream: "stdout", FieldTime: "2018-01-02T03:04:05Z"}, datas[1])
+	// 超过最大长度后不再等待剩余的部分
+	assert.Equal(t, `{"a":"bc"`, datas[2][KeyPandoraStash])
+	assert.Equal(t, `}`, datas[3][KeyPandoraStash])
+}
+
+func TestNewParserError(t *testing.T) {
+	for _, c := range []conf.MapConf{
+		{KeyContainerFormat: "podman"},
+		{KeyContainerMaxLineSize: "0"},
+		{KeyContainerNestedParser: "json"},
+		{KeyContainerNestedParser: `{"name":"x"}`},
+		{KeyContainerNestedParser: `{"type":"container"}`},
+		{KeyContainerNestedParser: `{"type":"unknown"}`},
+	} {
+		_, err := NewParser(c)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,105 @@
+package msgpack
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/parser"
+	. "github.com/qiniu/logkit/parser/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var (
+	_ parser.Parser       = &Parser{}
+	_ parser.ParserType   = &Parser{}
+	_ parser.BinaryParser = &Parser{}
+)
+
+func init() {
+	parser.RegisterConstructor(TypeMsgpack, NewParser)
+}
+
+// Parser 解析二进制的 MessagePack 数据，一行可以包含多个连续的 map，每个 map 解析为一条数据
+type Parser struct {
+	name                 string
+	labels               []GrokLabel
+	disableRecordErrData bool
+}
+
+func NewParser(c conf.MapConf) (parser.Parser, error) {
+	name, _ := c.GetStringOr(KeyParserName, "")
+	labelList, _ := c.GetStringListOr(KeyLabels, []string{})
+	disableRecordErrData, _ := c.GetBoolOr(KeyDisableRecordErrData, false)
+	return &Parser{
+		name:                 name,
+		labels:               GetGrokLabels(labelList, make(map[string]struct{})),
+		disableRecordErrData: disableRecordErrData,
+	}, nil
+}
+
+func (p *Parser) Name() string {
+	return p.name
+}
+
+func (p *Parser) Type() string {
+	return TypeMsgpack
+}
+
+func (p *Parser) Binary() bool {
+	return true
+}
+
+func (p *Parser) Parse(lines []string) ([]Data, error) {
+	var (
+		datas = make([]Data, 0, len(lines))
+		se    = &StatsError{}
+	)
+	for idx, line := range lines {
+		if line == PandoraParseFlushSignal || len(line) == 0 {
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+			continue
+		}
+		result, err := parse([]byte(line))
+		if err != nil {
+			se.AddErrors()
+			se.LastError = err.Error()
+			if p.disableRecordErrData {
+				se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+			} else {
+				datas = append(datas, Data{KeyPandoraStash: base64.StdEncoding.EncodeToString([]byte(line))})
+			}
+			continue
+		}
+		for _, data := range result {
+			for _, l := range p.labels {
+				data[l.Name] = l.Value
+			}
+		}
+		se.AddSuccess()
+		datas = append(datas, result...)
+	}
+	if se.Errors == 0 {
+		return datas, nil
+	}
+	return datas, se
+}
+
+func parse(b []byte) ([]Data, error) {
+	var (
+		datas []Data
+		d     = &decoder{buf: b}
+	)
+	for !d.done() {
+		v, err := d.decode()
+		if err != nil {
+			return nil, fmt.Errorf("decode msgpack data error %v", err)
+		}
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("msgpack data is not a map: %v", v)
+		}
+		datas = append(datas, Data(m))
+	}
+	return datas, nil
+}
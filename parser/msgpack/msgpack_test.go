@@ -0,0 +1,57 @@
+package msgpack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/parser/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestParse(t *testing.T) {
+	p, err := NewParser(conf.MapConf{KeyParserName: "mp", KeyLabels: "env prod"})
+	assert.NoError(t, err)
+	assert.Equal(t, "mp", p.Name())
+
+	line := "\x84\xa1a\xcc\xc8\xa1b\x81\xa1c\x92\xff\xc3\xa1t\xd6\xff\x5a\x49\x7a\x00\xa1n\xd1\xff\x38" +
+		// 一行中的第二个 map，key 为整数
+		"\x83\x01\xc0\xa1f\xcb\x3f\xf8\x00\x00\x00\x00\x00\x00\xa1u\xcf\xff\xff\xff\xff\xff\xff\xff\xff"
+	datas, err := p.Parse([]string{
+		line,
+		"\xde\x00\x02\xa1s\xd9\x02hi\xa3bin\xc4\x02\x00\x0a",
+		"\x81\xa1e\xd4\x05\x20",
+		"",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{
+		{
+			"a":   int64(200),
+			"b":   map[string]interface{}{"c": []interface{}{int64(-1), true}},
+			"t":   "2018-01-01T00:00:00Z",
+			"n":   int64(-200),
+			"env": "prod",
+		},
+		{"1": nil, "f": 1.5, "u": uint64(1<<64 - 1), "env": "prod"},
+		{"s": "hi", "bin": "AAo=", "env": "prod"},
+		{"e": map[string]interface{}{"type": int64(5), "data": "IA=="}, "env": "prod"},
+	}, datas)
+}
+
+func TestParseError(t *testing.T) {
+	p, err := NewParser(conf.MapConf{KeyDisableRecordErrData: "true"})
+	assert.NoError(t, err)
+	datas, err := p.Parse([]string{"\xc1", "\x01", "\x81\xa1", "\x80"})
+	se, ok := err.(*StatsError)
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), se.Errors)
+	assert.Equal(t, []int{0, 1, 2}, se.DatasourceSkipIndex)
+	assert.Equal(t, []Data{{}}, datas)
+
+	p, err = NewParser(conf.MapConf{})
+	assert.NoError(t, err)
+	datas, err = p.Parse([]string{"\x81\xa1a\xdc\xff\xff"})
+	assert.Error(t, err)
+	assert.Equal(t, []Data{{KeyPandoraStash: "gaFh3P//"}}, datas)
+}
@@ -25,6 +25,38 @@ type Flushable interface {
 	Flush() (Data, error)
 }
 
+// SourceParser 为需要按数据来源区分上下文的 parser，如拼接同一个文件中被切分的行，froms 为每一行的来源，与 lines 一一对应
+// 没有错误时也可以返回 Errors 为 0 的 StatsError，用于传递 DatasourceSkipIndex
+type SourceParser interface {
+	ParseWithSource(lines, froms []string) ([]Data, error)
+}
+
+// BinaryParser 为解析二进制消息的 parser，每一行为一条完整的消息，runner 不会丢弃只包含空白字符的行
+// 解析失败的消息以 base64 编码记录在 pandora_stash 中
+type BinaryParser interface {
+	Binary() bool
+}
+
+// StatefulParser 为在多次 Parse 之间保存状态的 parser，如按数据来源记录的字段或尚未拼接完成的行
+// Stateful 返回 true 时 Parse 不能并发调用，runner 只使用一个解析的 goroutine
+type StatefulParser interface {
+	Stateful() bool
+}
+
+// IsStateful 返回 Parse 是否只能串行调用，Flushable 的 parser 同样在多次 Parse 之间保存状态
+func IsStateful(p Parser) bool {
+	if _, ok := p.(Flushable); ok {
+		return true
+	}
+	sp, ok := p.(StatefulParser)
+	return ok && sp.Stateful()
+}
+
+// StatsParser 为可以提供细分统计的 parser，Details 会展示在 runner 的 parserStats 中
+type StatsParser interface {
+	Stats() StatsInfo
+}
+
 type ParseInfo struct {
 	Line  string
 	Index int
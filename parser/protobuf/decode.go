@@ -0,0 +1,163 @@
+package protobuf
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+)
+
+// decodeMessage 将消息解析为 map，嵌套的消息解析为嵌套的 map，repeated 字段解析为数组，map 字段解析为以 key 的字符串形式为键的 map
+// 未设置的字段不会出现在结果中，descriptor 中不存在的字段会被忽略
+func decodeMessage(md *messageDesc, b []byte) (map[string]interface{}, error) {
+	m := make(map[string]interface{}, len(md.fields))
+	err := eachField(b, func(num int32, wt int, r *wireReader) error {
+		f, ok := md.fields[num]
+		if !ok || f.typ == typeGroup {
+			return r.skip(wt)
+		}
+		if f.label != labelRepeated {
+			v, err := decodeValue(f, wt, r)
+			if err != nil {
+				return err
+			}
+			m[f.name] = v
+			return nil
+		}
+
+		if f.message != nil && f.message.mapEntry {
+			entry, err := decodeValue(f, wt, r)
+			if err != nil {
+				return err
+			}
+			em, _ := m[f.name].(map[string]interface{})
+			if em == nil {
+				em = make(map[string]interface{})
+				m[f.name] = em
+			}
+			kv := entry.(map[string]interface{})
+			key := ""
+			if k, ok := kv[f.message.fields[1].name]; ok {
+				key = fmt.Sprint(k)
+			}
+			em[key] = kv[f.message.fields[2].name]
+			return nil
+		}
+
+		list, _ := m[f.name].([]interface{})
+		// 数字类型的 repeated 字段可能以 packed 的方式编码
+		if wt == wireBytes && wireTypeOf(f.typ) != wireBytes {
+			packed, err := r.bytes()
+			if err != nil {
+				return err
+			}
+			pr := &wireReader{buf: packed}
+			for !pr.done() {
+				v, err := decodeValue(f, wireTypeOf(f.typ), pr)
+				if err != nil {
+					return err
+				}
+				list = append(list, v)
+			}
+		} else {
+			v, err := decodeValue(f, wt, r)
+			if err != nil {
+				return err
+			}
+			list = append(list, v)
+		}
+		m[f.name] = list
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func wireTypeOf(typ int32) int {
+	switch typ {
+	case typeDouble, typeFixed64, typeSfixed64:
+		return wireFixed64
+	case typeFloat, typeFixed32, typeSfixed32:
+		return wireFixed32
+	case typeString, typeBytes, typeMessage:
+		return wireBytes
+	case typeGroup:
+		return wireStartGroup
+	}
+	return wireVarint
+}
+
+// decodeValue 按字段类型解析一个值，有符号整数解析为 int64，uint64 和 fixed64 解析为 uint64，bytes 解析为 base64 编码的字符串，枚举解析为名称
+func decodeValue(f *fieldDesc, wt int, r *wireReader) (interface{}, error) {
+	if expect := wireTypeOf(f.typ); wt != expect {
+		return nil, fmt.Errorf("protobuf field %v expect wire type %d but got %d", f.name, expect, wt)
+	}
+	switch wt {
+	case wireFixed32:
+		v, err := r.fixed32()
+		if err != nil {
+			return nil, err
+		}
+		switch f.typ {
+		case typeFloat:
+			return float64(math.Float32frombits(v)), nil
+		case typeSfixed32:
+			return int64(int32(v)), nil
+		}
+		return int64(v), nil
+	case wireFixed64:
+		v, err := r.fixed64()
+		if err != nil {
+			return nil, err
+		}
+		switch f.typ {
+		case typeDouble:
+			return math.Float64frombits(v), nil
+		case typeSfixed64:
+			return int64(v), nil
+		}
+		return v, nil
+	case wireBytes:
+		v, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		switch f.typ {
+		case typeString:
+			return string(v), nil
+		case typeBytes:
+			return base64.StdEncoding.EncodeToString(v), nil
+		}
+		m, err := decodeMessage(f.message, v)
+		if err != nil {
+			return nil, fmt.Errorf("protobuf field %v: %v", f.name, err)
+		}
+		return m, nil
+	}
+
+	v, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	switch f.typ {
+	case typeBool:
+		return v != 0, nil
+	case typeInt32:
+		return int64(int32(v)), nil
+	case typeUint32:
+		return int64(uint32(v)), nil
+	case typeUint64:
+		return v, nil
+	case typeSint32:
+		return int64(int32(uint32(v>>1) ^ -uint32(v&1))), nil
+	case typeSint64:
+		return int64(v>>1) ^ -int64(v&1), nil
+	case typeEnum:
+		if name, ok := f.enum[int32(v)]; ok {
+			return name, nil
+		}
+		return int64(int32(v)), nil
+	}
+	return int64(v), nil
+}
@@ -0,0 +1,314 @@
+package protobuf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldDescriptorProto.Type
+const (
+	typeDouble   = 1
+	typeFloat    = 2
+	typeInt64    = 3
+	typeUint64   = 4
+	typeInt32    = 5
+	typeFixed64  = 6
+	typeFixed32  = 7
+	typeBool     = 8
+	typeString   = 9
+	typeGroup    = 10
+	typeMessage  = 11
+	typeBytes    = 12
+	typeUint32   = 13
+	typeEnum     = 14
+	typeSfixed32 = 15
+	typeSfixed64 = 16
+	typeSint32   = 17
+	typeSint64   = 18
+
+	labelRepeated = 3
+)
+
+type fieldDesc struct {
+	name     string
+	number   int32
+	label    int32
+	typ      int32
+	typeName string
+	message  *messageDesc
+	enum     map[int32]string
+}
+
+type messageDesc struct {
+	fullName string
+	fields   map[int32]*fieldDesc
+	mapEntry bool
+}
+
+// descriptorSet 为 FileDescriptorSet 中所有的消息和枚举类型，以 .package.Message 形式的完整名称索引
+type descriptorSet struct {
+	messages map[string]*messageDesc
+	enums    map[string]map[int32]string
+}
+
+// parseDescriptorSet 解析 protoc --descriptor_set_out 生成的 FileDescriptorSet
+func parseDescriptorSet(b []byte) (*descriptorSet, error) {
+	ds := &descriptorSet{
+		messages: make(map[string]*messageDesc),
+		enums:    make(map[string]map[int32]string),
+	}
+	err := eachField(b, func(num int32, wt int, r *wireReader) error {
+		if num != 1 || wt != wireBytes {
+			return r.skip(wt)
+		}
+		file, err := r.bytes()
+		if err != nil {
+			return err
+		}
+		return ds.addFile(file)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("parse protobuf descriptor set error %v", err)
+	}
+	if len(ds.messages) == 0 {
+		return nil, fmt.Errorf("no message type found in protobuf descriptor set")
+	}
+	for _, md := range ds.messages {
+		if md.mapEntry && (md.fields[1] == nil || md.fields[2] == nil) {
+			return nil, fmt.Errorf("protobuf map entry %v without key or value field", md.fullName)
+		}
+		for _, f := range md.fields {
+			if err := ds.resolve(md.fullName, f); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ds, nil
+}
+
+// eachField 依次读取消息中的字段，fn 需要读取或跳过字段的值
+func eachField(b []byte, fn func(num int32, wt int, r *wireReader) error) error {
+	r := &wireReader{buf: b}
+	for !r.done() {
+		num, wt, err := r.tag()
+		if err != nil {
+			return err
+		}
+		if err = fn(num, wt, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ds *descriptorSet) addFile(b []byte) error {
+	var (
+		pkg            string
+		messages, enum [][]byte
+	)
+	err := eachField(b, func(num int32, wt int, r *wireReader) error {
+		if wt != wireBytes {
+			return r.skip(wt)
+		}
+		v, err := r.bytes()
+		if err != nil {
+			return err
+		}
+		switch num {
+		case 2:
+			pkg = string(v)
+		case 4:
+			messages = append(messages, v)
+		case 5:
+			enum = append(enum, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	scope := ""
+	if pkg != "" {
+		scope = "." + pkg
+	}
+	for _, m := range messages {
+		if err = ds.addMessage(scope, m); err != nil {
+			return err
+		}
+	}
+	for _, e := range enum {
+		if err = ds.addEnum(scope, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ds *descriptorSet) addMessage(scope string, b []byte) error {
+	var (
+		name                 string
+		fields, nested, enum [][]byte
+		md                   = &messageDesc{fields: make(map[int32]*fieldDesc)}
+	)
+	err := eachField(b, func(num int32, wt int, r *wireReader) error {
+		if wt != wireBytes {
+			return r.skip(wt)
+		}
+		v, err := r.bytes()
+		if err != nil {
+			return err
+		}
+		switch num {
+		case 1:
+			name = string(v)
+		case 2:
+			fields = append(fields, v)
+		case 3:
+			nested = append(nested, v)
+		case 4:
+			enum = append(enum, v)
+		case 7:
+			// MessageOptions.map_entry
+			return eachField(v, func(num int32, wt int, r *wireReader) error {
+				if num != 7 || wt != wireVarint {
+					return r.skip(wt)
+				}
+				x, err := r.varint()
+				md.mapEntry = x != 0
+				return err
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	md.fullName = scope + "." + name
+	for _, f := range fields {
+		fd, err := parseField(f)
+		if err != nil {
+			return err
+		}
+		md.fields[fd.number] = fd
+	}
+	ds.messages[md.fullName] = md
+	for _, m := range nested {
+		if err = ds.addMessage(md.fullName, m); err != nil {
+			return err
+		}
+	}
+	for _, e := range enum {
+		if err = ds.addEnum(md.fullName, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseField(b []byte) (*fieldDesc, error) {
+	fd := &fieldDesc{}
+	err := eachField(b, func(num int32, wt int, r *wireReader) error {
+		switch {
+		case wt == wireBytes && (num == 1 || num == 6):
+			v, err := r.bytes()
+			if num == 1 {
+				fd.name = string(v)
+			} else {
+				fd.typeName = string(v)
+			}
+			return err
+		case wt == wireVarint && (num == 3 || num == 4 || num == 5):
+			v, err := r.varint()
+			switch num {
+			case 3:
+				fd.number = int32(v)
+			case 4:
+				fd.label = int32(v)
+			case 5:
+				fd.typ = int32(v)
+			}
+			return err
+		}
+		return r.skip(wt)
+	})
+	return fd, err
+}
+
+func (ds *descriptorSet) addEnum(scope string, b []byte) error {
+	var name string
+	values := make(map[int32]string)
+	err := eachField(b, func(num int32, wt int, r *wireReader) error {
+		if wt != wireBytes || (num != 1 && num != 2) {
+			return r.skip(wt)
+		}
+		v, err := r.bytes()
+		if err != nil {
+			return err
+		}
+		if num == 1 {
+			name = string(v)
+			return nil
+		}
+		var (
+			valueName string
+			number    int32
+		)
+		err = eachField(v, func(num int32, wt int, r *wireReader) error {
+			switch {
+			case num == 1 && wt == wireBytes:
+				b, err := r.bytes()
+				valueName = string(b)
+				return err
+			case num == 2 && wt == wireVarint:
+				x, err := r.varint()
+				number = int32(x)
+				return err
+			}
+			return r.skip(wt)
+		})
+		values[number] = valueName
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	ds.enums[scope+"."+name] = values
+	return nil
+}
+
+// resolve 查找消息和枚举类型的字段引用的类型，protoc 生成的类型名都是以 . 开头的完整名称，否则从内向外逐层查找
+func (ds *descriptorSet) resolve(scope string, f *fieldDesc) error {
+	if f.typ != typeMessage && f.typ != typeEnum && f.typ != typeGroup {
+		return nil
+	}
+	candidates := []string{f.typeName}
+	if !strings.HasPrefix(f.typeName, ".") {
+		candidates = candidates[:0]
+		for s := scope; ; s = s[:strings.LastIndex(s, ".")] {
+			candidates = append(candidates, s+"."+f.typeName)
+			if s == "" {
+				break
+			}
+		}
+	}
+	for _, name := range candidates {
+		if md, ok := ds.messages[name]; ok && f.typ != typeEnum {
+			f.message = md
+			return nil
+		}
+		if values, ok := ds.enums[name]; ok && f.typ == typeEnum {
+			f.enum = values
+			return nil
+		}
+	}
+	return fmt.Errorf("protobuf type %v of field %v.%v not found", f.typeName, scope, f.name)
+}
+
+// lookup 查找消息类型，类型名可以省略开头的 .
+func (ds *descriptorSet) lookup(name string) (*messageDesc, bool) {
+	if !strings.HasPrefix(name, ".") {
+		name = "." + name
+	}
+	md, ok := ds.messages[name]
+	return md, ok
+}
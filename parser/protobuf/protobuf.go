@@ -0,0 +1,108 @@
+package protobuf
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/parser"
+	. "github.com/qiniu/logkit/parser/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var (
+	_ parser.Parser       = &Parser{}
+	_ parser.ParserType   = &Parser{}
+	_ parser.BinaryParser = &Parser{}
+)
+
+func init() {
+	parser.RegisterConstructor(TypeProtobuf, NewParser)
+}
+
+// Parser 根据 FileDescriptorSet 解析二进制的 protobuf 消息，每一行为一条消息
+type Parser struct {
+	name                 string
+	labels               []GrokLabel
+	disableRecordErrData bool
+	message              *messageDesc
+}
+
+func NewParser(c conf.MapConf) (parser.Parser, error) {
+	name, _ := c.GetStringOr(KeyParserName, "")
+	labelList, _ := c.GetStringListOr(KeyLabels, []string{})
+	disableRecordErrData, _ := c.GetBoolOr(KeyDisableRecordErrData, false)
+	descriptorFile, err := c.GetString(KeyProtobufDescriptorFile)
+	if err != nil {
+		return nil, err
+	}
+	messageType, err := c.GetString(KeyProtobufMessageType)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(descriptorFile)
+	if err != nil {
+		return nil, fmt.Errorf("read %v error %v", KeyProtobufDescriptorFile, err)
+	}
+	ds, err := parseDescriptorSet(b)
+	if err != nil {
+		return nil, err
+	}
+	md, ok := ds.lookup(messageType)
+	if !ok {
+		return nil, fmt.Errorf("protobuf message type %v not found in %v", messageType, descriptorFile)
+	}
+	return &Parser{
+		name:                 name,
+		labels:               GetGrokLabels(labelList, make(map[string]struct{})),
+		disableRecordErrData: disableRecordErrData,
+		message:              md,
+	}, nil
+}
+
+func (p *Parser) Name() string {
+	return p.name
+}
+
+func (p *Parser) Type() string {
+	return TypeProtobuf
+}
+
+func (p *Parser) Binary() bool {
+	return true
+}
+
+func (p *Parser) Parse(lines []string) ([]Data, error) {
+	var (
+		datas = make([]Data, 0, len(lines))
+		se    = &StatsError{}
+	)
+	for idx, line := range lines {
+		if line == PandoraParseFlushSignal {
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+			continue
+		}
+		m, err := decodeMessage(p.message, []byte(line))
+		if err != nil {
+			se.AddErrors()
+			se.LastError = fmt.Sprintf("decode protobuf message %v error %v", p.message.fullName, err)
+			if p.disableRecordErrData {
+				se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+			} else {
+				datas = append(datas, Data{KeyPandoraStash: base64.StdEncoding.EncodeToString([]byte(line))})
+			}
+			continue
+		}
+		data := Data(m)
+		for _, l := range p.labels {
+			data[l.Name] = l.Value
+		}
+		se.AddSuccess()
+		datas = append(datas, data)
+	}
+	if se.Errors == 0 {
+		return datas, nil
+	}
+	return datas, se
+}
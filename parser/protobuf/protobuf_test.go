@@ -0,0 +1,171 @@
+package protobuf
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/parser/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func varintField(num int, v uint64) []byte {
+	b := proto.NewBuffer(nil)
+	b.EncodeVarint(uint64(num<<3 | wireVarint))
+	b.EncodeVarint(v)
+	return b.Bytes()
+}
+
+func bytesField(num int, fields ...[]byte) []byte {
+	b := proto.NewBuffer(nil)
+	b.EncodeVarint(uint64(num<<3 | wireBytes))
+	b.EncodeRawBytes(bytes.Join(fields, nil))
+	return b.Bytes()
+}
+
+func stringField(num int, s string) []byte {
+	return bytesField(num, []byte(s))
+}
+
+func fieldDescriptor(name string, number, label, typ int, typeName string) []byte {
+	fields := [][]byte{stringField(1, name), varintField(3, uint64(number)), varintField(4, uint64(label)), varintField(5, uint64(typ))}
+	if typeName != "" {
+		fields = append(fields, stringField(6, typeName))
+	}
+	return bytesField(2, fields...)
+}
+
+// writeDescriptor 生成 log.proto 的 FileDescriptorSet:
+//
+//	package log;
+//	enum Level { INFO = 0; ERROR = 1; }
+//	message Entry {
+//	  message Inner { string host = 1; uint64 big = 2; }
+//	  string msg = 1; int32 code = 2; sint64 delta = 3; repeated int32 ids = 4; Level level = 5;
+//	  Inner inner = 6; map<string, int64> tags = 7; bytes payload = 8; double ratio = 9; repeated Inner items = 10;
+//	}
+func writeDescriptor(t *testing.T, dir string) string {
+	inner := bytesField(3,
+		stringField(1, "Inner"),
+		fieldDescriptor("host", 1, 1, typeString, ""),
+		fieldDescriptor("big", 2, 1, typeUint64, ""),
+	)
+	tagsEntry := bytesField(3,
+		stringField(1, "TagsEntry"),
+		fieldDescriptor("key", 1, 1, typeString, ""),
+		fieldDescriptor("value", 2, 1, typeInt64, ""),
+		bytesField(7, varintField(7, 1)),
+	)
+	entry := bytesField(4,
+		stringField(1, "Entry"),
+		fieldDescriptor("msg", 1, 1, typeString, ""),
+		fieldDescriptor("code", 2, 1, typeInt32, ""),
+		fieldDescriptor("delta", 3, 1, typeSint64, ""),
+		fieldDescriptor("ids", 4, labelRepeated, typeInt32, ""),
+		fieldDescriptor("level", 5, 1, typeEnum, "Level"),
+		fieldDescriptor("inner", 6, 1, typeMessage, ".log.Entry.Inner"),
+		fieldDescriptor("tags", 7, labelRepeated, typeMessage, ".log.Entry.TagsEntry"),
+		fieldDescriptor("payload", 8, 1, typeBytes, ""),
+		fieldDescriptor("ratio", 9, 1, typeDouble, ""),
+		fieldDescriptor("items", 10, labelRepeated, typeMessage, "Inner"),
+		inner,
+		tagsEntry,
+	)
+	level := bytesField(5,
+		stringField(1, "Level"),
+		bytesField(2, stringField(1, "INFO"), varintField(2, 0)),
+		bytesField(2, stringField(1, "ERROR"), varintField(2, 1)),
+	)
+	file := bytesField(1, stringField(1, "log.proto"), stringField(2, "log"), entry, level)
+	path := filepath.Join(dir, "log.desc")
+	assert.NoError(t, ioutil.WriteFile(path, file, 0644))
+	return path
+}
+
+func TestParse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protobuf")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	descriptor := writeDescriptor(t, dir)
+
+	p, err := NewParser(conf.MapConf{
+		KeyParserName:             "pb",
+		KeyProtobufDescriptorFile: descriptor,
+		KeyProtobufMessageType:    "log.Entry",
+		KeyLabels:                 "env prod",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "pb", p.Name())
+
+	ratio := proto.NewBuffer(nil)
+	ratio.EncodeVarint(9<<3 | wireFixed64)
+	ratio.EncodeFixed64(math.Float64bits(0.5))
+	msg := bytes.Join([][]byte{
+		stringField(1, "hello"),
+		varintField(2, math.MaxUint64), // int32 -1 以 10 字节编码
+		varintField(3, 5),              // sint64 -3
+		bytesField(4, proto.EncodeVarint(1), proto.EncodeVarint(300)),
+		varintField(4, 7),
+		varintField(5, 1),
+		bytesField(6, stringField(1, "a"), varintField(2, math.MaxUint64)),
+		bytesField(7, stringField(1, "k1"), varintField(2, 10)),
+		bytesField(7, stringField(1, "k2")),
+		stringField(8, "\x00\xff\n"),
+		ratio.Bytes(),
+		bytesField(10, stringField(1, "b")),
+		bytesField(10),
+		varintField(99, 1),
+	}, nil)
+	datas, err := p.Parse([]string{string(msg), "", "\n\x00"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{
+		{
+			"msg":     "hello",
+			"code":    int64(-1),
+			"delta":   int64(-3),
+			"ids":     []interface{}{int64(1), int64(300), int64(7)},
+			"level":   "ERROR",
+			"inner":   map[string]interface{}{"host": "a", "big": uint64(math.MaxUint64)},
+			"tags":    map[string]interface{}{"k1": int64(10), "k2": nil},
+			"payload": "AP8K",
+			"ratio":   0.5,
+			"items":   []interface{}{map[string]interface{}{"host": "b"}, map[string]interface{}{}},
+			"env":     "prod",
+		},
+		{"env": "prod"},
+		{"env": "prod", "msg": ""},
+	}, datas)
+}
+
+func TestParseError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protobuf")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	descriptor := writeDescriptor(t, dir)
+
+	p, err := NewParser(conf.MapConf{KeyProtobufDescriptorFile: descriptor, KeyProtobufMessageType: ".log.Entry.Inner"})
+	assert.NoError(t, err)
+	datas, err := p.Parse([]string{"\x0a\x05ab", string(varintField(1, 1)), string(stringField(1, "ok"))})
+	se, ok := err.(*StatsError)
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), se.Errors)
+	assert.Equal(t, int64(1), se.Success)
+	assert.Equal(t, []Data{{KeyPandoraStash: "CgVhYg=="}, {KeyPandoraStash: "CAE="}, {"host": "ok"}}, datas)
+
+	for _, c := range []conf.MapConf{
+		{KeyProtobufDescriptorFile: descriptor},
+		{KeyProtobufDescriptorFile: descriptor, KeyProtobufMessageType: "log.Missing"},
+		{KeyProtobufDescriptorFile: filepath.Join(dir, "missing"), KeyProtobufMessageType: "log.Entry"},
+		{KeyProtobufMessageType: "log.Entry"},
+	} {
+		_, err = NewParser(c)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,115 @@
+package protobuf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+// wire type
+const (
+	wireVarint     = 0
+	wireFixed64    = 1
+	wireBytes      = 2
+	wireStartGroup = 3
+	wireEndGroup   = 4
+	wireFixed32    = 5
+)
+
+var errTruncated = errors.New("protobuf message is truncated")
+
+// wireReader 按 protobuf 编码逐个读取字段
+type wireReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *wireReader) done() bool {
+	return r.pos >= len(r.buf)
+}
+
+func (r *wireReader) varint() (uint64, error) {
+	v, n := proto.DecodeVarint(r.buf[r.pos:])
+	if n == 0 {
+		return 0, errTruncated
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *wireReader) fixed32() (uint32, error) {
+	if len(r.buf)-r.pos < 4 {
+		return 0, errTruncated
+	}
+	v := binary.LittleEndian.Uint32(r.buf[r.pos:])
+	r.pos += 4
+	return v, nil
+}
+
+func (r *wireReader) fixed64() (uint64, error) {
+	if len(r.buf)-r.pos < 8 {
+		return 0, errTruncated
+	}
+	v := binary.LittleEndian.Uint64(r.buf[r.pos:])
+	r.pos += 8
+	return v, nil
+}
+
+func (r *wireReader) bytes() ([]byte, error) {
+	n, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.buf)-r.pos) {
+		return nil, errTruncated
+	}
+	b := r.buf[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+// tag 返回字段编号和 wire type
+func (r *wireReader) tag() (int32, int, error) {
+	v, err := r.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	num := int32(v >> 3)
+	if num <= 0 {
+		return 0, 0, fmt.Errorf("invalid protobuf field number %d", num)
+	}
+	return num, int(v & 7), nil
+}
+
+// skip 跳过一个字段的值，group 会一直跳过到对应的结束标记
+func (r *wireReader) skip(wireType int) error {
+	var err error
+	switch wireType {
+	case wireVarint:
+		_, err = r.varint()
+	case wireFixed64:
+		_, err = r.fixed64()
+	case wireBytes:
+		_, err = r.bytes()
+	case wireFixed32:
+		_, err = r.fixed32()
+	case wireStartGroup:
+		for {
+			_, wt, err := r.tag()
+			if err != nil {
+				return err
+			}
+			if wt == wireEndGroup {
+				return nil
+			}
+			if err = r.skip(wt); err != nil {
+				return err
+			}
+		}
+	default:
+		err = fmt.Errorf("invalid protobuf wire type %d", wireType)
+	}
+	return err
+}
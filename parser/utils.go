@@ -1,6 +1,8 @@
 package parser
 
 import (
+	"encoding/json"
+	"errors"
 	"strconv"
 	"strings"
 
@@ -57,3 +59,24 @@ func ConvertWebParserConfig(conf conf.MapConf) conf.MapConf {
 
 	return conf
 }
+
+// NewConfFromJSON 将 json 对象形式的 parser 配置转换为 MapConf，非字符串的值转换为 json 字符串，用于嵌套的 parser 配置
+func NewConfFromJSON(config map[string]interface{}) (conf.MapConf, error) {
+	c := make(conf.MapConf, len(config))
+	for k, v := range config {
+		switch x := v.(type) {
+		case string:
+			c[k] = x
+		default:
+			b, err := json.Marshal(x)
+			if err != nil {
+				return nil, err
+			}
+			c[k] = string(b)
+		}
+	}
+	if c[KeyParserType] == "" {
+		return nil, errors.New("parser type is required")
+	}
+	return c, nil
+}
@@ -0,0 +1,323 @@
+package w3c
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/parser"
+	. "github.com/qiniu/logkit/parser/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// FieldTime 为 date 和 time 字段合并后的时间，W3C 扩展日志的时间为 UTC 时间
+const FieldTime = "time_iso8601"
+
+const fieldsDirective = "#Fields:"
+
+var (
+	_ parser.Parser         = &Parser{}
+	_ parser.ParserType     = &Parser{}
+	_ parser.SourceParser   = &Parser{}
+	_ parser.StatefulParser = &Parser{}
+)
+
+func init() {
+	parser.RegisterConstructor(TypeW3C, NewParser)
+}
+
+// 与 nginx 变量对应的字段
+var knownFields = map[string]struct {
+	name string
+	typ  DataType
+}{
+	"c-ip":            {"remote_addr", TypeString},
+	"cs-username":     {"remote_user", TypeString},
+	"cs-method":       {"request_method", TypeString},
+	"cs-uri-stem":     {"uri", TypeString},
+	"cs-uri-query":    {"args", TypeString},
+	"cs-uri":          {"request_uri", TypeString},
+	"cs-host":         {"host", TypeString},
+	"cs-version":      {"server_protocol", TypeString},
+	"sc-status":       {"status", TypeLong},
+	"sc-substatus":    {"sc_substatus", TypeLong},
+	"sc-win32-status": {"sc_win32_status", TypeLong},
+	"sc-bytes":        {"bytes_sent", TypeLong},
+	"cs-bytes":        {"request_length", TypeLong},
+	"time-taken":      {"request_time", TypeFloat},
+	"s-ip":            {"server_addr", TypeString},
+	"s-port":          {"server_port", TypeLong},
+	"s-sitename":      {"server_name", TypeString},
+	"s-computername":  {"hostname", TypeString},
+	"c-port":          {"remote_port", TypeLong},
+}
+
+// Parser 解析 W3C 扩展日志格式，字段由日志中的 #Fields 指令确定，每个数据来源分别记录当前的字段
+// 以 # 开头的指令行计入 DatasourceSkipIndex
+type Parser struct {
+	name                 string
+	labels               []GrokLabel
+	disableRecordErrData bool
+	keepRawData          bool
+	defaultFields        []string
+	timeTakenScale       float64
+
+	fields map[string][]string
+}
+
+func NewParser(c conf.MapConf) (parser.Parser, error) {
+	name, _ := c.GetStringOr(KeyParserName, "")
+	labelList, _ := c.GetStringListOr(KeyLabels, []string{})
+	disableRecordErrData, _ := c.GetBoolOr(KeyDisableRecordErrData, false)
+	keepRawData, _ := c.GetBoolOr(KeyKeepRawData, false)
+	defaultFields, _ := c.GetStringOr(KeyW3CFields, "")
+	unit, _ := c.GetStringOr(KeyW3CTimeTakenUnit, W3CTimeTakenMillis)
+	var timeTakenScale float64
+	switch unit {
+	case W3CTimeTakenMillis:
+		timeTakenScale = 1e-3
+	case W3CTimeTakenSeconds:
+		timeTakenScale = 1
+	default:
+		return nil, fmt.Errorf("unsupported %v %v", KeyW3CTimeTakenUnit, unit)
+	}
+
+	return &Parser{
+		name:                 name,
+		labels:               GetGrokLabels(labelList, make(map[string]struct{})),
+		disableRecordErrData: disableRecordErrData,
+		keepRawData:          keepRawData,
+		defaultFields:        strings.Fields(defaultFields),
+		timeTakenScale:       timeTakenScale,
+		fields:               make(map[string][]string),
+	}, nil
+}
+
+func (p *Parser) Name() string {
+	return p.name
+}
+
+func (p *Parser) Type() string {
+	return TypeW3C
+}
+
+// Stateful 每个数据来源的 #Fields 指令在多次 Parse 之间保留
+func (p *Parser) Stateful() bool {
+	return true
+}
+
+// Parse 在没有数据来源时所有行共用同一个 #Fields 指令
+func (p *Parser) Parse(lines []string) ([]Data, error) {
+	datas, se := p.parse(lines, nil)
+	if se.Errors == 0 {
+		return datas, nil
+	}
+	return datas, se
+}
+
+// ParseWithSource 在有指令行时即使没有错误也返回 StatsError，使 runner 能按 DatasourceSkipIndex 为数据填写来源
+func (p *Parser) ParseWithSource(lines, froms []string) ([]Data, error) {
+	datas, se := p.parse(lines, froms)
+	if se.Errors == 0 && len(se.DatasourceSkipIndex) == 0 {
+		return datas, nil
+	}
+	return datas, se
+}
+
+func (p *Parser) parse(lines, froms []string) ([]Data, *StatsError) {
+	var (
+		datas = make([]Data, 0, len(lines))
+		se    = &StatsError{}
+	)
+	for idx, line := range lines {
+		line = strings.TrimRight(line, "\r\n")
+		if line == PandoraParseFlushSignal || len(strings.TrimSpace(line)) == 0 {
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+			continue
+		}
+
+		var source string
+		if idx < len(froms) {
+			source = froms[idx]
+		}
+		if strings.HasPrefix(line, "#") {
+			if strings.HasPrefix(line, fieldsDirective) {
+				p.fields[source] = strings.Fields(line[len(fieldsDirective):])
+			}
+			se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+			continue
+		}
+
+		data, err := p.parseLine(source, line)
+		if err != nil {
+			se.AddErrors()
+			se.LastError = err.Error()
+			errData := make(Data)
+			if !p.disableRecordErrData {
+				errData[KeyPandoraStash] = line
+			} else if !p.keepRawData {
+				se.DatasourceSkipIndex = append(se.DatasourceSkipIndex, idx)
+				continue
+			}
+			if p.keepRawData {
+				errData[KeyRawData] = line
+			}
+			datas = append(datas, errData)
+			continue
+		}
+		se.AddSuccess()
+		if p.keepRawData {
+			data[KeyRawData] = line
+		}
+		datas = append(datas, data)
+	}
+	return datas, se
+}
+
+func (p *Parser) parseLine(source, line string) (Data, error) {
+	fields, ok := p.fields[source]
+	if !ok {
+		fields = p.defaultFields
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no %v directive found before line: %v", fieldsDirective, TruncateStrSize(line, DefaultTruncateMaxSize))
+	}
+	values, err := split(line)
+	if err != nil {
+		return nil, fmt.Errorf("%v, line: %v", err, TruncateStrSize(line, DefaultTruncateMaxSize))
+	}
+	if len(values) != len(fields) {
+		return nil, fmt.Errorf("line has %d values but %v has %d fields, line: %v", len(values), fieldsDirective, len(fields), TruncateStrSize(line, DefaultTruncateMaxSize))
+	}
+
+	var (
+		data             = make(Data, len(fields)+len(p.labels))
+		date, tm         string
+		hasDate, hasTime bool
+	)
+	for i, f := range fields {
+		raw := values[i]
+		switch strings.ToLower(f) {
+		case "date":
+			date, hasDate = raw, true
+			continue
+		case "time":
+			tm, hasTime = raw, true
+			continue
+		}
+		name, v, err := p.makeValue(f, raw)
+		if err != nil {
+			return nil, fmt.Errorf("%v, line: %v", err, TruncateStrSize(line, DefaultTruncateMaxSize))
+		}
+		data[name] = v
+	}
+	if hasDate && hasTime {
+		t, err := time.Parse("2006-01-02 15:04:05", date+" "+tm)
+		if err != nil {
+			return nil, fmt.Errorf("parse date %q and time %q error %v", date, tm, err)
+		}
+		data[FieldTime] = t.UTC().Format(time.RFC3339Nano)
+	} else if hasDate {
+		// 只有 date 或 time 时无法得到完整的时间，原样记录
+		data["date"] = date
+	} else if hasTime {
+		data["time"] = tm
+	}
+	for _, l := range p.labels {
+		data[l.Name] = l.Value
+	}
+	return data, nil
+}
+
+// makeValue 返回字段转换后的名称和值，数字字段的 - 转换为 0，time-taken 转换为以秒为单位的 request_time
+// cs(User-Agent) 形式的请求头转换为 http_user_agent，sc(Content-Type) 形式的响应头转换为 sent_http_content_type
+func (p *Parser) makeValue(field, raw string) (string, interface{}, error) {
+	known, ok := knownFields[strings.ToLower(field)]
+	if !ok {
+		return fieldName(field), raw, nil
+	}
+	switch known.typ {
+	case TypeLong:
+		if raw == "-" {
+			return known.name, int64(0), nil
+		}
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return "", nil, fmt.Errorf("convert %q to int64 failed: %q", field, raw)
+		}
+		return known.name, v, nil
+	case TypeFloat:
+		if raw == "-" {
+			return known.name, 0.0, nil
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return "", nil, fmt.Errorf("convert %q to float64 failed: %q", field, raw)
+		}
+		return known.name, v * p.timeTakenScale, nil
+	}
+	return known.name, raw, nil
+}
+
+func fieldName(field string) string {
+	lower := strings.ToLower(field)
+	if strings.HasSuffix(lower, ")") {
+		if i := strings.IndexByte(lower, '('); i > 0 {
+			header := strings.Replace(lower[i+1:len(lower)-1], "-", "_", -1)
+			switch lower[:i] {
+			case "cs":
+				return "http_" + header
+			case "sc":
+				return "sent_http_" + header
+			}
+		}
+	}
+	return strings.NewReplacer("-", "_", "(", "_", ")", "").Replace(lower)
+}
+
+// split 按空格或 tab 切分一行，值可以是以 "" 转义引号的字符串
+func split(line string) ([]string, error) {
+	var values []string
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+		if line[i] != '"' {
+			end := strings.IndexAny(line[i:], " \t")
+			if end < 0 {
+				end = len(line) - i
+			}
+			values = append(values, line[i:i+end])
+			i += end
+			continue
+		}
+
+		var (
+			value  strings.Builder
+			closed bool
+		)
+		for i++; i < len(line); i++ {
+			if line[i] != '"' {
+				value.WriteByte(line[i])
+				continue
+			}
+			if i+1 < len(line) && line[i+1] == '"' {
+				value.WriteByte('"')
+				i++
+				continue
+			}
+			i++
+			closed = true
+			break
+		}
+		if !closed {
+			return nil, errors.New("unclosed quoted string")
+		}
+		values = append(values, value.String())
+	}
+	return values, nil
+}
@@ -0,0 +1,112 @@
+package w3c
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	"github.com/qiniu/logkit/parser"
+	. "github.com/qiniu/logkit/parser/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestParse(t *testing.T) {
+	p, err := NewParser(conf.MapConf{KeyParserName: "iis", KeyLabels: "env prod"})
+	assert.NoError(t, err)
+	assert.Equal(t, "iis", p.Name())
+	assert.True(t, parser.IsStateful(p))
+
+	datas, err := p.Parse([]string{
+		"#Software: Microsoft Internet Information Services 10.0\r\n",
+		"#Fields: date time s-ip cs-method cs-uri-stem cs-uri-query s-port cs-username c-ip cs(User-Agent) cs(Referer) sc-status sc-substatus sc-win32-status time-taken\r\n",
+		"2018-01-02 03:04:05 10.0.0.1 GET /index.html a=1 80 - 192.168.1.2 Mozilla/5.0+(Windows+NT+10.0) - 200 0 0 15\r\n",
+		// 日志滚动后 IIS 重新写入指令，字段可能变化
+		"#Fields: date time cs-method sc(Content-Type) X-Custom sc-bytes\r\n",
+		`2018-01-02 03:04:06.5 POST "text/html; charset=""utf-8""" v -` + "\r\n",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{
+		{
+			"time_iso8601":    "2018-01-02T03:04:05Z",
+			"server_addr":     "10.0.0.1",
+			"request_method":  "GET",
+			"uri":             "/index.html",
+			"args":            "a=1",
+			"server_port":     int64(80),
+			"remote_user":     "-",
+			"remote_addr":     "192.168.1.2",
+			"http_user_agent": "Mozilla/5.0+(Windows+NT+10.0)",
+			"http_referer":    "-",
+			"status":          int64(200),
+			"sc_substatus":    int64(0),
+			"sc_win32_status": int64(0),
+			"request_time":    0.015,
+			"env":             "prod",
+		},
+		{
+			"time_iso8601":           "2018-01-02T03:04:06.5Z",
+			"request_method":         "POST",
+			"sent_http_content_type": `text/html; charset="utf-8"`,
+			"x_custom":               "v",
+			"bytes_sent":             int64(0),
+			"env":                    "prod",
+		},
+	}, datas)
+}
+
+func TestParseWithSource(t *testing.T) {
+	p, err := NewParser(conf.MapConf{
+		KeyW3CFields:            "time c-ip time-taken",
+		KeyW3CTimeTakenUnit:     W3CTimeTakenSeconds,
+		KeyDisableRecordErrData: "true",
+	})
+	assert.NoError(t, err)
+	sp := p.(*Parser)
+
+	datas, err := sp.ParseWithSource([]string{
+		"03:04:05 1.1.1.1 0.5",
+		"#Fields: c-ip sc-status",
+		"2.2.2.2 404",
+		"3.3.3.3 0.1 extra",
+		"4.4.4.4 200",
+	}, []string{"a", "b", "b", "a", "b"})
+	se, ok := err.(*StatsError)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), se.Errors)
+	assert.Equal(t, []int{1, 3}, se.DatasourceSkipIndex)
+	assert.Equal(t, []Data{
+		{"time": "03:04:05", "remote_addr": "1.1.1.1", "request_time": 0.5},
+		{"remote_addr": "2.2.2.2", "status": int64(404)},
+		{"remote_addr": "4.4.4.4", "status": int64(200)},
+	}, datas)
+
+	// 只有指令行时也返回 StatsError
+	_, err = sp.ParseWithSource([]string{"#Version: 1.0"}, []string{"c"})
+	assert.Error(t, err)
+	datas, err = sp.ParseWithSource([]string{"03:04:06 5.5.5.5 1"}, []string{"c"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{{"time": "03:04:06", "remote_addr": "5.5.5.5", "request_time": 1.0}}, datas)
+}
+
+func TestParseError(t *testing.T) {
+	_, err := NewParser(conf.MapConf{KeyW3CTimeTakenUnit: "us"})
+	assert.Error(t, err)
+
+	p, err := NewParser(conf.MapConf{KeyKeepRawData: "true"})
+	assert.NoError(t, err)
+	datas, err := p.Parse([]string{
+		"1.1.1.1 200",
+		"#Fields: c-ip sc-status",
+		"1.1.1.1 ok",
+		`1.1.1.1 "200`,
+	})
+	se, ok := err.(*StatsError)
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), se.Errors)
+	assert.Equal(t, []Data{
+		{KeyPandoraStash: "1.1.1.1 200", KeyRawData: "1.1.1.1 200"},
+		{KeyPandoraStash: "1.1.1.1 ok", KeyRawData: "1.1.1.1 ok"},
+		{KeyPandoraStash: `1.1.1.1 "200`, KeyRawData: `1.1.1.1 "200`},
+	}, datas)
+}
@@ -34,6 +34,7 @@ type diskQueue struct {
 	writeFileNum         int64
 	writePos             int64
 	depthMemory          int64
+	memoryUsedBytes      int64
 	depth                int64
 	currentDiskUsedBytes int64
 
@@ -53,6 +54,7 @@ type diskQueue struct {
 	writeRateLimit      int // 限速 单位byte
 	enableMemoryQueue   bool
 	memoryQueueSize     int64
+	maxMemoryUsedBytes  int64
 	enableDiskUsedLimit bool
 	maxDiskUsedBytes    int64
 
@@ -92,6 +94,8 @@ type NewDiskQueueOptions struct {
 	WriteRateLimit    int
 	EnableMemoryQueue bool
 	MemoryQueueSize   int64
+	// MaxMemoryUsedBytes 内存队列中数据最多占用的字节数，超出后与队列满时一样返回错误，小于等于 0 时不限制
+	MaxMemoryUsedBytes int64
 
 	// DisableDiskUsedLimit 指示是否禁用磁盘占用限制，超出限制后数据将不会再写入到文件而被直接丢弃
 	DisableDiskUsedLimit bool
@@ -119,6 +123,7 @@ func NewDiskQueue(opts NewDiskQueueOptions) BackendQueue {
 		maxMsgSize:          opts.MaxMsgSize,
 		enableMemoryQueue:   opts.EnableMemoryQueue,
 		memoryQueueSize:     opts.MemoryQueueSize,
+		maxMemoryUsedBytes:  opts.MaxMemoryUsedBytes,
 		enableDiskUsedLimit: !opts.DisableDiskUsedLimit,
 		maxDiskUsedBytes:    opts.MaxDiskUsedBytes,
 		readChan:            make(chan []byte),
@@ -256,6 +261,7 @@ func (d *diskQueue) deleteAllMemory() {
 		case <-d.memoryChan:
 		default:
 			atomic.StoreInt64(&d.depthMemory, 0)
+			atomic.StoreInt64(&d.memoryUsedBytes, 0)
 			return
 		}
 	}
@@ -456,9 +462,15 @@ func (d *diskQueue) writeMemory(msg []byte) error {
 	if atomic.LoadInt64(&d.depthMemory) >= d.memoryQueueSize {
 		return errors.New("memory channel is full")
 	}
+	// 队列为空时总是允许写入，避免超过限制的单条数据永远无法写入
+	used := atomic.LoadInt64(&d.memoryUsedBytes)
+	if d.maxMemoryUsedBytes > 0 && used > 0 && used+int64(len(msg)) > d.maxMemoryUsedBytes {
+		return errors.New("memory used bytes exceed limit")
+	}
 	select {
 	case d.memoryChan <- msg:
 		atomic.AddInt64(&d.depthMemory, 1)
+		atomic.AddInt64(&d.memoryUsedBytes, int64(len(msg)))
 		return nil
 	default:
 		return errors.New("memory channel is full")
@@ -469,6 +481,7 @@ func (d *diskQueue) saveToDisk() {
 	for {
 		select {
 		case msg := <-d.memoryChan:
+			atomic.AddInt64(&d.memoryUsedBytes, -int64(len(msg)))
 			err := d.writeOne(msg)
 			if err != nil {
 				// FIXME: 需要一个合适的方案防止数据丢失
@@ -769,6 +782,7 @@ DONE:
 				d.moveForward()
 			case FromMemory:
 				atomic.AddInt64(&d.depthMemory, -1)
+				atomic.AddInt64(&d.memoryUsedBytes, -int64(len(dataRead)))
 			}
 			origin = FromNone
 		case <-d.emptyChan:
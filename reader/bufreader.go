@@ -41,9 +41,11 @@ var (
 // buffered input.
 
 type LastSync struct {
-	cache string
-	buf   string
-	r, w  int
+	cache    string
+	buf      string
+	r, w     int
+	currFile string
+	offset   int64
 }
 
 // BufReader implements buffering for an FileReader object.
@@ -57,12 +59,14 @@ type BufReader struct {
 	lastByte      int
 	lastRuneSize  int
 	lastSync      LastSync
+	ack           bool // 确认模式下读取位置由 Commit 保存
 
 	mux     sync.Mutex
 	decoder mahonia.Decoder
 
 	Meta            *Meta // 存放offset的元信息
 	multiLineRegexp *regexp.Regexp
+	multiline       *multiline
 
 	stats     StatsInfo
 	statsLock sync.RWMutex
@@ -164,6 +168,23 @@ func (b *BufReader) SetMode(mode string, v interface{}) (err error) {
 	return
 }
 
+// SetMultiline 按 MultilineConfig 合并多行，meta 中保存的多行缓存作为正在合并的数据
+func (b *BufReader) SetMultiline(c *MultilineConfig) error {
+	m, err := newMultiline(c)
+	if err != nil {
+		return fmt.Errorf("%v set multiline error %v", b.Name(), err)
+	}
+	m.restore(string(b.FormMutiLine()))
+	b.mutiLineCache = nil
+	b.multiline = m
+	return nil
+}
+
+// MultilinePending 返回是否有正在合并的数据
+func (b *BufReader) MultilinePending() bool {
+	return b.multiline != nil && b.multiline.count() > 0
+}
+
 func (b *BufReader) reset(buf []byte, r FileReader) {
 	*b = BufReader{
 		buf:           buf,
@@ -422,7 +443,27 @@ func (b *BufReader) ReadPattern() (string, error) {
 	}
 }
 
+// readMultiline 按 multiline 合并多行，读取不到新的行时检查是否超时
+func (b *BufReader) readMultiline() (string, error) {
+	for {
+		line, err := b.ReadString('\n')
+		if len(line) == 0 {
+			if ret, ok := b.multiline.timeout(time.Now()); ok {
+				return ret, err
+			}
+			return "", err
+		}
+		ret, ok := b.multiline.push(line)
+		if ok || err != nil {
+			return ret, err
+		}
+	}
+}
+
 func (b *BufReader) FormMutiLine() []byte {
+	if b.multiline != nil {
+		return []byte(b.multiline.pending())
+	}
 	if len(b.mutiLineCache) <= 0 {
 		return make([]byte, 0)
 	}
@@ -448,7 +489,9 @@ func (b *BufReader) calcMutiLineCache() (ret int) {
 
 //ReadLine returns a string line as a normal Reader
 func (b *BufReader) ReadLine() (ret string, err error) {
-	if b.multiLineRegexp == nil {
+	if b.multiline != nil {
+		ret, err = b.readMultiline()
+	} else if b.multiLineRegexp == nil {
 		ret, err = b.ReadString('\n')
 		if os.IsNotExist(err) {
 			if b.lastErrShowTime.Add(5 * time.Second).Before(time.Now()) {
@@ -525,33 +568,102 @@ func (b *BufReader) Lag() (rl *LagInfo, err error) {
 }
 
 func (b *BufReader) SyncMeta() {
+	if b.ack {
+		return
+	}
 	b.mux.Lock()
 	defer b.mux.Unlock()
 	linecache := string(b.FormMutiLine())
+	fp, positioner := b.rd.(filePositioner)
+	var (
+		currFile string
+		offset   int64
+	)
+	if positioner {
+		currFile, offset = fp.position()
+	}
 	//把linecache也缓存
-	if b.lastSync.cache != linecache || b.lastSync.buf != string(b.buf) || b.r != b.lastSync.r || b.w != b.lastSync.w {
+	if b.lastSync.cache != linecache || b.lastSync.buf != string(b.buf) || b.r != b.lastSync.r || b.w != b.lastSync.w ||
+		b.lastSync.currFile != currFile || b.lastSync.offset != offset {
 		log.Debugf("Runner[%v] %v sync meta started, linecache [%v] buf [%v] （%v %v）", b.Meta.RunnerName, b.Name(), linecache, string(b.buf), b.r, b.w)
-		err := b.Meta.WriteBuf(b.buf, b.r, b.w, len(b.buf))
-		if err != nil {
-			log.Errorf("Runner[%v] %s cannot write buf, err :%v", b.Meta.RunnerName, b.Name(), err)
-			return
+		var err error
+		if positioner {
+			// buf、多行缓存和文件的读取位置在一次 Commit 中写入，使用 kv store 时三者始终一致
+			err = b.Meta.WritePosition(b.buf, b.r, b.w, len(b.buf), linecache, currFile, offset)
+		} else {
+			err = b.Meta.WriteBufCache(b.buf, b.r, b.w, len(b.buf), linecache)
 		}
-		err = b.Meta.WriteCacheLine(linecache)
 		if err != nil {
-			log.Errorf("Runner[%v] %s cannot write linecache, err :%v", b.Meta.RunnerName, b.Name(), err)
+			log.Errorf("Runner[%v] %s cannot write buf and linecache, err :%v", b.Meta.RunnerName, b.Name(), err)
 			return
 		}
 		b.lastSync.cache = linecache
 		b.lastSync.buf = string(b.buf)
 		b.lastSync.r = b.r
 		b.lastSync.w = b.w
+		b.lastSync.currFile = currFile
+		b.lastSync.offset = offset
 		log.Debugf("Runner[%v] %v sync meta succeed, linecache [%v] buf [%v] （%v %v）", b.Meta.RunnerName, b.Name(), linecache, string(b.buf), b.r, b.w)
 	} else {
 		log.Debugf("Runner[%v] %v meta data was just syncd, cache %v, buf %v, r,w =(%v,%v), ignore this sync...", b.Meta.RunnerName, b.Name(), linecache, string(b.buf), b.r, b.w)
 	}
+	if positioner {
+		return
+	}
 	err := b.rd.SyncMeta()
 	if err != nil {
 		log.Errorf("Runner[%v] %s cannot write reader %v's meta info, err %v", b.Meta.RunnerName, b.Name(), b.rd.Name(), err)
 		return
 	}
 }
+
+// filePositioner 可以返回当前读取位置的 FileReader，BufReader 依赖它支持确认模式
+type filePositioner interface {
+	position() (currFile string, offset int64)
+}
+
+type bufPosition struct {
+	buf       []byte
+	bufsize   int
+	linecache string
+	currFile  string
+	offset    int64
+}
+
+// String 返回下一行数据在文件中的位置，buf 中未读取的数据不计入
+func (p *bufPosition) String() string {
+	return fmt.Sprintf("%s:%d", p.currFile, p.offset-int64(len(p.buf)))
+}
+
+func (b *BufReader) EnableAck() error {
+	if _, ok := b.rd.(filePositioner); !ok {
+		return fmt.Errorf("%s does not support ack", b.rd.Name())
+	}
+	b.ack = true
+	return nil
+}
+
+// Position 返回 buf 中未读取的数据、多行缓存以及文件的读取位置，底层文件不支持时返回 nil
+func (b *BufReader) Position() Position {
+	fp, ok := b.rd.(filePositioner)
+	if !ok {
+		return nil
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	pos := &bufPosition{
+		buf:       append([]byte{}, b.buf[b.r:b.w]...),
+		bufsize:   len(b.buf),
+		linecache: string(b.FormMutiLine()),
+	}
+	pos.currFile, pos.offset = fp.position()
+	return pos
+}
+
+func (b *BufReader) Commit(pos Position) error {
+	p, ok := pos.(*bufPosition)
+	if !ok {
+		return fmt.Errorf("invalid position type %T", pos)
+	}
+	return b.Meta.WritePosition(p.buf, 0, len(p.buf), p.bufsize, p.linecache, p.currFile, p.offset)
+}
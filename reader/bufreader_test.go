@@ -1,6 +1,7 @@
 package reader
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
@@ -371,6 +372,83 @@ func Test_BuffReaderMultiLine(t *testing.T) {
 	r.Close()
 }
 
+func Test_BuffReaderAck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "Test_BuffReaderAck")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "test.log")
+	assert.NoError(t, ioutil.WriteFile(logPath, []byte("test1\na\ntest2\nb\ntest3\nc\n"), DefaultFilePerm))
+	c := conf.MapConf{
+		"log_path":     logPath,
+		"meta_path":    filepath.Join(dir, "meta"),
+		"mode":         ModeFile,
+		"read_from":    "oldest",
+		"head_pattern": "^test",
+	}
+	r, err := NewFileBufReader(c, false)
+	assert.NoError(t, err)
+	br := r.(*BufReader)
+	assert.NoError(t, br.EnableAck())
+	line, err := br.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "test1\na\n", line)
+	pos := br.Position()
+	line, err = br.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "test2\nb\n", line)
+	// 确认模式下 SyncMeta 不保存读取位置
+	br.SyncMeta()
+	assert.True(t, br.Meta.IsNotExist())
+	assert.NoError(t, br.Commit(pos))
+	br.Close()
+
+	r, err = NewFileBufReader(c, false)
+	assert.NoError(t, err)
+	line, err = r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "test2\nb\n", line)
+	r.Close()
+}
+
+func Test_BuffReaderSyncMeta(t *testing.T) {
+	dir, err := ioutil.TempDir("", "Test_BuffReaderSyncMeta")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "test.log")
+	content := "test1\na\ntest2\nb\ntest3\n"
+	assert.NoError(t, ioutil.WriteFile(logPath, []byte(content), DefaultFilePerm))
+	c := conf.MapConf{
+		"log_path":         logPath,
+		"meta_path":        filepath.Join(dir, "meta"),
+		"mode":             ModeFile,
+		"read_from":        "oldest",
+		"head_pattern":     "^test",
+		KeyCheckpointStore: CheckpointStoreKV,
+	}
+	r, err := NewFileBufReader(c, false)
+	assert.NoError(t, err)
+	br := r.(*BufReader)
+	line, err := br.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "test1\na\n", line)
+	// buf、多行缓存和读取位置一起写入
+	br.SyncMeta()
+	_, offset, err := br.Meta.ReadOffset()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(content)), offset)
+	cache, err := br.Meta.ReadCacheLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "test2\n", string(cache))
+	br.Close()
+
+	r, err = NewFileBufReader(c, false)
+	assert.NoError(t, err)
+	line, err = r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "test2\nb\n", line)
+	r.Close()
+}
+
 func Test_BuffReaderStats(t *testing.T) {
 	body := "Test_BuffReaderStats\n"
 	createSeqFile(1000, body)
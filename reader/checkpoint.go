@@ -0,0 +1,169 @@
+package reader
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/qiniu/log"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	checkpointDBName = "checkpoint.db"
+	migratedSuffix   = ".migrated"
+)
+
+// checkpointFiles 由 CheckpointStore 保存的 meta 文件，done file 仍以文件的形式保存在 file_done 目录中供 cleaner 使用
+// 使用 kv store 时 reader 读取的处理完的文件记录在 store 中，见 Meta.AppendDoneFileInode
+var checkpointFiles = []string{metaFileName, bufMetaFilePath, bufFilePath, lineCacheFilePath, statisticFileName}
+
+// CheckpointStore 保存 reader 的读取进度，key 为对应 meta 文件的路径
+type CheckpointStore interface {
+	// Get 读取 key 的内容，key 不存在时返回的错误满足 os.IsNotExist
+	Get(key string) ([]byte, error)
+	Put(key string, value []byte) error
+	// Commit 写入多个 key，kv 实现保证要么全部写入要么全部不写入
+	Commit(kvs map[string][]byte) error
+	ModTime(key string) (time.Time, error)
+	// Delete 删除 key 以及 key 目录下的所有 key
+	Delete(key string) error
+}
+
+func notExistError(key string) error {
+	return &os.PathError{Op: "get", Path: key, Err: os.ErrNotExist}
+}
+
+// fileCheckpointStore 每个 key 保存为一个文件，先写临时文件再 rename 保证单个 key 写入的原子性
+type fileCheckpointStore struct{}
+
+func (fileCheckpointStore) Get(key string) ([]byte, error) {
+	return ioutil.ReadFile(key)
+}
+
+func (fileCheckpointStore) Put(key string, value []byte) error {
+	tmpFile, err := writeTmpFile(key, value)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, key)
+}
+
+func (fileCheckpointStore) Commit(kvs map[string][]byte) error {
+	tmpFiles := make(map[string]string, len(kvs))
+	defer func() {
+		for _, tmpFile := range tmpFiles {
+			os.RemoveAll(tmpFile)
+		}
+	}()
+	for key, value := range kvs {
+		tmpFile, err := writeTmpFile(key, value)
+		if err != nil {
+			return err
+		}
+		tmpFiles[key] = tmpFile
+	}
+	for key, tmpFile := range tmpFiles {
+		if err := os.Rename(tmpFile, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fileCheckpointStore) ModTime(key string) (time.Time, error) {
+	fi, err := os.Stat(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+func (fileCheckpointStore) Delete(key string) error {
+	return os.RemoveAll(key)
+}
+
+func writeTmpFile(key string, value []byte) (string, error) {
+	tmpFile := fmt.Sprintf("%s.%d.tmp", key, rand.Int())
+	f, err := os.OpenFile(tmpFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, DefaultFilePerm)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(value)
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		os.RemoveAll(tmpFile)
+		return "", err
+	}
+	return tmpFile, nil
+}
+
+var (
+	kvStoresLock sync.Mutex
+	kvStores     = make(map[string]*kvCheckpointStore)
+)
+
+// openKVCheckpointStore 同一个路径的 kv 存储在进程内共享，tailx 和 dirx 的 submeta 与其父 meta 使用同一个存储
+func openKVCheckpointStore(path string) (*kvCheckpointStore, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	kvStoresLock.Lock()
+	defer kvStoresLock.Unlock()
+	if s, ok := kvStores[path]; ok {
+		return s, nil
+	}
+	s, err := newKVCheckpointStore(path)
+	if err != nil {
+		return nil, err
+	}
+	kvStores[path] = s
+	return s, nil
+}
+
+// migrateToStore 将 dir 下已有的 meta 文件导入 store，导入后原文件重命名为 *.migrated
+// store 中已有该目录的进度时不导入，避免旧文件覆盖新的进度
+func migrateToStore(store CheckpointStore, dir string) error {
+	if _, ok := store.(fileCheckpointStore); ok {
+		return nil
+	}
+	for _, name := range checkpointFiles {
+		if _, err := store.Get(filepath.Join(dir, name)); err == nil {
+			return nil
+		}
+	}
+	kvs := make(map[string][]byte)
+	for _, name := range checkpointFiles {
+		key := filepath.Join(dir, name)
+		value, err := ioutil.ReadFile(key)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		kvs[key] = value
+	}
+	if len(kvs) == 0 {
+		return nil
+	}
+	if err := store.Commit(kvs); err != nil {
+		return err
+	}
+	for key := range kvs {
+		if err := os.Rename(key, key+migratedSuffix); err != nil {
+			log.Warnf("rename migrated meta file %v error %v", key, err)
+		}
+	}
+	log.Infof("migrated %d meta files in %v to checkpoint store", len(kvs), dir)
+	return nil
+}
@@ -0,0 +1,348 @@
+package reader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qiniu/log"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	kvOpPut    byte = 1
+	kvOpDelete byte = 2
+
+	kvFrameHeaderSize = 8
+	// 日志文件超过 kvCompactMinSize 且为有效数据的 kvCompactRatio 倍以上时进行压缩
+	kvCompactMinSize = 1024 * 1024
+	kvCompactRatio   = 4
+)
+
+var errCorruptedFrame = errors.New("corrupted checkpoint frame")
+
+type kvEntry struct {
+	value   []byte
+	modTime time.Time
+}
+
+type kvOp struct {
+	op      byte
+	key     string
+	value   []byte
+	modTime time.Time
+}
+
+// kvCheckpointStore 嵌入式的 kv 存储，所有数据保存在一个追加写的日志文件中
+// 每次 Commit 写入一个带长度和 crc 校验的帧并 fsync，启动时重放日志恢复数据，末尾写了一半的帧会被丢弃，
+// 因此一次 Commit 中的多个 key 要么全部生效要么全部不生效
+type kvCheckpointStore struct {
+	path string
+
+	mux     sync.Mutex
+	f       *os.File
+	fi      os.FileInfo
+	size    int64
+	entries map[string]kvEntry
+}
+
+func newKVCheckpointStore(path string) (*kvCheckpointStore, error) {
+	s := &kvCheckpointStore{path: path}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load 重放日志文件，日志文件不存在时数据为空，写入时再创建
+func (s *kvCheckpointStore) load() error {
+	if s.f != nil {
+		s.f.Close()
+	}
+	s.f, s.fi, s.size = nil, nil, 0
+	s.entries = make(map[string]kvEntry)
+
+	f, err := os.OpenFile(s.path, os.O_RDWR, DefaultFilePerm)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	var offset int64
+	for offset < int64(len(data)) {
+		ops, n, err := decodeKVFrame(data[offset:])
+		if err != nil {
+			log.Warnf("checkpoint store %v is truncated at offset %d: %v", s.path, offset, err)
+			if err = f.Truncate(offset); err != nil {
+				f.Close()
+				return err
+			}
+			break
+		}
+		s.apply(ops)
+		offset += n
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	if s.fi, err = f.Stat(); err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.size = f, offset
+	return nil
+}
+
+// ensure 日志文件被外部删除或替换（如 meta 目录被删除）时重新加载
+func (s *kvCheckpointStore) ensure() error {
+	fi, err := os.Stat(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if s.fi == nil && fi == nil {
+		return nil
+	}
+	if s.fi != nil && fi != nil && os.SameFile(s.fi, fi) {
+		return nil
+	}
+	return s.load()
+}
+
+func (s *kvCheckpointStore) apply(ops []kvOp) {
+	for _, op := range ops {
+		switch op.op {
+		case kvOpPut:
+			s.entries[op.key] = kvEntry{value: op.value, modTime: op.modTime}
+		case kvOpDelete:
+			prefix := op.key + string(filepath.Separator)
+			for key := range s.entries {
+				if key == op.key || strings.HasPrefix(key, prefix) {
+					delete(s.entries, key)
+				}
+			}
+		}
+	}
+}
+
+func (s *kvCheckpointStore) write(ops []kvOp) error {
+	if err := s.ensure(); err != nil {
+		return err
+	}
+	if s.f == nil {
+		if err := os.MkdirAll(filepath.Dir(s.path), DefaultDirPerm); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(s.path, os.O_RDWR|os.O_CREATE|os.O_EXCL, DefaultFilePerm)
+		if err != nil {
+			return err
+		}
+		if s.fi, err = f.Stat(); err != nil {
+			f.Close()
+			return err
+		}
+		s.f = f
+	}
+	frame := encodeKVFrame(ops)
+	if _, err := s.f.Write(frame); err != nil {
+		// 丢弃写了一半的帧，保证后续写入的数据可以被重放
+		s.f.Truncate(s.size)
+		s.f.Seek(s.size, io.SeekStart)
+		return err
+	}
+	if err := s.f.Sync(); err != nil {
+		return err
+	}
+	s.size += int64(len(frame))
+	s.apply(ops)
+	if s.size > kvCompactMinSize && s.size > kvCompactRatio*s.liveSize() {
+		if err := s.compact(); err != nil {
+			log.Warnf("compact checkpoint store %v error %v", s.path, err)
+		}
+	}
+	return nil
+}
+
+func (s *kvCheckpointStore) liveSize() int64 {
+	var size int64
+	for key, entry := range s.entries {
+		size += int64(len(key) + len(entry.value))
+	}
+	return size
+}
+
+// compact 将当前的有效数据写入新的日志文件后替换旧文件
+func (s *kvCheckpointStore) compact() error {
+	keys := make([]string, 0, len(s.entries))
+	for key := range s.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	ops := make([]kvOp, 0, len(keys))
+	for _, key := range keys {
+		entry := s.entries[key]
+		ops = append(ops, kvOp{op: kvOpPut, key: key, value: entry.value, modTime: entry.modTime})
+	}
+	tmpFile, err := writeTmpFile(s.path, encodeKVFrame(ops))
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmpFile, s.path); err != nil {
+		os.RemoveAll(tmpFile)
+		return err
+	}
+	return s.load()
+}
+
+func (s *kvCheckpointStore) Get(key string) ([]byte, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := s.ensure(); err != nil {
+		return nil, err
+	}
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, notExistError(key)
+	}
+	return append([]byte{}, entry.value...), nil
+}
+
+func (s *kvCheckpointStore) Put(key string, value []byte) error {
+	return s.Commit(map[string][]byte{key: value})
+}
+
+func (s *kvCheckpointStore) Commit(kvs map[string][]byte) error {
+	now := time.Now()
+	ops := make([]kvOp, 0, len(kvs))
+	for key, value := range kvs {
+		ops = append(ops, kvOp{op: kvOpPut, key: key, value: append([]byte{}, value...), modTime: now})
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.write(ops)
+}
+
+func (s *kvCheckpointStore) ModTime(key string) (time.Time, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := s.ensure(); err != nil {
+		return time.Time{}, err
+	}
+	entry, ok := s.entries[key]
+	if !ok {
+		return time.Time{}, notExistError(key)
+	}
+	return entry.modTime, nil
+}
+
+func (s *kvCheckpointStore) Delete(key string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := s.ensure(); err != nil {
+		return err
+	}
+	if s.f == nil {
+		return nil
+	}
+	return s.write([]kvOp{{op: kvOpDelete, key: key}})
+}
+
+// encodeKVFrame 帧格式为 [4 字节 payload 长度][4 字节 payload crc32][payload]
+// payload 由若干个操作组成，每个操作为 [op][key 长度][key][修改时间][value 长度][value]
+func encodeKVFrame(ops []kvOp) []byte {
+	var payload bytes.Buffer
+	buf := make([]byte, binary.MaxVarintLen64)
+	writeBytes := func(b []byte) {
+		n := binary.PutUvarint(buf, uint64(len(b)))
+		payload.Write(buf[:n])
+		payload.Write(b)
+	}
+	for _, op := range ops {
+		payload.WriteByte(op.op)
+		writeBytes([]byte(op.key))
+		if op.op != kvOpPut {
+			continue
+		}
+		n := binary.PutVarint(buf, op.modTime.UnixNano())
+		payload.Write(buf[:n])
+		writeBytes(op.value)
+	}
+	frame := make([]byte, kvFrameHeaderSize+payload.Len())
+	binary.BigEndian.PutUint32(frame[0:4], uint32(payload.Len()))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload.Bytes()))
+	copy(frame[kvFrameHeaderSize:], payload.Bytes())
+	return frame
+}
+
+// decodeKVFrame 解析 data 开头的一个帧，返回帧中的操作和帧的长度
+func decodeKVFrame(data []byte) ([]kvOp, int64, error) {
+	if len(data) < kvFrameHeaderSize {
+		return nil, 0, errCorruptedFrame
+	}
+	size := int64(binary.BigEndian.Uint32(data[0:4]))
+	if int64(len(data)-kvFrameHeaderSize) < size {
+		return nil, 0, errCorruptedFrame
+	}
+	payload := data[kvFrameHeaderSize : kvFrameHeaderSize+size]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(data[4:8]) {
+		return nil, 0, errCorruptedFrame
+	}
+
+	r := bytes.NewReader(payload)
+	readBytes := func() ([]byte, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(r.Len()) {
+			return nil, errCorruptedFrame
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(r, b)
+		return b, err
+	}
+	var ops []kvOp
+	for r.Len() > 0 {
+		op, err := r.ReadByte()
+		if err != nil {
+			return nil, 0, err
+		}
+		if op != kvOpPut && op != kvOpDelete {
+			return nil, 0, fmt.Errorf("unknown checkpoint op %d", op)
+		}
+		key, err := readBytes()
+		if err != nil {
+			return nil, 0, err
+		}
+		item := kvOp{op: op, key: string(key)}
+		if op == kvOpPut {
+			nano, err := binary.ReadVarint(r)
+			if err != nil {
+				return nil, 0, err
+			}
+			item.modTime = time.Unix(0, nano)
+			if item.value, err = readBytes(); err != nil {
+				return nil, 0, err
+			}
+		}
+		ops = append(ops, item)
+	}
+	return ops, kvFrameHeaderSize + size, nil
+}
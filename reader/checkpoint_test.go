@@ -0,0 +1,169 @@
+package reader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestKVCheckpointStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestKVCheckpointStore")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, checkpointDBName)
+
+	s, err := newKVCheckpointStore(path)
+	assert.NoError(t, err)
+	_, err = s.Get("a")
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, s.Delete("a"))
+	assert.NoError(t, s.Put("a", []byte("1")))
+	assert.NoError(t, s.Commit(map[string][]byte{
+		filepath.Join("sub", "b"): []byte("2"),
+		filepath.Join("sub", "c"): []byte("3"),
+		"subx":                    []byte("4"),
+	}))
+	assert.NoError(t, s.Delete("sub"))
+	modTime, err := s.ModTime("a")
+	assert.NoError(t, err)
+	assert.False(t, modTime.IsZero())
+
+	// 重新打开后恢复数据，末尾写了一半的帧被丢弃
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, DefaultFilePerm)
+	assert.NoError(t, err)
+	frame := encodeKVFrame([]kvOp{{op: kvOpPut, key: "a", value: []byte("5"), modTime: time.Now()}})
+	_, err = f.Write(frame[:len(frame)-1])
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	s, err = newKVCheckpointStore(path)
+	assert.NoError(t, err)
+	value, err := s.Get("a")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(value))
+	value, err = s.Get("subx")
+	assert.NoError(t, err)
+	assert.Equal(t, "4", string(value))
+	_, err = s.Get(filepath.Join("sub", "b"))
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, s.Put("a", []byte("6")))
+	s, err = newKVCheckpointStore(path)
+	assert.NoError(t, err)
+	value, err = s.Get("a")
+	assert.NoError(t, err)
+	assert.Equal(t, "6", string(value))
+
+	// 日志文件过大时压缩
+	big := make([]byte, 512*1024)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, s.Put("big", big))
+	}
+	fi, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.True(t, fi.Size() < kvCompactMinSize*2)
+	value, err = s.Get("big")
+	assert.NoError(t, err)
+	assert.Len(t, value, len(big))
+
+	// 日志文件被删除后数据为空
+	assert.NoError(t, os.Remove(path))
+	_, err = s.Get("a")
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, s.Put("a", []byte("7")))
+	value, err = s.Get("a")
+	assert.NoError(t, err)
+	assert.Equal(t, "7", string(value))
+}
+
+func TestMetaWithKVCheckpointStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestMetaWithKVCheckpointStore")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	metaDir := filepath.Join(dir, "meta")
+	logPath := filepath.Join(dir, "test.log")
+	assert.NoError(t, ioutil.WriteFile(logPath, []byte("abc\n"), DefaultFilePerm))
+
+	c := conf.MapConf{
+		KeyMetaPath: metaDir,
+		KeyLogPath:  logPath,
+		KeyMode:     ModeFile,
+	}
+	meta, err := NewMetaWithConf(c)
+	assert.NoError(t, err)
+	assert.NoError(t, meta.WriteOffset(logPath, 2))
+	assert.NoError(t, meta.WriteCacheLine("cache"))
+
+	c[KeyCheckpointStore] = "unknown"
+	_, err = NewMetaWithConf(c)
+	assert.Error(t, err)
+
+	// 已有的 meta 文件导入 kv 存储
+	c[KeyCheckpointStore] = CheckpointStoreKV
+	c[KeyCheckpointPath] = filepath.Join(dir, "store", checkpointDBName)
+	meta, err = NewMetaWithConf(c)
+	assert.NoError(t, err)
+	assert.False(t, meta.IsNotExist())
+	currFile, offset, err := meta.ReadOffset()
+	assert.NoError(t, err)
+	assert.Equal(t, logPath, currFile)
+	assert.Equal(t, int64(2), offset)
+	lines, err := meta.ReadCacheLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "cache", string(lines))
+	_, err = os.Stat(meta.MetaFile())
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(meta.MetaFile() + migratedSuffix)
+	assert.NoError(t, err)
+
+	assert.NoError(t, meta.WriteBuf([]byte("xyz"), 1, 2, 3))
+	r, w, bufsize, err := meta.ReadBufMeta()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, []int{r, w, bufsize})
+	buf := make([]byte, 3)
+	n, err := meta.ReadBuf(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "xyz", string(buf[:n]))
+	_, err = os.Stat(meta.BufFile())
+	assert.True(t, os.IsNotExist(err))
+
+	// 处理完的文件记录在 kv 存储中，过期的记录被丢弃
+	assert.NoError(t, meta.store.Put(meta.doneInodeFile(), []byte("old\t1\t"+time.Now().Add(-8*24*time.Hour).Format(time.RFC3339Nano)+"\n")))
+	assert.NoError(t, meta.AppendDoneFileInode(logPath, 2))
+	assert.Equal(t, map[string]bool{joinFileInode(logPath, "2"): true}, meta.GetDoneFileInode())
+	assert.NoError(t, os.Remove(meta.DoneFile()))
+	assert.Equal(t, map[string]bool{joinFileInode(logPath, "2"): true}, meta.GetDoneFileInode())
+
+	// submeta 使用同一个 kv 存储，过期后被清理
+	subMeta, err := meta.NewSubMeta(filepath.Join(metaDir, "sub"), logPath, ModeFile)
+	assert.NoError(t, err)
+	assert.True(t, subMeta.IsNotExist())
+	assert.NoError(t, subMeta.WriteOffset(logPath, 1))
+	assert.False(t, subMeta.IsNotExist())
+	meta.CheckExpiredSubMetas(time.Nanosecond)
+	meta.CleanExpiredSubMetas(time.Nanosecond)
+	assert.False(t, subMeta.IsNotExist())
+	store := meta.checkpointStore().(*kvCheckpointStore)
+	assert.NoError(t, store.write([]kvOp{{op: kvOpPut, key: subMeta.MetaFile(), modTime: time.Now().Add(-25 * time.Hour)}}))
+	meta.CheckExpiredSubMetas(time.Nanosecond)
+	meta.CleanExpiredSubMetas(time.Nanosecond)
+	assert.True(t, subMeta.IsNotExist())
+
+	// 重新创建的 meta 使用同一个 kv 存储，重置后读取进度被删除
+	meta, err = NewMetaWithConf(c)
+	assert.NoError(t, err)
+	_, offset, err = meta.ReadOffset()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), offset)
+	assert.NoError(t, meta.Reset())
+	assert.True(t, meta.IsNotExist())
+	_, err = NewMetaWithConf(c)
+	assert.NoError(t, err)
+	assert.True(t, meta.IsNotExist())
+}
@@ -63,6 +63,25 @@ var (
 		Advance:      true,
 		ToolTip:      "一个文件夹，记录本次reader的读取位置，默认会自动生成",
 	}
+	OptionCheckpointStore = Option{
+		KeyName:       KeyCheckpointStore,
+		Element:       Radio,
+		ChooseOnly:    true,
+		ChooseOptions: []interface{}{CheckpointStoreFile, CheckpointStoreKV},
+		Default:       CheckpointStoreFile,
+		Description:   "读取进度存储方式(checkpoint_store)",
+		Advance:       true,
+		ToolTip:       "file 将读取进度分别保存为 meta_path 下的文件；kv 将读取进度保存在一个嵌入式 kv 数据库文件中，多个 key 原子提交，meta_path 下已有的读取进度会自动导入",
+	}
+	OptionCheckpointPath = Option{
+		KeyName:      KeyCheckpointPath,
+		ChooseOnly:   false,
+		Default:      "",
+		DefaultNoUse: false,
+		Description:  "读取进度数据库路径(checkpoint_path)",
+		Advance:      true,
+		ToolTip:      "checkpoint_store 为 kv 时数据库文件的路径，默认为 meta_path 下的 checkpoint.db，可以指定到持久化存储上，多个 runner 可以共用同一个文件",
+	}
 	OptionIgnoreLogPath = Option{
 		KeyName:      KeyIgnoreLogPath,
 		ChooseOnly:   false,
@@ -140,6 +159,76 @@ var (
 		Advance:      true,
 		ToolTip:      "reader每次读取一行，若要读取多行，请填写head_pattern，表示匹配多行时新的一行的开始符合该正则表达式",
 	}
+	OptionMultilinePreset = Option{
+		KeyName:       KeyMultilinePreset,
+		ChooseOnly:    true,
+		ChooseOptions: []interface{}{"", MultilinePresetJava, MultilinePresetPython, MultilinePresetGo},
+		Default:       "",
+		DefaultNoUse:  false,
+		Description:   "多行合并预设(multiline_preset)",
+		Advance:       true,
+		ToolTip:       "按预设的规则合并 Java、Python、Go 的异常堆栈，填写 multiline_pattern 等配置会覆盖预设中对应的值",
+	}
+	OptionMultilinePattern = Option{
+		KeyName:      KeyMultilinePattern,
+		ChooseOnly:   false,
+		Default:      "",
+		DefaultNoUse: false,
+		Description:  "多行合并正则表达式(multiline_pattern)",
+		Advance:      true,
+		ToolTip:      "按 multiline_match 的方式合并多行，匹配时会去掉行尾的换行符，不能与 head_pattern 同时使用",
+	}
+	OptionMultilineMatch = Option{
+		KeyName:       KeyMultilineMatch,
+		ChooseOnly:    true,
+		ChooseOptions: []interface{}{MultilineMatchHead, MultilineMatchContinue, MultilineMatchTail},
+		Default:       MultilineMatchHead,
+		DefaultNoUse:  false,
+		Description:   "多行合并方式(multiline_match)",
+		Advance:       true,
+		ToolTip:       "head 表示匹配的行是新的一条数据的开始；continue 表示匹配的行属于上一条数据；tail 表示匹配的行是一条数据的结束",
+	}
+	OptionMultilineNegate = Option{
+		KeyName:       KeyMultilineNegate,
+		Element:       Radio,
+		ChooseOnly:    true,
+		ChooseOptions: []interface{}{"false", "true"},
+		Default:       "false",
+		DefaultNoUse:  false,
+		Description:   "多行合并取反(multiline_negate)",
+		Advance:       true,
+		ToolTip:       "开启后不匹配 multiline_pattern 的行视为匹配",
+	}
+	OptionMultilineMaxLines = Option{
+		KeyName:      KeyMultilineMaxLines,
+		ChooseOnly:   false,
+		Default:      "500",
+		DefaultNoUse: false,
+		Description:  "多行合并最大行数(multiline_max_lines)",
+		CheckRegex:   "\\d+",
+		Advance:      true,
+		ToolTip:      "一条数据合并的行数达到上限后直接输出，0 表示不限制",
+	}
+	OptionMultilineMaxBytes = Option{
+		KeyName:      KeyMultilineMaxBytes,
+		ChooseOnly:   false,
+		Default:      "",
+		DefaultNoUse: false,
+		Description:  "多行合并最大字节数(multiline_max_bytes)",
+		CheckRegex:   "\\d+",
+		Advance:      true,
+		ToolTip:      "一条数据合并的字节数达到上限后直接输出，默认为 20MB",
+	}
+	OptionMultilineFlushTimeout = Option{
+		KeyName:      KeyMultilineFlushTimeout,
+		ChooseOnly:   false,
+		Default:      "5s",
+		DefaultNoUse: false,
+		Description:  "多行合并超时时间(multiline_flush_timeout)",
+		CheckRegex:   "\\d+[hms]",
+		Advance:      true,
+		ToolTip:      "超过这个时间没有新的行时输出已经合并的数据，避免最后一条数据一直等待下一行，0s 表示不超时",
+	}
 	OptionSQLSchema = Option{
 		KeyName:      KeySQLSchema,
 		ChooseOnly:   false,
@@ -283,12 +372,21 @@ var ModeKeyOptions = map[string][]Option{
 			ToolTip:      "需要收集的日志的文件夹路径",
 		},
 		OptionMetaPath,
+		OptionCheckpointStore,
+		OptionCheckpointPath,
 		OptionBuffSize,
 		OptionWhence,
 		OptionEncoding,
 		OptionDataSourceTag,
 		OptionReadIoLimit,
 		OptionHeadPattern,
+		OptionMultilinePreset,
+		OptionMultilinePattern,
+		OptionMultilineMatch,
+		OptionMultilineNegate,
+		OptionMultilineMaxLines,
+		OptionMultilineMaxBytes,
+		OptionMultilineFlushTimeout,
 		OptionKeyNewFileNewLine,
 		OptionKeySkipFileFirstLine,
 		OptionKeyReadSameInode,
@@ -308,12 +406,21 @@ var ModeKeyOptions = map[string][]Option{
 			ToolTip:      "需要收集的日志的文件路径",
 		},
 		OptionMetaPath,
+		OptionCheckpointStore,
+		OptionCheckpointPath,
 		OptionBuffSize,
 		OptionWhence,
 		OptionDataSourceTag,
 		OptionEncoding,
 		OptionReadIoLimit,
 		OptionHeadPattern,
+		OptionMultilinePreset,
+		OptionMultilinePattern,
+		OptionMultilineMatch,
+		OptionMultilineNegate,
+		OptionMultilineMaxLines,
+		OptionMultilineMaxBytes,
+		OptionMultilineFlushTimeout,
 	},
 	ModeTailx: {
 		{
@@ -328,12 +435,21 @@ var ModeKeyOptions = map[string][]Option{
 		},
 		OptionIgnoreLogPath,
 		OptionMetaPath,
+		OptionCheckpointStore,
+		OptionCheckpointPath,
 		OptionBuffSize,
 		OptionWhence,
 		OptionEncoding,
 		OptionReadIoLimit,
 		OptionDataSourceTag,
 		OptionHeadPattern,
+		OptionMultilinePreset,
+		OptionMultilinePattern,
+		OptionMultilineMatch,
+		OptionMultilineNegate,
+		OptionMultilineMaxLines,
+		OptionMultilineMaxBytes,
+		OptionMultilineFlushTimeout,
 		{
 			KeyName:      KeyExpire,
 			ChooseOnly:   false,
@@ -361,12 +477,21 @@ var ModeKeyOptions = map[string][]Option{
 		},
 		OptionIgnoreLogPath,
 		OptionMetaPath,
+		OptionCheckpointStore,
+		OptionCheckpointPath,
 		OptionBuffSize,
 		OptionWhence,
 		OptionEncoding,
 		OptionDataSourceTag,
 		OptionReadIoLimit,
 		OptionHeadPattern,
+		OptionMultilinePreset,
+		OptionMultilinePattern,
+		OptionMultilineMatch,
+		OptionMultilineNegate,
+		OptionMultilineMaxLines,
+		OptionMultilineMaxBytes,
+		OptionMultilineFlushTimeout,
 		OptionKeyNewFileNewLine,
 		OptionKeySkipFileFirstLine,
 		OptionKeyReadSameInode,
@@ -416,6 +541,13 @@ var ModeKeyOptions = map[string][]Option{
 		OptionDataSourceTag,
 		OptionReadIoLimit,
 		OptionHeadPattern,
+		OptionMultilinePreset,
+		OptionMultilinePattern,
+		OptionMultilineMatch,
+		OptionMultilineNegate,
+		OptionMultilineMaxLines,
+		OptionMultilineMaxBytes,
+		OptionMultilineFlushTimeout,
 		OptionKeyNewFileNewLine,
 		OptionKeySkipFileFirstLine,
 		OptionKeyReadSameInode,
@@ -956,15 +1088,23 @@ var ModeKeyOptions = map[string][]Option{
 			DefaultNoUse: true,
 			Description:  "topic名称(kafka_topic)",
 		},
+		{
+			KeyName:      KeyKafkaBrokers,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "localhost:9092",
+			DefaultNoUse: true,
+			Description:  "broker地址(kafka_brokers)",
+			ToolTip:      "kafka broker地址列表，多个用逗号分隔，填写后使用 broker 协调的 consumer group(kafka >= 0.10.2)，不再需要 zookeeper",
+		},
 		{
 			KeyName:      KeyKafkaZookeeper,
 			ChooseOnly:   false,
 			Default:      "",
-			Required:     true,
 			Placeholder:  "localhost:2181",
 			DefaultNoUse: true,
 			Description:  "zookeeper地址(kafka_zookeeper)",
-			ToolTip:      "zookeeper地址列表，多个用逗号分隔，常用端口是2181",
+			ToolTip:      "zookeeper地址列表，多个用逗号分隔，常用端口是2181，没有填写 kafka_brokers 时必填",
 		},
 		{
 			KeyName:      KeyKafkaZookeeperChroot,
@@ -996,6 +1136,110 @@ var ModeKeyOptions = map[string][]Option{
 			Advance:      true,
 			ToolTip:      "kafka单次请求最大处理时间，可以填写单位如1s(1秒)、2m(2分钟)、3h(3小时)",
 		},
+		{
+			KeyName:      KeyKafkaVersion,
+			ChooseOnly:   false,
+			Default:      "0.10.2.0",
+			DefaultNoUse: false,
+			Description:  "kafka版本(kafka_version)",
+			Advance:      true,
+			ToolTip:      "填写 kafka_brokers 时生效，kafka 集群的版本，至少为 0.10.2.0",
+		},
+		{
+			KeyName:       KeyKafkaBalanceStrategy,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{KafkaBalanceRange, KafkaBalanceRoundRobin},
+			Default:       KafkaBalanceRange,
+			DefaultNoUse:  false,
+			Description:   "分区分配策略(kafka_balance_strategy)",
+			Advance:       true,
+			ToolTip:       "填写 kafka_brokers 时生效，consumer group rebalance 时分配分区的策略，不支持 cooperative rebalance",
+		},
+		{
+			KeyName:       KeyKafkaSASLMechanism,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{KafkaSASLPlain, KafkaSASLSCRAMSHA256, KafkaSASLSCRAMSHA512},
+			Default:       KafkaSASLPlain,
+			DefaultNoUse:  false,
+			Description:   "SASL认证方式(kafka_sasl_mechanism)",
+			Advance:       true,
+			ToolTip:       "填写 kafka_brokers 和 kafka_sasl_username 时生效，支持 PLAIN、SCRAM-SHA-256 和 SCRAM-SHA-512",
+		},
+		{
+			KeyName:      KeyKafkaSASLUsername,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "SASL用户名(kafka_sasl_username)",
+			Advance:      true,
+			ToolTip:      "填写 kafka_brokers 时生效，不填写表示不使用 SASL 认证",
+		},
+		{
+			KeyName:      KeyKafkaSASLPassword,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Secret:       true,
+			Description:  "SASL密码(kafka_sasl_password)",
+			Advance:      true,
+		},
+		{
+			KeyName:       KeyKafkaTLSEnable,
+			Element:       Radio,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"false", "true"},
+			Default:       "false",
+			DefaultNoUse:  false,
+			Description:   "是否使用TLS(kafka_tls_enable)",
+			Advance:       true,
+			ToolTip:       "填写 kafka_brokers 时生效",
+		},
+		{
+			KeyName:            KeyKafkaTLSCA,
+			ChooseOnly:         false,
+			Default:            "",
+			DefaultNoUse:       false,
+			Description:        "CA证书路径(kafka_tls_ca)",
+			AdvanceDepend:      KeyKafkaTLSEnable,
+			AdvanceDependValue: "true",
+			ToolTip:            "不填写时使用系统的 CA 证书",
+		},
+		{
+			KeyName:            KeyKafkaTLSCert,
+			ChooseOnly:         false,
+			Default:            "",
+			DefaultNoUse:       false,
+			Description:        "客户端证书路径(kafka_tls_cert)",
+			AdvanceDepend:      KeyKafkaTLSEnable,
+			AdvanceDependValue: "true",
+		},
+		{
+			KeyName:            KeyKafkaTLSKey,
+			ChooseOnly:         false,
+			Default:            "",
+			DefaultNoUse:       false,
+			Description:        "客户端私钥路径(kafka_tls_key)",
+			AdvanceDepend:      KeyKafkaTLSEnable,
+			AdvanceDependValue: "true",
+		},
+		{
+			KeyName:            KeyKafkaTLSInsecureSkipVerify,
+			Element:            Radio,
+			ChooseOnly:         true,
+			ChooseOptions:      []interface{}{"false", "true"},
+			Default:            "false",
+			DefaultNoUse:       false,
+			Description:        "跳过证书校验(kafka_tls_insecure_skip_verify)",
+			AdvanceDepend:      KeyKafkaTLSEnable,
+			AdvanceDependValue: "true",
+		},
+		OptionMultilinePreset,
+		OptionMultilinePattern,
+		OptionMultilineMatch,
+		OptionMultilineNegate,
+		OptionMultilineMaxLines,
+		OptionMultilineMaxBytes,
+		OptionMultilineFlushTimeout,
 		OptionDataSourceTag,
 	},
 	ModeRedis: {
@@ -1099,11 +1343,23 @@ var ModeKeyOptions = map[string][]Option{
 		{
 			KeyName:       KeySocketRule,
 			ChooseOnly:    true,
-			ChooseOptions: []interface{}{SocketRulePacket, SocketRuleLine, SocketRuleJson}, //SocketRuleHeadPattern,
+			ChooseOptions: []interface{}{SocketRulePacket, SocketRuleLine, SocketRuleJson, SocketRuleLengthPrefix}, //SocketRuleHeadPattern,
 			Default:       SocketRulePacket,
 			Advance:       true,
 			Description:   "获取方式(socket_rule)",
-			ToolTip:       "默认对socket内容按包获取, json仅对tcp有效",
+			ToolTip:       "默认对socket内容按包获取, json仅对tcp有效, 按长度前缀读取时每个消息之前为4字节大端序的长度, 适用于protobuf等二进制消息",
+		},
+		{
+			KeyName:            KeySocketMaxFrameSize,
+			ChooseOnly:         false,
+			Default:            "16777216",
+			DefaultNoUse:       false,
+			Element:            InputNumber,
+			Description:        "消息最大字节数(socket_max_frame_size)",
+			Advance:            true,
+			AdvanceDepend:      KeySocketRule,
+			AdvanceDependValue: SocketRuleLengthPrefix,
+			ToolTip:            "按长度前缀读取时单个消息的最大字节数, 超过时tcp连接会被关闭",
 		},
 		OptionEncoding,
 		//{
@@ -1143,6 +1399,13 @@ var ModeKeyOptions = map[string][]Option{
 			Advance:      true,
 			ToolTip:      "填0为关闭keep_alive",
 		},
+		OptionMultilinePreset,
+		OptionMultilinePattern,
+		OptionMultilineMatch,
+		OptionMultilineNegate,
+		OptionMultilineMaxLines,
+		OptionMultilineMaxBytes,
+		OptionMultilineFlushTimeout,
 		OptionDataSourceTag,
 	},
 	ModeHTTP: {
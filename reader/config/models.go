@@ -13,6 +13,8 @@ const (
 	KeyLogPath           = "log_path"
 	KeyMetaPath          = "meta_path"
 	KeyFileDone          = "file_done"
+	KeyCheckpointStore   = "checkpoint_store"
+	KeyCheckpointPath    = "checkpoint_path"
 	KeyMode              = "mode"
 	KeyBufSize           = "reader_buf_size"
 	KeyWhence            = "read_from"
@@ -26,6 +28,15 @@ const (
 	KeySkipFileFirstLine = "skip_first_line"
 	KeyReadSameInode     = "read_same_inode"
 
+	// 多行合并
+	KeyMultilinePattern      = "multiline_pattern"
+	KeyMultilineMatch        = "multiline_match"
+	KeyMultilineNegate       = "multiline_negate"
+	KeyMultilineMaxLines     = "multiline_max_lines"
+	KeyMultilineMaxBytes     = "multiline_max_bytes"
+	KeyMultilineFlushTimeout = "multiline_flush_timeout"
+	KeyMultilinePreset       = "multiline_preset"
+
 	// 忽略文件路径
 	KeyIgnoreLogPath = "ignore_log_path"
 
@@ -103,6 +114,18 @@ const (
 	KeyKafkaZookeeperTimeout = "kafka_zookeeper_timeout"
 	KeyKafkaMaxProcessTime   = "kafka_maxprocessing_time"
 
+	KeyKafkaBrokers               = "kafka_brokers"
+	KeyKafkaVersion               = "kafka_version"
+	KeyKafkaBalanceStrategy       = "kafka_balance_strategy"
+	KeyKafkaSASLMechanism         = "kafka_sasl_mechanism"
+	KeyKafkaSASLUsername          = "kafka_sasl_username"
+	KeyKafkaSASLPassword          = "kafka_sasl_password"
+	KeyKafkaTLSEnable             = "kafka_tls_enable"
+	KeyKafkaTLSCA                 = "kafka_tls_ca"
+	KeyKafkaTLSCert               = "kafka_tls_cert"
+	KeyKafkaTLSKey                = "kafka_tls_key"
+	KeyKafkaTLSInsecureSkipVerify = "kafka_tls_insecure_skip_verify"
+
 	KeyExecInterpreter   = "script_exec_interprepter"
 	KeyScriptCron        = "script_cron"
 	KeyScriptExecOnStart = "script_exec_onstart"
@@ -193,6 +216,8 @@ const (
 	SocketRuleJson        = "按json格式读取"
 	SocketRuleLine        = "按换行符读取"
 	SocketRuleHeadPattern = "按行首正则读取"
+	// 每个消息之前为 4 字节大端序的消息长度，用于传输 protobuf 等二进制消息
+	SocketRuleLengthPrefix = "按长度前缀读取"
 )
 
 // Constants for SNMP
@@ -252,6 +277,10 @@ const (
 	KeySocketRule            = "socket_rule"
 	KeySocketRuleHeadPattern = "head_pattern"
 
+	// 按长度前缀读取时单个消息的最大字节数，超过时 tcp 连接会被关闭，udp 数据包会被丢弃
+	KeySocketMaxFrameSize     = "socket_max_frame_size"
+	DefaultSocketMaxFrameSize = 16 * 1024 * 1024
+
 	// 最大并发连接数
 	// 仅用于 stream sockets (e.g. TCP).
 	// 0 (default) 为无限制.
@@ -307,6 +336,39 @@ const (
 	WhenceNewest = "newest"
 )
 
+// KeyMultilineMatch 的可选项
+const (
+	MultilineMatchHead     = "head"
+	MultilineMatchContinue = "continue"
+	MultilineMatchTail     = "tail"
+)
+
+// KeyMultilinePreset 的可选项
+const (
+	MultilinePresetJava   = "java"
+	MultilinePresetPython = "python"
+	MultilinePresetGo     = "go"
+)
+
+// KeyKafkaBalanceStrategy 的可选项
+const (
+	KafkaBalanceRange      = "range"
+	KafkaBalanceRoundRobin = "roundrobin"
+)
+
+// KeyKafkaSASLMechanism 的可选项
+const (
+	KafkaSASLPlain       = "PLAIN"
+	KafkaSASLSCRAMSHA256 = "SCRAM-SHA-256"
+	KafkaSASLSCRAMSHA512 = "SCRAM-SHA-512"
+)
+
+// KeyCheckpointStore 的可选项
+const (
+	CheckpointStoreFile = "file"
+	CheckpointStoreKV   = "kv"
+)
+
 const (
 	Loop = "loop"
 )
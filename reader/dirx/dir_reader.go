@@ -194,6 +194,23 @@ func (dr *dirReader) SyncMeta() string {
 	return dr.readcache
 }
 
+// dirPosition 为 dirReader 的读取位置，readcache 为已经读取但尚未发送的一行
+type dirPosition struct {
+	dr        *dirReader
+	pos       reader.Position
+	readcache string
+}
+
+func (p dirPosition) String() string {
+	return fmt.Sprint(p.pos)
+}
+
+func (dr *dirReader) position() dirPosition {
+	dr.readLock.Lock()
+	defer dr.readLock.Unlock()
+	return dirPosition{dr: dr, pos: dr.br.Position(), readcache: dr.readcache}
+}
+
 func (dr *dirReader) Close() error {
 	defer log.Warnf("Runner[%v] log path[%v] reader has closed", dr.runnerName, dr.originalPath)
 	err := dr.br.Close()
@@ -226,6 +243,7 @@ type dirReaders struct {
 	// 以下为传入参数
 	meta   *reader.Meta
 	expire time.Duration
+	ack    bool // 确认模式下读取位置由 Commit 保存
 }
 
 func newDirReaders(meta *reader.Meta, expire time.Duration, cachedLines map[string]string) *dirReaders {
@@ -274,7 +292,7 @@ type newReaderOptions struct {
 func (drs *dirReaders) NewReader(opts newReaderOptions, notFirstTime bool) (*dirReader, error) {
 	rpath := strings.Replace(opts.LogPath, string(os.PathSeparator), "_", -1)
 	subMetaPath := filepath.Join(opts.Meta.Dir, rpath)
-	subMeta, err := reader.NewMeta(subMetaPath, subMetaPath, opts.LogPath, ModeDir, opts.Meta.TagFile, reader.DefautFileRetention)
+	subMeta, err := opts.Meta.NewSubMeta(subMetaPath, opts.LogPath, ModeDir)
 	if err != nil {
 		return nil, fmt.Errorf("new meta: %v", err)
 	}
@@ -297,6 +315,12 @@ func (drs *dirReaders) NewReader(opts newReaderOptions, notFirstTime bool) (*dir
 		fr.Close()
 		return nil, fmt.Errorf("new buffer reader: %v", err)
 	}
+	if drs.ack {
+		if err = br.EnableAck(); err != nil {
+			br.Close()
+			return nil, fmt.Errorf("enable ack: %v", err)
+		}
+	}
 
 	dr := &dirReader{
 		status:       StatusInit,
@@ -380,6 +404,43 @@ func (drs *dirReaders) SyncMeta() ([]byte, error) {
 	return data, nil
 }
 
+func (drs *dirReaders) Position() []dirPosition {
+	var positions []dirPosition
+	for _, dr := range drs.getReaders() {
+		positions = append(positions, dr.position())
+	}
+	return positions
+}
+
+// Commit 保存各个目录的读取位置，已经过期回收的目录不再保存，返回需要保存的缓存行
+func (drs *dirReaders) Commit(positions []dirPosition) ([]byte, error) {
+	for _, p := range positions {
+		drs.lock.RLock()
+		active := drs.readers[p.dr.logPath] == p.dr
+		drs.lock.RUnlock()
+		if !active {
+			continue
+		}
+		if err := p.dr.br.Commit(p.pos); err != nil {
+			return nil, err
+		}
+		drs.lock.Lock()
+		if len(p.readcache) > 0 {
+			drs.cachedLines[p.dr.logPath] = p.readcache
+		} else {
+			delete(drs.cachedLines, p.dr.logPath)
+		}
+		drs.lock.Unlock()
+	}
+	drs.lock.RLock()
+	data, err := jsoniter.Marshal(drs.cachedLines)
+	drs.lock.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("marshal cached lines: %v", err)
+	}
+	return data, nil
+}
+
 func (drs *dirReaders) Close() {
 	var wg sync.WaitGroup
 	for _, dr := range drs.getReaders() {
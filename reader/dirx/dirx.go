@@ -22,10 +22,12 @@ import (
 )
 
 var (
-	_ reader.DaemonReader = &Reader{}
-	_ reader.StatsReader  = &Reader{}
-	_ reader.Reader       = &Reader{}
-	_ Resetable           = &Reader{}
+	_ reader.AckReader       = &Reader{}
+	_ reader.DaemonReader    = &Reader{}
+	_ reader.StatsReader     = &Reader{}
+	_ reader.MultilineSetter = &Reader{}
+	_ reader.Reader          = &Reader{}
+	_ Resetable              = &Reader{}
 )
 
 func init() {
@@ -51,6 +53,7 @@ type Reader struct {
 	statsLock sync.RWMutex
 
 	headRegexp  *regexp.Regexp
+	multiline   *reader.MultilineConfig
 	currentFile string
 	dirReaders  *dirReaders
 
@@ -187,6 +190,11 @@ func (r *Reader) SetMode(mode string, v interface{}) error {
 	return nil
 }
 
+func (r *Reader) SetMultiline(c *reader.MultilineConfig) error {
+	r.multiline = c
+	return nil
+}
+
 func (r *Reader) setStatsError(err string) {
 	r.statsLock.Lock()
 	defer r.statsLock.Unlock()
@@ -296,6 +304,13 @@ func (r *Reader) statLogPath() {
 				r.setStatsError(errMsg)
 			}
 		}
+		if r.multiline != nil {
+			if err = dr.br.SetMultiline(r.multiline); err != nil {
+				errMsg := fmt.Sprintf("Runner[%v] set multiline for log path %q failed: %v", r.meta.RunnerName, logPath, err)
+				log.Error(errMsg)
+				r.setStatsError(errMsg)
+			}
+		}
 		newPaths = append(newPaths, logPath)
 
 		if r.hasStopped() || r.isStopping() {
@@ -394,6 +409,9 @@ func (r *Reader) Status() StatsInfo {
 
 // SyncMeta 从队列取数据时同步队列，作用在于保证数据不重复
 func (r *Reader) SyncMeta() {
+	if r.dirReaders.ack {
+		return
+	}
 	data, err := r.dirReaders.SyncMeta()
 	if err != nil {
 		log.Errorf("Runner[%v] reader %q sync meta failed: %v", r.meta.RunnerName, r.Name(), err)
@@ -411,6 +429,34 @@ func (r *Reader) SyncMeta() {
 	}
 }
 
+func (r *Reader) EnableAck() error {
+	r.dirReaders.ack = true
+	return nil
+}
+
+func (r *Reader) Position() reader.Position {
+	return r.dirReaders.Position()
+}
+
+func (r *Reader) Commit(pos reader.Position) error {
+	positions, ok := pos.([]dirPosition)
+	if !ok {
+		return fmt.Errorf("invalid position type %T", pos)
+	}
+	data, err := r.dirReaders.Commit(positions)
+	if err != nil {
+		return err
+	}
+	if err = r.meta.WriteBuf(data, 0, 0, len(data)); err != nil {
+		return err
+	}
+
+	if IsSubMetaExpire(r.submetaExpire, r.expire) {
+		r.meta.CleanExpiredSubMetas(r.submetaExpire)
+	}
+	return nil
+}
+
 func (r *Reader) Close() error {
 	if !atomic.CompareAndSwapInt32(&r.status, StatusRunning, StatusStopping) {
 		log.Warnf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
@@ -0,0 +1,285 @@
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/reader/config"
+)
+
+const (
+	defaultKafkaVersion = "0.10.2.0"
+	groupRetryInterval  = 5 * time.Second
+)
+
+// groupConsumer 使用 broker 协调的 consumer group(kafka >= 0.10.2)消费，不依赖 zookeeper
+// offset 只在 markOffsets 时标记，由 sarama 定期提交到 broker
+type groupConsumer struct {
+	runnerName string
+	group      sarama.ConsumerGroup
+	topics     []string
+	messages   chan *sarama.ConsumerMessage
+	errors     chan error
+	cancel     context.CancelFunc
+	done       chan struct{}
+	// onAssign 在分配到新的分区时调用，用于清理之前的读取位置
+	onAssign func(claims map[string][]int32)
+
+	mux     sync.Mutex
+	session sarama.ConsumerGroupSession
+	claims  map[string]map[int32]*claimOffset
+}
+
+// claimOffset 记录分区的起始 offset 和最新的 high water mark，用于计算 lag
+type claimOffset struct {
+	initial       int64
+	highWaterMark int64
+}
+
+func newGroupConfig(c conf.MapConf, whence string, maxProcessingTime time.Duration) (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	version, _ := c.GetStringOr(KeyKafkaVersion, defaultKafkaVersion)
+	v, err := sarama.ParseKafkaVersion(version)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s %s err %v", KeyKafkaVersion, version, err)
+	}
+	if !v.IsAtLeast(sarama.V0_10_2_0) {
+		return nil, fmt.Errorf("%s %s is too old, consumer group requires kafka >= 0.10.2", KeyKafkaVersion, version)
+	}
+	config.Version = v
+	config.Consumer.Return.Errors = true
+	config.Consumer.MaxProcessingTime = maxProcessingTime
+	switch strings.ToLower(whence) {
+	case WhenceNewest:
+		config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	default:
+		config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	}
+
+	strategy, _ := c.GetStringOr(KeyKafkaBalanceStrategy, KafkaBalanceRange)
+	switch strategy {
+	case KafkaBalanceRange:
+		config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRange
+	case KafkaBalanceRoundRobin:
+		config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	default:
+		// sarama 的 consumer group 只实现了 eager rebalance，不支持 cooperative-sticky 等增量 rebalance 策略
+		return nil, fmt.Errorf("%s %s is not supported, only %s and %s are supported", KeyKafkaBalanceStrategy, strategy, KafkaBalanceRange, KafkaBalanceRoundRobin)
+	}
+
+	username, _ := c.GetStringOr(KeyKafkaSASLUsername, "")
+	if username != "" {
+		mechanism, _ := c.GetStringOr(KeyKafkaSASLMechanism, KafkaSASLPlain)
+		switch m := sarama.SASLMechanism(strings.ToUpper(mechanism)); m {
+		case sarama.SASLTypePlaintext:
+			config.Net.SASL.Mechanism = m
+		case sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512:
+			config.Net.SASL.Mechanism = m
+			config.Net.SASL.SCRAMClientGeneratorFunc = newSCRAMClientGenerator(m)
+		default:
+			return nil, fmt.Errorf("%s %s is not supported, only %s, %s and %s are supported", KeyKafkaSASLMechanism, mechanism,
+				KafkaSASLPlain, KafkaSASLSCRAMSHA256, KafkaSASLSCRAMSHA512)
+		}
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Handshake = true
+		config.Net.SASL.User = username
+		config.Net.SASL.Password, _ = c.GetStringOr(KeyKafkaSASLPassword, "")
+	}
+
+	tlsEnable, _ := c.GetBoolOr(KeyKafkaTLSEnable, false)
+	if tlsEnable {
+		ca, _ := c.GetStringOr(KeyKafkaTLSCA, "")
+		cert, _ := c.GetStringOr(KeyKafkaTLSCert, "")
+		key, _ := c.GetStringOr(KeyKafkaTLSKey, "")
+		insecure, _ := c.GetBoolOr(KeyKafkaTLSInsecureSkipVerify, false)
+		tlsConfig, err := newTLSConfig(ca, cert, key, insecure)
+		if err != nil {
+			return nil, err
+		}
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+	return config, config.Validate()
+}
+
+func newTLSConfig(ca, cert, key string, insecure bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+	if ca != "" {
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, fmt.Errorf("read %s error %v", KeyKafkaTLSCA, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s %s contains no valid certificate", KeyKafkaTLSCA, ca)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cert != "" || key != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("load %s and %s error %v", KeyKafkaTLSCert, KeyKafkaTLSKey, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+	return tlsConfig, nil
+}
+
+func newGroupConsumer(runnerName string, brokers []string, groupID string, topics []string, config *sarama.Config, onAssign func(map[string][]int32)) (*groupConsumer, error) {
+	group, err := sarama.NewConsumerGroup(brokers, groupID, config)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	g := &groupConsumer{
+		runnerName: runnerName,
+		group:      group,
+		topics:     topics,
+		messages:   make(chan *sarama.ConsumerMessage),
+		errors:     make(chan error, 100),
+		cancel:     cancel,
+		done:       make(chan struct{}),
+		onAssign:   onAssign,
+	}
+	go g.consume(ctx)
+	go func() {
+		for err := range group.Errors() {
+			g.sendError(err)
+		}
+	}()
+	return g, nil
+}
+
+// consume 每次 rebalance 后 Consume 返回，需要重新加入 group
+func (g *groupConsumer) consume(ctx context.Context) {
+	defer close(g.done)
+	for {
+		err := g.group.Consume(ctx, g.topics, g)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			g.sendError(err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(groupRetryInterval):
+			}
+		}
+	}
+}
+
+func (g *groupConsumer) sendError(err error) {
+	select {
+	case g.errors <- err:
+	default:
+		log.Errorf("Runner[%v] kafka consumer group error %v", g.runnerName, err)
+	}
+}
+
+func (g *groupConsumer) Setup(session sarama.ConsumerGroupSession) error {
+	log.Infof("Runner[%v] kafka consumer group generation %v claims %v", g.runnerName, session.GenerationID(), session.Claims())
+	if g.onAssign != nil {
+		g.onAssign(session.Claims())
+	}
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	g.session = session
+	g.claims = make(map[string]map[int32]*claimOffset)
+	return nil
+}
+
+func (g *groupConsumer) Cleanup(session sarama.ConsumerGroupSession) error {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	g.session = nil
+	g.claims = nil
+	return nil
+}
+
+func (g *groupConsumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	g.mux.Lock()
+	if g.claims != nil {
+		if g.claims[claim.Topic()] == nil {
+			g.claims[claim.Topic()] = make(map[int32]*claimOffset)
+		}
+		g.claims[claim.Topic()][claim.Partition()] = &claimOffset{initial: claim.InitialOffset(), highWaterMark: claim.HighWaterMarkOffset()}
+	}
+	g.mux.Unlock()
+	for msg := range claim.Messages() {
+		g.mux.Lock()
+		if co, ok := g.claims[msg.Topic][msg.Partition]; ok {
+			co.highWaterMark = claim.HighWaterMarkOffset()
+		}
+		g.mux.Unlock()
+		select {
+		case g.messages <- msg:
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+	return nil
+}
+
+// markOffsets 标记已经读取并发送成功的 offset，不属于当前 session 的分区会被忽略
+func (g *groupConsumer) markOffsets(offsets map[string]map[int32]int64) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	if g.session == nil {
+		return
+	}
+	for topic, partOffset := range offsets {
+		for partition, offset := range partOffset {
+			g.session.MarkOffset(topic, partition, offset+1, "")
+		}
+	}
+}
+
+// lag 返回当前分配到的每个分区的 lag，key 为 topic:partition
+func (g *groupConsumer) lag(currentOffsets map[string]map[int32]int64) map[string]int64 {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	lags := make(map[string]int64)
+	for topic, partitions := range g.claims {
+		for partition, co := range partitions {
+			next := co.initial
+			if offset, ok := currentOffsets[topic][partition]; ok && offset+1 > next {
+				next = offset + 1
+			}
+			lag := co.highWaterMark - next
+			if lag < 0 || next < 0 {
+				lag = 0
+			}
+			lags[topic+":"+strconv.Itoa(int(partition))] = lag
+		}
+	}
+	return lags
+}
+
+// Close 等待当前 session 退出并提交已经标记的 offset 后离开 group
+func (g *groupConsumer) Close() error {
+	g.cancel()
+	var err error
+	select {
+	case <-g.done:
+	case <-time.After(groupRetryInterval):
+		err = errors.New("wait kafka consumer group session exit timeout")
+	}
+	if cerr := g.group.Close(); cerr != nil {
+		err = cerr
+	}
+	return err
+}
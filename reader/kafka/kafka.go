@@ -20,6 +20,7 @@ import (
 )
 
 var (
+	_ reader.AckReader   = &Reader{}
 	_ reader.StatsReader = &Reader{}
 	_ reader.LagReader   = &Reader{}
 	_ reader.Reader      = &Reader{}
@@ -39,13 +40,18 @@ type Reader struct {
 	errChan  <-chan error
 
 	Consumer       *consumergroup.ConsumerGroup
+	group          *groupConsumer             // 填写 kafka_brokers 时使用 broker 协调的 consumer group
 	currentOffsets map[string]map[int32]int64 // <topic,<partition,offset>>
+	// 确认模式下只标记已经确认送达的 offset
+	ack          bool
+	ackedOffsets map[string]map[int32]int64
 
 	stats     StatsInfo
 	statsLock *sync.RWMutex
 
 	ConsumerGroup    string
 	Topics           []string
+	Brokers          []string
 	ZookeeperPeers   []string
 	ZookeeperChroot  string
 	ZookeeperTimeout time.Duration
@@ -63,35 +69,51 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 	if err != nil {
 		return nil, err
 	}
-	zookeeperTimeout, _ := conf.GetIntOr(KeyKafkaZookeeperTimeout, 1)
 	maxProcessingTime, _ := conf.GetStringOr(KeyKafkaMaxProcessTime, "1s")
 	maxProcessingTimeDur, err := time.ParseDuration(maxProcessingTime)
 	if err != nil {
 		return nil, fmt.Errorf("parse %s %s err %v", KeyKafkaMaxProcessTime, maxProcessingTime, err)
 	}
 
-	zookeeper, err := conf.GetStringList(KeyKafkaZookeeper)
-	if err != nil {
-		return nil, err
-	}
-	zkchroot, _ := conf.GetStringOr(KeyKafkaZookeeperChroot, "")
 	offsets := make(map[string]map[int32]int64)
 	for _, v := range topics {
 		offsets[v] = make(map[int32]int64)
 	}
 	sarama.Logger = log.Std
 	kr := &Reader{
-		meta:             meta,
-		ConsumerGroup:    consumerGroup,
-		ZookeeperPeers:   zookeeper,
-		ZookeeperTimeout: time.Duration(zookeeperTimeout) * time.Second,
-		ZookeeperChroot:  zkchroot,
-		Topics:           topics,
-		Whence:           whence,
-		lock:             new(sync.Mutex),
-		statsLock:        new(sync.RWMutex),
-		currentOffsets:   offsets,
+		meta:           meta,
+		ConsumerGroup:  consumerGroup,
+		Topics:         topics,
+		Whence:         whence,
+		lock:           new(sync.Mutex),
+		statsLock:      new(sync.RWMutex),
+		currentOffsets: offsets,
+	}
+
+	kr.Brokers, _ = conf.GetStringListOr(KeyKafkaBrokers, nil)
+	if len(kr.Brokers) > 0 {
+		config, err := newGroupConfig(conf, whence, maxProcessingTimeDur)
+		if err != nil {
+			return nil, err
+		}
+		kr.group, err = newGroupConsumer(meta.RunnerName, kr.Brokers, consumerGroup, topics, config, kr.resetOffsets)
+		if err != nil {
+			err = fmt.Errorf("runner[%v] kafka reader join group err: %v", kr.meta.RunnerName, err)
+			log.Error(err)
+			return nil, err
+		}
+		kr.readChan = kr.group.messages
+		kr.errChan = kr.group.errors
+		return kr, nil
+	}
+
+	zookeeperTimeout, _ := conf.GetIntOr(KeyKafkaZookeeperTimeout, 1)
+	kr.ZookeeperTimeout = time.Duration(zookeeperTimeout) * time.Second
+	kr.ZookeeperPeers, err = conf.GetStringList(KeyKafkaZookeeper)
+	if err != nil {
+		return nil, err
 	}
+	kr.ZookeeperChroot, _ = conf.GetStringOr(KeyKafkaZookeeperChroot, "")
 
 	config := consumergroup.NewConfig()
 	config.Zookeeper.Chroot = kr.ZookeeperChroot
@@ -170,6 +192,7 @@ func (r *Reader) Source() string {
 	return fmt.Sprintf("[%s],[%s]", strings.Join(r.Topics, ","), r.ConsumerGroup)
 }
 
+// ReadLine 每次返回一条 kafka 消息，消息内容原样返回，不按换行切分，可以使用 protobuf 等二进制格式的 parser 解析
 func (r *Reader) ReadLine() (string, error) {
 	timer := time.NewTimer(time.Second)
 	defer timer.Stop()
@@ -212,11 +235,28 @@ func (r *Reader) Status() StatsInfo {
 }
 
 func (r *Reader) Start() error {
-	go r.startMarkOffset()
+	if !atomic.CompareAndSwapInt32(&r.status, StatusInit, StatusRunning) {
+		log.Warnf("Runner[%v] %q daemon has already started and is running", r.meta.RunnerName, r.Name())
+		return nil
+	}
+	// consumer group 只在 SyncMeta 时标记 offset，发送成功之前不提交
+	if r.group == nil {
+		go r.startMarkOffset()
+	}
 	return nil
 }
 
 func (r *Reader) Lag() (*LagInfo, error) {
+	if r.group != nil {
+		r.statsLock.RLock()
+		details := r.group.lag(r.currentOffsets)
+		r.statsLock.RUnlock()
+		rl := &LagInfo{SizeUnit: "records", Details: details}
+		for _, v := range details {
+			rl.Size += v
+		}
+		return rl, nil
+	}
 	if r.Consumer == nil {
 		return nil, errors.New("kafka consumer is closed")
 	}
@@ -247,7 +287,17 @@ func (r *Reader) SyncMeta() {
 func (r *Reader) markOffset() {
 	r.lock.Lock()
 	defer r.lock.Unlock()
-	for topic, partOffset := range r.currentOffsets {
+	offsets := r.currentOffsets
+	if r.ack {
+		offsets = r.ackedOffsets
+	}
+	if r.group != nil {
+		r.statsLock.RLock()
+		r.group.markOffsets(offsets)
+		r.statsLock.RUnlock()
+		return
+	}
+	for topic, partOffset := range offsets {
 		if partOffset == nil {
 			continue
 		}
@@ -261,6 +311,36 @@ func (r *Reader) markOffset() {
 	}
 }
 
+func (r *Reader) EnableAck() error {
+	r.ack = true
+	return nil
+}
+
+func (r *Reader) Position() reader.Position {
+	r.statsLock.RLock()
+	defer r.statsLock.RUnlock()
+	offsets := make(map[string]map[int32]int64, len(r.currentOffsets))
+	for topic, partOffset := range r.currentOffsets {
+		offsets[topic] = make(map[int32]int64, len(partOffset))
+		for partition, offset := range partOffset {
+			offsets[topic][partition] = offset
+		}
+	}
+	return offsets
+}
+
+func (r *Reader) Commit(pos reader.Position) error {
+	offsets, ok := pos.(map[string]map[int32]int64)
+	if !ok {
+		return fmt.Errorf("invalid position type %T", pos)
+	}
+	r.lock.Lock()
+	r.ackedOffsets = offsets
+	r.lock.Unlock()
+	r.markOffset()
+	return nil
+}
+
 func (r *Reader) Close() error {
 	if !atomic.CompareAndSwapInt32(&r.status, StatusRunning, StatusStopping) {
 		log.Warnf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
@@ -268,10 +348,24 @@ func (r *Reader) Close() error {
 	}
 	log.Debugf("Runner[%v] %q daemon is stopping", r.meta.RunnerName, r.Name())
 
-	r.lock.Lock()
-	defer r.lock.Unlock()
-	r.markOffset()
-	err := r.Consumer.Close()
+	var err error
+	if r.group != nil {
+		err = r.group.Close()
+	} else {
+		r.markOffset()
+		err = r.Consumer.Close()
+	}
 	atomic.StoreInt32(&r.status, StatusStopped)
 	return err
 }
+
+// resetOffsets 分配到新的分区后，之前 session 中读取的位置不再有效，从 broker 提交的 offset 重新读取
+func (r *Reader) resetOffsets(claims map[string][]int32) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	for topic, partitions := range claims {
+		for _, partition := range partitions {
+			delete(r.currentOffsets[topic], partition)
+		}
+	}
+}
@@ -1,11 +1,13 @@
 package kafka
 
 import (
+	"context"
 	"os"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/Shopify/sarama"
 	"github.com/qiniu/logkit/conf"
 	"github.com/stretchr/testify/assert"
 
@@ -39,3 +41,146 @@ func TestKafkaReader(t *testing.T) {
 
 	assert.Equal(t, StatsInfo{}, er.Status())
 }
+
+func TestNewGroupConfig(t *testing.T) {
+	c := conf.MapConf{
+		KeyKafkaVersion:         "1.0.0",
+		KeyKafkaBalanceStrategy: KafkaBalanceRoundRobin,
+		KeyKafkaSASLUsername:    "user",
+		KeyKafkaSASLPassword:    "pass",
+		KeyKafkaTLSEnable:       "true",
+	}
+	config, err := newGroupConfig(c, WhenceNewest, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, sarama.V1_0_0_0, config.Version)
+	assert.Equal(t, sarama.OffsetNewest, config.Consumer.Offsets.Initial)
+	assert.Equal(t, sarama.BalanceStrategyRoundRobin, config.Consumer.Group.Rebalance.Strategy)
+	assert.True(t, config.Net.SASL.Enable)
+	assert.Equal(t, "pass", config.Net.SASL.Password)
+	assert.True(t, config.Net.TLS.Enable)
+
+	config, err = newGroupConfig(conf.MapConf{}, WhenceOldest, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, sarama.V0_10_2_0, config.Version)
+	assert.Equal(t, sarama.BalanceStrategyRange, config.Consumer.Group.Rebalance.Strategy)
+	assert.False(t, config.Net.SASL.Enable)
+	assert.False(t, config.Net.TLS.Enable)
+
+	config, err = newGroupConfig(conf.MapConf{
+		KeyKafkaSASLUsername:  "user",
+		KeyKafkaSASLPassword:  "pass",
+		KeyKafkaSASLMechanism: "scram-sha-512",
+	}, WhenceOldest, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, sarama.SASLTypeSCRAMSHA512, config.Net.SASL.Mechanism)
+	assert.NotNil(t, config.Net.SASL.SCRAMClientGeneratorFunc)
+
+	for _, c := range []conf.MapConf{
+		{KeyKafkaVersion: "0.9.0.0"},
+		{KeyKafkaBalanceStrategy: "unknown"},
+		{KeyKafkaBalanceStrategy: "cooperative-sticky"},
+		{KeyKafkaSASLUsername: "user", KeyKafkaSASLPassword: "pass", KeyKafkaSASLMechanism: "GSSAPI"},
+		{KeyKafkaTLSEnable: "true", KeyKafkaTLSCA: "/not/exist"},
+	} {
+		_, err = newGroupConfig(c, WhenceOldest, time.Second)
+		assert.Error(t, err, c)
+	}
+}
+
+type mockSession struct {
+	sarama.ConsumerGroupSession
+	marked map[string]map[int32]int64
+}
+
+func (s *mockSession) MarkOffset(topic string, partition int32, offset int64, _ string) {
+	if s.marked[topic] == nil {
+		s.marked[topic] = make(map[int32]int64)
+	}
+	s.marked[topic][partition] = offset
+}
+
+func (s *mockSession) Claims() map[string][]int32 {
+	return map[string][]int32{"topic1": {0}}
+}
+
+func (s *mockSession) GenerationID() int32 {
+	return 1
+}
+
+func (s *mockSession) Context() context.Context {
+	return context.Background()
+}
+
+type mockClaim struct {
+	sarama.ConsumerGroupClaim
+	partition int32
+	messages  chan *sarama.ConsumerMessage
+}
+
+func (c *mockClaim) Topic() string                            { return "topic1" }
+func (c *mockClaim) Partition() int32                         { return c.partition }
+func (c *mockClaim) InitialOffset() int64                     { return 10 }
+func (c *mockClaim) HighWaterMarkOffset() int64               { return 20 }
+func (c *mockClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func TestKafkaGroupReader(t *testing.T) {
+	logkitConf := conf.MapConf{
+		KeyMetaPath: MetaDir,
+		KeyFileDone: MetaDir,
+		KeyMode:     ModeKafka,
+	}
+	meta, err := reader.NewMetaWithConf(logkitConf)
+	assert.NoError(t, err)
+	defer os.RemoveAll(MetaDir)
+	er := &Reader{
+		meta:           meta,
+		ConsumerGroup:  "group1",
+		Topics:         []string{"topic1"},
+		lock:           new(sync.Mutex),
+		statsLock:      new(sync.RWMutex),
+		currentOffsets: map[string]map[int32]int64{"topic1": {0: 5}},
+	}
+	g := &groupConsumer{messages: make(chan *sarama.ConsumerMessage), onAssign: er.resetOffsets}
+	er.group = g
+	er.readChan = g.messages
+
+	// 没有 session 时不标记 offset
+	er.SyncMeta()
+	session := &mockSession{marked: make(map[string]map[int32]int64)}
+	assert.NoError(t, g.Setup(session))
+	assert.Equal(t, map[string]map[int32]int64{"topic1": {}}, er.currentOffsets)
+
+	claims := []*mockClaim{
+		{partition: 0, messages: make(chan *sarama.ConsumerMessage, 1)},
+		{partition: 1, messages: make(chan *sarama.ConsumerMessage)},
+	}
+	claims[0].messages <- &sarama.ConsumerMessage{Topic: "topic1", Partition: 0, Offset: 10, Value: []byte("abc")}
+	close(claims[0].messages)
+	done := make(chan struct{})
+	for _, claim := range claims {
+		go func(claim *mockClaim) {
+			assert.NoError(t, g.ConsumeClaim(session, claim))
+			done <- struct{}{}
+		}(claim)
+	}
+	line, err := er.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", line)
+	<-done
+
+	lag, err := er.Lag()
+	assert.NoError(t, err)
+	assert.Equal(t, &LagInfo{Size: 19, SizeUnit: "records", Details: map[string]int64{"topic1:0": 9, "topic1:1": 10}}, lag)
+
+	// 只在 SyncMeta 时标记下一条要读取的 offset
+	assert.Empty(t, session.marked)
+	er.SyncMeta()
+	assert.Equal(t, map[string]map[int32]int64{"topic1": {0: 11}}, session.marked)
+
+	close(claims[1].messages)
+	<-done
+	assert.NoError(t, g.Cleanup(session))
+	lag, err = er.Lag()
+	assert.NoError(t, err)
+	assert.Equal(t, &LagInfo{SizeUnit: "records", Details: map[string]int64{}}, lag)
+}
@@ -0,0 +1,52 @@
+package kafka
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSCRAMClient(t *testing.T) {
+	// RFC 7677 第 3 节的 SCRAM-SHA-256 示例
+	const serverFirst = "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"
+	c := &scramClient{hashFn: sha256.New, nonce: "rOprNGfwEbeRWgbNEkqO"}
+	assert.NoError(t, c.Begin("user", "pencil", ""))
+	msg, err := c.Step("")
+	assert.NoError(t, err)
+	assert.Equal(t, "n,,n=user,r=rOprNGfwEbeRWgbNEkqO", msg)
+	msg, err = c.Step(serverFirst)
+	assert.NoError(t, err)
+	assert.Equal(t, "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=", msg)
+	assert.False(t, c.Done())
+	msg, err = c.Step("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=")
+	assert.NoError(t, err)
+	assert.Equal(t, "", msg)
+	assert.True(t, c.Done())
+
+	// 服务端签名不对
+	c = &scramClient{hashFn: sha256.New, nonce: "rOprNGfwEbeRWgbNEkqO"}
+	assert.NoError(t, c.Begin("user", "pencil", ""))
+	c.Step("")
+	_, err = c.Step(serverFirst)
+	assert.NoError(t, err)
+	_, err = c.Step("v=AAAA")
+	assert.Error(t, err)
+	assert.False(t, c.Done())
+
+	// 服务端 nonce 不是客户端 nonce 的扩展
+	c = &scramClient{hashFn: sha256.New, nonce: "rOprNGfwEbeRWgbNEkqO"}
+	assert.NoError(t, c.Begin("user", "pencil", ""))
+	c.Step("")
+	_, err = c.Step("r=other,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	assert.Error(t, err)
+
+	// 用户名和 authzid 需要转义，随机生成 nonce
+	c = newSCRAMClientGenerator(sarama.SASLTypeSCRAMSHA512)().(*scramClient)
+	assert.NoError(t, c.Begin("a=b,c", "pass", "admin"))
+	msg, err = c.Step("")
+	assert.NoError(t, err)
+	assert.Equal(t, "n,a=admin,n=a=3Db=2Cc,r="+c.nonce, msg)
+	assert.NotEmpty(t, c.nonce)
+}
@@ -1,10 +1,9 @@
 package reader
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"math/rand"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -18,7 +17,6 @@ import (
 
 	"github.com/qiniu/logkit/conf"
 	. "github.com/qiniu/logkit/reader/config"
-	"github.com/qiniu/logkit/utils"
 	. "github.com/qiniu/logkit/utils/models"
 	utilsos "github.com/qiniu/logkit/utils/os"
 )
@@ -31,6 +29,7 @@ const (
 	bufFilePath       = "buf.dat"
 	lineCacheFilePath = "cache.dat"
 	statisticFileName = "statistic.meta"
+	doneInodeFileName = "done_inode.meta"
 	doneFileRetention = "donefile_retention"
 	FtSaveLogPath     = "ft_log" // ft log 在 meta 中的文件夹名字
 )
@@ -74,6 +73,7 @@ type Meta struct {
 	ftSaveLogPath     string                 // 记录 ft_sender 日志信息
 	RunnerName        string
 	extrainfo         map[string]string
+	store             CheckpointStore // 保存读取进度，为空时保存为 meta 目录下的文件
 
 	subMetaLock        sync.RWMutex
 	subMetas           map[string]*Meta // 对于 tailx 和 dirx 模式的情况会有嵌套的 meta
@@ -168,6 +168,23 @@ func NewMetaWithConf(conf conf.MapConf) (meta *Meta, err error) {
 		log.Warnf("Runner[%v] %s - newMeta failed, err:%v", runnerName, metaPath, err)
 		return
 	}
+	storeType, _ := conf.GetStringOr(KeyCheckpointStore, CheckpointStoreFile)
+	switch storeType {
+	case CheckpointStoreFile:
+	case CheckpointStoreKV:
+		checkpointPath, _ := conf.GetStringOr(KeyCheckpointPath, filepath.Join(meta.Dir, checkpointDBName))
+		store, err := openKVCheckpointStore(checkpointPath)
+		if err != nil {
+			log.Errorf("Runner[%v] open checkpoint store %v failed, err:%v", runnerName, checkpointPath, err)
+			return nil, err
+		}
+		if err = meta.SetCheckpointStore(store); err != nil {
+			log.Errorf("Runner[%v] migrate meta %v to checkpoint store %v failed, err:%v", runnerName, meta.Dir, checkpointPath, err)
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown %v %q", KeyCheckpointStore, storeType)
+	}
 	extrainfo, _ := conf.GetBoolOr(ExtraInfo, false)
 	if extrainfo {
 		meta.extrainfo = utilsos.GetExtraInfo()
@@ -184,6 +201,34 @@ func NewMetaWithConf(conf conf.MapConf) (meta *Meta, err error) {
 	return
 }
 
+// SetCheckpointStore 设置保存读取进度的 store，meta 目录下已有的进度文件会被导入到新的 store 中
+func (m *Meta) SetCheckpointStore(store CheckpointStore) error {
+	if err := migrateToStore(store, m.Dir); err != nil {
+		return err
+	}
+	m.store = store
+	return nil
+}
+
+func (m *Meta) checkpointStore() CheckpointStore {
+	if m.store == nil {
+		return fileCheckpointStore{}
+	}
+	return m.store
+}
+
+// NewSubMeta 创建 tailx 和 dirx 模式下的 submeta，submeta 与父 meta 使用同一个 store
+func (m *Meta) NewSubMeta(metadir, logpath, mode string) (*Meta, error) {
+	subMeta, err := NewMeta(metadir, metadir, logpath, mode, m.TagFile, DefautFileRetention)
+	if err != nil {
+		return nil, err
+	}
+	if err = subMeta.SetCheckpointStore(m.checkpointStore()); err != nil {
+		return nil, err
+	}
+	return subMeta, nil
+}
+
 func (m *Meta) AddSubMeta(key string, meta *Meta) error {
 	m.subMetaLock.Lock()
 	defer m.subMetaLock.Unlock()
@@ -210,25 +255,23 @@ const (
 	maximumSubMetaCleanNumOneTime = 5
 )
 
-func hasSubMetaExpired(path string, expire time.Duration) bool {
+func (m *Meta) hasSubMetaExpired(path string, expire time.Duration) bool {
 	// 为防止用户上层设置不准确导致误删 submeta 重复处理数据，设定一个最小阈值
 	if expire < minimumSubMetaExpire {
 		expire = minimumSubMetaExpire
 	}
 
-	// 只有存在 file.meta 文件的子目录是 submeta
+	// 只有存在 file.meta 的子目录是 submeta
 	fileMetaPath := filepath.Join(path, metaFileName)
-	if !utils.IsExist(fileMetaPath) {
-		return false
-	}
-
-	fi, err := os.Stat(fileMetaPath)
+	modTime, err := m.checkpointStore().ModTime(fileMetaPath)
 	if err != nil {
-		log.Errorf("Failed to stat file %q: %v", fileMetaPath, err)
+		if !os.IsNotExist(err) {
+			log.Errorf("Failed to stat file %q: %v", fileMetaPath, err)
+		}
 		return false
 	}
 
-	return fi.ModTime().Add(expire).Before(time.Now())
+	return modTime.Add(expire).Before(time.Now())
 }
 
 // CheckExpiredSubMetas 仅用于轮询收集所有过期的 submeta，清理操作应通过调用 CleanExpiredSubMetas 方法完成。
@@ -243,7 +286,7 @@ func (m *Meta) CheckExpiredSubMetas(expire time.Duration) {
 			return nil
 		}
 
-		if hasSubMetaExpired(path, expire) {
+		if m.hasSubMetaExpired(path, expire) {
 			m.subMetaExpiredLock.Lock()
 			m.subMetaExpired[path] = true
 			m.subMetaExpiredLock.Unlock()
@@ -268,9 +311,12 @@ func (m *Meta) CleanExpiredSubMetas(expire time.Duration) {
 		}
 
 		// 二次确认 submeta 目录在删除前的一刻仍旧是过期状态才执行删除操作
-		if hasSubMetaExpired(path, expire) {
+		if m.hasSubMetaExpired(path, expire) {
 			numCleaned++
-			err := os.RemoveAll(path)
+			err := m.checkpointStore().Delete(path)
+			if err == nil {
+				err = os.RemoveAll(path)
+			}
 			log.Infof("Expired submeta %q has been removed with error %v", path, err)
 		}
 		delete(m.subMetaExpired, path)
@@ -289,8 +335,7 @@ func (m *Meta) IsValid() bool {
 
 // IsNotExist meta 不存在，用来判断是第一次创建
 func (m *Meta) IsNotExist() bool {
-	path := m.MetaFile()
-	_, err := os.Stat(path)
+	_, err := m.checkpointStore().ModTime(m.MetaFile())
 	return os.IsNotExist(err)
 }
 
@@ -306,7 +351,10 @@ func (m *Meta) IsNotValid() bool {
 
 // Clear 删除所有meta信息
 func (m *Meta) Clear() error {
-	err := os.RemoveAll(m.Dir)
+	err := m.checkpointStore().Delete(m.Dir)
+	if err == nil {
+		err = os.RemoveAll(m.Dir)
+	}
 	if err != nil {
 		log.Errorf("Runner[%v] remove %v err %v", m.RunnerName, m.Dir, err)
 		return err
@@ -319,88 +367,55 @@ func (m *Meta) CacheLineFile() string {
 }
 
 func (m *Meta) ReadCacheLine() ([]byte, error) {
-	return ioutil.ReadFile(m.CacheLineFile())
+	return m.checkpointStore().Get(m.CacheLineFile())
 }
 
 func (m *Meta) WriteCacheLine(lines string) error {
-	return ioutil.WriteFile(m.CacheLineFile(), []byte(lines), DefaultFilePerm)
+	return m.checkpointStore().Put(m.CacheLineFile(), []byte(lines))
 }
 
 func (m *Meta) ReadBufMeta() (r, w, bufsize int, err error) {
-	f, err := os.Open(m.BufMetaFile())
+	data, err := m.checkpointStore().Get(m.BufMetaFile())
 	if err != nil {
 		return
 	}
-	defer f.Close()
-	_, err = fmt.Fscanf(f, bufMetaFormat, &r, &w, &bufsize)
+	_, err = fmt.Fscanf(bytes.NewReader(data), bufMetaFormat, &r, &w, &bufsize)
 	return
 }
 
 func (m *Meta) ReadBuf(buf []byte) (n int, err error) {
-	f, err := os.Open(m.BufFile())
+	data, err := m.checkpointStore().Get(m.BufFile())
 	if err != nil {
 		return
 	}
-	defer f.Close()
-	return f.Read(buf)
+	return bytes.NewReader(data).Read(buf)
 }
 
+// WriteBuf 同时写入 buf 数据和 buf 的 offset 数据
 func (m *Meta) WriteBuf(buf []byte, r, w, bufsize int) (err error) {
-	var f *os.File
-	bufMetaFileName := m.BufMetaFile()
-	bufFileName := m.BufFile()
-
-	tmpBufMetaFileName := fmt.Sprintf("%s.%d.tmp", bufMetaFileName, rand.Int())
-	tmpBufFileName := fmt.Sprintf("%s.%d.tmp", bufFileName, rand.Int())
-
-	defer func() {
-		os.RemoveAll(tmpBufMetaFileName)
-		os.RemoveAll(tmpBufFileName)
-	}()
-
-	// write to tmp file
-	f, err = os.OpenFile(tmpBufMetaFileName, os.O_RDWR|os.O_CREATE, DefaultFilePerm)
-	if err != nil {
-		return
-	}
-	_, err = fmt.Fprintf(f, bufMetaFormat, r, w, bufsize)
-	if err != nil {
-		f.Close()
-		return
-	}
-	f.Sync()
-	f.Close()
-
-	// write to tmp file
-	f, err = os.OpenFile(tmpBufFileName, os.O_RDWR|os.O_CREATE, DefaultFilePerm)
-	if err != nil {
-		return
-	}
-
-	_, err = f.Write(buf)
-	if err != nil {
-		f.Close()
-		return
-	}
-	f.Sync()
-	f.Close()
+	return m.checkpointStore().Commit(map[string][]byte{
+		m.BufMetaFile(): []byte(fmt.Sprintf(bufMetaFormat, r, w, bufsize)),
+		m.BufFile():     buf,
+	})
+}
 
-	err = os.Rename(tmpBufMetaFileName, bufMetaFileName)
-	if err != nil {
-		return
-	}
-	return os.Rename(tmpBufFileName, bufFileName)
+// WriteBufCache 同时写入 buf 数据和多行缓存
+func (m *Meta) WriteBufCache(buf []byte, r, w, bufsize int, lines string) error {
+	return m.checkpointStore().Commit(map[string][]byte{
+		m.BufMetaFile():   []byte(fmt.Sprintf(bufMetaFormat, r, w, bufsize)),
+		m.BufFile():       buf,
+		m.CacheLineFile(): []byte(lines),
+	})
 }
 
 // ReadOffset 读取当前读取的文件和offset
 func (m *Meta) ReadOffset() (currFile string, offset int64, err error) {
-	f, err := os.Open(m.MetaFile())
+	data, err := m.checkpointStore().Get(m.MetaFile())
 	if err != nil {
 		return
 	}
-	defer f.Close()
 
-	_, err = fmt.Fscanf(f, metaFormat, &currFile, &offset)
+	_, err = fmt.Fscanf(bytes.NewReader(data), metaFormat, &currFile, &offset)
 	if err != nil {
 		log.Debugf("meta file format err %v", err)
 		return
@@ -450,24 +465,17 @@ func (m *Meta) ReadRecordsFile(recordsFile string) ([]string, error) {
 
 // WriteOffset 将当前文件和offset写入meta中
 func (m *Meta) WriteOffset(currFile string, offset int64) (err error) {
-	var f *os.File
-	fileName := m.MetaFile()
-	tmpFileName := fmt.Sprintf("%s.%d.tmp", fileName, rand.Int())
-
-	// write to tmp file
-	f, err = os.OpenFile(tmpFileName, os.O_RDWR|os.O_CREATE, DefaultFilePerm)
-	if err != nil {
-		return err
-	}
-	_, err = fmt.Fprintf(f, metaFormat, currFile, offset)
-	if err != nil {
-		f.Close()
-		return err
-	}
-	f.Sync()
-	f.Close()
+	return m.checkpointStore().Put(m.MetaFile(), []byte(fmt.Sprintf(metaFormat, currFile, offset)))
+}
 
-	return os.Rename(tmpFileName, fileName)
+// WritePosition 同时写入 buf 数据、多行缓存以及读取的文件和 offset
+func (m *Meta) WritePosition(buf []byte, r, w, bufsize int, lines, currFile string, offset int64) error {
+	return m.checkpointStore().Commit(map[string][]byte{
+		m.BufMetaFile():   []byte(fmt.Sprintf(bufMetaFormat, r, w, bufsize)),
+		m.BufFile():       buf,
+		m.CacheLineFile(): []byte(lines),
+		m.MetaFile():      []byte(fmt.Sprintf(metaFormat, currFile, offset)),
+	})
 }
 
 // AppendDoneFile 将处理完的文件写入doneFile中
@@ -483,17 +491,58 @@ func (m *Meta) AppendDoneFile(path string) (err error) {
 }
 
 // AppendDoneFileInode 将处理完的文件路径、inode以及完成时间写入doneFile中
+// 使用 kv store 时同时记录在 store 中，reader 从 store 中恢复处理完的文件，doneFile 只供 cleaner 使用
 func (m *Meta) AppendDoneFileInode(path string, inode uint64) (err error) {
+	line := fmt.Sprintf("%s\t%v\t%s\n", path, inode, time.Now().Format(time.RFC3339Nano))
+	if _, ok := m.store.(*kvCheckpointStore); ok {
+		if err = m.appendDoneInode(line); err != nil {
+			return
+		}
+	}
 	f, err := os.OpenFile(m.DoneFile(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, DefaultFilePerm)
 	if err != nil {
 		return
 	}
 	defer f.Close()
 
-	_, err = fmt.Fprintf(f, "%s\t%v\t%s\n", path, inode, time.Now().Format(time.RFC3339Nano))
+	_, err = f.WriteString(line)
 	return
 }
 
+func (m *Meta) doneInodeFile() string {
+	return filepath.Join(m.Dir, doneInodeFileName)
+}
+
+// appendDoneInode 追加 store 中的记录，与 doneFile 一样丢弃超过 donefile_retention 的记录
+// store 中还没有记录时先导入 doneFile 中已有的记录
+func (m *Meta) appendDoneInode(line string) error {
+	contents, err := m.getDoneInodeContent()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, v := range contents {
+		if v == "" || m.doneInodeExpired(v) {
+			continue
+		}
+		buf.WriteString(v + "\n")
+	}
+	buf.WriteString(line)
+	return m.store.Put(m.doneInodeFile(), buf.Bytes())
+}
+
+func (m *Meta) doneInodeExpired(line string) bool {
+	sps := strings.Split(line, "\t")
+	if len(sps) < 3 {
+		return false
+	}
+	doneTime, err := time.Parse(time.RFC3339Nano, sps[2])
+	if err != nil {
+		return false
+	}
+	return float64(m.donefileretention*24) < time.Since(doneTime).Hours()
+}
+
 func (m *Meta) GetDoneFileContent() ([]string, error) {
 	return m.getDoneFileContent()
 }
@@ -515,13 +564,28 @@ func (m *Meta) getDoneFileContent() ([]string, error) {
 	return ret, nil
 }
 
+// getDoneInodeContent 使用 kv store 时从 store 中读取，store 中还没有记录时读取 doneFile
+func (m *Meta) getDoneInodeContent() ([]string, error) {
+	if _, ok := m.store.(*kvCheckpointStore); !ok {
+		return m.getDoneFileContent()
+	}
+	data, err := m.store.Get(m.doneInodeFile())
+	if os.IsNotExist(err) {
+		return m.getDoneFileContent()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n"), nil
+}
+
 func joinFileInode(filename, inode string) string {
 	return filepath.Base(filename) + "_" + inode
 }
 
 func (m *Meta) GetDoneFileInode() map[string]bool {
 	inodeMap := make(map[string]bool)
-	contents, err := m.getDoneFileContent()
+	contents, err := m.getDoneInodeContent()
 	if err != nil {
 		log.Error(err)
 		return inodeMap
@@ -580,8 +644,7 @@ func (m *Meta) IsStatisticFileExist() bool {
 
 // IsNotExist meta 不存在，用来判断是第一次创建
 func (m *Meta) IsStatisticFileNotExist() bool {
-	path := m.StatisticFile()
-	_, err := os.Stat(path)
+	_, err := m.checkpointStore().ModTime(m.StatisticFile())
 	return os.IsNotExist(err)
 }
 
@@ -734,6 +797,9 @@ func (m *Meta) Delete() error {
 	}
 	m.subMetaLock.RUnlock()
 
+	if err := m.checkpointStore().Delete(m.Dir); err != nil {
+		return err
+	}
 	if err := os.RemoveAll(m.Dir); err != nil {
 		return err
 	}
@@ -741,7 +807,7 @@ func (m *Meta) Delete() error {
 }
 
 func (m *Meta) ReadStatistic() (stat Statistic, err error) {
-	statData, err := ioutil.ReadFile(m.StatisticFile())
+	statData, err := m.checkpointStore().Get(m.StatisticFile())
 	if statData == nil || err != nil {
 		return
 	}
@@ -754,7 +820,7 @@ func (m *Meta) WriteStatistic(stat *Statistic) error {
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(m.StatisticFile(), statStr, DefaultFilePerm)
+	return m.checkpointStore().Put(m.StatisticFile(), statStr)
 }
 
 func (m *Meta) ExtraInfo() map[string]string {
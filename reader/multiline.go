@@ -0,0 +1,340 @@
+package reader
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	DefaultMultilineMaxLines     = 500
+	DefaultMultilineFlushTimeout = 5 * time.Second
+)
+
+// MultilineConfig 多行合并的配置
+type MultilineConfig struct {
+	Pattern string
+	// Match 为 head 时匹配的行是新数据的开始，continue 时匹配的行属于上一条数据，tail 时匹配的行是数据的结束
+	Match  string
+	Negate bool
+	// MaxLines 为 0 表示不限制行数
+	MaxLines int
+	MaxBytes int
+	// FlushTimeout 为 0 表示不超时
+	FlushTimeout time.Duration
+}
+
+// multilinePresets 常见语言异常堆栈的合并规则，堆栈中的行都属于上一条数据
+var multilinePresets = map[string]MultilineConfig{
+	// 异常类名开头的行、以空白开头的 at ...、... 3 more 以及 Caused by: 等
+	MultilinePresetJava: {
+		Pattern: `^([ \t]+|([\w$]+\.)+[\w$]*(Exception|Error|Throwable)(: |$)|Caused by:|Suppressed:)`,
+		Match:   MultilineMatchContinue,
+	},
+	// Traceback 以及缩进的调用栈，最后的异常行和链式异常的提示
+	MultilinePresetPython: {
+		Pattern: `^([ \t]+|$|Traceback \(most recent call last\):|(\w+\.)*\w*(Error|Exception|Warning|Exit|Interrupt)(: |$)|During handling of the above exception|The above exception was the direct cause)`,
+		Match:   MultilineMatchContinue,
+	},
+	// panic 之后的空行、goroutine 头、函数调用行以及缩进的文件位置
+	MultilinePresetGo: {
+		Pattern: `^([ \t]+|$|goroutine \d+ \[|created by |\[signal |\S+\(.*\)$|exit status \d+)`,
+		Match:   MultilineMatchContinue,
+	},
+}
+
+// NewMultilineConfig 从 reader 的配置中读取多行合并的配置，没有配置时返回 nil
+func NewMultilineConfig(c conf.MapConf) (*MultilineConfig, error) {
+	mc := MultilineConfig{Match: MultilineMatchHead}
+	preset, _ := c.GetStringOr(KeyMultilinePreset, "")
+	if preset != "" {
+		p, ok := multilinePresets[preset]
+		if !ok {
+			return nil, fmt.Errorf("multiline preset %q is not supported", preset)
+		}
+		mc = p
+	}
+	mc.Pattern, _ = c.GetStringOr(KeyMultilinePattern, mc.Pattern)
+	if mc.Pattern == "" {
+		return nil, nil
+	}
+	mc.Match, _ = c.GetStringOr(KeyMultilineMatch, mc.Match)
+	mc.Negate, _ = c.GetBoolOr(KeyMultilineNegate, false)
+	mc.MaxLines, _ = c.GetIntOr(KeyMultilineMaxLines, DefaultMultilineMaxLines)
+	mc.MaxBytes, _ = c.GetIntOr(KeyMultilineMaxBytes, MaxHeadPatternBufferSize)
+	timeout, _ := c.GetStringOr(KeyMultilineFlushTimeout, DefaultMultilineFlushTimeout.String())
+	var err error
+	if mc.FlushTimeout, err = time.ParseDuration(timeout); err != nil {
+		return nil, fmt.Errorf("parse %v error %v", KeyMultilineFlushTimeout, err)
+	}
+	return &mc, nil
+}
+
+// multiline 按 MultilineConfig 将多行合并为一条数据
+type multiline struct {
+	conf    MultilineConfig
+	pattern *regexp.Regexp
+	lines   []string
+	size    int
+	last    time.Time // 最后一次加入行的时间
+}
+
+func newMultiline(c *MultilineConfig) (*multiline, error) {
+	switch c.Match {
+	case MultilineMatchHead, MultilineMatchContinue, MultilineMatchTail:
+	default:
+		return nil, fmt.Errorf("multiline match %q is not supported", c.Match)
+	}
+	if c.MaxLines < 0 || c.FlushTimeout < 0 {
+		return nil, fmt.Errorf("multiline max lines %v and flush timeout %v can not be negative", c.MaxLines, c.FlushTimeout)
+	}
+	pattern, err := regexp.Compile(c.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("multiline pattern %v compile error %v", c.Pattern, err)
+	}
+	m := &multiline{conf: *c, pattern: pattern}
+	if m.conf.MaxBytes <= 0 {
+		m.conf.MaxBytes = MaxHeadPatternBufferSize
+	}
+	return m, nil
+}
+
+func (m *multiline) match(line string) bool {
+	return m.pattern.MatchString(strings.TrimRight(line, "\r\n")) != m.conf.Negate
+}
+
+// full 判断加入 n 字节的一行后是否超过限制
+func (m *multiline) full(n int) bool {
+	return (m.conf.MaxLines > 0 && len(m.lines)+1 > m.conf.MaxLines) || m.size+n > m.conf.MaxBytes
+}
+
+// push 加入一行，返回合并完成的数据，每次最多完成一条
+func (m *multiline) push(line string) (ret string, ok bool) {
+	matched := m.match(line)
+	if m.conf.Match == MultilineMatchTail {
+		m.add(line)
+		if matched || (m.conf.MaxLines > 0 && len(m.lines) >= m.conf.MaxLines) || m.size >= m.conf.MaxBytes {
+			ret, ok = m.flush()
+		}
+		return
+	}
+	if len(m.lines) > 0 {
+		newEvent := matched
+		if m.conf.Match == MultilineMatchContinue {
+			newEvent = !matched
+		}
+		if newEvent || m.full(len(line)) {
+			ret, ok = m.flush()
+		}
+	}
+	m.add(line)
+	return
+}
+
+func (m *multiline) add(line string) {
+	// 没有换行符的行(如 kafka 的消息)合并时补上换行符
+	if n := len(m.lines); n > 0 && !strings.HasSuffix(m.lines[n-1], "\n") {
+		m.lines[n-1] += "\n"
+		m.size++
+	}
+	m.lines = append(m.lines, line)
+	m.size += len(line)
+	m.last = time.Now()
+}
+
+// timeout 超过 FlushTimeout 没有新的行时输出已经合并的数据
+func (m *multiline) timeout(now time.Time) (string, bool) {
+	if m.conf.FlushTimeout <= 0 || now.Sub(m.last) < m.conf.FlushTimeout {
+		return "", false
+	}
+	return m.flush()
+}
+
+func (m *multiline) flush() (string, bool) {
+	if len(m.lines) == 0 {
+		return "", false
+	}
+	ret := m.pending()
+	m.lines = nil
+	m.size = 0
+	return ret, true
+}
+
+func (m *multiline) count() int {
+	return len(m.lines)
+}
+
+// pending 返回正在合并的数据，用于保存到 meta 中
+func (m *multiline) pending() string {
+	return strings.Join(m.lines, "")
+}
+
+// restore 恢复 meta 中保存的正在合并的数据
+func (m *multiline) restore(cache string) {
+	if cache == "" {
+		return
+	}
+	m.lines = []string{cache}
+	m.size = len(cache)
+	m.last = time.Now()
+}
+
+// MultilineSetter 代表了一个按数据源分别合并多行的读取器，例如 tailx 对每个文件分别合并
+type MultilineSetter interface {
+	SetMultiline(c *MultilineConfig) error
+}
+
+// MultilineReader 对不区分数据源的读取器(如 socket、kafka)读取的行进行合并
+// 非确认模式下正在合并的数据保存在 meta 的多行缓存中，与 BufReader 一样重启后继续合并
+type MultilineReader struct {
+	Reader
+	meta      *Meta
+	multiline *multiline
+	ack       bool
+	start     Position // 确认模式下正在合并的数据第一行之前的读取位置
+	restored  bool     // 从 meta 中恢复了正在合并的数据，确认模式下返回后在 Commit 时清空多行缓存
+	emitted   bool
+}
+
+var (
+	_ DaemonReader = &MultilineReader{}
+	_ StatsReader  = &MultilineReader{}
+	_ LagReader    = &MultilineReader{}
+	_ AckReader    = &MultilineReader{}
+	_ Resetable    = &MultilineReader{}
+)
+
+// NewMultilineReader 的 meta 为空时不保存正在合并的数据
+func NewMultilineReader(rd Reader, meta *Meta, c *MultilineConfig) (*MultilineReader, error) {
+	if _, ok := rd.(DataReader); ok {
+		return nil, fmt.Errorf("%v does not support multiline", rd.Name())
+	}
+	m, err := newMultiline(c)
+	if err != nil {
+		return nil, err
+	}
+	r := &MultilineReader{Reader: rd, meta: meta, multiline: m}
+	if meta != nil {
+		if cache, err := meta.ReadCacheLine(); err == nil && len(cache) > 0 {
+			m.restore(string(cache))
+			r.restored = true
+		}
+	}
+	return r, nil
+}
+
+func (r *MultilineReader) ReadLine() (string, error) {
+	for {
+		var pos Position
+		if r.ack {
+			pos = r.Reader.(AckReader).Position()
+		}
+		line, err := r.Reader.ReadLine()
+		if line == "" {
+			if ret, ok := r.multiline.timeout(time.Now()); ok {
+				r.emitted = r.restored
+				return ret, err
+			}
+			return "", err
+		}
+		ret, ok := r.multiline.push(line)
+		if r.multiline.count() == 1 {
+			// 新的数据从这一行开始
+			r.start = pos
+		}
+		if ok || err != nil {
+			if ok {
+				r.emitted = r.restored
+			}
+			return ret, err
+		}
+	}
+}
+
+// SyncMeta 先保存正在合并的数据再同步内部 reader 的读取位置，读取位置之前没有返回的行在重启后恢复
+func (r *MultilineReader) SyncMeta() {
+	if !r.ack && r.meta != nil {
+		if err := r.meta.WriteCacheLine(r.multiline.pending()); err != nil {
+			log.Errorf("Runner[%v] %s cannot write linecache, err :%v", r.meta.RunnerName, r.Name(), err)
+			return
+		}
+	}
+	r.Reader.SyncMeta()
+}
+
+func (r *MultilineReader) Start() error {
+	if dr, ok := r.Reader.(DaemonReader); ok {
+		return dr.Start()
+	}
+	return nil
+}
+
+func (r *MultilineReader) Status() StatsInfo {
+	if sr, ok := r.Reader.(StatsReader); ok {
+		return sr.Status()
+	}
+	return StatsInfo{}
+}
+
+func (r *MultilineReader) Lag() (*LagInfo, error) {
+	if lr, ok := r.Reader.(LagReader); ok {
+		return lr.Lag()
+	}
+	return &LagInfo{}, nil
+}
+
+func (r *MultilineReader) Reset() error {
+	if rr, ok := r.Reader.(Resetable); ok {
+		return rr.Reset()
+	}
+	return nil
+}
+
+func (r *MultilineReader) EnableAck() error {
+	ar, ok := r.Reader.(AckReader)
+	if !ok {
+		return fmt.Errorf("%v does not support ack", r.Name())
+	}
+	if err := ar.EnableAck(); err != nil {
+		return err
+	}
+	r.ack = true
+	if r.multiline.count() > 0 {
+		// 恢复的数据已经在内部 reader 的读取位置之前
+		r.start = ar.Position()
+	}
+	return nil
+}
+
+// Position 正在合并的数据没有返回，读取位置不能超过其第一行，内部 reader 不支持时返回 nil
+func (r *MultilineReader) Position() Position {
+	ar, ok := r.Reader.(AckReader)
+	if !ok {
+		return nil
+	}
+	if r.multiline.count() > 0 {
+		return r.start
+	}
+	return ar.Position()
+}
+
+// Commit 之后清空非确认模式下保存的多行缓存，避免重启后再次恢复
+func (r *MultilineReader) Commit(pos Position) error {
+	if err := r.Reader.(AckReader).Commit(pos); err != nil {
+		return err
+	}
+	if r.restored && r.emitted {
+		if err := r.meta.WriteCacheLine(""); err != nil {
+			return err
+		}
+		r.restored = false
+	}
+	return nil
+}
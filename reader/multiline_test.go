@@ -0,0 +1,306 @@
+package reader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/reader/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func pushLines(m *multiline, lines ...string) (events []string) {
+	for _, line := range lines {
+		if ret, ok := m.push(line); ok {
+			events = append(events, ret)
+		}
+	}
+	return
+}
+
+func TestMultiline(t *testing.T) {
+	tests := []struct {
+		conf   MultilineConfig
+		lines  []string
+		events []string
+		rest   string
+	}{
+		{
+			conf:   MultilineConfig{Pattern: `^\d`, Match: MultilineMatchHead},
+			lines:  []string{"1 a\n", " b\n", "2 c\n", "3 d\n", " e\n"},
+			events: []string{"1 a\n b\n", "2 c\n"},
+			rest:   "3 d\n e\n",
+		},
+		{
+			conf:   MultilineConfig{Pattern: `^\s`, Match: MultilineMatchHead, Negate: true},
+			lines:  []string{"1 a\n", " b\n", "2 c\n"},
+			events: []string{"1 a\n b\n"},
+			rest:   "2 c\n",
+		},
+		{
+			conf:   MultilineConfig{Pattern: `\\$`, Match: MultilineMatchTail, Negate: true},
+			lines:  []string{"a \\\n", "b \\\r\n", "c\n", "d\n"},
+			events: []string{"a \\\nb \\\r\nc\n", "d\n"},
+		},
+		{
+			conf:   MultilineConfig{Pattern: `;$`, Match: MultilineMatchTail},
+			lines:  []string{"select", "1;", "select 2;"},
+			events: []string{"select\n1;", "select 2;"},
+		},
+		{
+			conf:   MultilineConfig{Pattern: `^\s`, Match: MultilineMatchContinue, MaxLines: 2},
+			lines:  []string{"a\n", " b\n", " c\n", "d\n"},
+			events: []string{"a\n b\n", " c\n"},
+			rest:   "d\n",
+		},
+		{
+			conf:   MultilineConfig{Pattern: `^\s`, Match: MultilineMatchContinue, MaxBytes: 5},
+			lines:  []string{"a\n", " b\n", " c\n"},
+			events: []string{"a\n b\n"},
+			rest:   " c\n",
+		},
+	}
+	for _, test := range tests {
+		m, err := newMultiline(&test.conf)
+		assert.NoError(t, err)
+		assert.Equal(t, test.events, pushLines(m, test.lines...))
+		assert.Equal(t, test.rest, m.pending())
+	}
+
+	m, err := newMultiline(&MultilineConfig{Pattern: `^\s`, Match: MultilineMatchContinue, FlushTimeout: time.Second})
+	assert.NoError(t, err)
+	pushLines(m, "a\n", " b\n")
+	_, ok := m.timeout(time.Now())
+	assert.False(t, ok)
+	ret, ok := m.timeout(time.Now().Add(time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, "a\n b\n", ret)
+	_, ok = m.timeout(time.Now().Add(time.Second))
+	assert.False(t, ok)
+
+	_, err = newMultiline(&MultilineConfig{Pattern: `^\s`, Match: "unknown"})
+	assert.Error(t, err)
+	_, err = newMultiline(&MultilineConfig{Pattern: `(`, Match: MultilineMatchHead})
+	assert.Error(t, err)
+}
+
+func TestMultilinePresets(t *testing.T) {
+	tests := map[string][]string{
+		MultilinePresetJava: {
+			"2018-01-01 ERROR failed\n",
+			"java.lang.IllegalStateException: boom\n",
+			"\tat com.example.Foo.bar(Foo.java:10)\n",
+			"Caused by: java.lang.NullPointerException\n",
+			"\t... 3 more\n",
+		},
+		MultilinePresetPython: {
+			"2018-01-01 ERROR failed\n",
+			"Traceback (most recent call last):\n",
+			"  File \"main.py\", line 1, in <module>\n",
+			"    foo()\n",
+			"KeyError: 'a'\n",
+			"\n",
+			"During handling of the above exception, another exception occurred:\n",
+			"\n",
+			"Traceback (most recent call last):\n",
+			"  File \"main.py\", line 3, in <module>\n",
+			"ValueError: bad\n",
+		},
+		MultilinePresetGo: {
+			"panic: runtime error: index out of range\n",
+			"\n",
+			"goroutine 1 [running]:\n",
+			"main.main()\n",
+			"\t/go/src/main.go:5 +0x1d\n",
+			"github.com/qiniu/logkit/mgr.(*LogExportRunner).Run(0xc420001, 0x1)\n",
+			"created by main.start\n",
+			"exit status 2\n",
+		},
+	}
+	for preset, lines := range tests {
+		mc, err := NewMultilineConfig(conf.MapConf{KeyMultilinePreset: preset})
+		assert.NoError(t, err)
+		m, err := newMultiline(mc)
+		assert.NoError(t, err)
+		events := pushLines(m, append(lines, "2018-01-01 INFO next\n")...)
+		assert.Equal(t, []string{strings.Join(lines, "")}, events, preset)
+		assert.Equal(t, "2018-01-01 INFO next\n", m.pending(), preset)
+	}
+}
+
+func TestNewMultilineConfig(t *testing.T) {
+	mc, err := NewMultilineConfig(conf.MapConf{})
+	assert.NoError(t, err)
+	assert.Nil(t, mc)
+
+	mc, err = NewMultilineConfig(conf.MapConf{
+		KeyMultilinePreset:       MultilinePresetJava,
+		KeyMultilineMatch:        MultilineMatchHead,
+		KeyMultilineNegate:       "true",
+		KeyMultilineMaxBytes:     "",
+		KeyMultilineFlushTimeout: "1s",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &MultilineConfig{
+		Pattern:      multilinePresets[MultilinePresetJava].Pattern,
+		Match:        MultilineMatchHead,
+		Negate:       true,
+		MaxLines:     DefaultMultilineMaxLines,
+		MaxBytes:     MaxHeadPatternBufferSize,
+		FlushTimeout: time.Second,
+	}, mc)
+
+	_, err = NewMultilineConfig(conf.MapConf{KeyMultilinePreset: "unknown"})
+	assert.Error(t, err)
+	_, err = NewMultilineConfig(conf.MapConf{KeyMultilinePattern: "^a", KeyMultilineFlushTimeout: "1"})
+	assert.Error(t, err)
+}
+
+// lineReader 依次返回 lines，读完后返回空行，读取位置为已经读取的行数
+type lineReader struct {
+	lines     []string
+	n         int
+	synced    int
+	committed Position
+}
+
+func (r *lineReader) Name() string                      { return "lineReader" }
+func (r *lineReader) SetMode(string, interface{}) error { return nil }
+func (r *lineReader) Source() string                    { return "lineReader" }
+func (r *lineReader) SyncMeta()                         { r.synced = r.n }
+func (r *lineReader) Close() error                      { return nil }
+func (r *lineReader) EnableAck() error                  { return nil }
+func (r *lineReader) Position() Position                { return r.n }
+func (r *lineReader) Commit(pos Position) error {
+	r.committed = pos
+	return nil
+}
+
+func (r *lineReader) ReadLine() (string, error) {
+	if r.n >= len(r.lines) {
+		return "", nil
+	}
+	r.n++
+	return r.lines[r.n-1], nil
+}
+
+func TestMultilineReader(t *testing.T) {
+	rd := &lineReader{lines: []string{"a", " b", "c", " d"}}
+	r, err := NewMultilineReader(rd, nil, &MultilineConfig{Pattern: `^\s`, Match: MultilineMatchContinue, FlushTimeout: 100 * time.Millisecond})
+	assert.NoError(t, err)
+	assert.NoError(t, r.EnableAck())
+	assert.Equal(t, 0, r.Position())
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "a\n b", line)
+	// c 还在合并中，读取位置停在 c 之前
+	assert.Equal(t, 2, r.Position())
+	line, err = r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "", line)
+	assert.Equal(t, 2, r.Position())
+	time.Sleep(100 * time.Millisecond)
+	line, err = r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "c\n d", line)
+	assert.Equal(t, 4, r.Position())
+	assert.NoError(t, r.Commit(r.Position()))
+	assert.Equal(t, 4, rd.committed)
+
+	lag, err := r.Lag()
+	assert.NoError(t, err)
+	assert.Equal(t, &LagInfo{}, lag)
+	assert.NoError(t, r.Start())
+	assert.NoError(t, r.Reset())
+}
+
+func TestMultilineReaderSyncMeta(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestMultilineReaderSyncMeta")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	meta, err := NewMeta(dir, dir, "", ModeSocket, "", DefautFileRetention)
+	assert.NoError(t, err)
+	mc := &MultilineConfig{Pattern: `^\s`, Match: MultilineMatchContinue}
+
+	rd := &lineReader{lines: []string{"a", " b", "c", " d"}}
+	r, err := NewMultilineReader(rd, meta, mc)
+	assert.NoError(t, err)
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "a\n b", line)
+	line, err = r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "", line)
+	// 内部 reader 的读取位置越过了正在合并的行，这些行保存在 meta 中
+	r.SyncMeta()
+	assert.Equal(t, 4, rd.synced)
+
+	r, err = NewMultilineReader(&lineReader{lines: []string{" e", "f"}}, meta, mc)
+	assert.NoError(t, err)
+	line, err = r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "c\n d\n e", line)
+
+	// 确认模式下恢复的数据返回并确认后清空多行缓存
+	r, err = NewMultilineReader(&lineReader{lines: []string{"f"}}, meta, mc)
+	assert.NoError(t, err)
+	assert.NoError(t, r.EnableAck())
+	assert.Equal(t, 0, r.Position())
+	line, err = r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "c\n d", line)
+	assert.NoError(t, r.Commit(0))
+	cache, err := meta.ReadCacheLine()
+	assert.NoError(t, err)
+	assert.Empty(t, cache)
+}
+
+func TestBufReaderMultiline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestBufReaderMultiline")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "test.log")
+	assert.NoError(t, ioutil.WriteFile(logPath, []byte("a \\\nb\nc \\\nd \\\n"), DefaultFilePerm))
+	c := conf.MapConf{
+		KeyLogPath:               logPath,
+		KeyMetaPath:              filepath.Join(dir, "meta"),
+		KeyMode:                  ModeFile,
+		KeyWhence:                WhenceOldest,
+		KeyMultilinePattern:      `\\$`,
+		KeyMultilineMatch:        MultilineMatchTail,
+		KeyMultilineNegate:       "true",
+		KeyMultilineFlushTimeout: "0s",
+	}
+	r, err := NewFileBufReader(c, false)
+	assert.NoError(t, err)
+	line, err := r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "a \\\nb\n", line)
+	line, _ = r.ReadLine()
+	assert.Equal(t, "", line)
+	// 正在合并的行保存在 meta 中，重启后继续合并
+	r.SyncMeta()
+	r.Close()
+
+	f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, DefaultFilePerm)
+	assert.NoError(t, err)
+	_, err = f.WriteString("e\n")
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	r, err = NewFileBufReader(c, false)
+	assert.NoError(t, err)
+	line, err = r.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "c \\\nd \\\ne\n", line)
+	r.Close()
+
+	c[KeyHeadPattern] = "^a"
+	_, err = NewFileBufReader(c, false)
+	assert.Error(t, err)
+}
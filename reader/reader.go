@@ -51,6 +51,20 @@ type LagReader interface {
 	Lag() (*LagInfo, error)
 }
 
+// Position 代表了读取器的读取位置，具体内容由读取器定义
+type Position interface{}
+
+// AckReader 代表了一个支持端到端确认的读取器
+// 开启确认后 SyncMeta 不再保存当前的读取位置，而是由调用方在数据全部送达后通过 Commit 保存之前获取的读取位置
+type AckReader interface {
+	// EnableAck 用于开启确认模式，需要在开始读取之前调用
+	EnableAck() error
+	// Position 用于返回当前的读取位置，需要与 ReadLine 在同一个 goroutine 中调用
+	Position() Position
+	// Commit 用于保存通过 Position 获取的读取位置
+	Commit(pos Position) error
+}
+
 // FileReader reader 接口方法
 type FileReader interface {
 	Name() string
@@ -124,6 +138,13 @@ func (reg *Registry) NewReaderWithMeta(conf conf.MapConf, meta *Meta, errDirectR
 	}
 	mode, _ := conf.GetStringOr(KeyMode, ModeDir)
 	headPattern, _ := conf.GetStringOr(KeyHeadPattern, "")
+	multiline, err := NewMultilineConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	if headPattern != "" && multiline != nil {
+		return nil, fmt.Errorf("%v and multiline can not be used together", KeyHeadPattern)
+	}
 
 	constructor, exist := reg.readerTypeMap[mode]
 	if !exist {
@@ -140,6 +161,17 @@ func (reg *Registry) NewReaderWithMeta(conf conf.MapConf, meta *Meta, errDirectR
 			return nil, err
 		}
 	}
+	if multiline != nil {
+		// 按文件读取的 reader 对每个文件分别合并，其他 reader 对读取到的行统一合并
+		if ms, ok := reader.(MultilineSetter); ok {
+			err = ms.SetMultiline(multiline)
+		} else {
+			reader, err = NewMultilineReader(reader, meta, multiline)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return reader, nil
 }
@@ -599,6 +599,12 @@ func (sf *SeqFile) SyncMeta() (err error) {
 	return sf.meta.WriteOffset(sf.currFile, sf.offset)
 }
 
+func (sf *SeqFile) position() (string, int64) {
+	sf.mux.Lock()
+	defer sf.mux.Unlock()
+	return sf.currFile, sf.offset
+}
+
 func (sf *SeqFile) Lag() (rl *LagInfo, err error) {
 	sf.mux.Lock()
 	rl = &LagInfo{Size: -sf.offset, SizeUnit: "bytes"}
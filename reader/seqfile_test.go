@@ -327,13 +327,13 @@ func TestLag(t *testing.T) {
 	CreateDir()
 	rl, err := sf.Lag()
 	assert.NoError(t, err)
-	assert.Equal(t, &LagInfo{0, "bytes", 0, 0}, rl)
+	assert.Equal(t, &LagInfo{Size: 0, SizeUnit: "bytes"}, rl)
 	createQiniuLogFile(Dir)
 	createInvalidSuffixFile(Dir)
 
 	rl, err = sf.Lag()
 	assert.NoError(t, err)
-	assert.Equal(t, &LagInfo{8, "bytes", 0, 0}, rl)
+	assert.Equal(t, &LagInfo{Size: 8, SizeUnit: "bytes"}, rl)
 }
 
 func Test_NewFileNewLine2(t *testing.T) {
@@ -337,6 +337,12 @@ func (sf *SingleFile) SyncMeta() error {
 	return sf.meta.WriteOffset(sf.originpath, sf.offset)
 }
 
+func (sf *SingleFile) position() (string, int64) {
+	sf.mux.Lock()
+	defer sf.mux.Unlock()
+	return sf.originpath, sf.offset
+}
+
 func (sf *SingleFile) Lag() (rl *LagInfo, err error) {
 	sf.mux.Lock()
 	rl = &LagInfo{Size: -sf.offset, SizeUnit: "bytes"}
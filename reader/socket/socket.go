@@ -2,6 +2,7 @@ package socket
 
 import (
 	"bufio"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -31,6 +32,9 @@ var (
 	_ reader.Reader       = &Reader{}
 )
 
+// frameHeaderSize 为按长度前缀读取时长度前缀的字节数
+const frameHeaderSize = 4
+
 type setReadBufferer interface {
 	SetReadBuffer(bytes int) error
 }
@@ -115,7 +119,9 @@ func (ssr *streamSocketReader) read(c net.Conn) {
 	defer ssr.removeConnection(c)
 	defer c.Close()
 
-	if ssr.IsSplitByLine ||
+	if ssr.SocketRule == SocketRuleLengthPrefix {
+		ssr.lengthPrefixRead(c)
+	} else if ssr.IsSplitByLine ||
 		ssr.SocketRule == SocketRuleLine ||
 		ssr.SocketRule == SocketRulePacket {
 		ssr.packetAndLineRead(c)
@@ -187,6 +193,68 @@ func (ssr *streamSocketReader) packetAndLineRead(c net.Conn) {
 	}
 }
 
+// lengthPrefixRead 按 4 字节大端序的长度前缀读取二进制消息，消息内容原样传递，不做换行切分和编码转换
+func (ssr *streamSocketReader) lengthPrefixRead(c net.Conn) {
+	var address string
+	// get remote addr
+	if remoteAddr := c.RemoteAddr(); remoteAddr != nil && len(remoteAddr.String()) != 0 {
+		address = remoteAddr.String()
+	}
+	// if remote addr is empty, get local addr
+	if len(address) == 0 {
+		if localAddr := c.LocalAddr(); localAddr != nil {
+			address = localAddr.String()
+		}
+	}
+
+	bufioReader := bufio.NewReader(c)
+	header := make([]byte, frameHeaderSize)
+	for {
+		if atomic.LoadInt32(&ssr.status) == StatusStopped || atomic.LoadInt32(&ssr.status) == StatusStopping {
+			return
+		}
+		if ssr.ReadTimeout != 0 && ssr.ReadTimeout > 0 {
+			c.SetReadDeadline(time.Now().Add(ssr.ReadTimeout))
+		}
+		if _, err := io.ReadFull(bufioReader, header); err != nil {
+			if err != io.EOF && !strings.HasSuffix(err.Error(), ": use of closed network connection") {
+				log.Errorf("runner[%v] Reader %q read frame header from %v error %v", ssr.meta.RunnerName, ssr.Name(), address, err)
+			}
+			return
+		}
+		size := binary.BigEndian.Uint32(header)
+		if int64(size) > ssr.MaxFrameSize {
+			// 长度前缀错误时无法找到下一个消息的开始，只能关闭连接
+			log.Errorf("runner[%v] Reader %q frame size %d from %v exceeds %v %d, close connection", ssr.meta.RunnerName, ssr.Name(), size, address, KeySocketMaxFrameSize, ssr.MaxFrameSize)
+			return
+		}
+		frame := make([]byte, size)
+		if _, err := io.ReadFull(bufioReader, frame); err != nil {
+			log.Errorf("runner[%v] Reader %q read frame from %v error %v", ssr.meta.RunnerName, ssr.Name(), address, err)
+			return
+		}
+		ssr.sendReadChan(address, string(frame))
+	}
+}
+
+// splitFrames 将一个数据包按长度前缀切分为多个消息
+func splitFrames(b []byte, maxFrameSize int64) ([]string, error) {
+	var frames []string
+	for len(b) > 0 {
+		if len(b) < frameHeaderSize {
+			return frames, fmt.Errorf("incomplete frame header of %d bytes", len(b))
+		}
+		size := binary.BigEndian.Uint32(b)
+		b = b[frameHeaderSize:]
+		if int64(size) > maxFrameSize || int64(size) > int64(len(b)) {
+			return frames, fmt.Errorf("invalid frame size %d, %d bytes left", size, len(b))
+		}
+		frames = append(frames, string(b[:size]))
+		b = b[size:]
+	}
+	return frames, nil
+}
+
 func (ssr *streamSocketReader) jsonRead(c net.Conn) {
 	var err error
 	defer ssr.sendError(err)
@@ -292,6 +360,16 @@ func (psr *packetSocketReader) listen() {
 				address = localAddr.String()
 			}
 		}
+		if psr.SocketRule == SocketRuleLengthPrefix {
+			frames, err := splitFrames(buf[:n], psr.MaxFrameSize)
+			if err != nil {
+				log.Errorf("runner[%v] Reader %q split packet from %v error %v", psr.meta.RunnerName, psr.Name(), address, err)
+			}
+			for _, frame := range frames {
+				psr.sendReadChan(address, frame)
+			}
+			continue
+		}
 		val := string(buf[:n])
 
 		if psr.IsSplitByLine || psr.SocketRule == SocketRuleLine {
@@ -330,6 +408,7 @@ type Reader struct {
 	KeepAlivePeriod time.Duration
 	IsSplitByLine   bool
 	SocketRule      string
+	MaxFrameSize    int64
 	HeadPattern     *regexp.Regexp
 	decoder         mahonia.Decoder
 
@@ -366,10 +445,15 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 			return nil, err
 		}
 	}
+	maxFrameSize, _ := conf.GetInt64Or(KeySocketMaxFrameSize, DefaultSocketMaxFrameSize)
+	if maxFrameSize <= 0 {
+		return nil, fmt.Errorf("%v must be positive", KeySocketMaxFrameSize)
+	}
 	var decoder mahonia.Decoder
 	encoding, _ := conf.GetStringOr(KeyEncoding, "")
 	encoding = strings.ToUpper(encoding)
-	if encoding != "UTF-8" {
+	// 按长度前缀读取的为二进制消息，不做编码转换
+	if encoding != "UTF-8" && socketRule != SocketRuleLengthPrefix {
 		decoder = mahonia.NewDecoder(encoding)
 		if decoder == nil {
 			log.Warnf("Encoding Way [%v] is not supported, will read as utf-8", encoding)
@@ -388,6 +472,7 @@ func NewReader(meta *reader.Meta, conf conf.MapConf) (reader.Reader, error) {
 		KeepAlivePeriod: KeepAlivePeriodDur,
 		IsSplitByLine:   IsSplitByLine,
 		SocketRule:      socketRule,
+		MaxFrameSize:    maxFrameSize,
 		HeadPattern:     headPattern,
 		decoder:         decoder,
 	}, nil
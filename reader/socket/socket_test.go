@@ -2,12 +2,14 @@ package socket
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"log"
 	"log/syslog"
 	"net"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -383,3 +385,74 @@ func TestSocketReaderClosePanic(t *testing.T) {
 	assert.Equal(t, "", line)
 	sysLog.Emerg("this is OK")
 }
+
+func frame(payload string) []byte {
+	b := make([]byte, frameHeaderSize, frameHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(b, uint32(len(payload)))
+	return append(b, payload...)
+}
+
+func TestSocketReaderLengthPrefix(t *testing.T) {
+	binaryMsg := "\x00\xff\n\x20\r\n"
+	for _, address := range []string{"tcp://127.0.0.1:5142", "udp://127.0.0.1:5143"} {
+		logkitConf := conf.MapConf{
+			KeyMetaPath:             MetaDir,
+			KeyFileDone:             MetaDir,
+			KeyRunnerName:           "TestSocketReaderLengthPrefix",
+			KeyMode:                 ModeSocket,
+			KeySocketServiceAddress: address,
+			KeySocketRule:           SocketRuleLengthPrefix,
+			KeySocketSplitByLine:    "true",
+			// 二进制消息不做编码转换
+			KeyEncoding: "gbk",
+		}
+		meta, err := reader.NewMetaWithConf(logkitConf)
+		assert.NoError(t, err)
+
+		ssr, err := NewReader(meta, logkitConf)
+		assert.NoError(t, err)
+		sr := ssr.(*Reader)
+		assert.NoError(t, sr.Start())
+
+		u := strings.SplitN(address, "://", 2)
+		conn, err := net.Dial(u[0], u[1])
+		assert.NoError(t, err)
+		data := append(frame(binaryMsg), frame("中文")...)
+		if u[0] == "tcp" {
+			// 一个消息可能被分成多次发送
+			_, err = conn.Write(data[:3])
+			assert.NoError(t, err)
+			time.Sleep(10 * time.Millisecond)
+			_, err = conn.Write(data[3:])
+		} else {
+			_, err = conn.Write(data)
+		}
+		assert.NoError(t, err)
+
+		line, err := sr.ReadLine()
+		assert.NoError(t, err)
+		assert.Equal(t, binaryMsg, line, address)
+		assert.Contains(t, sr.Source(), "127.0.0.1")
+		line, err = sr.ReadLine()
+		assert.NoError(t, err)
+		assert.Equal(t, "中文", line, address)
+
+		conn.Close()
+		assert.NoError(t, sr.Close())
+		os.RemoveAll(MetaDir)
+	}
+}
+
+func TestSplitFrames(t *testing.T) {
+	frames, err := splitFrames(append(frame("a"), frame("")...), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", ""}, frames)
+
+	frames, err = splitFrames(append(frame("a"), 0, 0), 10)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"a"}, frames)
+	_, err = splitFrames(frame("too long"), 4)
+	assert.Error(t, err)
+	_, err = splitFrames(frame("abc")[:5], 10)
+	assert.Error(t, err)
+}
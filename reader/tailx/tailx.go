@@ -24,11 +24,13 @@ import (
 )
 
 var (
-	_ reader.DaemonReader = &Reader{}
-	_ reader.StatsReader  = &Reader{}
-	_ reader.LagReader    = &Reader{}
-	_ reader.Reader       = &Reader{}
-	_ Resetable           = &Reader{}
+	_ reader.AckReader       = &Reader{}
+	_ reader.DaemonReader    = &Reader{}
+	_ reader.StatsReader     = &Reader{}
+	_ reader.LagReader       = &Reader{}
+	_ reader.MultilineSetter = &Reader{}
+	_ reader.Reader          = &Reader{}
+	_ Resetable              = &Reader{}
 )
 
 func init() {
@@ -51,6 +53,7 @@ type Reader struct {
 	armapmux    sync.Mutex
 	currentFile string
 	headRegexp  *regexp.Regexp
+	multiline   *reader.MultilineConfig
 	cacheMap    map[string]string
 
 	//以下为传入参数
@@ -63,6 +66,7 @@ type Reader struct {
 	whence               string
 
 	notFirstTime bool
+	ack          bool // 确认模式下读取位置由 Commit 保存
 }
 
 type ActiveReader struct {
@@ -94,7 +98,7 @@ func NewActiveReader(originPath, realPath, whence string, notFirstTime bool, met
 		rpath = strings.Replace(rpath, ":", "_", -1)
 	}
 	subMetaPath := filepath.Join(meta.Dir, rpath)
-	subMeta, err := reader.NewMeta(subMetaPath, subMetaPath, realPath, ModeFile, meta.TagFile, reader.DefautFileRetention)
+	subMeta, err := meta.NewSubMeta(subMetaPath, realPath, ModeFile)
 	if err != nil {
 		return nil, err
 	}
@@ -216,14 +220,14 @@ func (ar *ActiveReader) Run() {
 			if ar.readcache == "" {
 				ar.emptyLineCnt++
 				//文件EOF，同时没有任何内容，代表不是第一次EOF，休息时间设置长一些
-				if err == io.EOF {
+				if err == io.EOF && !ar.br.MultilinePending() {
 					atomic.StoreInt32(&ar.inactive, 1)
 					log.Debugf("Runner[%v] %v meet EOF, ActiveReader was inactive now, stop it", ar.runnerName, ar.originpath)
 					ar.Stop()
 					return
 				}
-				// 3s 没读到内容，设置为inactive
-				if ar.emptyLineCnt > 3 {
+				// 3s 没读到内容，设置为inactive，有正在合并的多行时等待超时输出
+				if ar.emptyLineCnt > 3 && !ar.br.MultilinePending() {
 					atomic.StoreInt32(&ar.inactive, 1)
 					log.Debugf("Runner[%v] %v meet EOF, ActiveReader was inactive now, stop it", ar.runnerName, ar.originpath)
 					ar.Stop()
@@ -321,6 +325,23 @@ func (ar *ActiveReader) SyncMeta() string {
 	return ar.readcache
 }
 
+// activePosition 为 ActiveReader 的读取位置，readcache 为已经读取但尚未发送的一行
+type activePosition struct {
+	ar        *ActiveReader
+	pos       reader.Position
+	readcache string
+}
+
+func (p activePosition) String() string {
+	return fmt.Sprint(p.pos)
+}
+
+func (ar *ActiveReader) position() activePosition {
+	ar.cacheLineMux.Lock()
+	defer ar.cacheLineMux.Unlock()
+	return activePosition{ar: ar, pos: ar.br.Position(), readcache: ar.readcache}
+}
+
 func (ar *ActiveReader) expired(expire time.Duration) bool {
 	// 如果过期时间为 0，则永不过期
 	if expire.Nanoseconds() == 0 {
@@ -443,6 +464,11 @@ func (r *Reader) SetMode(mode string, value interface{}) error {
 	return nil
 }
 
+func (r *Reader) SetMultiline(c *reader.MultilineConfig) error {
+	r.multiline = c
+	return nil
+}
+
 func (r *Reader) setStatsError(err string) {
 	r.statsLock.Lock()
 	defer r.statsLock.Unlock()
@@ -555,6 +581,13 @@ func (r *Reader) statLogPath() {
 			continue
 		}
 		ar.readcache = cacheline
+		if r.ack {
+			if err = ar.br.EnableAck(); err != nil {
+				log.Errorf("Runner[%v] NewActiveReader for matches %v EnableAck error %v", r.meta.RunnerName, rp, err)
+				ar.Close()
+				continue
+			}
+		}
 		if r.headRegexp != nil {
 			err = ar.br.SetMode(ReadModeHeadPatternRegexp, r.headRegexp)
 			if err != nil {
@@ -562,6 +595,12 @@ func (r *Reader) statLogPath() {
 				r.setStatsError("Runner[" + r.meta.RunnerName + "] NewActiveReader for matches " + rp + " SetMode error " + err.Error())
 			}
 		}
+		if r.multiline != nil {
+			if err = ar.br.SetMultiline(r.multiline); err != nil {
+				log.Errorf("Runner[%v] NewActiveReader for matches %v SetMultiline error %v", r.meta.RunnerName, rp, err)
+				r.setStatsError("Runner[" + r.meta.RunnerName + "] NewActiveReader for matches " + rp + " SetMultiline error " + err.Error())
+			}
+		}
 		newaddsPath = append(newaddsPath, rp)
 		r.armapmux.Lock()
 		if !r.hasStopped() && !r.isStopping() {
@@ -700,6 +739,9 @@ func (r *Reader) Lag() (*LagInfo, error) {
 
 // SyncMeta 从队列取数据时同步队列，作用在于保证数据不重复
 func (r *Reader) SyncMeta() {
+	if r.ack {
+		return
+	}
 	ars := r.getActiveReaders()
 	for _, ar := range ars {
 		readcache := ar.SyncMeta()
@@ -728,6 +770,59 @@ func (r *Reader) SyncMeta() {
 	}
 }
 
+func (r *Reader) EnableAck() error {
+	r.ack = true
+	return nil
+}
+
+func (r *Reader) Position() reader.Position {
+	var positions []activePosition
+	for _, ar := range r.getActiveReaders() {
+		positions = append(positions, ar.position())
+	}
+	return positions
+}
+
+// Commit 保存各个文件的读取位置，已经过期回收的文件不再保存
+func (r *Reader) Commit(pos reader.Position) error {
+	positions, ok := pos.([]activePosition)
+	if !ok {
+		return fmt.Errorf("invalid position type %T", pos)
+	}
+	for _, p := range positions {
+		r.armapmux.Lock()
+		active := r.fileReaders[p.ar.realpath] == p.ar
+		r.armapmux.Unlock()
+		if !active {
+			continue
+		}
+		if err := p.ar.br.Commit(p.pos); err != nil {
+			return err
+		}
+		r.armapmux.Lock()
+		if p.readcache != "" {
+			r.cacheMap[p.ar.realpath] = p.readcache
+		} else {
+			delete(r.cacheMap, p.ar.realpath)
+		}
+		r.armapmux.Unlock()
+	}
+	r.armapmux.Lock()
+	buf, err := jsoniter.Marshal(r.cacheMap)
+	r.armapmux.Unlock()
+	if err != nil {
+		return err
+	}
+	if err = r.meta.WriteBuf(buf, 0, 0, len(buf)); err != nil {
+		return err
+	}
+
+	if IsSubMetaExpire(r.submetaExpire, r.expire) {
+		r.meta.CleanExpiredSubMetas(r.submetaExpire)
+	}
+	return nil
+}
+
 func (r *Reader) Close() error {
 	if !atomic.CompareAndSwapInt32(&r.status, StatusRunning, StatusStopping) {
 		log.Warnf("Runner[%v] reader %q is not running, close operation ignored", r.meta.RunnerName, r.Name())
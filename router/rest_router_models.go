@@ -1,6 +1,8 @@
 package router
 
 import (
+	"sort"
+
 	. "github.com/qiniu/logkit/utils/models"
 )
 
@@ -27,6 +29,16 @@ func GetRouterOption() []Option {
 			DefaultNoUse:  true,
 			Description:   "选择值匹配方式(router_match_type)",
 		},
+		{
+			KeyName:      RouterRules,
+			ChooseOnly:   false,
+			Default:      "",
+			Required:     false,
+			DefaultNoUse: true,
+			Placeholder:  `[{"conditions":[{"key":"type","match_type":"equal","value":"access"},{"key":"status","match_type":"gte","value":"500"}],"senders":[0,1],"continue":false}]`,
+			Description:  "有序路由规则(router_rules)",
+			ToolTip:      "按顺序匹配，一条规则内的所有条件同时满足时发往 senders 中的每一个 sender，continue 为 true 时继续匹配后续规则，可用的匹配方式见 router usage",
+		},
 		{
 			KeyName:      RouterDefaultIndex,
 			ChooseOnly:   false,
@@ -38,14 +50,16 @@ func GetRouterOption() []Option {
 	}
 }
 
+// GetRouterMatchTypeUsage 返回路由规则支持的所有匹配方式，router_match_type 只支持其中的 equal 和 contains
 func GetRouterMatchTypeUsage() KeyValueSlice {
-	mTypeUsage := make(KeyValueSlice, 0)
-	for name, mType := range MatchTypeRegistry {
-		mTypeFunc := mType()
+	mTypeUsage := make(KeyValueSlice, 0, len(ConditionTypeRegistry))
+	for name, conditionType := range ConditionTypeRegistry {
 		mTypeUsage = append(mTypeUsage, KeyValue{
-			Key:   name,
-			Value: mTypeFunc.usage(),
+			Key:     name,
+			Value:   conditionType.Usage,
+			SortKey: name,
 		})
 	}
+	sort.Stable(mTypeUsage)
 	return mTypeUsage
 }
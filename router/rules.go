@@ -0,0 +1,306 @@
+package router
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	RouterRules = "router_rules"
+
+	MTypeNotEqualName  = "not_equal"
+	MTypeRegexName     = "regex"
+	MTypeGtName        = "gt"
+	MTypeGteName       = "gte"
+	MTypeLtName        = "lt"
+	MTypeLteName       = "lte"
+	MTypeExistsName    = "exists"
+	MTypeNotExistsName = "not_exists"
+	MTypeInName        = "in"
+)
+
+// ConditionConfig 对数据中某个字段的判断条件
+type ConditionConfig struct {
+	// Key 字段名，嵌套字段用 "." 分隔，如 a.b
+	Key       string `json:"key"`
+	MatchType string `json:"match_type"`
+	Value     string `json:"value"`
+	// Values 为 in 匹配方式的候选值列表
+	Values []string `json:"values"`
+	// Not 为 true 时对匹配结果取反
+	Not bool `json:"not"`
+	// literalKey 为 true 时 Key 整体作为一个字段名，不按 "." 拆分，用于兼容旧版 router_key_name
+	literalKey bool
+}
+
+// RuleConfig 一条路由规则，所有条件同时满足时将数据发往 Senders 中的每一个 sender
+type RuleConfig struct {
+	Conditions []ConditionConfig `json:"conditions"`
+	Senders    []int             `json:"senders"`
+	// Continue 为 true 时匹配成功后继续匹配后面的规则，否则在此规则停止
+	Continue bool `json:"continue"`
+}
+
+type condition struct {
+	keys    []string
+	not     bool
+	matcher matcher
+}
+
+type rule struct {
+	conditions []condition
+	senders    []int
+	next       bool
+}
+
+// matcher 判断字段值是否满足条件，exist 表示数据中是否存在该字段
+type matcher interface {
+	match(value interface{}, exist bool) bool
+}
+
+type ConditionType struct {
+	Usage string
+	New   func(conf ConditionConfig) (matcher, error)
+}
+
+// ConditionTypeRegistry 路由规则中支持的所有匹配方式
+var ConditionTypeRegistry = map[string]ConditionType{}
+
+type mTypeMatcher struct {
+	mType      mType
+	matchValue string
+}
+
+func (m *mTypeMatcher) match(value interface{}, exist bool) bool {
+	return exist && m.mType.isMatch(value, m.matchValue)
+}
+
+type regexMatcher struct {
+	re *regexp.Regexp
+}
+
+func (m *regexMatcher) match(value interface{}, exist bool) bool {
+	if !exist {
+		return false
+	}
+	str, ok := senderValueToString(value)
+	return ok && m.re.MatchString(str)
+}
+
+type numberMatcher struct {
+	number  float64
+	compare func(a, b float64) bool
+}
+
+func (m *numberMatcher) match(value interface{}, exist bool) bool {
+	if !exist {
+		return false
+	}
+	str, ok := senderValueToString(value)
+	if !ok {
+		return false
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	if err != nil {
+		return false
+	}
+	return m.compare(f, m.number)
+}
+
+type existsMatcher struct {
+	want bool
+}
+
+func (m *existsMatcher) match(_ interface{}, exist bool) bool {
+	return exist == m.want
+}
+
+type inMatcher struct {
+	values map[string]struct{}
+}
+
+func (m *inMatcher) match(value interface{}, exist bool) bool {
+	if !exist {
+		return false
+	}
+	str, ok := senderValueToString(value)
+	if !ok {
+		return false
+	}
+	_, ok = m.values[str]
+	return ok
+}
+
+func newMTypeCondition(name string) func(conf ConditionConfig) (matcher, error) {
+	return func(conf ConditionConfig) (matcher, error) {
+		return &mTypeMatcher{mType: MatchTypeRegistry[name](), matchValue: conf.Value}, nil
+	}
+}
+
+func newNumberCondition(compare func(a, b float64) bool) func(conf ConditionConfig) (matcher, error) {
+	return func(conf ConditionConfig) (matcher, error) {
+		number, err := strconv.ParseFloat(strings.TrimSpace(conf.Value), 64)
+		if err != nil {
+			return nil, fmt.Errorf("value %q of %v is not a number", conf.Value, conf.MatchType)
+		}
+		return &numberMatcher{number: number, compare: compare}, nil
+	}
+}
+
+func newCondition(conf ConditionConfig) (condition, error) {
+	if conf.Key == "" {
+		return condition{}, fmt.Errorf("condition key can not be empty")
+	}
+	conditionType, ok := ConditionTypeRegistry[conf.MatchType]
+	if !ok {
+		return condition{}, fmt.Errorf("match type %v is not support", conf.MatchType)
+	}
+	m, err := conditionType.New(conf)
+	if err != nil {
+		return condition{}, err
+	}
+	keys := []string{conf.Key}
+	if !conf.literalKey {
+		keys = strings.Split(conf.Key, ".")
+	}
+	return condition{keys: keys, not: conf.Not, matcher: m}, nil
+}
+
+func (c *condition) isMatch(data map[string]interface{}) bool {
+	value, exist := getValue(data, c.keys)
+	return c.matcher.match(value, exist) != c.not
+}
+
+func getValue(data map[string]interface{}, keys []string) (interface{}, bool) {
+	var cur interface{} = data
+	for _, k := range keys {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+func newRule(conf RuleConfig, senderCnt int) (rule, error) {
+	if len(conf.Senders) == 0 {
+		return rule{}, fmt.Errorf("senders of router rule can not be empty")
+	}
+	for _, index := range conf.Senders {
+		if index < 0 || index >= senderCnt {
+			return rule{}, fmt.Errorf("router rule error, sender %v is not exist", index)
+		}
+	}
+	r := rule{senders: conf.Senders, next: conf.Continue}
+	for _, cc := range conf.Conditions {
+		c, err := newCondition(cc)
+		if err != nil {
+			return rule{}, fmt.Errorf("router rule error, %v", err)
+		}
+		r.conditions = append(r.conditions, c)
+	}
+	return r, nil
+}
+
+func (r *rule) isMatch(data map[string]interface{}) bool {
+	for i := range r.conditions {
+		if !r.conditions[i].isMatch(data) {
+			return false
+		}
+	}
+	return true
+}
+
+// legacyRules 将旧版单字段路由转换为有序规则，按匹配值排序以保证多个值都匹配时结果固定
+func legacyRules(conf RouterConfig) []RuleConfig {
+	values := make([]string, 0, len(conf.Routes))
+	for val := range conf.Routes {
+		values = append(values, val)
+	}
+	sort.Strings(values)
+	rules := make([]RuleConfig, 0, len(values))
+	for _, val := range values {
+		rules = append(rules, RuleConfig{
+			Conditions: []ConditionConfig{{Key: conf.KeyName, MatchType: conf.MatchType, Value: val, literalKey: true}},
+			Senders:    []int{conf.Routes[val]},
+		})
+	}
+	return rules
+}
+
+func init() {
+	ConditionTypeRegistry = map[string]ConditionType{
+		MTypeEqualName: {
+			Usage: (&MTypeEqual{}).usage(),
+			New:   newMTypeCondition(MTypeEqualName),
+		},
+		MTypeContainsName: {
+			Usage: (&MTypeContains{}).usage(),
+			New:   newMTypeCondition(MTypeContainsName),
+		},
+		MTypeNotEqualName: {
+			Usage: "值不相等时转发",
+			New: func(conf ConditionConfig) (matcher, error) {
+				conf.Not = !conf.Not
+				return newMTypeCondition(MTypeEqualName)(conf)
+			},
+		},
+		MTypeRegexName: {
+			Usage: "值匹配正则表达式时转发",
+			New: func(conf ConditionConfig) (matcher, error) {
+				re, err := regexp.Compile(conf.Value)
+				if err != nil {
+					return nil, fmt.Errorf("compile regex %q error %v", conf.Value, err)
+				}
+				return &regexMatcher{re: re}, nil
+			},
+		},
+		MTypeGtName: {
+			Usage: "数值大于该值时转发",
+			New:   newNumberCondition(func(a, b float64) bool { return a > b }),
+		},
+		MTypeGteName: {
+			Usage: "数值大于等于该值时转发",
+			New:   newNumberCondition(func(a, b float64) bool { return a >= b }),
+		},
+		MTypeLtName: {
+			Usage: "数值小于该值时转发",
+			New:   newNumberCondition(func(a, b float64) bool { return a < b }),
+		},
+		MTypeLteName: {
+			Usage: "数值小于等于该值时转发",
+			New:   newNumberCondition(func(a, b float64) bool { return a <= b }),
+		},
+		MTypeExistsName: {
+			Usage: "字段存在时转发",
+			New: func(ConditionConfig) (matcher, error) {
+				return &existsMatcher{want: true}, nil
+			},
+		},
+		MTypeNotExistsName: {
+			Usage: "字段不存在时转发",
+			New: func(ConditionConfig) (matcher, error) {
+				return &existsMatcher{want: false}, nil
+			},
+		},
+		MTypeInName: {
+			Usage: "值在 values 列表中时转发",
+			New: func(conf ConditionConfig) (matcher, error) {
+				if len(conf.Values) == 0 {
+					return nil, fmt.Errorf("values of %v can not be empty", MTypeInName)
+				}
+				values := make(map[string]struct{}, len(conf.Values))
+				for _, v := range conf.Values {
+					values[v] = struct{}{}
+				}
+				return &inMatcher{values: values}, nil
+			},
+		},
+	}
+}
@@ -0,0 +1,122 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestRouterRules(t *testing.T) {
+	conf := RouterConfig{
+		DefaultIndex: 0,
+		Rules: []RuleConfig{
+			{
+				Conditions: []ConditionConfig{
+					{Key: "type", MatchType: MTypeEqualName, Value: "access"},
+					{Key: "status", MatchType: MTypeGteName, Value: "500"},
+				},
+				Senders: []int{1, 2},
+			},
+			{
+				Conditions: []ConditionConfig{
+					{Key: "req.path", MatchType: MTypeRegexName, Value: "^/api/"},
+				},
+				Senders:  []int{1},
+				Continue: true,
+			},
+			{
+				Conditions: []ConditionConfig{
+					{Key: "level", MatchType: MTypeInName, Values: []string{"WARN", "ERROR"}},
+					{Key: "trace_id", MatchType: MTypeExistsName, Not: true},
+				},
+				Senders: []int{2},
+			},
+			{
+				Conditions: []ConditionConfig{
+					{Key: "level", MatchType: MTypeNotExistsName},
+				},
+				Senders: []int{3},
+			},
+		},
+	}
+	r, err := NewSenderRouter(conf, 4)
+	assert.NoError(t, err)
+	assert.True(t, r.HasRoutes())
+
+	tests := []struct {
+		data Data
+		exp  []int
+	}{
+		{data: Data{"type": "access", "status": 502, "level": "INFO"}, exp: []int{1, 2}},
+		{data: Data{"type": "access", "status": "404", "level": "INFO"}, exp: []int{0}},
+		{data: Data{"req": map[string]interface{}{"path": "/api/v1"}, "level": "ERROR"}, exp: []int{1, 2}},
+		{data: Data{"req": map[string]interface{}{"path": "/api/v1"}, "level": "ERROR", "trace_id": "x"}, exp: []int{1}},
+		{data: Data{"req": map[string]interface{}{"path": "/index"}, "level": "WARN"}, exp: []int{2}},
+		{data: Data{"msg": "no level"}, exp: []int{3}},
+	}
+	for _, ti := range tests {
+		assert.Equal(t, ti.exp, r.GetSenderIndexes(ti.data), "%v", ti.data)
+	}
+	assert.Equal(t, 1, r.GetSenderIndex(Data{"type": "access", "status": 502, "level": "INFO"}))
+}
+
+func TestRouterRulesInvalid(t *testing.T) {
+	invalid := []RuleConfig{
+		{Conditions: []ConditionConfig{{Key: "a", MatchType: MTypeEqualName, Value: "a"}}},
+		{Conditions: []ConditionConfig{{Key: "a", MatchType: MTypeEqualName, Value: "a"}}, Senders: []int{4}},
+		{Conditions: []ConditionConfig{{Key: "a", MatchType: "like", Value: "a"}}, Senders: []int{0}},
+		{Conditions: []ConditionConfig{{Key: "", MatchType: MTypeEqualName, Value: "a"}}, Senders: []int{0}},
+		{Conditions: []ConditionConfig{{Key: "a", MatchType: MTypeRegexName, Value: "("}}, Senders: []int{0}},
+		{Conditions: []ConditionConfig{{Key: "a", MatchType: MTypeLtName, Value: "abc"}}, Senders: []int{0}},
+		{Conditions: []ConditionConfig{{Key: "a", MatchType: MTypeInName}}, Senders: []int{0}},
+	}
+	for _, rc := range invalid {
+		r, err := NewSenderRouter(RouterConfig{Rules: []RuleConfig{rc}}, 2)
+		assert.Error(t, err)
+		assert.Nil(t, r)
+	}
+}
+
+func TestLegacyRoutesOrdered(t *testing.T) {
+	conf := RouterConfig{
+		KeyName:   "a",
+		MatchType: MTypeContainsName,
+		Routes: map[string]int{
+			"b":  2,
+			"ab": 1,
+			"c":  3,
+		},
+	}
+	r, err := NewSenderRouter(conf, 4)
+	assert.NoError(t, err)
+	// "abc" 同时匹配 ab、b、c，按匹配值排序后固定选择 ab
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, []int{1}, r.GetSenderIndexes(Data{"a": "abc"}))
+	}
+	assert.Equal(t, []int{0}, r.GetSenderIndexes(Data{"b": "abc"}))
+}
+
+func TestLegacyRoutesDottedKey(t *testing.T) {
+	conf := RouterConfig{
+		KeyName:   "app.name",
+		MatchType: MTypeEqualName,
+		Routes: map[string]int{
+			"web": 1,
+		},
+	}
+	r, err := NewSenderRouter(conf, 2)
+	assert.NoError(t, err)
+	// 旧版 router_key_name 是顶层字段名，不当作嵌套路径
+	assert.Equal(t, []int{1}, r.GetSenderIndexes(Data{"app.name": "web"}))
+	assert.Equal(t, []int{0}, r.GetSenderIndexes(Data{"app": map[string]interface{}{"name": "web"}}))
+}
+
+func TestGetRouterMatchTypeUsage(t *testing.T) {
+	usages := GetRouterMatchTypeUsage()
+	assert.Equal(t, len(ConditionTypeRegistry), len(usages))
+	for _, u := range usages {
+		assert.NotEmpty(t, u.Value, u.Key)
+	}
+}
@@ -28,6 +28,8 @@ type RouterConfig struct {
 	MatchType    string         `json:"router_match_type"`
 	DefaultIndex int            `json:"router_default_sender"`
 	Routes       map[string]int `json:"router_routes"`
+	// Rules 有序的路由规则，按顺序匹配，优先于 KeyName 方式配置的路由
+	Rules []RuleConfig `json:"router_rules"`
 }
 
 type Router struct {
@@ -35,41 +37,79 @@ type Router struct {
 	matchType    mType          // 匹配模式，如 完全相同，包含 等
 	defaultIndex int            // 默认 sender
 	routes       map[string]int // value1: sender1, value2: sender2
+	rules        []rule         // 按顺序匹配的路由规则，包含由 routes 转换而来的规则
 }
 
+// GetSenderIndex 返回数据匹配到的第一个 sender
 func (r *Router) GetSenderIndex(data Data) int {
-	if d, exist := data[r.key]; exist {
-		for matchValue, index := range r.routes {
-			if r.matchType.isMatch(d, matchValue) {
-				return index
+	return r.GetSenderIndexes(data)[0]
+}
+
+// GetSenderIndexes 按顺序匹配规则，返回数据需要发往的所有 sender，没有匹配的规则时返回默认 sender
+func (r *Router) GetSenderIndexes(data Data) []int {
+	var indexes []int
+	for i := range r.rules {
+		if !r.rules[i].isMatch(data) {
+			continue
+		}
+		if indexes == nil && !r.rules[i].next {
+			return r.rules[i].senders
+		}
+		for _, index := range r.rules[i].senders {
+			if !containsIndex(indexes, index) {
+				indexes = append(indexes, index)
 			}
 		}
+		if !r.rules[i].next {
+			break
+		}
+	}
+	if len(indexes) == 0 {
+		return []int{r.defaultIndex}
+	}
+	return indexes
+}
+
+func containsIndex(indexes []int, index int) bool {
+	for _, v := range indexes {
+		if v == index {
+			return true
+		}
 	}
-	return r.defaultIndex
+	return false
 }
 
 func NewSenderRouter(conf RouterConfig, senderCnt int) (*Router, error) {
 	keyName := conf.KeyName
-	if keyName == "" {
-		log.Debug("route key name is empty, ignored it")
+	if keyName == "" && len(conf.Rules) == 0 {
+		log.Debug("route key name and rules are empty, ignored it")
 		return nil, nil
 	}
 	defaultIndex := conf.DefaultIndex
 	if defaultIndex >= senderCnt {
 		return nil, fmt.Errorf("router default match error, sender %v is not exist", defaultIndex)
 	}
+	r := &Router{
+		key:          keyName,
+		defaultIndex: defaultIndex,
+	}
+	for _, rc := range conf.Rules {
+		ru, err := newRule(rc, senderCnt)
+		if err != nil {
+			return nil, err
+		}
+		r.rules = append(r.rules, ru)
+	}
+	if keyName == "" {
+		return r, nil
+	}
+
 	matchTypeName := conf.MatchType
 	matchTypeFunc, exist := MatchTypeRegistry[matchTypeName]
 	if !exist {
 		return nil, fmt.Errorf("router match type error, match Type %v is not support", matchTypeName)
 	}
-	matchType := matchTypeFunc()
-
-	r := &Router{
-		key:          keyName,
-		matchType:    matchType,
-		defaultIndex: defaultIndex,
-	}
+	r.matchType = matchTypeFunc()
 	routes := make(map[string]int)
 	for val, index := range conf.Routes {
 		if index >= senderCnt {
@@ -78,12 +118,19 @@ func NewSenderRouter(conf RouterConfig, senderCnt int) (*Router, error) {
 		routes[val] = index
 	}
 	r.routes = routes
+	for _, rc := range legacyRules(conf) {
+		ru, err := newRule(rc, senderCnt)
+		if err != nil {
+			return nil, err
+		}
+		r.rules = append(r.rules, ru)
+	}
 	return r, nil
 }
 
 // HasRoutes 当有实际路由时返回 true，否则返回 false
 func (r *Router) HasRoutes() bool {
-	return len(r.routes) > 0
+	return len(r.rules) > 0
 }
 
 type MatchType func() mType
@@ -0,0 +1,74 @@
+package sender
+
+import (
+	"sync"
+	"time"
+)
+
+// ftAck 记录一次 SendWithAck 调用尚未释放的引用，调用本身以及队列中的每个 datasContext 各持有一个引用
+type ftAck struct {
+	refs int
+	ack  func()
+}
+
+// ftAcks 跟踪 FtSender 中带确认标记的数据，epoch 为 FtSender 实例创建的时间
+// 队列中其他 epoch 的数据没有被确认，对应的读取位置没有保存，重启后会被 reader 重新读取，因此直接丢弃
+type ftAcks struct {
+	mux   sync.Mutex
+	epoch int64
+	seq   int64
+	acks  map[int64]*ftAck
+}
+
+func newFtAcks() *ftAcks {
+	return &ftAcks{
+		epoch: time.Now().UnixNano(),
+		acks:  make(map[int64]*ftAck),
+	}
+}
+
+// begin 开始一次调用，返回的 seq 持有一个引用
+func (a *ftAcks) begin(ack func()) int64 {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.seq++
+	a.acks[a.seq] = &ftAck{refs: 1, ack: ack}
+	return a.seq
+}
+
+// tag 为 ctx 加上 seq 的确认标记并增加一个引用，seq 为 0 表示不需要确认
+func (a *ftAcks) tag(ctx *datasContext, seq int64) {
+	if seq == 0 {
+		return
+	}
+	ctx.AckEpoch, ctx.AckSeq = a.epoch, seq
+	a.mux.Lock()
+	a.acks[seq].refs++
+	a.mux.Unlock()
+}
+
+// done 释放 seq 的一个引用，引用全部释放后调用 ack
+func (a *ftAcks) done(seq int64) {
+	if seq == 0 {
+		return
+	}
+	a.mux.Lock()
+	fa, ok := a.acks[seq]
+	if ok {
+		fa.refs--
+		if fa.refs > 0 {
+			ok = false
+		} else {
+			delete(a.acks, seq)
+		}
+	}
+	a.mux.Unlock()
+	if ok {
+		fa.ack()
+	}
+}
+
+// stale 判断 ctx 是否为其他 FtSender 实例写入的带确认标记的数据
+func (a *ftAcks) stale(ctx *datasContext) bool {
+	return ctx.AckEpoch != 0 && ctx.AckEpoch != a.epoch
+}
@@ -12,5 +12,6 @@ import (
 	_ "github.com/qiniu/logkit/sender/mongodb"
 	_ "github.com/qiniu/logkit/sender/mysql"
 	_ "github.com/qiniu/logkit/sender/pandora"
+	_ "github.com/qiniu/logkit/sender/parquet"
 	_ "github.com/qiniu/logkit/sender/sqlfile"
 )
@@ -21,6 +21,7 @@ var ModeUsages = KeyValueSlice{
 	{TypeMySQL, "Mysql服务", ""},
 	{TypeSQLFile, "SqlFile文件", ""},
 	{TypeCSV, "CSV文件", ""},
+	{TypeParquet, "Parquet文件", ""},
 }
 
 var (
@@ -172,6 +173,54 @@ var (
 		CheckRegex:   "\\d+",
 		ToolTip:      `可选项，默认值为-1，表示不限速，单位为条/秒，比如填写1000，则表示每秒限制发送1000条`,
 	}
+	OptionRotateMaxAge = Option{
+		KeyName:      KeyRotateMaxAge,
+		ChooseOnly:   false,
+		Default:      "",
+		DefaultNoUse: false,
+		Description:  "文件最长写入时间(rotate_max_age)",
+		Advance:      true,
+		ToolTip:      `如 1h，文件写入超过该时间后切割，开启滚动策略后正在写入的文件带有 .tmp 后缀，关闭时重命名为最终的文件名`,
+	}
+	OptionRotateCompress = Option{
+		KeyName:       KeyRotateCompress,
+		Element:       Radio,
+		ChooseOnly:    true,
+		ChooseOptions: []interface{}{"", "gzip", "zstd"},
+		Default:       "",
+		DefaultNoUse:  false,
+		Description:   "切割后的文件压缩方式(rotate_compress)",
+		Advance:       true,
+	}
+	OptionRotateMaxBackups = Option{
+		KeyName:      KeyRotateMaxBackups,
+		ChooseOnly:   false,
+		Default:      "0",
+		DefaultNoUse: false,
+		Description:  "最多保留的文件个数(rotate_max_backups)",
+		Advance:      true,
+		CheckRegex:   "\\d+",
+		ToolTip:      `0 表示不限制，超过时删除最旧的已完成文件`,
+	}
+	OptionRotateMaxTotalSize = Option{
+		KeyName:      KeyRotateMaxTotalSize,
+		ChooseOnly:   false,
+		Default:      "0",
+		DefaultNoUse: false,
+		Description:  "最多保留的文件总大小(rotate_max_total_size)",
+		Advance:      true,
+		CheckRegex:   "\\d+",
+		ToolTip:      `单位为字节，0 表示不限制，超过时删除最旧的已完成文件`,
+	}
+	OptionRotateRetention = Option{
+		KeyName:      KeyRotateRetention,
+		ChooseOnly:   false,
+		Default:      "",
+		DefaultNoUse: false,
+		Description:  "文件保留时间(rotate_retention)",
+		Advance:      true,
+		ToolTip:      `如 168h，删除修改时间早于该时间的已完成文件`,
+	}
 )
 
 var ModeKeyOptions = map[string][]Option{
@@ -216,6 +265,21 @@ var ModeKeyOptions = map[string][]Option{
 			Description:  "文件Partition切分数量(file_partition)",
 			ToolTip:      `文件Partition切分可以指定路径写入`,
 		},
+		{
+			KeyName:      KeyRotateMaxSize,
+			ChooseOnly:   false,
+			Default:      "0",
+			DefaultNoUse: false,
+			Description:  "文件切割大小(rotate_max_size)",
+			Advance:      true,
+			CheckRegex:   "\\d+",
+			ToolTip:      `单位为字节，0 表示不按大小切割，切割后的文件名在扩展名前添加序号，如 data.1.log`,
+		},
+		OptionRotateMaxAge,
+		OptionRotateCompress,
+		OptionRotateMaxBackups,
+		OptionRotateMaxTotalSize,
+		OptionRotateRetention,
 		OptionFtProcs,
 	},
 	TypePandora: {
@@ -1093,6 +1157,11 @@ var ModeKeyOptions = map[string][]Option{
 			CheckRegex:   "\\d+",
 			ToolTip:      `默认为10485760（10MB），表示当sqlfile文件大小超过指定大小时将触发该文件的切割，继续写入到下一个sqlfile文件`,
 		},
+		OptionRotateMaxAge,
+		OptionRotateCompress,
+		OptionRotateMaxBackups,
+		OptionRotateMaxTotalSize,
+		OptionRotateRetention,
 		OptionMaxSendRate,
 	},
 	TypeCSV: {
@@ -1136,8 +1205,63 @@ var ModeKeyOptions = map[string][]Option{
 			CheckRegex:   "\\d+",
 			ToolTip:      `默认为10485760（10MB），表示当csv文件大小超过指定大小时将触发该文件的切割，继续写入到下一个csv文件`,
 		},
+		OptionRotateMaxAge,
+		OptionRotateCompress,
+		OptionRotateMaxBackups,
+		OptionRotateMaxTotalSize,
+		OptionRotateRetention,
 		OptionMaxSendRate,
 	},
+	TypeParquet: {
+		{
+			KeyName:      KeyParquetPath,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "/home/user/logkit/%Y%m%d%H.parquet",
+			DefaultNoUse: true,
+			Required:     true,
+			Description:  "发送到指定文件(parquet_path)",
+			ToolTip:      `路径支持 strftime 的时间格式，路径变化时结束当前文件并写入新的文件，正在写入的文件带有 .tmp 后缀`,
+		},
+		{
+			KeyName:      KeyParquetSchema,
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "a long, b string, c float, d date, e bool",
+			DefaultNoUse: false,
+			Description:  "文件的列和类型(parquet_schema)",
+			ToolTip:      `格式与 csv 解析器的 csv_schema 相同，另外支持 bool 类型，不在 schema 中的字段被忽略。不填时根据数据推断，出现新的字段时切换到新的文件；使用 csv 解析器时默认使用 csv_schema`,
+		},
+		{
+			KeyName:       KeyParquetCompression,
+			Element:       Radio,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"snappy", "zstd", "gzip", "none"},
+			Default:       "snappy",
+			DefaultNoUse:  false,
+			Description:   "压缩方式(parquet_compression)",
+		},
+		{
+			KeyName:      KeyParquetRowGroupSize,
+			ChooseOnly:   false,
+			Default:      strconv.Itoa(32 * MB),
+			DefaultNoUse: false,
+			Description:  "row group 大小(parquet_row_group_size)",
+			Advance:      true,
+			CheckRegex:   "\\d+",
+			ToolTip:      `单位为字节，数据在内存中缓存到该大小后写入一个 row group`,
+		},
+		{
+			KeyName:      KeyParquetFileSize,
+			ChooseOnly:   false,
+			Default:      strconv.Itoa(512 * MB),
+			DefaultNoUse: false,
+			Description:  "文件切割大小(parquet_file_size)",
+			Advance:      true,
+			CheckRegex:   "\\d+",
+			ToolTip:      `单位为字节，文件超过该大小后写入新的文件，0 表示不限制`,
+		},
+	},
 	TypeMySQL: {
 		{
 			KeyName:      KeyMySQLDataSource,
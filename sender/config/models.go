@@ -88,9 +88,12 @@ const (
 	TypeMySQL             = "mysql"
 	TypeCSV               = "csv"
 	TypeSQLFile           = "sqlfile"
+	TypeParquet           = "parquet"
 
 	InnerUserAgent = "_useragent"
 	InnerSendRaw   = "_send_raw"
+	// InnerParquetTypeHints 为 parquet sender 推断 schema 时使用的解析器字段类型，格式与 csv_schema 相同
+	InnerParquetTypeHints = "_parquet_type_hints"
 )
 
 const (
@@ -238,6 +241,21 @@ const (
 	KeySQLFileRotateSize = "sqlfile_rotate_size"
 	KeySQLFileTable      = "sqlfile_table"
 	KeySQLFilePathPrefix = "sqlfile_path_prefix"
+
+	// parquet
+	KeyParquetPath         = "parquet_path"
+	KeyParquetSchema       = "parquet_schema"
+	KeyParquetCompression  = "parquet_compression"
+	KeyParquetRowGroupSize = "parquet_row_group_size"
+	KeyParquetFileSize     = "parquet_file_size"
+
+	// file、csv、sqlfile 共用的文件滚动、压缩和保留策略
+	KeyRotateMaxSize      = "rotate_max_size"
+	KeyRotateMaxAge       = "rotate_max_age"
+	KeyRotateCompress     = "rotate_compress"
+	KeyRotateMaxBackups   = "rotate_max_backups"
+	KeyRotateMaxTotalSize = "rotate_max_total_size"
+	KeyRotateRetention    = "rotate_retention"
 )
 
 // NotAsyncSender return when sender is not async
@@ -7,6 +7,7 @@ import (
 	"os"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/qiniu/pandora-go-sdk/base/ratelimit"
@@ -14,6 +15,7 @@ import (
 	"github.com/qiniu/logkit/conf"
 	"github.com/qiniu/logkit/sender"
 	. "github.com/qiniu/logkit/sender/config"
+	"github.com/qiniu/logkit/sender/file"
 	"github.com/qiniu/logkit/utils/models"
 )
 
@@ -35,6 +37,11 @@ type writer struct {
 	delimeter  string
 	rotateSize int64
 	pathPrefix string
+
+	// policy 不为 nil 时正在写入的文件带有 .tmp 后缀，关闭时重命名为 pathname 并按策略压缩和清理
+	policy   *file.RotatePolicy
+	pathname string
+	opened   time.Time
 }
 
 func (w *writer) Write(rawdata []models.Data) error {
@@ -103,6 +110,9 @@ func (w *writer) NeedRotate() bool {
 	if w.w == nil {
 		return true
 	}
+	if w.policy != nil && w.policy.NeedRotate(w.apprSize, w.opened) {
+		return true
+	}
 	return w.apprSize >= w.rotateSize
 }
 
@@ -123,7 +133,11 @@ func (w *writer) CreateFile() (*os.File, error) {
 	now := time.Now()
 	pathname := fmt.Sprintf("%s-%.2d%.2d%.2d%.2d%.2d", w.pathPrefix,
 		now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second())
-	return os.Create(pathname)
+	if w.policy == nil {
+		return os.Create(pathname)
+	}
+	w.pathname, w.opened = pathname, now
+	return w.policy.Open(pathname)
 }
 
 func (w *writer) Close() error {
@@ -131,6 +145,14 @@ func (w *writer) Close() error {
 		w.w.Flush()
 		w.w = nil
 	}
+	if w.policy != nil {
+		if w.file == nil {
+			return nil
+		}
+		f := w.file
+		w.file = nil
+		return w.policy.Complete(f, w.pathname)
+	}
 	if w.file != nil {
 		if err := w.file.Close(); err != nil {
 			return err
@@ -160,12 +182,21 @@ func NewSender(conf conf.MapConf) (s sender.Sender, err error) {
 		return
 	}
 	pathPrefix, _ := conf.GetStringOr(KeyCSVPathPrefix, defaultPathPrefix)
+	policy, err := file.NewRotatePolicy(conf)
+	if err != nil {
+		return
+	}
+	if policy != nil {
+		// 与 CreateFile 生成的文件名一致
+		policy.SetPattern(strings.Replace(pathPrefix, "%", "%%", -1)+"-%m%d%H%M%S", false)
+	}
 
 	w := &writer{
 		fields:     fields,
 		delimeter:  delimeter,
 		rotateSize: rotateSize,
 		pathPrefix: pathPrefix,
+		policy:     policy,
 	}
 	name, _ := conf.GetStringOr(KeyName, fmt.Sprintf("sqlfile(path_prefix:%s)", pathPrefix))
 	rate, _ := conf.GetInt64Or(KeyMaxSendRate, -1)
@@ -187,5 +218,9 @@ func (s *Sender) Name() string {
 
 func (s *Sender) Close() error {
 	s.limiter.Close()
-	return s.w.Close()
+	err := s.w.Close()
+	if s.w.policy != nil {
+		s.w.policy.Wait()
+	}
+	return err
 }
@@ -35,6 +35,7 @@ const (
 
 var _ SkipDeepCopySender = &FtSender{}
 var _ RawSender = &FtSender{}
+var _ AckSender = &FtSender{}
 
 // FtSender fault tolerance sender wrapper
 type FtSender struct {
@@ -53,6 +54,8 @@ type FtSender struct {
 	jsontool        jsoniter.API
 	pandoraKeyCache map[string]KeyInfo
 	discardErr      bool
+	deadLetter      DeadLetterHandler
+	acks            *ftAcks
 }
 
 type FtOption struct {
@@ -75,6 +78,9 @@ type FtOption struct {
 type datasContext struct {
 	Datas []Data   `json:"datas"`
 	Lines []string `json:"lines"`
+	// 以下为 SendWithAck 的确认标记
+	AckEpoch int64 `json:"ack_epoch,omitempty"`
+	AckSeq   int64 `json:"ack_seq,omitempty"`
 }
 
 // NewFtSender Fault tolerant sender constructor
@@ -188,6 +194,7 @@ func newFtSender(innerSender Sender, runnerName string, opt *FtOption) (*FtSende
 		statsMutex:  new(sync.RWMutex),
 		jsontool:    jsoniter.Config{EscapeHTML: true, UseNumber: true}.Froze(),
 		discardErr:  opt.discardErr,
+		acks:        newFtAcks(),
 	}
 
 	if opt.innerSenderType == TypePandora {
@@ -262,6 +269,17 @@ func (ft *FtSender) RawSend(datas []string) error {
 }
 
 func (ft *FtSender) Send(datas []Data) error {
+	return ft.send(datas, 0)
+}
+
+// SendWithAck 数据被 inner sender 发送成功或被丢弃后确认，进入队列的数据在从队列中发送完成后确认
+func (ft *FtSender) SendWithAck(datas []Data, ack func()) error {
+	seq := ft.acks.begin(ack)
+	defer ft.acks.done(seq)
+	return ft.send(datas, seq)
+}
+
+func (ft *FtSender) send(datas []Data, seq int64) error {
 	if ft.opt.sendRaw {
 		return errors.New("ft sender is initialized by send raw, can not use Send(), please use SendRaw")
 	}
@@ -279,10 +297,14 @@ func (ft *FtSender) Send(datas []Data) error {
 	if ft.strategy == KeyFtStrategyBackupOnly {
 		// 尝试直接发送数据，当数据失败的时候会加入到本地重试队列。外部不需要重试
 		isRetry := false
-		backDataContext, err := ft.trySendDatas(datas, 1, isRetry)
+		backDataContext, err := ft.trySendDatas(datas, 1, isRetry, seq)
 		if err == nil {
 			return nil
 		}
+		// 未放入队列的数据由外部重试，不再等待确认
+		for range backDataContext {
+			ft.acks.done(seq)
+		}
 
 		if ste, ok := err.(*StatsError); ok {
 			se.Errors = ste.Errors
@@ -313,7 +335,7 @@ func (ft *FtSender) Send(datas []Data) error {
 			}
 		}
 	} else {
-		err := ft.saveToFile(datas)
+		err := ft.saveToFile(datas, seq)
 		if err != nil {
 			se.FtNotRetry = false
 			if sendError, ok := err.(*reqerr.SendError); ok {
@@ -401,10 +423,9 @@ func (ft *FtSender) unmarshalRaws(dat []byte) (datas []string, err error) {
 }
 
 // marshalData 将数据序列化
-func (ft *FtSender) marshalData(datas []Data) ([]byte, error) {
-	bs, err := jsoniter.Marshal(&datasContext{
-		Datas: datas,
-	})
+func (ft *FtSender) marshalData(ctx *datasContext) ([]byte, error) {
+	datas := ctx.Datas
+	bs, err := jsoniter.Marshal(ctx)
 	if err != nil {
 		return nil, reqerr.NewSendError("Cannot marshal data :"+err.Error(), ConvertDatasBack(datas), reqerr.TypeDefault)
 	}
@@ -412,13 +433,13 @@ func (ft *FtSender) marshalData(datas []Data) ([]byte, error) {
 }
 
 // unmarshalData 如何将数据从磁盘中反序列化出来
-func (ft *FtSender) unmarshalData(dat []byte) (datas []Data, err error) {
+func (ft *FtSender) unmarshalData(dat []byte) (*datasContext, error) {
 	ctx := new(datasContext)
-	err = ft.jsontool.Unmarshal(dat, &ctx)
+	err := ft.jsontool.Unmarshal(dat, &ctx)
 	if err != nil {
 		return nil, err
 	}
-	return ctx.Datas, nil
+	return ctx, nil
 }
 
 func (ft *FtSender) saveRawToFile(datas []string) error {
@@ -436,18 +457,23 @@ func (ft *FtSender) saveRawToFile(datas []string) error {
 	return nil
 }
 
-func (ft *FtSender) saveToFile(datas []Data) error {
-	if dqueue, ok := ft.logQueue.(queue.DataQueue); ok {
+// saveToFile 需要确认的数据带上确认标记序列化后放入队列
+func (ft *FtSender) saveToFile(datas []Data, seq int64) error {
+	if dqueue, ok := ft.logQueue.(queue.DataQueue); ok && seq == 0 {
 		return dqueue.PutDatas(datas)
 	}
 
-	bs, err := ft.marshalData(datas)
+	ctx := &datasContext{Datas: datas}
+	ft.acks.tag(ctx, seq)
+	bs, err := ft.marshalData(ctx)
 	if err != nil {
+		ft.acks.done(seq)
 		return err
 	}
 
 	err = ft.logQueue.Put(bs)
 	if err != nil {
+		ft.acks.done(seq)
 		return reqerr.NewSendError(ft.innerSender.Name()+" Cannot put data into backendQueue: "+err.Error(), ConvertDatasBack(datas), TypeMarshalError)
 	}
 	return nil
@@ -487,11 +513,21 @@ func (ft *FtSender) trySendBytes(dat []byte, failSleep int, isRetry bool) (backD
 		}
 		return ft.trySendRaws(datas, failSleep, isRetry)
 	}
-	datas, err := ft.unmarshalData(dat)
+	ctx, err := ft.unmarshalData(dat)
 	if err != nil {
 		return nil, err
 	}
-	return ft.trySendDatas(datas, failSleep, isRetry)
+	if ft.acks.stale(ctx) {
+		log.Debugf("Runner[%v] Sender[%v] discard %d datas of previous ack epoch %v", ft.runnerName, ft.innerSender.Name(), len(ctx.Datas), ctx.AckEpoch)
+		return nil, nil
+	}
+	return ft.trySendContext(ctx, failSleep, isRetry)
+}
+
+// trySendContext 发送队列中的数据，发送完成后释放其确认引用，需要重试的数据带有新的引用
+func (ft *FtSender) trySendContext(ctx *datasContext, failSleep int, isRetry bool) (backDataContext []*datasContext, err error) {
+	defer ft.acks.done(ctx.AckSeq)
+	return ft.trySendDatas(ctx.Datas, failSleep, isRetry, ctx.AckSeq)
 }
 
 func (ft *FtSender) trySendRaws(datas []string, failSleep int, isRetry bool) (backDataContext []*datasContext, err error) {
@@ -528,7 +564,8 @@ func (ft *FtSender) trySendRaws(datas []string, failSleep int, isRetry bool) (ba
 
 // trySendDatas 尝试发送数据，如果失败，将失败数据加入backup queue，并睡眠指定时间。返回结果为是否正常发送
 // isRetry 只有在 FtStrategy 为 BackupOnly 或
-func (ft *FtSender) trySendDatas(datas []Data, failSleep int, isRetry bool) (backDataContext []*datasContext, err error) {
+// seq 不为 0 时失败数据带上确认标记，放入 backup queue 或返回的每个 datasContext 各持有一个引用
+func (ft *FtSender) trySendDatas(datas []Data, failSleep int, isRetry bool, seq int64) (backDataContext []*datasContext, err error) {
 	err = ft.innerSender.Send(datas)
 	dataLen := int64(0)
 	if datas != nil {
@@ -542,8 +579,10 @@ func (ft *FtSender) trySendDatas(datas []Data, failSleep int, isRetry bool) (bac
 
 	retDatasContext := ft.handleSendError(err, datas)
 	for _, v := range retDatasContext {
+		ft.acks.tag(v, seq)
 		nnBytes, err := jsoniter.Marshal(v)
 		if err != nil {
+			ft.acks.done(seq)
 			log.Errorf("Runner[%v] Sender[%v] marshal %v failed: %v", ft.runnerName, ft.innerSender.Name(), *v, err)
 			continue
 		}
@@ -647,6 +686,7 @@ func (ft *FtSender) handleRawSendError(err error, datas []string) (retDatasConte
 		//当数据仅有一条且还要binary Unpack时，只能丢弃
 		if len(failCtx.Lines) == 1 {
 			log.Infof("Runner[%s] Sender[%s] discard long data (more than 2M), length: %d", ft.runnerName, ft.innerSender.Name(), len(failCtx.Lines[0]))
+			ft.discard(nil, failCtx.Lines, err)
 		}
 		return retDatasContext
 	}
@@ -697,13 +737,14 @@ func (ft *FtSender) handleSendError(err error, datas []Data) (retDatasContext []
 		if len(failCtx.Datas) == 1 {
 			//当数据仅有一条且discardErr为true时，丢弃数据
 			if ft.discardErr {
+				ft.discard(failCtx.Datas, nil, err)
 				return nil
 			}
 			failCtxData := failCtx.Datas[0]
 			// 小于 2M 时，放入 pandora_stash中
-			dataBytes, err := jsoniter.Marshal(failCtxData)
-			if err != nil {
-				log.Errorf("binaryUnpack marshal failed, err: %v", err)
+			dataBytes, marshalErr := jsoniter.Marshal(failCtxData)
+			if marshalErr != nil {
+				log.Errorf("binaryUnpack marshal failed, err: %v", marshalErr)
 				retDatasContext = append(retDatasContext, failCtx)
 				return retDatasContext
 			}
@@ -728,6 +769,7 @@ func (ft *FtSender) handleSendError(err error, datas []Data) (retDatasContext []
 
 			if ft.opt.longDataDiscard {
 				log.Infof("Runner[%s] Sender[%s] discard long data (more than 2M), length: %d", ft.runnerName, ft.innerSender.Name(), len(string(dataBytes)))
+				ft.discard(failCtx.Datas, nil, err)
 				return retDatasContext
 			}
 
@@ -855,14 +897,14 @@ func (ft *FtSender) sendFromQueue(queueName string, readChan <-chan []byte, read
 			return
 		}
 		if curIdx < len(curDataContext) {
-			backDataContext, err = ft.trySendDatas(curDataContext[curIdx].Datas, numWaits, isRetry)
+			backDataContext, err = ft.trySendContext(curDataContext[curIdx], numWaits, isRetry)
 			curIdx++
 		} else {
 			select {
 			case bytes := <-readChan:
 				backDataContext, err = ft.trySendBytes(bytes, numWaits, isRetry)
 			case datas := <-readDatasChan:
-				backDataContext, err = ft.trySendDatas(datas, numWaits, isRetry)
+				backDataContext, err = ft.trySendDatas(datas, numWaits, isRetry, 0)
 			case <-timer.C:
 				continue
 			}
@@ -889,6 +931,17 @@ func (ft *FtSender) sendFromQueue(queueName string, readChan <-chan []byte, read
 	}
 }
 
+// SetDeadLetter 设置后，因 ft_discard_failed_data 或 ft_long_data_discard 被丢弃的数据交给 handler 处理
+func (ft *FtSender) SetDeadLetter(handler DeadLetterHandler) {
+	ft.deadLetter = handler
+}
+
+func (ft *FtSender) discard(datas []Data, raws []string, err error) {
+	if ft.deadLetter != nil {
+		ft.deadLetter(datas, raws, err)
+	}
+}
+
 func (ft *FtSender) SkipDeepCopy() bool {
 	ss, ok := ft.innerSender.(SkipDeepCopySender)
 	if ok {
@@ -106,6 +106,15 @@ func NewSender(conf conf.MapConf) (sender.Sender, error) {
 		return nil, err
 	}
 	s.partition = partition
+
+	policy, err := NewRotatePolicy(conf)
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil {
+		policy.SetPattern(path, partition > 1)
+		s.writers.policy = policy
+	}
 	return s, nil
 }
 
@@ -185,7 +194,11 @@ func (s *Sender) writeFile(filename string, datas []Data, wg *sync.WaitGroup) {
 }
 
 func (s *Sender) Close() error {
-	return s.writers.Close()
+	err := s.writers.Close()
+	if s.writers.policy != nil {
+		s.writers.policy.Wait()
+	}
+	return err
 }
 
 func getPartitionFolder(nowStr string, idx int) string {
@@ -0,0 +1,389 @@
+package file
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/sender/config"
+)
+
+const (
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+
+	// TmpSuffix 为开启滚动策略后正在写入或正在压缩的文件的后缀
+	TmpSuffix = ".tmp"
+	// rotatingSuffix 为等待压缩的文件的后缀
+	rotatingSuffix = ".rotating" + TmpSuffix
+)
+
+var (
+	strftimeVerbRegex = regexp.MustCompile(`(%.)+`)
+	cleanExtRegex     = regexp.MustCompile(`^\.[A-Za-z][A-Za-z0-9]{0,7}$`)
+
+	// strftimeRegexes 为 strftime 各个格式化符号输出内容的正则
+	strftimeRegexes = map[byte]string{
+		'A': `[A-Za-z]+`, 'a': `[A-Za-z]+`, 'B': `[A-Za-z]+`, 'b': `[A-Za-z]+`, 'h': `[A-Za-z]+`,
+		'C': `\d{2}`, 'd': `\d{2}`, 'H': `\d{2}`, 'M': `\d{2}`, 'm': `\d{2}`, 'S': `\d{2}`, 'y': `\d{2}`,
+		'U': `\d{2}`, 'V': `\d{2}`, 'W': `\d{2}`, 'Y': `\d{4}`, 'j': `\d{3}`, 'u': `\d`, 'w': `\d`,
+		'e': `[ \d]\d`, 'k': `[ \d]\d`, 'l': `[ \d]\d`, 'I': `\d{1,2}`, 'p': `[AP]M`,
+		'Z': `[A-Za-z0-9+-]+`, 'z': `[+-]\d{4}`, 'n': `\n`, 't': `\t`, '%': `%`,
+	}
+	// strftimeCompounds 为组合的格式化符号对应的基本符号
+	strftimeCompounds = map[byte]string{
+		'c': "%a %b %e %H:%M:%S %Y", 'D': "%m/%d/%y", 'F': "%Y-%m-%d", 'R': "%H:%M", 'r': "%I:%M:%S %p",
+		'T': "%H:%M:%S", 'v': "%e-%b-%Y", 'X': "%H:%M:%S", 'x': "%m/%d/%y",
+	}
+)
+
+// RotatePolicy 为 file、csv、sqlfile sender 共用的文件滚动、压缩和保留策略
+// 开启后正在写入的文件名带有 .tmp 后缀，关闭时原子地重命名为最终的文件名，下游只会看到已经写完的文件
+type RotatePolicy struct {
+	// MaxSize 和 MaxAge 为单个文件的最大字节数和最长写入时间，0 表示不限制
+	MaxSize  int64
+	MaxAge   time.Duration
+	Compress string
+	// MaxBackups、MaxTotalSize 和 Retention 为已完成文件的保留个数、总大小和时间，0 表示不限制
+	MaxBackups   int
+	MaxTotalSize int64
+	Retention    time.Duration
+
+	// glob 用于列出候选的已完成文件，只有完整路径匹配 match 的文件才会被删除，都通过 SetPattern 设置，为空时不执行保留策略
+	glob  string
+	match *regexp.Regexp
+
+	lock     sync.Mutex
+	reserved map[string]bool
+	wg       sync.WaitGroup
+}
+
+// NewRotatePolicy 从配置中解析滚动策略，没有配置任何滚动选项时返回 nil
+func NewRotatePolicy(c conf.MapConf) (*RotatePolicy, error) {
+	var (
+		p   = &RotatePolicy{reserved: make(map[string]bool)}
+		set bool
+		err error
+	)
+	for _, key := range []string{KeyRotateMaxSize, KeyRotateMaxAge, KeyRotateCompress, KeyRotateMaxBackups, KeyRotateMaxTotalSize, KeyRotateRetention} {
+		if v, _ := c.GetStringOr(key, ""); v != "" && v != "0" {
+			set = true
+		}
+	}
+	if !set {
+		return nil, nil
+	}
+	if p.MaxSize, err = getInt64(c, KeyRotateMaxSize); err != nil {
+		return nil, err
+	}
+	if p.MaxTotalSize, err = getInt64(c, KeyRotateMaxTotalSize); err != nil {
+		return nil, err
+	}
+	backups, err := getInt64(c, KeyRotateMaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	p.MaxBackups = int(backups)
+	if p.MaxAge, err = getDuration(c, KeyRotateMaxAge); err != nil {
+		return nil, err
+	}
+	if p.Retention, err = getDuration(c, KeyRotateRetention); err != nil {
+		return nil, err
+	}
+	p.Compress, _ = c.GetStringOr(KeyRotateCompress, "")
+	switch p.Compress {
+	case "", CompressGzip, CompressZstd:
+	default:
+		return nil, fmt.Errorf("%v %v is not supported, must be %v or %v", KeyRotateCompress, p.Compress, CompressGzip, CompressZstd)
+	}
+	if p.MaxSize < 0 || p.MaxTotalSize < 0 || p.MaxBackups < 0 || p.MaxAge < 0 || p.Retention < 0 {
+		return nil, fmt.Errorf("rotate policy options must not be negative")
+	}
+	return p, nil
+}
+
+// getInt64 和 getDuration 在没有配置时返回 0
+func getInt64(c conf.MapConf, key string) (int64, error) {
+	if s, _ := c.GetStringOr(key, ""); s == "" {
+		return 0, nil
+	}
+	return c.GetInt64(key)
+}
+
+func getDuration(c conf.MapConf, key string) (time.Duration, error) {
+	s, _ := c.GetStringOr(key, "")
+	if s == "" || s == "0" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("parse %v %v error %v", key, s, err)
+	}
+	return d, nil
+}
+
+// PatternGlob 将 strftime 格式的路径转换为匹配所有已完成文件的 glob，扩展名前允许有切割的序号
+func PatternGlob(pattern string) string {
+	glob := strftimeVerbRegex.ReplaceAllString(pattern, "*")
+	dir, base := filepath.Split(glob)
+	ext := filepath.Ext(base)
+	if !cleanExtRegex.MatchString(ext) {
+		return glob + "*"
+	}
+	return dir + strings.TrimSuffix(base, ext) + "*" + ext
+}
+
+// strftimeRegex 将 strftime 格式转换为只匹配其输出的正则
+func strftimeRegex(pattern string) string {
+	var buf strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' || i == len(pattern)-1 {
+			buf.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			continue
+		}
+		i++
+		if compound, ok := strftimeCompounds[pattern[i]]; ok {
+			buf.WriteString(strftimeRegex(compound))
+		} else if expr, ok := strftimeRegexes[pattern[i]]; ok {
+			buf.WriteString(expr)
+		} else {
+			buf.WriteString(`[^` + regexp.QuoteMeta(string(filepath.Separator)) + `]*`)
+		}
+	}
+	return buf.String()
+}
+
+// SetPattern 根据 strftime 格式的路径设置保留策略匹配的文件，partition 为 true 时文件位于 partitionN 子目录中
+// 保留策略只删除完整路径与格式一致的文件，扩展名前允许有 insertSeq 添加的序号，同一目录中的其他文件不受影响
+func (p *RotatePolicy) SetPattern(pattern string, partition bool) {
+	pattern = filepath.Clean(pattern)
+	dir, base := filepath.Split(pattern)
+	sep := regexp.QuoteMeta(string(filepath.Separator))
+	expr := strftimeRegex(dir)
+	glob := PatternGlob(pattern)
+	if partition {
+		expr += "partition" + `\d+` + sep
+		glob = filepath.Join(filepath.Dir(glob), "partition*", filepath.Base(glob))
+	}
+	if ext := filepath.Ext(base); cleanExtRegex.MatchString(ext) {
+		expr += strftimeRegex(strings.TrimSuffix(base, ext)) + `(\.\d+)?` + regexp.QuoteMeta(ext)
+	} else {
+		expr += strftimeRegex(base) + `(\.\d+)?`
+	}
+	p.lock.Lock()
+	p.glob, p.match = glob, regexp.MustCompile("^"+expr+"$")
+	p.lock.Unlock()
+}
+
+// Open 打开 name 对应的正在写入的文件，如果上次没有正常关闭则继续追加写入
+func (p *RotatePolicy) Open(name string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("create parent directory: %v", err)
+	}
+	return os.OpenFile(name+TmpSuffix, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+}
+
+// NeedRotate 返回已经写入 size 字节、在 opened 时打开的文件是否需要切割
+func (p *RotatePolicy) NeedRotate(size int64, opened time.Time) bool {
+	if size <= 0 {
+		return false
+	}
+	return (p.MaxSize > 0 && size >= p.MaxSize) || (p.MaxAge > 0 && time.Since(opened) >= p.MaxAge)
+}
+
+// Complete 关闭通过 Open 打开的文件并重命名为最终的文件名，文件名已经存在时添加序号，然后在后台压缩并执行保留策略
+func (p *RotatePolicy) Complete(f *os.File, name string) error {
+	if err := f.Close(); err != nil {
+		return err
+	}
+	tmp := name + TmpSuffix
+	info, err := os.Stat(tmp)
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return os.Remove(tmp)
+	}
+	final := p.reserve(name)
+	if p.Compress == "" {
+		err = os.Rename(tmp, final)
+		p.release(final)
+		if err != nil {
+			return err
+		}
+		p.cleanup()
+		return nil
+	}
+	staging := final + rotatingSuffix
+	if err = os.Rename(tmp, staging); err != nil {
+		p.release(final)
+		return err
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		if err := p.compress(staging, final); err != nil {
+			log.Errorf("compress rotated file %v error %v", final, err)
+		}
+		p.release(final)
+		p.cleanup()
+	}()
+	return nil
+}
+
+// Wait 等待后台的压缩全部完成
+func (p *RotatePolicy) Wait() {
+	p.wg.Wait()
+}
+
+func (p *RotatePolicy) compressExt() string {
+	switch p.Compress {
+	case CompressGzip:
+		return ".gz"
+	case CompressZstd:
+		return ".zst"
+	}
+	return ""
+}
+
+// reserve 选择一个没有被占用的最终文件名
+func (p *RotatePolicy) reserve(name string) string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.reserved == nil {
+		p.reserved = make(map[string]bool)
+	}
+	ext := p.compressExt()
+	for i := 0; ; i++ {
+		final := name
+		if i > 0 {
+			final = insertSeq(name, i)
+		}
+		if p.reserved[final] || exists(final) || exists(final+ext) {
+			continue
+		}
+		p.reserved[final] = true
+		return final
+	}
+}
+
+func (p *RotatePolicy) release(final string) {
+	p.lock.Lock()
+	delete(p.reserved, final)
+	p.lock.Unlock()
+}
+
+// insertSeq 在扩展名前插入序号，如 data.log 变为 data.1.log，没有合适的扩展名时添加在末尾
+func insertSeq(name string, seq int) string {
+	ext := filepath.Ext(name)
+	if !cleanExtRegex.MatchString(ext) {
+		return name + "." + strconv.Itoa(seq)
+	}
+	return strings.TrimSuffix(name, ext) + "." + strconv.Itoa(seq) + ext
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+func (p *RotatePolicy) compress(src, final string) (err error) {
+	target := final + p.compressExt()
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(target+TmpSuffix, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(target + TmpSuffix)
+		}
+	}()
+	var w io.WriteCloser
+	if p.Compress == CompressGzip {
+		w = gzip.NewWriter(out)
+	} else if w, err = zstd.NewWriter(out); err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, in); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(target+TmpSuffix, target); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+type completedFile struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+// cleanup 按保留策略从旧到新删除已完成的文件
+func (p *RotatePolicy) cleanup() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.glob == "" || p.match == nil || (p.MaxBackups <= 0 && p.MaxTotalSize <= 0 && p.Retention <= 0) {
+		return
+	}
+
+	var (
+		files []completedFile
+		seen  = make(map[string]bool)
+	)
+	for _, suffix := range []string{"", ".gz", ".zst"} {
+		matches, err := filepath.Glob(p.glob + suffix)
+		if err != nil {
+			log.Errorf("rotate policy glob %v error %v", p.glob+suffix, err)
+			return
+		}
+		for _, name := range matches {
+			if seen[name] || strings.HasSuffix(name, TmpSuffix) || !p.match.MatchString(strings.TrimSuffix(name, suffix)) {
+				continue
+			}
+			seen[name] = true
+			info, err := os.Stat(name)
+			if err != nil || info.IsDir() {
+				continue
+			}
+			files = append(files, completedFile{name: name, size: info.Size(), modTime: info.ModTime()})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+
+	var total int64
+	for i, f := range files {
+		total += f.size
+		if (p.MaxBackups > 0 && i >= p.MaxBackups) ||
+			(p.MaxTotalSize > 0 && total > p.MaxTotalSize) ||
+			(p.Retention > 0 && time.Since(f.modTime) > p.Retention) {
+			if err := os.Remove(f.name); err != nil {
+				log.Errorf("rotate policy remove %v error %v", f.name, err)
+			}
+		}
+	}
+}
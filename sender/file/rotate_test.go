@@ -0,0 +1,171 @@
+package file
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/sender/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestFileSenderRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_sender_rotate")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fsender, err := NewSender(conf.MapConf{
+		KeyFileSenderPath:   filepath.Join(dir, "app.log"),
+		KeyFileWriteRaw:     "true",
+		KeyRotateMaxSize:    "2",
+		KeyRotateMaxBackups: "2",
+	})
+	assert.NoError(t, err)
+	s := fsender.(*Sender)
+	assert.Equal(t, filepath.Join(dir, "app*.log"), s.writers.policy.glob)
+	// 匹配 glob 但不是该 sender 写出的文件不会被删除
+	foreign := filepath.Join(dir, "app-backup.log")
+	assert.NoError(t, ioutil.WriteFile(foreign, []byte("foreign"), 0644))
+	assert.NoError(t, os.Chtimes(foreign, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour)))
+
+	assert.NoError(t, s.Send([]Data{{"raw": "0123456789"}}))
+	// 正在写入的文件带有 .tmp 后缀
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	assert.Equal(t, []string{foreign, filepath.Join(dir, "app.log.tmp")}, files)
+
+	for _, raw := range []string{"a", "b", "c"} {
+		assert.NoError(t, s.Send([]Data{{"raw": raw}}))
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NoError(t, s.Close())
+
+	// 超过 max_backups 的最旧的文件被删除，被删除的文件名可以再次使用
+	files, _ = filepath.Glob(filepath.Join(dir, "*"))
+	assert.Equal(t, []string{foreign, filepath.Join(dir, "app.2.log"), filepath.Join(dir, "app.log")}, files)
+	body, err := ioutil.ReadFile(filepath.Join(dir, "app.2.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "b\n", string(body))
+	body, err = ioutil.ReadFile(filepath.Join(dir, "app.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "c\n", string(body))
+}
+
+func TestFileSenderRotateCompress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_sender_compress")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, compress := range []string{CompressGzip, CompressZstd} {
+		fsender, err := NewSender(conf.MapConf{
+			KeyFileSenderPath: filepath.Join(dir, compress+".log"),
+			KeyFileWriteRaw:   "true",
+			KeyRotateMaxSize:  "4",
+			KeyRotateCompress: compress,
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, fsender.Send([]Data{{"raw": "abc"}}))
+		assert.NoError(t, fsender.Send([]Data{{"raw": "def"}}))
+		assert.NoError(t, fsender.Close())
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	sort.Strings(files)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "gzip.1.log.gz"),
+		filepath.Join(dir, "gzip.log.gz"),
+		filepath.Join(dir, "zstd.1.log.zst"),
+		filepath.Join(dir, "zstd.log.zst"),
+	}, files)
+
+	f, err := os.Open(filepath.Join(dir, "gzip.log.gz"))
+	assert.NoError(t, err)
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	assert.NoError(t, err)
+	body, err := ioutil.ReadAll(gr)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc\n", string(body))
+
+	compressed, err := ioutil.ReadFile(filepath.Join(dir, "zstd.1.log.zst"))
+	assert.NoError(t, err)
+	zr, err := zstd.NewReader(bytes.NewReader(compressed))
+	assert.NoError(t, err)
+	defer zr.Close()
+	body, err = ioutil.ReadAll(zr)
+	assert.NoError(t, err)
+	assert.Equal(t, "def\n", string(body))
+}
+
+func TestRotatePolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate_policy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p, err := NewRotatePolicy(conf.MapConf{})
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+	for _, c := range []conf.MapConf{
+		{KeyRotateCompress: "lz4"},
+		{KeyRotateMaxAge: "1x"},
+		{KeyRotateMaxSize: "-1"},
+	} {
+		_, err = NewRotatePolicy(c)
+		assert.Error(t, err)
+	}
+
+	p, err = NewRotatePolicy(conf.MapConf{KeyRotateMaxAge: "1h", KeyRotateRetention: "24h"})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, p.MaxAge)
+	assert.True(t, p.NeedRotate(1, time.Now().Add(-time.Hour)))
+	assert.False(t, p.NeedRotate(1, time.Now()))
+	assert.False(t, p.NeedRotate(0, time.Now().Add(-time.Hour)))
+
+	// 超过保留时间的文件被删除，正在写入的文件不受影响
+	p.SetPattern(filepath.Join(dir, "app-%Y%m%d.log"), false)
+	old := filepath.Join(dir, "app-20180101.log")
+	// 同一目录中不是按格式生成的文件不受保留策略影响
+	foreign := filepath.Join(dir, "app-notes.log")
+	for _, name := range []string{old, old + ".gz", foreign} {
+		assert.NoError(t, ioutil.WriteFile(name, []byte("old"), 0644))
+		assert.NoError(t, os.Chtimes(name, time.Now().Add(-48*time.Hour), time.Now().Add(-48*time.Hour)))
+	}
+	newName := filepath.Join(dir, "app-20180102.log")
+	f, err := p.Open(newName)
+	assert.NoError(t, err)
+	_, err = f.WriteString("new")
+	assert.NoError(t, err)
+	assert.NoError(t, p.Complete(f, newName))
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	assert.Equal(t, []string{newName, foreign}, files)
+
+	// 空文件不会生成已完成的文件
+	f, err = p.Open(filepath.Join(dir, "app-20180103.log"))
+	assert.NoError(t, err)
+	assert.NoError(t, p.Complete(f, filepath.Join(dir, "app-20180103.log")))
+	files, _ = filepath.Glob(filepath.Join(dir, "*"))
+	assert.Equal(t, []string{newName, foreign}, files)
+
+	// 扩展名前的序号、partition 子目录和 csv、sqlfile 的文件名
+	p.SetPattern("/logs/%Y/app-%H.log", true)
+	assert.Equal(t, "/logs/*/partition*/app-**.log", p.glob)
+	assert.True(t, p.match.MatchString("/logs/2018/partition1/app-01.2.log"))
+	assert.False(t, p.match.MatchString("/logs/2018/partition1/app-1.log"))
+	assert.False(t, p.match.MatchString("/logs/2018/app-01.log"))
+	p.SetPattern("./sync.csv-%m%d%H%M%S", false)
+	assert.True(t, p.match.MatchString("sync.csv-0102030405.1"))
+	assert.False(t, p.match.MatchString("sync.csv-old"))
+	p.SetPattern("/logs/%F %T.log", false)
+	assert.True(t, p.match.MatchString("/logs/2018-01-02 03:04:05.log"))
+
+	assert.Equal(t, "/logs/*-*-1*.log", PatternGlob("/logs/%Y%m%d-%H-1.log"))
+	assert.Equal(t, "./sync.csv-0101.1", insertSeq("./sync.csv-0101", 1))
+}
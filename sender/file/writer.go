@@ -20,6 +20,14 @@ type writer struct {
 	inUseStatus int32 // Note: 原子操作
 	lastWrote   int32
 	wc          io.WriteCloser
+
+	// 以下字段仅在开启滚动策略时使用，file 为正在写入的 .tmp 文件
+	lock   sync.Mutex
+	name   string
+	policy *RotatePolicy
+	file   *os.File
+	size   int64
+	opened time.Time
 }
 
 // IsBusy 当任务数为 0 时返回 false 表示可以被安全回收，否则返回 true
@@ -39,11 +47,62 @@ func (w *writer) SetIdle() {
 
 func (w *writer) Write(b []byte) (int, error) {
 	atomic.StoreInt32(&w.lastWrote, int32(time.Now().Unix()))
-	return w.wc.Write(b)
+	if w.policy == nil {
+		return w.wc.Write(b)
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file == nil || w.policy.NeedRotate(w.size, w.opened) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+// rotate 完成当前正在写入的文件并打开一个新文件，需要持有 w.lock
+func (w *writer) rotate() error {
+	if w.file != nil {
+		f := w.file
+		w.file = nil
+		if err := w.policy.Complete(f, w.name); err != nil {
+			return fmt.Errorf("complete file: %v", err)
+		}
+	}
+	return w.open()
+}
+
+// open 打开正在写入的文件，上次没有正常关闭留下的 .tmp 文件会继续追加写入
+func (w *writer) open() error {
+	f, err := w.policy.Open(w.name)
+	if err != nil {
+		return fmt.Errorf("open file: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("stat file: %v", err)
+	}
+	w.file, w.size, w.opened = f, info.Size(), time.Now()
+	return nil
 }
 
 func (w *writer) Close() error {
-	return w.wc.Close()
+	if w.policy == nil {
+		return w.wc.Close()
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	f := w.file
+	w.file = nil
+	return w.policy.Complete(f, w.name)
 }
 
 // writerStore 维护了指定大小的文件句柄池，根据需要自动关闭和开启句柄。
@@ -52,6 +111,8 @@ type writerStore struct {
 	size    int
 	lock    sync.RWMutex
 	writers map[string]*writer
+	// policy 不为 nil 时按滚动策略写入文件
+	policy *RotatePolicy
 }
 
 func newWriterStore(size int) *writerStore {
@@ -83,18 +144,28 @@ func (s *writerStore) NewWriter(filename string) (w *writer, _ error) {
 		return w, nil
 	}
 
-	dir := filepath.Dir(filename)
-	if !utils.IsExist(dir) {
-		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
-			return nil, fmt.Errorf("create parent directory: %v", err)
+	if s.policy != nil {
+		w = &writer{
+			name:   filename,
+			policy: s.policy,
+		}
+		if err := w.open(); err != nil {
+			return nil, err
+		}
+	} else {
+		dir := filepath.Dir(filename)
+		if !utils.IsExist(dir) {
+			if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+				return nil, fmt.Errorf("create parent directory: %v", err)
+			}
+		}
+		f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("open file: %v", err)
+		}
+		w = &writer{
+			wc: f,
 		}
-	}
-	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
-	if err != nil {
-		return nil, fmt.Errorf("open file: %v", err)
-	}
-	w = &writer{
-		wc: f,
 	}
 	w.SetBusy()
 
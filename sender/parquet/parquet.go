@@ -0,0 +1,477 @@
+package parquet
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/strftime"
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/conf"
+	parserconf "github.com/qiniu/logkit/parser/config"
+	"github.com/qiniu/logkit/sender"
+	. "github.com/qiniu/logkit/sender/config"
+	"github.com/qiniu/logkit/sender/file"
+	"github.com/qiniu/logkit/times"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+var (
+	_ sender.SkipDeepCopySender = &Sender{}
+	_ sender.Sender             = &Sender{}
+)
+
+type DataType = parserconf.DataType
+
+// 除 TypeBool 外与 parser 的 schema 类型相同
+const (
+	TypeFloat   = parserconf.TypeFloat
+	TypeLong    = parserconf.TypeLong
+	TypeString  = parserconf.TypeString
+	TypeDate    = parserconf.TypeDate
+	TypeJSONMap = parserconf.TypeJSONMap
+	TypeBool    = DataType("bool")
+
+	CompressionNone   = "none"
+	CompressionSnappy = "snappy"
+	CompressionGzip   = "gzip"
+	CompressionZstd   = "zstd"
+
+	DefaultRowGroupSize = 32 * MB
+	DefaultFileSize     = 512 * MB
+)
+
+// Field 为 parquet 文件中的一列，所有列都可以为 null
+type Field struct {
+	Name string
+	Type DataType
+}
+
+// Sender 将数据按列写入 parquet 文件
+// 没有配置 schema 时根据数据推断列的类型，解析器提供了字段类型时优先使用，出现新的字段时结束当前文件，后续数据写入包含新字段的文件
+// 正在写入的文件带有 .tmp 后缀，写入 footer 后才重命名为最终的文件名
+type Sender struct {
+	name         string
+	pattern      *strftime.Strftime
+	codec        int32
+	rowGroupSize int64
+	fileSize     int64
+	// fixed 为 true 表示使用配置的 schema，不在 schema 中的字段被忽略
+	fixed  bool
+	fields []Field
+	types  map[string]DataType
+	// hints 为推断 schema 时优先使用的字段类型，如 csv 解析器的 csv_schema
+	hints map[string]DataType
+
+	lock     sync.Mutex
+	policy   *file.RotatePolicy
+	filename string
+	f        *os.File
+	w        *fileWriter
+}
+
+func init() {
+	sender.RegisterConstructor(TypeParquet, NewSender)
+}
+
+func NewSender(c conf.MapConf) (sender.Sender, error) {
+	path, err := c.GetString(KeyParquetPath)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := strftime.New(path)
+	if err != nil {
+		return nil, err
+	}
+	name, _ := c.GetStringOr(KeyName, "parquetSender:"+path)
+	schema, _ := c.GetStringOr(KeyParquetSchema, "")
+	compression, _ := c.GetStringOr(KeyParquetCompression, CompressionSnappy)
+	rowGroupSize, _ := c.GetInt64Or(KeyParquetRowGroupSize, DefaultRowGroupSize)
+	fileSize, _ := c.GetInt64Or(KeyParquetFileSize, DefaultFileSize)
+	if rowGroupSize <= 0 {
+		rowGroupSize = DefaultRowGroupSize
+	}
+
+	s := &Sender{
+		name:         name,
+		pattern:      pattern,
+		rowGroupSize: rowGroupSize,
+		fileSize:     fileSize,
+		types:        make(map[string]DataType),
+		policy:       &file.RotatePolicy{},
+	}
+	switch compression {
+	case CompressionNone, "":
+		s.codec = codecUncompressed
+	case CompressionSnappy:
+		s.codec = codecSnappy
+	case CompressionGzip:
+		s.codec = codecGzip
+	case CompressionZstd:
+		s.codec = codecZstd
+	default:
+		return nil, fmt.Errorf("%v %v is not supported", KeyParquetCompression, compression)
+	}
+	if strings.TrimSpace(schema) != "" {
+		if s.fields, err = ParseSchema(schema); err != nil {
+			return nil, err
+		}
+		s.fixed = true
+		for _, field := range s.fields {
+			s.types[field.Name] = field.Type
+		}
+	} else if hints, _ := c.GetStringOr(InnerParquetTypeHints, ""); hints != "" {
+		fields, err := ParseSchema(hints)
+		if err != nil {
+			log.Warnf("%s ignore parser schema %q: %v", name, hints, err)
+		}
+		s.hints = make(map[string]DataType, len(fields))
+		for _, field := range fields {
+			s.hints[field.Name] = field.Type
+		}
+	}
+	return s, nil
+}
+
+// ParseSchema 解析逗号分隔的 "字段名 类型" 列表，格式与 csv parser 的 csv_schema 相同，另外支持 bool 类型
+func ParseSchema(schema string) ([]Field, error) {
+	var (
+		fields []Field
+		seen   = make(map[string]bool)
+	)
+	for _, item := range splitSchema(schema) {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.Fields(item)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("parquet schema %q must be in the form of \"name type\"", item)
+		}
+		typ := DataType(strings.ToLower(parts[1]))
+		// jsonmap 在 csv_schema 中可以带有 {...} 的子字段定义，parquet 中整体作为 JSON 字符串写入
+		if strings.HasPrefix(string(typ), string(TypeJSONMap)) {
+			typ = TypeJSONMap
+		}
+		switch typ {
+		case TypeLong, TypeFloat, TypeString, TypeDate, TypeJSONMap, TypeBool:
+		default:
+			return nil, fmt.Errorf("parquet schema type %v of %v is not supported", parts[1], parts[0])
+		}
+		if seen[parts[0]] {
+			return nil, fmt.Errorf("parquet schema field %v is duplicated", parts[0])
+		}
+		seen[parts[0]] = true
+		fields = append(fields, Field{Name: parts[0], Type: typ})
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("parquet schema is empty")
+	}
+	return fields, nil
+}
+
+// splitSchema 按不在 {} 中的逗号切分
+func splitSchema(schema string) []string {
+	var (
+		items []string
+		depth int
+		start int
+	)
+	for i, r := range schema {
+		switch r {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, schema[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(items, schema[start:])
+}
+
+func (s *Sender) Name() string {
+	return s.name
+}
+
+func (_ *Sender) SkipDeepCopy() bool { return true }
+
+func (s *Sender) Send(datas []Data) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	filename := s.pattern.FormatString(time.Now())
+	evolved := !s.fixed && s.inferFields(datas)
+	if s.w != nil && (filename != s.filename || evolved) {
+		if err := s.closeFile(); err != nil {
+			return err
+		}
+	}
+
+	var invalid int64
+	for _, data := range datas {
+		if s.w == nil {
+			if err := s.openFile(filename); err != nil {
+				return err
+			}
+		}
+		values, n := s.convert(data)
+		invalid += n
+		s.w.Append(values)
+		if s.w.BufferedSize() >= s.rowGroupSize {
+			if err := s.w.Flush(); err != nil {
+				s.abandonFile(err)
+				return err
+			}
+		}
+		if s.fileSize > 0 && s.w.Size() >= s.fileSize {
+			if err := s.closeFile(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if invalid > 0 {
+		// 仅仅上报错误信息，无法转换的值写入 null，所以不需要上层重试
+		return &StatsError{
+			StatsInfo:  StatsInfo{LastError: fmt.Sprintf("%s write %d values which can not be converted to the schema type as null", s.Name(), invalid)},
+			Ft:         true,
+			FtNotRetry: true,
+		}
+	}
+	return nil
+}
+
+// inferFields 根据数据推断新出现的字段的类型，返回是否有新的字段
+func (s *Sender) inferFields(datas []Data) bool {
+	var added []Field
+	for _, data := range datas {
+		for k, v := range data {
+			if v == nil {
+				continue
+			}
+			if _, ok := s.types[k]; ok {
+				continue
+			}
+			typ, ok := s.hints[k]
+			if !ok {
+				typ = inferType(v)
+			}
+			s.types[k] = typ
+			added = append(added, Field{Name: k, Type: typ})
+		}
+	}
+	if len(added) == 0 {
+		return false
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i].Name < added[j].Name })
+	s.fields = append(s.fields, added...)
+	return true
+}
+
+func inferType(v interface{}) DataType {
+	switch x := v.(type) {
+	case string, []byte:
+		return TypeString
+	case bool:
+		return TypeBool
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return TypeLong
+	case float32, float64:
+		return TypeFloat
+	case json.Number:
+		if _, err := x.Int64(); err == nil {
+			return TypeLong
+		}
+		return TypeFloat
+	case time.Time:
+		return TypeDate
+	}
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array:
+		return TypeJSONMap
+	}
+	return TypeString
+}
+
+// convert 将数据转换为 schema 中每一列对应的类型，返回无法转换的值的个数
+func (s *Sender) convert(data Data) (map[string]interface{}, int64) {
+	var (
+		values  = make(map[string]interface{}, len(s.fields))
+		invalid int64
+	)
+	for _, field := range s.fields {
+		v, ok := data[field.Name]
+		if !ok || v == nil {
+			continue
+		}
+		cv, err := convertValue(field.Type, v)
+		if err != nil {
+			invalid++
+			continue
+		}
+		values[field.Name] = cv
+	}
+	return values, invalid
+}
+
+// convertValue 将值转换为写入 parquet 的类型，long 和 date 转换为 int64，float 转换为 float64，string 和 jsonmap 转换为 []byte
+func convertValue(typ DataType, v interface{}) (interface{}, error) {
+	switch typ {
+	case TypeLong:
+		switch x := v.(type) {
+		case float32:
+			return floatToInt64(float64(x))
+		case float64:
+			return floatToInt64(x)
+		case json.Number:
+			if i, err := x.Int64(); err == nil {
+				return i, nil
+			}
+			f, err := x.Float64()
+			if err != nil {
+				return nil, err
+			}
+			return floatToInt64(f)
+		case string:
+			return strconv.ParseInt(strings.TrimSpace(x), 10, 64)
+		}
+		rv := reflect.ValueOf(v)
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return rv.Int(), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return int64(rv.Uint()), nil
+		}
+	case TypeFloat:
+		switch x := v.(type) {
+		case json.Number:
+			return x.Float64()
+		case string:
+			return strconv.ParseFloat(strings.TrimSpace(x), 64)
+		}
+		rv := reflect.ValueOf(v)
+		switch rv.Kind() {
+		case reflect.Float32, reflect.Float64:
+			return rv.Float(), nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return float64(rv.Int()), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return float64(rv.Uint()), nil
+		}
+	case TypeBool:
+		switch x := v.(type) {
+		case bool:
+			return x, nil
+		case string:
+			return strconv.ParseBool(strings.TrimSpace(x))
+		}
+	case TypeDate:
+		switch x := v.(type) {
+		case time.Time:
+			return x.UnixNano() / int64(time.Millisecond), nil
+		case string:
+			t, err := time.Parse(time.RFC3339Nano, x)
+			if err != nil {
+				if t, err = times.StrToTime(x); err != nil {
+					return nil, err
+				}
+			}
+			return t.UnixNano() / int64(time.Millisecond), nil
+		}
+	case TypeJSONMap:
+		switch x := v.(type) {
+		case string:
+			return []byte(x), nil
+		case []byte:
+			return x, nil
+		}
+		return json.Marshal(v)
+	default:
+		switch x := v.(type) {
+		case string:
+			return []byte(x), nil
+		case []byte:
+			return x, nil
+		}
+		switch reflect.ValueOf(v).Kind() {
+		case reflect.Map, reflect.Slice, reflect.Array:
+			return json.Marshal(v)
+		}
+		return []byte(fmt.Sprint(v)), nil
+	}
+	return nil, fmt.Errorf("can not convert %v(%T) to %v", v, v, typ)
+}
+
+func floatToInt64(f float64) (interface{}, error) {
+	if f != math.Trunc(f) || f > math.MaxInt64 || f < math.MinInt64 {
+		return nil, fmt.Errorf("%v is not an integer", f)
+	}
+	return int64(f), nil
+}
+
+func (s *Sender) openFile(filename string) error {
+	// 上次没有正常关闭留下的文件没有 footer，无法继续追加写入
+	tmp := filename + file.TmpSuffix
+	if _, err := os.Stat(tmp); err == nil {
+		log.Warnf("%s remove unfinished parquet file %v", s.Name(), tmp)
+		if err = os.Remove(tmp); err != nil {
+			return err
+		}
+	}
+	f, err := s.policy.Open(filename)
+	if err != nil {
+		return err
+	}
+	w, err := newFileWriter(f, s.fields, s.codec)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.w, s.filename = f, w, filename
+	return nil
+}
+
+// closeFile 写入 footer 并将文件重命名为最终的文件名
+func (s *Sender) closeFile() error {
+	if s.w == nil {
+		return nil
+	}
+	if s.w.numRows+s.w.bufRows == 0 {
+		s.f.Close()
+		os.Remove(s.filename + file.TmpSuffix)
+		s.f, s.w = nil, nil
+		return nil
+	}
+	if err := s.w.Close(); err != nil {
+		s.abandonFile(err)
+		return err
+	}
+	f := s.f
+	s.f, s.w = nil, nil
+	return s.policy.Complete(f, s.filename)
+}
+
+// abandonFile 在写入失败后放弃当前文件，后续数据写入新的文件
+func (s *Sender) abandonFile(err error) {
+	log.Errorf("%s write parquet file %v failed, abandon it: %v", s.Name(), s.filename, err)
+	s.f.Close()
+	s.f, s.w = nil, nil
+}
+
+func (s *Sender) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.closeFile()
+}
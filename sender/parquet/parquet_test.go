@@ -0,0 +1,293 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qiniu/logkit/conf"
+	. "github.com/qiniu/logkit/sender/config"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// thriftReader 解码 thrift compact protocol，struct 解码为字段 id 到值的 map，仅用于测试
+type thriftReader struct {
+	r *bytes.Reader
+}
+
+func (t *thriftReader) zigzag() int64 {
+	v, _ := binary.ReadUvarint(t.r)
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (t *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case 1:
+		return true
+	case 2:
+		return false
+	case 3:
+		b, _ := t.r.ReadByte()
+		return int64(b)
+	case 4, 5, 6:
+		return t.zigzag()
+	case 7:
+		var f float64
+		binary.Read(t.r, binary.LittleEndian, &f)
+		return f
+	case 8:
+		n, _ := binary.ReadUvarint(t.r)
+		b := make([]byte, n)
+		t.r.Read(b)
+		return string(b)
+	case 9, 10:
+		h, _ := t.r.ReadByte()
+		n := int(h >> 4)
+		if n == 15 {
+			v, _ := binary.ReadUvarint(t.r)
+			n = int(v)
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = t.value(h & 0x0f)
+		}
+		return list
+	case 12:
+		return t.structure()
+	}
+	panic("unsupported thrift type")
+}
+
+func (t *thriftReader) structure() map[int16]interface{} {
+	s := make(map[int16]interface{})
+	var last int16
+	for {
+		h, _ := t.r.ReadByte()
+		if h == 0 {
+			return s
+		}
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			id = int16(t.zigzag())
+		}
+		s[id] = t.value(h & 0x0f)
+		last = id
+	}
+}
+
+func decompress(t *testing.T, codec int64, data []byte) []byte {
+	switch codec {
+	case codecSnappy:
+		b, err := snappy.Decode(nil, data)
+		assert.NoError(t, err)
+		return b
+	case codecGzip:
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		assert.NoError(t, err)
+		b, err := ioutil.ReadAll(gr)
+		assert.NoError(t, err)
+		return b
+	case codecZstd:
+		zr, err := zstd.NewReader(nil)
+		assert.NoError(t, err)
+		defer zr.Close()
+		b, err := zr.DecodeAll(data, nil)
+		assert.NoError(t, err)
+		return b
+	}
+	return data
+}
+
+// readParquet 读取 parquet 文件，返回 schema 和所有行，null 值不出现在行中
+func readParquet(t *testing.T, path string) ([]Field, []Data) {
+	body, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, magic, string(body[:4]))
+	assert.Equal(t, magic, string(body[len(body)-4:]))
+	n := binary.LittleEndian.Uint32(body[len(body)-8:])
+	meta := (&thriftReader{bytes.NewReader(body[len(body)-8-int(n) : len(body)-8])}).structure()
+
+	var (
+		fields []Field
+		types  = make(map[string]int64)
+		rows   []Data
+	)
+	for _, e := range meta[2].([]interface{})[1:] {
+		se := e.(map[int16]interface{})
+		name := se[4].(string)
+		typ := TypeString
+		switch se[1].(int64) {
+		case typeBoolean:
+			typ = TypeBool
+		case typeDouble:
+			typ = TypeFloat
+		case typeInt64:
+			typ = TypeLong
+			if se[6] != nil {
+				typ = TypeDate
+			}
+		case typeByteArray:
+			if se[6].(int64) == convertedJSON {
+				typ = TypeJSONMap
+			}
+		}
+		fields = append(fields, Field{Name: name, Type: typ})
+		types[name] = se[1].(int64)
+	}
+	for _, e := range meta[4].([]interface{}) {
+		rg := e.(map[int16]interface{})
+		numRows := int(rg[3].(int64))
+		group := make([]Data, numRows)
+		for i := range group {
+			group[i] = Data{}
+		}
+		for _, c := range rg[1].([]interface{}) {
+			cm := c.(map[int16]interface{})[3].(map[int16]interface{})
+			name := cm[3].([]interface{})[0].(string)
+			r := bytes.NewReader(body[cm[9].(int64):])
+			header := (&thriftReader{r}).structure()
+			compressed := make([]byte, header[3].(int64))
+			r.Read(compressed)
+			page := bytes.NewReader(decompress(t, cm[4].(int64), compressed))
+
+			var levelsLen uint32
+			binary.Read(page, binary.LittleEndian, &levelsLen)
+			lb := make([]byte, levelsLen)
+			page.Read(lb)
+			levels := bytes.NewReader(lb)
+			var defs []bool
+			for levels.Len() > 0 {
+				h, _ := binary.ReadUvarint(levels)
+				v, _ := levels.ReadByte()
+				for i := uint64(0); i < h>>1; i++ {
+					defs = append(defs, v == 1)
+				}
+			}
+			assert.Len(t, defs, numRows)
+			rest, _ := ioutil.ReadAll(page)
+			var idx int
+			for i, def := range defs {
+				if !def {
+					continue
+				}
+				switch types[name] {
+				case typeBoolean:
+					group[i][name] = rest[idx/8]&(1<<uint(idx%8)) != 0
+					idx++
+				case typeInt64:
+					group[i][name] = int64(binary.LittleEndian.Uint64(rest[idx:]))
+					idx += 8
+				case typeDouble:
+					group[i][name] = math.Float64frombits(binary.LittleEndian.Uint64(rest[idx:]))
+					idx += 8
+				case typeByteArray:
+					l := int(binary.LittleEndian.Uint32(rest[idx:]))
+					group[i][name] = string(rest[idx+4 : idx+4+l])
+					idx += 4 + l
+				}
+			}
+		}
+		rows = append(rows, group...)
+	}
+	assert.Equal(t, int64(len(rows)), meta[3].(int64))
+	return fields, rows
+}
+
+func TestParquetSenderInferAndEvolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parquet_sender")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := NewSender(conf.MapConf{
+		KeyParquetPath:         filepath.Join(dir, "data.parquet"),
+		KeyParquetRowGroupSize: "30",
+		InnerParquetTypeHints:  "cost float, region string, code long",
+	})
+	assert.NoError(t, err)
+	ts := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, s.Send([]Data{
+		{"host": "web1", "code": 200, "cost": "0.5", "ok": true, "t": ts},
+		{"host": "web2", "code": int64(500), "tags": map[string]interface{}{"a": 1}},
+	}))
+	// 正在写入的文件带有 .tmp 后缀
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	assert.Equal(t, []string{filepath.Join(dir, "data.parquet.tmp")}, files)
+
+	// 新字段出现时结束当前文件
+	assert.NoError(t, s.Send([]Data{{"host": "web3", "code": "404", "region": "bj"}}))
+	assert.NoError(t, s.Close())
+	files, _ = filepath.Glob(filepath.Join(dir, "*"))
+	assert.Equal(t, []string{filepath.Join(dir, "data.1.parquet"), filepath.Join(dir, "data.parquet")}, files)
+
+	fields, rows := readParquet(t, filepath.Join(dir, "data.parquet"))
+	assert.Equal(t, []Field{
+		{"code", TypeLong}, {"cost", TypeFloat}, {"host", TypeString}, {"ok", TypeBool}, {"t", TypeDate}, {"tags", TypeJSONMap},
+	}, fields)
+	assert.Equal(t, []Data{
+		{"host": "web1", "code": int64(200), "cost": 0.5, "ok": true, "t": ts.UnixNano() / int64(time.Millisecond)},
+		{"host": "web2", "code": int64(500), "tags": `{"a":1}`},
+	}, rows)
+
+	fields, rows = readParquet(t, filepath.Join(dir, "data.1.parquet"))
+	assert.Len(t, fields, 7)
+	assert.Equal(t, Field{"region", TypeString}, fields[6])
+	assert.Equal(t, []Data{{"host": "web3", "code": int64(404), "region": "bj"}}, rows)
+}
+
+func TestParquetSenderSchema(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parquet_sender_schema")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, compression := range []string{CompressionNone, CompressionSnappy, CompressionGzip, CompressionZstd} {
+		s, err := NewSender(conf.MapConf{
+			KeyParquetPath:        filepath.Join(dir, compression+"-%Y.parquet"),
+			KeyParquetSchema:      "a long, b string, c jsonmap{x long,y string}, d date, e bool, f float",
+			KeyParquetCompression: compression,
+			KeyParquetFileSize:    "1",
+		})
+		assert.NoError(t, err)
+		// 不在 schema 中的字段被忽略，无法转换的值写入 null
+		err = s.Send([]Data{
+			{"a": "1", "b": 2, "c": map[string]interface{}{"x": 1}, "d": "2018-01-01T00:00:00Z", "e": "true", "f": 1, "g": "x"},
+			{"a": "x", "b": "s", "e": false},
+		})
+		se, ok := err.(*StatsError)
+		assert.True(t, ok)
+		assert.True(t, se.FtNotRetry)
+		assert.Contains(t, se.LastError, "write 1 values")
+		assert.NoError(t, s.Close())
+
+		year := time.Now().Format("2006")
+		files, _ := filepath.Glob(filepath.Join(dir, compression+"-*"))
+		sort.Strings(files)
+		// 超过文件大小后切换到新的文件
+		assert.Equal(t, []string{filepath.Join(dir, compression+"-"+year+".1.parquet"), filepath.Join(dir, compression+"-"+year+".parquet")}, files)
+		_, rows := readParquet(t, files[1])
+		assert.Equal(t, []Data{{"a": int64(1), "b": "2", "c": `{"x":1}`, "d": int64(1514764800000), "e": true, "f": float64(1)}}, rows)
+		_, rows = readParquet(t, files[0])
+		assert.Equal(t, []Data{{"b": "s", "e": false}}, rows)
+	}
+
+	for _, c := range []conf.MapConf{
+		{},
+		{KeyParquetPath: "a", KeyParquetCompression: "lz4"},
+		{KeyParquetPath: "a", KeyParquetSchema: "a"},
+		{KeyParquetPath: "a", KeyParquetSchema: "a int"},
+		{KeyParquetPath: "a", KeyParquetSchema: "a long, a string"},
+	} {
+		_, err := NewSender(c)
+		assert.Error(t, err)
+	}
+}
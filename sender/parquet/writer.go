@@ -0,0 +1,362 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// parquet 格式中用到的常量，参考 https://github.com/apache/parquet-format/blob/master/src/main/thrift/parquet.thrift
+const (
+	magic = "PAR1"
+
+	typeBoolean   = 0
+	typeInt64     = 2
+	typeDouble    = 5
+	typeByteArray = 6
+
+	repetitionOptional = 1
+
+	convertedNone            = -1
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+	convertedJSON            = 19
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	pageTypeData = 0
+
+	codecUncompressed = 0
+	codecSnappy       = 1
+	codecGzip         = 2
+	codecZstd         = 6
+)
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdErr     error
+)
+
+// column 缓存一个列在当前 row group 中的数据
+type column struct {
+	field Field
+	// defs 为每一行是否有值，values 为有值的行转换后的值
+	defs   []bool
+	values []interface{}
+}
+
+type chunkMeta struct {
+	field        Field
+	offset       int64
+	numValues    int64
+	uncompressed int64
+	compressed   int64
+}
+
+type rowGroupMeta struct {
+	chunks    []chunkMeta
+	numRows   int64
+	totalSize int64
+}
+
+// fileWriter 将数据按列缓存，缓存达到 row group 大小时写入一个 row group，最后写入 footer
+// 所有列都是 OPTIONAL 的，每个列在每个 row group 中只有一个使用 PLAIN 编码的 data page
+type fileWriter struct {
+	f       *os.File
+	codec   int32
+	columns []*column
+	index   map[string]*column
+
+	offset    int64
+	numRows   int64
+	rowGroups []rowGroupMeta
+	bufRows   int64
+	bufSize   int64
+}
+
+func newFileWriter(f *os.File, fields []Field, codec int32) (*fileWriter, error) {
+	w := &fileWriter{
+		f:     f,
+		codec: codec,
+		index: make(map[string]*column, len(fields)),
+	}
+	for _, field := range fields {
+		c := &column{field: field}
+		w.columns = append(w.columns, c)
+		w.index[field.Name] = c
+	}
+	if _, err := f.Write([]byte(magic)); err != nil {
+		return nil, err
+	}
+	w.offset = int64(len(magic))
+	return w, nil
+}
+
+// Append 缓存一行数据，values 中没有的列写入 null
+func (w *fileWriter) Append(values map[string]interface{}) {
+	for _, c := range w.columns {
+		v, ok := values[c.field.Name]
+		c.defs = append(c.defs, ok)
+		w.bufSize++
+		if !ok {
+			continue
+		}
+		c.values = append(c.values, v)
+		switch x := v.(type) {
+		case []byte:
+			w.bufSize += int64(len(x)) + 4
+		case bool:
+			w.bufSize++
+		default:
+			w.bufSize += 8
+		}
+	}
+	w.bufRows++
+}
+
+// Size 返回已经写入的字节数和缓存中数据的大致字节数之和
+func (w *fileWriter) Size() int64 {
+	return w.offset + w.bufSize
+}
+
+// BufferedSize 返回缓存中数据的大致字节数
+func (w *fileWriter) BufferedSize() int64 {
+	return w.bufSize
+}
+
+// Flush 将缓存的数据写入一个 row group
+func (w *fileWriter) Flush() error {
+	if w.bufRows == 0 {
+		return nil
+	}
+	rg := rowGroupMeta{numRows: w.bufRows}
+	for _, c := range w.columns {
+		meta, err := w.writeColumn(c, w.bufRows)
+		if err != nil {
+			return err
+		}
+		rg.chunks = append(rg.chunks, meta)
+		rg.totalSize += meta.uncompressed
+		c.defs, c.values = c.defs[:0], c.values[:0]
+	}
+	w.rowGroups = append(w.rowGroups, rg)
+	w.numRows += w.bufRows
+	w.bufRows, w.bufSize = 0, 0
+	return nil
+}
+
+func (w *fileWriter) writeColumn(c *column, rows int64) (chunkMeta, error) {
+	page := encodePage(c)
+	compressed, err := compress(w.codec, page)
+	if err != nil {
+		return chunkMeta{}, err
+	}
+	if len(page) > math.MaxInt32 || len(compressed) > math.MaxInt32 {
+		return chunkMeta{}, fmt.Errorf("parquet page of column %v is too large", c.field.Name)
+	}
+
+	t := &thriftWriter{}
+	t.structBegin()
+	t.i32Field(1, pageTypeData)
+	t.i32Field(2, int32(len(page)))
+	t.i32Field(3, int32(len(compressed)))
+	t.structField(5)
+	t.i32Field(1, int32(rows))
+	t.i32Field(2, encodingPlain)
+	t.i32Field(3, encodingRLE)
+	t.i32Field(4, encodingRLE)
+	t.structEnd()
+	t.structEnd()
+	header := t.Bytes()
+
+	meta := chunkMeta{
+		field:        c.field,
+		offset:       w.offset,
+		numValues:    rows,
+		uncompressed: int64(len(header) + len(page)),
+		compressed:   int64(len(header) + len(compressed)),
+	}
+	if _, err = w.f.Write(header); err != nil {
+		return meta, err
+	}
+	if _, err = w.f.Write(compressed); err != nil {
+		return meta, err
+	}
+	w.offset += meta.compressed
+	return meta, nil
+}
+
+// Close 写入剩余的数据和 footer，不会关闭文件
+func (w *fileWriter) Close() error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	t := &thriftWriter{}
+	t.structBegin()
+	t.i32Field(1, 1)
+	t.listField(2, compactStruct, len(w.columns)+1)
+	t.structBegin()
+	t.stringField(4, "schema")
+	t.i32Field(5, int32(len(w.columns)))
+	t.structEnd()
+	for _, c := range w.columns {
+		typ, converted := physicalType(c.field.Type)
+		t.structBegin()
+		t.i32Field(1, typ)
+		t.i32Field(3, repetitionOptional)
+		t.stringField(4, c.field.Name)
+		if converted != convertedNone {
+			t.i32Field(6, converted)
+		}
+		t.structEnd()
+	}
+	t.i64Field(3, w.numRows)
+	t.listField(4, compactStruct, len(w.rowGroups))
+	for _, rg := range w.rowGroups {
+		t.structBegin()
+		t.listField(1, compactStruct, len(rg.chunks))
+		for _, chunk := range rg.chunks {
+			typ, _ := physicalType(chunk.field.Type)
+			t.structBegin()
+			t.i64Field(2, chunk.offset)
+			t.structField(3)
+			t.i32Field(1, typ)
+			t.listField(2, compactI32, 2)
+			t.zigzag(encodingPlain)
+			t.zigzag(encodingRLE)
+			t.listField(3, compactBinary, 1)
+			t.string(chunk.field.Name)
+			t.i32Field(4, w.codec)
+			t.i64Field(5, chunk.numValues)
+			t.i64Field(6, chunk.uncompressed)
+			t.i64Field(7, chunk.compressed)
+			t.i64Field(9, chunk.offset)
+			t.structEnd()
+			t.structEnd()
+		}
+		t.i64Field(2, rg.totalSize)
+		t.i64Field(3, rg.numRows)
+		t.structEnd()
+	}
+	t.stringField(6, "logkit")
+	t.structEnd()
+
+	footer := t.Bytes()
+	var buf bytes.Buffer
+	buf.Write(footer)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(footer)))
+	buf.WriteString(magic)
+	_, err := w.f.Write(buf.Bytes())
+	return err
+}
+
+// physicalType 返回 schema 类型对应的 parquet 物理类型和 converted type
+func physicalType(typ DataType) (int32, int32) {
+	switch typ {
+	case TypeBool:
+		return typeBoolean, convertedNone
+	case TypeLong:
+		return typeInt64, convertedNone
+	case TypeFloat:
+		return typeDouble, convertedNone
+	case TypeDate:
+		return typeInt64, convertedTimestampMillis
+	case TypeJSONMap:
+		return typeByteArray, convertedJSON
+	}
+	return typeByteArray, convertedUTF8
+}
+
+// encodePage 编码 data page 的内容，包括 RLE 编码的 definition levels 和 PLAIN 编码的值
+func encodePage(c *column) []byte {
+	var buf bytes.Buffer
+	levels := encodeLevels(c.defs)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(levels)))
+	buf.Write(levels)
+
+	var b [8]byte
+	if c.field.Type == TypeBool {
+		packed := make([]byte, (len(c.values)+7)/8)
+		for i, v := range c.values {
+			if v.(bool) {
+				packed[i/8] |= 1 << uint(i%8)
+			}
+		}
+		buf.Write(packed)
+		return buf.Bytes()
+	}
+	for _, v := range c.values {
+		switch x := v.(type) {
+		case int64:
+			binary.LittleEndian.PutUint64(b[:], uint64(x))
+			buf.Write(b[:])
+		case float64:
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(x))
+			buf.Write(b[:])
+		case []byte:
+			binary.LittleEndian.PutUint32(b[:4], uint32(len(x)))
+			buf.Write(b[:4])
+			buf.Write(x)
+		}
+	}
+	return buf.Bytes()
+}
+
+// encodeLevels 使用 RLE/bit-packing hybrid 编码中的 RLE run 编码位宽为 1 的 definition levels
+func encodeLevels(defs []bool) []byte {
+	var (
+		buf bytes.Buffer
+		b   [binary.MaxVarintLen64]byte
+	)
+	for i := 0; i < len(defs); {
+		j := i + 1
+		for j < len(defs) && defs[j] == defs[i] {
+			j++
+		}
+		n := binary.PutUvarint(b[:], uint64(j-i)<<1)
+		buf.Write(b[:n])
+		if defs[i] {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+		i = j
+	}
+	return buf.Bytes()
+}
+
+func compress(codec int32, data []byte) ([]byte, error) {
+	switch codec {
+	case codecSnappy:
+		return snappy.Encode(nil, data), nil
+	case codecGzip:
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		if _, err := gw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := gw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case codecZstd:
+		zstdOnce.Do(func() {
+			zstdEncoder, zstdErr = zstd.NewWriter(nil)
+		})
+		if zstdErr != nil {
+			return nil, zstdErr
+		}
+		return zstdEncoder.EncodeAll(data, nil), nil
+	}
+	return data, nil
+}
@@ -28,6 +28,21 @@ type SkipDeepCopySender interface {
 	SkipDeepCopy() bool
 }
 
+// AckSender 表示该 sender 支持在数据送达后进行确认，用于 reader 读取位置的端到端确认
+type AckSender interface {
+	// SendWithAck 与 Send 相同，本次调用中被接收的数据全部送达或被丢弃后调用一次 ack，
+	// 未被接收的数据以错误的形式返回，由调用方重试
+	SendWithAck(datas []Data, ack func()) error
+}
+
+// DeadLetterHandler 处理 sender 最终放弃发送的数据，datas 和 raws 分别对应 Send 和 RawSend 的数据
+type DeadLetterHandler func(datas []Data, raws []string, err error)
+
+// DeadLetterSender 表示该 sender 会自行丢弃发送失败的数据，设置 handler 后被丢弃的数据交给 handler 处理
+type DeadLetterSender interface {
+	SetDeadLetter(DeadLetterHandler)
+}
+
 type StatsSender interface {
 	Name() string
 	// send data, error if failed
@@ -17,6 +17,7 @@ import (
 	"github.com/qiniu/logkit/conf"
 	"github.com/qiniu/logkit/sender"
 	. "github.com/qiniu/logkit/sender/config"
+	"github.com/qiniu/logkit/sender/file"
 	"github.com/qiniu/logkit/utils/models"
 )
 
@@ -44,6 +45,11 @@ type writer struct {
 	table      string
 	rotateSize int64
 	pathPrefix string
+
+	// policy 不为 nil 时正在写入的文件带有 .tmp 后缀，关闭时重命名为 pathname 并按策略压缩和清理
+	policy   *file.RotatePolicy
+	pathname string
+	opened   time.Time
 }
 
 func (w *writer) Write(rawdata []models.Data) error {
@@ -120,6 +126,9 @@ func (w *writer) NeedRotate() bool {
 	if w.sqlfile == nil {
 		return true
 	}
+	if w.policy != nil && w.policy.NeedRotate(w.fileSize, w.opened) {
+		return true
+	}
 	return w.fileSize >= w.rotateSize
 }
 
@@ -139,10 +148,22 @@ func (w *writer) CreateFile() (*os.File, error) {
 	now := time.Now()
 	pathname := fmt.Sprintf("%s-%.2d%.2d%.2d%.2d%.2d", w.pathPrefix,
 		now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second())
-	return os.Create(pathname)
+	if w.policy == nil {
+		return os.Create(pathname)
+	}
+	w.pathname, w.opened = pathname, now
+	return w.policy.Open(pathname)
 }
 
 func (w *writer) Close() error {
+	if w.policy != nil {
+		if w.sqlfile == nil {
+			return nil
+		}
+		f := w.sqlfile
+		w.sqlfile = nil
+		return w.policy.Complete(f, w.pathname)
+	}
 	if w.sqlfile != nil {
 		if err := w.sqlfile.Close(); err != nil {
 			return err
@@ -168,11 +189,20 @@ func NewSender(conf conf.MapConf) (s sender.Sender, err error) {
 		return
 	}
 	pathPrefix, _ := conf.GetStringOr(KeySQLFilePathPrefix, defaultPathPrefix)
+	policy, err := file.NewRotatePolicy(conf)
+	if err != nil {
+		return
+	}
+	if policy != nil {
+		// 与 CreateFile 生成的文件名一致
+		policy.SetPattern(strings.Replace(pathPrefix, "%", "%%", -1)+"-%m%d%H%M%S", false)
+	}
 
 	w := &writer{
 		rotateSize: rotateSize,
 		table:      table,
 		pathPrefix: pathPrefix,
+		policy:     policy,
 	}
 	name, _ := conf.GetStringOr(KeyName, fmt.Sprintf("sqlfile(table:%s)", table))
 	rate, _ := conf.GetInt64Or(KeyMaxSendRate, -1)
@@ -194,5 +224,9 @@ func (s *Sender) Name() string {
 
 func (s *Sender) Close() error {
 	s.limiter.Close()
-	return s.w.Close()
+	err := s.w.Close()
+	if s.w.policy != nil {
+		s.w.policy.Wait()
+	}
+	return err
 }
@@ -0,0 +1,422 @@
+package aggregate
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qiniu/logkit/times"
+	"github.com/qiniu/logkit/transforms"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	DefaultWindow = time.Minute
+
+	KeyWindowStart = "window_start"
+	KeyWindowEnd   = "window_end"
+)
+
+var (
+	_ transforms.StatsTransformer     = &Aggregator{}
+	_ transforms.Transformer          = &Aggregator{}
+	_ transforms.Initializer          = &Aggregator{}
+	_ transforms.FlushableTransformer = &Aggregator{}
+)
+
+// Aggregator 按 group_by 的字段分组，在时间窗口内计算聚合指标
+// 窗口结束后每个分组输出一条包含分组字段、window_start、window_end 以及各个指标的数据
+type Aggregator struct {
+	GroupBy string `json:"group_by"`
+	Metrics string `json:"metrics"`
+	Window  string `json:"window"`
+	// Slide 为空时为滚动窗口，小于 Window 时为滑动窗口，一条数据会计入多个窗口
+	Slide string `json:"slide"`
+	// TimeKey 为空时使用处理时间，否则使用数据中该字段的时间
+	TimeKey    string `json:"time_key"`
+	TimeLayout string `json:"time_layout"`
+	// AllowedLateness 为按数据时间聚合时等待迟到数据的时间，超过后窗口输出，之后到达的数据不再计入
+	AllowedLateness string `json:"allowed_lateness"`
+	DiscardRaw      bool   `json:"discard_raw"`
+	stats           StatsInfo
+
+	groupKeys [][]string
+	metrics   []metricConfig
+	window    time.Duration
+	slide     time.Duration
+	lateness  time.Duration
+	timeKeys  []string
+	now       func() time.Time
+
+	lock sync.Mutex
+	// windows 中 key 为窗口的开始时间，watermark 之前结束的窗口都已经输出
+	windows   map[int64]*window
+	watermark time.Time
+	// lastArrival 为最后一次有数据到达的处理时间，arrivalWatermark 为当时的 watermark，没有数据到达时 watermark 随处理时间推进
+	lastArrival      time.Time
+	arrivalWatermark time.Time
+}
+
+type window struct {
+	start, end time.Time
+	groups     map[string]*group
+}
+
+type group struct {
+	values []interface{}
+	count  int64
+	accs   []accumulator
+}
+
+func (g *Aggregator) Init() error {
+	if strings.TrimSpace(g.GroupBy) != "" {
+		for _, key := range strings.Split(g.GroupBy, ",") {
+			if keys := GetKeys(key); len(keys) > 0 {
+				g.groupKeys = append(g.groupKeys, keys)
+			}
+		}
+	}
+	var err error
+	if g.metrics, err = parseMetrics(g.Metrics); err != nil {
+		return err
+	}
+	if g.window, err = parseDuration(g.Window, DefaultWindow); err != nil {
+		return err
+	}
+	if g.slide, err = parseDuration(g.Slide, g.window); err != nil {
+		return err
+	}
+	if g.lateness, err = parseDuration(g.AllowedLateness, 0); err != nil {
+		return err
+	}
+	if g.window <= 0 || g.slide <= 0 || g.slide > g.window {
+		return fmt.Errorf("aggregate window %v and slide %v must be positive and slide can not be larger than window", g.window, g.slide)
+	}
+	if g.window%g.slide != 0 {
+		return fmt.Errorf("aggregate window %v must be a multiple of slide %v", g.window, g.slide)
+	}
+	g.timeKeys = GetKeys(g.TimeKey)
+	if g.now == nil {
+		g.now = time.Now
+	}
+	g.windows = make(map[int64]*window)
+	return nil
+}
+
+func parseDuration(s string, def time.Duration) (time.Duration, error) {
+	if strings.TrimSpace(s) == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("parse duration %v error %v", s, err)
+	}
+	return d, nil
+}
+
+func (g *Aggregator) RawTransform(datas []string) ([]string, error) {
+	return datas, errors.New("aggregate transformer not support rawTransform")
+}
+
+func (g *Aggregator) Transform(datas []Data) ([]Data, error) {
+	if g.windows == nil {
+		if err := g.Init(); err != nil {
+			return datas, err
+		}
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	var (
+		err, fmtErr error
+		errNum      int
+		ret         []Data
+	)
+	if !g.DiscardRaw {
+		ret = datas
+	}
+	now := g.now()
+	for _, data := range datas {
+		t := now
+		if len(g.timeKeys) > 0 {
+			var timeErr error
+			if t, timeErr = g.eventTime(data); timeErr != nil {
+				errNum, err = transforms.SetError(errNum, timeErr, transforms.General, "")
+				continue
+			}
+		}
+		g.add(data, t)
+	}
+	// 按数据时间聚合时 watermark 由已经到达的最大数据时间推进，否则由处理时间推进
+	if len(g.timeKeys) == 0 {
+		g.advance(now.Add(-g.lateness))
+	} else if len(datas) > 0 {
+		g.lastArrival, g.arrivalWatermark = now, g.watermark
+	}
+	ret = append(ret, g.emit(false)...)
+
+	g.stats, fmtErr = transforms.SetStatsInfo(err, g.stats, int64(errNum), int64(len(datas)), g.Type())
+	return ret, fmtErr
+}
+
+func (g *Aggregator) eventTime(data Data) (time.Time, error) {
+	v, ok := lookupField(data, g.timeKeys)
+	if !ok {
+		return time.Time{}, fmt.Errorf("time key %v not exist in data", g.TimeKey)
+	}
+	switch val := v.(type) {
+	case time.Time:
+		return val, nil
+	case string:
+		if g.TimeLayout != "" {
+			return time.ParseInLocation(g.TimeLayout, val, time.Local)
+		}
+		return times.StrToTimeLocation(val, time.Local)
+	}
+	s, err := ConvertDate("", time.RFC3339Nano, 0, time.Local, v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, s.(string))
+}
+
+// add 将数据计入所有包含时间 t 并且还没有输出的窗口
+func (g *Aggregator) add(data Data, t time.Time) {
+	if len(g.timeKeys) > 0 {
+		if wm := t.Add(-g.lateness); wm.After(g.watermark) {
+			g.watermark = wm
+		}
+	}
+	var (
+		values = make([]interface{}, len(g.groupKeys))
+		parts  = make([]string, len(g.groupKeys))
+	)
+	for i, keys := range g.groupKeys {
+		values[i], _ = lookupField(data, keys)
+		parts[i] = fmt.Sprint(values[i])
+	}
+	groupKey := strings.Join(parts, "\x00")
+
+	ts := t.UnixNano()
+	slide := int64(g.slide)
+	for start := ts - mod(ts, slide); start > ts-int64(g.window); start -= slide {
+		end := time.Unix(0, start).Add(g.window)
+		if !end.After(g.watermark) && !g.watermark.IsZero() {
+			// 窗口已经输出，迟到的数据不再计入
+			continue
+		}
+		w, ok := g.windows[start]
+		if !ok {
+			w = &window{start: time.Unix(0, start), end: end, groups: make(map[string]*group)}
+			g.windows[start] = w
+		}
+		gr, ok := w.groups[groupKey]
+		if !ok {
+			gr = &group{values: values, accs: make([]accumulator, len(g.metrics))}
+			for i := range g.metrics {
+				gr.accs[i] = newAccumulator(&g.metrics[i])
+			}
+			w.groups[groupKey] = gr
+		}
+		gr.count++
+		for _, acc := range gr.accs {
+			acc.add(data)
+		}
+	}
+}
+
+func mod(a, b int64) int64 {
+	m := a % b
+	if m < 0 {
+		m += b
+	}
+	return m
+}
+
+func (g *Aggregator) advance(watermark time.Time) {
+	if watermark.After(g.watermark) {
+		g.watermark = watermark
+	}
+}
+
+// Flush 输出已经到期的窗口，final 为 true 时输出所有还没有输出的窗口
+// 按数据时间聚合时，没有新数据到达的这段处理时间同样推进 watermark，否则最后的窗口要等到下一条数据到达才会输出
+func (g *Aggregator) Flush(final bool) ([]Data, error) {
+	if g.windows == nil {
+		return nil, nil
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	now := g.now()
+	if len(g.timeKeys) == 0 {
+		g.advance(now.Add(-g.lateness))
+	} else if !g.lastArrival.IsZero() {
+		g.advance(g.arrivalWatermark.Add(now.Sub(g.lastArrival)))
+	}
+	return g.emit(final), nil
+}
+
+// emit 输出 watermark 之前结束的窗口，all 为 true 时输出所有窗口，按窗口开始时间和分组排序
+func (g *Aggregator) emit(all bool) []Data {
+	var starts []int64
+	for start, w := range g.windows {
+		if all || !w.end.After(g.watermark) {
+			starts = append(starts, start)
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	var ret []Data
+	for _, start := range starts {
+		w := g.windows[start]
+		delete(g.windows, start)
+		groupKeys := make([]string, 0, len(w.groups))
+		for key := range w.groups {
+			groupKeys = append(groupKeys, key)
+		}
+		sort.Strings(groupKeys)
+		for _, key := range groupKeys {
+			ret = append(ret, g.summary(w, w.groups[key]))
+		}
+	}
+	return ret
+}
+
+func (g *Aggregator) summary(w *window, gr *group) Data {
+	data := Data{
+		KeyWindowStart: w.start.Format(time.RFC3339Nano),
+		KeyWindowEnd:   w.end.Format(time.RFC3339Nano),
+	}
+	for i, keys := range g.groupKeys {
+		SetMapValue(data, gr.values[i], false, keys...)
+	}
+	for i, m := range g.metrics {
+		data[m.name] = gr.accs[i].result(gr.count)
+	}
+	return data
+}
+
+func (g *Aggregator) Description() string {
+	return "按字段分组在时间窗口内计算 count、sum、avg、min、max、分位数、去重数等聚合指标，每个窗口每个分组输出一条数据"
+}
+
+func (g *Aggregator) Type() string {
+	return "aggregate"
+}
+
+func (g *Aggregator) SampleConfig() string {
+	return `{
+       "type":"aggregate",
+       "group_by":"host,status",
+       "metrics":"count, ratio(status >= 500) as error_rate, avg(latency), p99(latency), distinct(remote_addr)",
+       "window":"1m",
+       "time_key":"time_local",
+       "allowed_lateness":"10s",
+       "discard_raw":true
+    }`
+}
+
+func (g *Aggregator) ConfigOptions() []Option {
+	return []Option{
+		{
+			KeyName:      "group_by",
+			ChooseOnly:   false,
+			Default:      "",
+			Placeholder:  "host,status",
+			DefaultNoUse: false,
+			Description:  "分组字段(group_by)",
+			ToolTip:      "多个字段用逗号分隔，嵌套字段用 . 分隔，不填写时所有数据为一组",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "metrics",
+			ChooseOnly:   false,
+			Default:      MetricCount,
+			Placeholder:  "count, ratio(status >= 500) as error_rate, avg(latency), p99(latency)",
+			DefaultNoUse: false,
+			Description:  "聚合指标(metrics)",
+			ToolTip:      "逗号分隔，支持 count、count_if(条件)、ratio(条件)、sum、avg、min、max、distinct、p50/p95/p99 等分位数(每个分组超过一万个值时为抽样估算)，可以用 as 指定输出字段名",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "window",
+			ChooseOnly:   false,
+			Default:      DefaultWindow.String(),
+			DefaultNoUse: false,
+			Description:  "窗口大小(window)",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "slide",
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "滑动间隔(slide)",
+			ToolTip:      "不填写时为滚动窗口，填写小于窗口大小的值时为滑动窗口，窗口大小需要是滑动间隔的整数倍",
+			Advance:      true,
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "time_key",
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "数据时间字段(time_key)",
+			ToolTip:      "不填写时按处理时间划分窗口",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "time_layout",
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "数据时间格式(time_layout)",
+			ToolTip:      "不填写时自动识别时间格式",
+			Advance:      true,
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "allowed_lateness",
+			ChooseOnly:   false,
+			Default:      "0s",
+			DefaultNoUse: false,
+			Description:  "迟到数据等待时间(allowed_lateness)",
+			ToolTip:      "窗口结束后继续等待迟到数据的时间，之后到达的数据不再计入该窗口",
+			Advance:      true,
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:       "discard_raw",
+			Element:       Radio,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{false, true},
+			Default:       false,
+			DefaultNoUse:  false,
+			Description:   "丢弃原始数据(discard_raw)",
+			ToolTip:       "只发送聚合后的数据",
+			Type:          transforms.TransformTypeBoolean,
+		},
+	}
+}
+
+func (g *Aggregator) Stage() string {
+	return transforms.StageAfterParser
+}
+
+func (g *Aggregator) Stats() StatsInfo {
+	return g.stats
+}
+
+func (g *Aggregator) SetStats(err string) StatsInfo {
+	g.stats.LastError = err
+	return g.stats
+}
+
+func init() {
+	transforms.Add("aggregate", func() transforms.Transformer {
+		return &Aggregator{}
+	})
+}
@@ -0,0 +1,169 @@
+package aggregate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestParseMetrics(t *testing.T) {
+	metrics, err := parseMetrics(`count, ratio(status >= 500 and method == "a,b") as error_rate, avg(req.latency), p99.9(latency), distinct(user) AS users`)
+	assert.NoError(t, err)
+	var names []string
+	for _, m := range metrics {
+		names = append(names, m.name)
+	}
+	assert.Equal(t, []string{"count", "error_rate", "req_latency_avg", "latency_p99.9", "users"}, names)
+	assert.Equal(t, 99.9, metrics[3].percentile)
+
+	metrics, err = parseMetrics("")
+	assert.NoError(t, err)
+	assert.Len(t, metrics, 1)
+
+	for _, s := range []string{"count, count", "avg", "count(a)", "ratio()", "ratio(a ==)", "median(a)", "p0(a)", "p101(a)", "avg(a", "avg(a))"} {
+		_, err = parseMetrics(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestAggregatorEventTime(t *testing.T) {
+	g := &Aggregator{
+		GroupBy:         "host",
+		Metrics:         "count, ratio(status >= 500) as error_rate, sum(latency), avg(latency), max(latency), p50(latency), distinct(user)",
+		Window:          "1m",
+		TimeKey:         "time",
+		AllowedLateness: "10s",
+		DiscardRaw:      true,
+	}
+	assert.NoError(t, g.Init())
+	base := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) string { return base.Add(d).Local().Format(time.RFC3339Nano) }
+
+	res, err := g.Transform([]Data{
+		{"host": "a", "status": 200, "latency": 1, "user": "u1", "time": at(1 * time.Second)},
+		{"host": "a", "status": 500, "latency": 3, "user": "u1", "time": at(2 * time.Second)},
+		{"host": "b", "status": 200, "latency": "2", "user": "u2", "time": at(30 * time.Second)},
+		{"host": "a", "status": 200, "latency": 8, "user": "u2", "time": at(65 * time.Second)},
+	})
+	assert.NoError(t, err)
+	// 65s - 10s 还没有到第一个窗口的结束时间
+	assert.Len(t, res, 0)
+
+	res, err = g.Transform([]Data{
+		// 迟到但在允许范围内
+		{"host": "b", "status": 503, "latency": 4, "user": "u2", "time": at(59 * time.Second)},
+		{"host": "a", "status": 200, "latency": 1, "user": "u1", "time": at(70 * time.Second)},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{
+		{"host": "a", "window_start": at(0), "window_end": at(time.Minute), "count": int64(2), "error_rate": 0.5,
+			"latency_sum": float64(4), "latency_avg": float64(2), "latency_max": float64(3), "latency_p50": float64(1), "user_distinct": int64(1)},
+		{"host": "b", "window_start": at(0), "window_end": at(time.Minute), "count": int64(2), "error_rate": 0.5,
+			"latency_sum": float64(6), "latency_avg": float64(3), "latency_max": float64(4), "latency_p50": float64(2), "user_distinct": int64(1)},
+	}, res)
+
+	// 超过允许的迟到时间，不再计入已经输出的窗口
+	res, err = g.Transform([]Data{
+		{"host": "a", "status": 200, "latency": 1, "time": at(50 * time.Second)},
+		{"host": "a", "time": "bad time"},
+		{"host": "a", "status": 200, "latency": 1, "time": at(130 * time.Second)},
+	})
+	assert.Error(t, err)
+	assert.Len(t, res, 1)
+	assert.Equal(t, at(time.Minute), res[0]["window_start"])
+	assert.Equal(t, int64(2), res[0]["count"])
+	assert.Equal(t, int64(1), g.Stats().Errors)
+}
+
+// local 将 UTC 时间转换为输出窗口时间时使用的本地时区
+func local(s string) string {
+	t, _ := time.Parse(time.RFC3339, s)
+	return t.Local().Format(time.RFC3339Nano)
+}
+
+func TestAggregatorSlidingProcessingTime(t *testing.T) {
+	now := time.Date(2018, 1, 1, 0, 0, 5, 0, time.UTC)
+	g := &Aggregator{Window: "20s", Slide: "10s", now: func() time.Time { return now }}
+	assert.NoError(t, g.Init())
+
+	datas := []Data{{"a": 1}, {"a": 2}}
+	res, err := g.Transform(datas)
+	assert.NoError(t, err)
+	// 没有丢弃原始数据
+	assert.Equal(t, datas, res)
+
+	now = now.Add(10 * time.Second)
+	res, err = g.Transform([]Data{{"a": 3}})
+	assert.NoError(t, err)
+	// 23:59:50 开始的窗口在 00:00:15 时已经结束
+	assert.Equal(t, []Data{
+		{"a": 3},
+		{"window_start": local("2017-12-31T23:59:50Z"), "window_end": local("2018-01-01T00:00:10Z"), "count": int64(2)},
+	}, res)
+
+	now = now.Add(20 * time.Second)
+	res, err = g.Transform(nil)
+	assert.NoError(t, err)
+	// 每条数据计入两个窗口
+	assert.Equal(t, []Data{
+		{"window_start": local("2018-01-01T00:00:00Z"), "window_end": local("2018-01-01T00:00:20Z"), "count": int64(3)},
+		{"window_start": local("2018-01-01T00:00:10Z"), "window_end": local("2018-01-01T00:00:30Z"), "count": int64(1)},
+	}, res)
+
+	assert.Error(t, (&Aggregator{Window: "20s", Slide: "30s"}).Init())
+	assert.Error(t, (&Aggregator{Window: "20s", Slide: "15s"}).Init())
+	assert.Error(t, (&Aggregator{Window: "x"}).Init())
+}
+
+func TestAggregatorFlush(t *testing.T) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	g := &Aggregator{Window: "1m", TimeKey: "time", DiscardRaw: true, now: func() time.Time { return now }}
+	res, err := g.Flush(false)
+	assert.NoError(t, err)
+	assert.Len(t, res, 0)
+	assert.NoError(t, g.Init())
+	at := func(d time.Duration) string { return now.Add(d).Local().Format(time.RFC3339Nano) }
+
+	res, err = g.Transform([]Data{{"time": at(10 * time.Second)}, {"time": at(30 * time.Second)}})
+	assert.NoError(t, err)
+	assert.Len(t, res, 0)
+	base := now
+
+	// 没有新数据到达时 watermark 随处理时间推进
+	now = base.Add(20 * time.Second)
+	res, err = g.Flush(false)
+	assert.NoError(t, err)
+	assert.Len(t, res, 0)
+	now = base.Add(30 * time.Second)
+	res, err = g.Flush(false)
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{{"window_start": local("2018-01-01T00:00:00Z"), "window_end": local("2018-01-01T00:01:00Z"), "count": int64(2)}}, res)
+	// 重复调用不会重复推进
+	res, err = g.Flush(false)
+	assert.NoError(t, err)
+	assert.Len(t, res, 0)
+
+	res, err = g.Transform([]Data{{"time": at(125 * time.Second)}})
+	assert.NoError(t, err)
+	assert.Len(t, res, 0)
+	// 退出时输出所有窗口
+	res, err = g.Flush(true)
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{{"window_start": local("2018-01-01T00:02:00Z"), "window_end": local("2018-01-01T00:03:00Z"), "count": int64(1)}}, res)
+	assert.Len(t, g.windows, 0)
+}
+
+func TestPercentileAccBounded(t *testing.T) {
+	acc := &percentileAcc{keys: []string{"v"}, percentile: 50}
+	n := percentileSampleSize * 3
+	for i := 1; i <= n; i++ {
+		acc.add(Data{"v": i})
+	}
+	assert.Len(t, acc.values, percentileSampleSize)
+	assert.Equal(t, int64(n), acc.seen)
+	p50 := acc.result(int64(n)).(float64)
+	assert.InDelta(t, float64(n)/2, p50, float64(n)/20)
+}
@@ -0,0 +1,356 @@
+package aggregate
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/qiniu/logkit/transforms"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	MetricCount    = "count"
+	MetricCountIf  = "count_if"
+	MetricRatio    = "ratio"
+	MetricSum      = "sum"
+	MetricAvg      = "avg"
+	MetricMin      = "min"
+	MetricMax      = "max"
+	MetricDistinct = "distinct"
+)
+
+// metricConfig 是一个聚合指标，如 avg(latency) as latency_avg
+type metricConfig struct {
+	name string
+	fn   string
+	// keys 为统计的字段，cond 为 count_if 和 ratio 的条件，percentile 为 pNN 的分位数
+	keys       []string
+	cond       *transforms.Condition
+	percentile float64
+}
+
+var (
+	metricRegex     = regexp.MustCompile(`(?s)^([a-zA-Z_]+[0-9.]*)\s*(?:\((.*)\))?(?:\s+[aA][sS]\s+([\w.@$-]+))?$`)
+	percentileRegex = regexp.MustCompile(`^p([0-9]+(?:\.[0-9]+)?)$`)
+)
+
+// parseMetrics 解析逗号分隔的指标列表，如 count, ratio(status >= 500) as error_rate, p99(latency)
+func parseMetrics(s string) ([]metricConfig, error) {
+	items, err := splitMetrics(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		items = []string{MetricCount}
+	}
+	var (
+		metrics = make([]metricConfig, 0, len(items))
+		names   = make(map[string]bool, len(items))
+	)
+	for _, item := range items {
+		m, err := parseMetric(item)
+		if err != nil {
+			return nil, err
+		}
+		if names[m.name] {
+			return nil, fmt.Errorf("metric name %v is duplicated, use \"as\" to rename it", m.name)
+		}
+		names[m.name] = true
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
+
+// splitMetrics 按不在括号和引号中的逗号拆分
+func splitMetrics(s string) ([]string, error) {
+	var (
+		items []string
+		depth int
+		quote rune
+		start int
+	)
+	runes := []rune(s)
+	for i, r := range runes {
+		switch {
+		case quote != 0:
+			if r == '\\' && i+1 < len(runes) && runes[i+1] == quote {
+				continue
+			}
+			if r == quote && (i == 0 || runes[i-1] != '\\') {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("metrics %q has unbalanced parentheses", s)
+			}
+		case r == ',' && depth == 0:
+			items = appendItem(items, string(runes[start:i]))
+			start = i + 1
+		}
+	}
+	if depth != 0 || quote != 0 {
+		return nil, fmt.Errorf("metrics %q has unbalanced parentheses or quotes", s)
+	}
+	return appendItem(items, string(runes[start:])), nil
+}
+
+func appendItem(items []string, item string) []string {
+	if item = strings.TrimSpace(item); item != "" {
+		items = append(items, item)
+	}
+	return items
+}
+
+func parseMetric(item string) (metricConfig, error) {
+	match := metricRegex.FindStringSubmatch(item)
+	if match == nil {
+		return metricConfig{}, fmt.Errorf("metric %q is invalid", item)
+	}
+	m := metricConfig{fn: strings.ToLower(match[1]), name: match[3]}
+	arg := strings.TrimSpace(match[2])
+	switch m.fn {
+	case MetricCount:
+		if arg != "" {
+			return metricConfig{}, fmt.Errorf("metric %v does not need argument", item)
+		}
+		if m.name == "" {
+			m.name = m.fn
+		}
+		return m, nil
+	case MetricCountIf, MetricRatio:
+		if arg == "" {
+			return metricConfig{}, fmt.Errorf("metric %v requires a condition", item)
+		}
+		cond, err := transforms.NewCondition(arg)
+		if err != nil {
+			return metricConfig{}, err
+		}
+		m.cond = cond
+		if m.name == "" {
+			m.name = m.fn
+		}
+		return m, nil
+	case MetricSum, MetricAvg, MetricMin, MetricMax, MetricDistinct:
+	default:
+		pm := percentileRegex.FindStringSubmatch(m.fn)
+		if pm == nil {
+			return metricConfig{}, fmt.Errorf("metric function %v is not supported", match[1])
+		}
+		m.percentile, _ = strconv.ParseFloat(pm[1], 64)
+		if m.percentile <= 0 || m.percentile > 100 {
+			return metricConfig{}, fmt.Errorf("percentile of metric %v must be in (0, 100]", item)
+		}
+	}
+	if arg == "" {
+		return metricConfig{}, fmt.Errorf("metric %v requires a field", item)
+	}
+	m.keys = GetKeys(arg)
+	if m.name == "" {
+		m.name = strings.Join(m.keys, "_") + "_" + m.fn
+	}
+	return m, nil
+}
+
+// accumulator 累计一个分组在一个窗口内的某个指标
+type accumulator interface {
+	add(data Data)
+	result(count int64) interface{}
+}
+
+func newAccumulator(m *metricConfig) accumulator {
+	switch m.fn {
+	case MetricCount:
+		return &countAcc{}
+	case MetricCountIf:
+		return &countIfAcc{cond: m.cond}
+	case MetricRatio:
+		return &countIfAcc{cond: m.cond, ratio: true}
+	case MetricDistinct:
+		return &distinctAcc{keys: m.keys, values: make(map[string]struct{})}
+	case MetricSum, MetricAvg, MetricMin, MetricMax:
+		return &numberAcc{fn: m.fn, keys: m.keys}
+	}
+	return &percentileAcc{keys: m.keys, percentile: m.percentile}
+}
+
+type countAcc struct{}
+
+func (a *countAcc) add(Data) {}
+
+func (a *countAcc) result(count int64) interface{} {
+	return count
+}
+
+type countIfAcc struct {
+	cond  *transforms.Condition
+	ratio bool
+	n     int64
+}
+
+func (a *countIfAcc) add(data Data) {
+	if a.cond.Match(data) {
+		a.n++
+	}
+}
+
+func (a *countIfAcc) result(count int64) interface{} {
+	if !a.ratio {
+		return a.n
+	}
+	if count == 0 {
+		return float64(0)
+	}
+	return float64(a.n) / float64(count)
+}
+
+// numberAcc 统计 sum、avg、min、max，字段不存在或不是数字时忽略
+type numberAcc struct {
+	fn       string
+	keys     []string
+	n        int64
+	sum      float64
+	min, max float64
+}
+
+func (a *numberAcc) add(data Data) {
+	f, ok := numberValue(data, a.keys)
+	if !ok {
+		return
+	}
+	if a.n == 0 || f < a.min {
+		a.min = f
+	}
+	if a.n == 0 || f > a.max {
+		a.max = f
+	}
+	a.n++
+	a.sum += f
+}
+
+func (a *numberAcc) result(int64) interface{} {
+	switch a.fn {
+	case MetricSum:
+		return a.sum
+	case MetricAvg:
+		if a.n == 0 {
+			return nil
+		}
+		return a.sum / float64(a.n)
+	case MetricMin:
+		if a.n == 0 {
+			return nil
+		}
+		return a.min
+	}
+	if a.n == 0 {
+		return nil
+	}
+	return a.max
+}
+
+// percentileSampleSize 为每个分组计算分位数时最多保存的值的个数
+const percentileSampleSize = 10000
+
+// percentileAcc 使用蓄水池抽样保存窗口内最多 percentileSampleSize 个值，输出时按 nearest-rank 计算分位数
+// 值的个数不超过 percentileSampleSize 时结果是精确的，超过时为均匀抽样的近似值
+type percentileAcc struct {
+	keys       []string
+	percentile float64
+	values     []float64
+	seen       int64
+}
+
+func (a *percentileAcc) add(data Data) {
+	f, ok := numberValue(data, a.keys)
+	if !ok {
+		return
+	}
+	a.seen++
+	if len(a.values) < percentileSampleSize {
+		a.values = append(a.values, f)
+		return
+	}
+	if i := rand.Int63n(a.seen); i < percentileSampleSize {
+		a.values[i] = f
+	}
+}
+
+func (a *percentileAcc) result(int64) interface{} {
+	if len(a.values) == 0 {
+		return nil
+	}
+	sort.Float64s(a.values)
+	rank := int(math.Ceil(a.percentile / 100 * float64(len(a.values))))
+	if rank < 1 {
+		rank = 1
+	}
+	return a.values[rank-1]
+}
+
+type distinctAcc struct {
+	keys   []string
+	values map[string]struct{}
+}
+
+func (a *distinctAcc) add(data Data) {
+	v, ok := lookupField(data, a.keys)
+	if !ok || v == nil {
+		return
+	}
+	a.values[fmt.Sprint(v)] = struct{}{}
+}
+
+func (a *distinctAcc) result(int64) interface{} {
+	return int64(len(a.values))
+}
+
+func lookupField(data Data, keys []string) (interface{}, bool) {
+	var cur interface{} = map[string]interface{}(data)
+	for _, k := range keys {
+		var m map[string]interface{}
+		switch val := cur.(type) {
+		case map[string]interface{}:
+			m = val
+		case Data:
+			m = val
+		default:
+			return nil, false
+		}
+		var ok bool
+		if cur, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+func numberValue(data Data, keys []string) (float64, bool) {
+	v, ok := lookupField(data, keys)
+	if !ok || v == nil {
+		return 0, false
+	}
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), true
+	case reflect.String:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value.String()), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
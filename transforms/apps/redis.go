@@ -35,6 +35,8 @@ type Redis struct {
 	keys       []string
 	stats      StatsInfo
 	numRoutine int
+
+	transforms.ErrorIndexRecorder
 }
 
 func (r *Redis) Init() error {
@@ -139,6 +141,7 @@ func (r *Redis) Transform(datas []Data) ([]Data, error) {
 		datas[transformResult.Index] = transformResult.CurData
 	}
 
+	r.RecordErrorIndex(transformResultSlice)
 	r.stats, fmtErr = transforms.SetStatsInfo(err, r.stats, int64(errNum), int64(dataLen), r.Type())
 	return datas, fmtErr
 }
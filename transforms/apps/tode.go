@@ -37,6 +37,8 @@ type Tode struct {
 	keys       []string
 	stats      StatsInfo
 	numRoutine int
+
+	transforms.ErrorIndexRecorder
 }
 
 func (t *Tode) Init() error {
@@ -141,6 +143,7 @@ func (t *Tode) Transform(datas []Data) ([]Data, error) {
 		datas[transformResult.Index] = transformResult.CurData
 	}
 
+	t.RecordErrorIndex(transformResultSlice)
 	t.stats, fmtErr = transforms.SetStatsInfo(err, t.stats, int64(errNum), int64(dataLen), t.Type())
 	return datas, fmtErr
 }
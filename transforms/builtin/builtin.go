@@ -1,10 +1,12 @@
 package builtin
 
 import (
+	_ "github.com/qiniu/logkit/transforms/aggregate"
 	_ "github.com/qiniu/logkit/transforms/apps"
 	_ "github.com/qiniu/logkit/transforms/aws"
 	_ "github.com/qiniu/logkit/transforms/date"
 	_ "github.com/qiniu/logkit/transforms/ip"
+	_ "github.com/qiniu/logkit/transforms/lookup"
 	_ "github.com/qiniu/logkit/transforms/mutate"
 	_ "github.com/qiniu/logkit/transforms/service"
 	_ "github.com/qiniu/logkit/transforms/ua"
@@ -0,0 +1,506 @@
+package transforms
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+// KeyIf 为任意 transformer 配置执行条件，只有满足条件的数据才会经过该 transformer
+const KeyIf = "if"
+
+var KeyCondition = Option{
+	KeyName:      KeyIf,
+	ChooseOnly:   false,
+	Default:      "",
+	DefaultNoUse: false,
+	Placeholder:  `type == "access" and status >= 400`,
+	Description:  "执行条件(if)",
+	ToolTip:      `满足条件的数据才进行 transform，支持 == != < <= > >= =~(正则匹配) !~ exists(字段) 以及 and or not 和括号`,
+	Type:         TransformTypeString,
+	Advance:      true,
+}
+
+// Condition 是对数据字段的布尔表达式，例如 type == "access" and (status >= 400 or exists(error))
+// 字段名中的 "." 表示嵌套字段，字符串可以使用单引号或双引号，字符串中只有引号需要用 \ 转义
+type Condition struct {
+	expr string
+	root condNode
+}
+
+// NewCondition 解析条件表达式
+func NewCondition(expr string) (*Condition, error) {
+	tokens, err := lexCondition(expr)
+	if err != nil {
+		return nil, fmt.Errorf("parse condition %q error %v", expr, err)
+	}
+	p := &condParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse condition %q error %v", expr, err)
+	}
+	return &Condition{expr: expr, root: root}, nil
+}
+
+// Match 判断数据是否满足条件
+func (c *Condition) Match(data Data) bool {
+	return c.root.eval(data)
+}
+
+func (c *Condition) String() string {
+	return c.expr
+}
+
+type condNode interface {
+	eval(data Data) bool
+}
+
+type andNode struct{ left, right condNode }
+
+func (n *andNode) eval(data Data) bool { return n.left.eval(data) && n.right.eval(data) }
+
+type orNode struct{ left, right condNode }
+
+func (n *orNode) eval(data Data) bool { return n.left.eval(data) || n.right.eval(data) }
+
+type notNode struct{ node condNode }
+
+func (n *notNode) eval(data Data) bool { return !n.node.eval(data) }
+
+type existsNode struct{ keys []string }
+
+func (n *existsNode) eval(data Data) bool {
+	_, ok := lookupField(data, n.keys)
+	return ok
+}
+
+// truthNode 单独的字段或值，字段存在且不为 false、0、空字符串或 null 时为真
+type truthNode struct{ operand condOperand }
+
+func (n *truthNode) eval(data Data) bool {
+	v, ok := n.operand.value(data)
+	if !ok || v == nil {
+		return false
+	}
+	switch val := v.(type) {
+	case bool:
+		return val
+	case string:
+		return val != ""
+	}
+	if f, ok := toFloat(v); ok {
+		return f != 0
+	}
+	return true
+}
+
+type compareNode struct {
+	op          string
+	left, right condOperand
+}
+
+func (n *compareNode) eval(data Data) bool {
+	l, lok := n.left.value(data)
+	r, rok := n.right.value(data)
+	if !lok {
+		l = nil
+	}
+	if !rok {
+		r = nil
+	}
+	switch n.op {
+	case "==":
+		return equalValue(l, r)
+	case "!=":
+		return !equalValue(l, r)
+	}
+	if l == nil || r == nil {
+		return false
+	}
+	var cmp int
+	lf, lok := toFloat(l)
+	rf, rok := toFloat(r)
+	if lok && rok {
+		switch {
+		case lf < rf:
+			cmp = -1
+		case lf > rf:
+			cmp = 1
+		}
+	} else {
+		ls, lok := l.(string)
+		rs, rok := r.(string)
+		if !lok || !rok {
+			return false
+		}
+		cmp = strings.Compare(ls, rs)
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+type regexNode struct {
+	not     bool
+	operand condOperand
+	re      *regexp.Regexp
+}
+
+func (n *regexNode) eval(data Data) bool {
+	v, ok := n.operand.value(data)
+	if !ok {
+		return n.not
+	}
+	s, ok := toString(v)
+	if !ok {
+		return n.not
+	}
+	return n.re.MatchString(s) != n.not
+}
+
+type condOperand interface {
+	value(data Data) (interface{}, bool)
+}
+
+type fieldOperand struct{ keys []string }
+
+func (o *fieldOperand) value(data Data) (interface{}, bool) {
+	return lookupField(data, o.keys)
+}
+
+type literalOperand struct{ v interface{} }
+
+func (o *literalOperand) value(Data) (interface{}, bool) {
+	return o.v, true
+}
+
+func lookupField(data Data, keys []string) (interface{}, bool) {
+	var cur interface{} = map[string]interface{}(data)
+	for _, k := range keys {
+		var m map[string]interface{}
+		switch val := cur.(type) {
+		case map[string]interface{}:
+			m = val
+		case Data:
+			m = val
+		default:
+			return nil, false
+		}
+		var ok bool
+		if cur, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// equalValue 两边都可以转换为数字时按数字比较，否则按字符串比较
+func equalValue(l, r interface{}) bool {
+	if l == nil || r == nil {
+		return l == nil && r == nil
+	}
+	if lb, ok := l.(bool); ok {
+		rb, ok := r.(bool)
+		return ok && lb == rb
+	}
+	if _, ok := r.(bool); ok {
+		return false
+	}
+	lf, lok := toFloat(l)
+	rf, rok := toFloat(r)
+	if lok && rok {
+		return lf == rf
+	}
+	ls, lok := toString(l)
+	rs, rok := toString(r)
+	return lok && rok && ls == rs
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), true
+	case reflect.String:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value.String()), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toString(v interface{}) (string, bool) {
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(value.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(value.Uint(), 10), true
+	case reflect.Float32:
+		return strconv.FormatFloat(value.Float(), 'f', -1, 32), true
+	case reflect.Float64:
+		return strconv.FormatFloat(value.Float(), 'f', -1, 64), true
+	case reflect.String:
+		return value.String(), true
+	case reflect.Bool:
+		return strconv.FormatBool(value.Bool()), true
+	}
+	return "", false
+}
+
+const (
+	tokenIdent = iota
+	tokenString
+	tokenNumber
+	tokenOp
+)
+
+type condToken struct {
+	typ  int
+	text string
+}
+
+var condOperators = []string{"==", "!=", "<=", ">=", "=~", "!~", "&&", "||", "<", ">", "!", "(", ")"}
+
+func isIdentRune(r rune, first bool) bool {
+	if unicode.IsLetter(r) || r == '_' || r == '@' || r == '$' {
+		return true
+	}
+	return !first && (unicode.IsDigit(r) || r == '.' || r == '-')
+}
+
+func lexCondition(expr string) ([]condToken, error) {
+	var tokens []condToken
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(runes) && runes[j] != r; j++ {
+				// 只有引号需要转义，其他的 \ 原样保留，方便书写正则表达式
+				if runes[j] == '\\' && j+1 < len(runes) && runes[j+1] == r {
+					j++
+				}
+				sb.WriteRune(runes[j])
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, condToken{typ: tokenString, text: sb.String()})
+			i = j + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.' || runes[j] == 'e' || runes[j] == 'E' ||
+				((runes[j] == '-' || runes[j] == '+') && (runes[j-1] == 'e' || runes[j-1] == 'E'))) {
+				j++
+			}
+			tokens = append(tokens, condToken{typ: tokenNumber, text: string(runes[i:j])})
+			i = j
+		case isIdentRune(r, true):
+			j := i + 1
+			for j < len(runes) && isIdentRune(runes[j], false) {
+				j++
+			}
+			tokens = append(tokens, condToken{typ: tokenIdent, text: string(runes[i:j])})
+			i = j
+		default:
+			matched := false
+			for _, op := range condOperators {
+				if strings.HasPrefix(string(runes[i:]), op) {
+					tokens = append(tokens, condToken{typ: tokenOp, text: op})
+					i += len([]rune(op))
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at %d", r, i)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+type condParser struct {
+	tokens []condToken
+	pos    int
+}
+
+func (p *condParser) peek() (condToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return condToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+// accept 当前 token 为 texts 中的某个操作符或关键字时前进并返回 true
+func (p *condParser) accept(texts ...string) bool {
+	t, ok := p.peek()
+	if !ok || (t.typ != tokenOp && t.typ != tokenIdent) {
+		return false
+	}
+	for _, text := range texts {
+		if t.text == text {
+			p.pos++
+			return true
+		}
+	}
+	return false
+}
+
+func (p *condParser) expect(text string) error {
+	if p.accept(text) {
+		return nil
+	}
+	if t, ok := p.peek(); ok {
+		return fmt.Errorf("expect %q but got %q", text, t.text)
+	}
+	return fmt.Errorf("expect %q but got end of expression", text)
+}
+
+func (p *condParser) parseOr() (condNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("or", "||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *condParser) parseAnd() (condNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("and", "&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *condParser) parseUnary() (condNode, error) {
+	if p.accept("not", "!") {
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{node: node}, nil
+	}
+	if p.accept("(") {
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return node, p.expect(")")
+	}
+	if t, ok := p.peek(); ok && t.typ == tokenIdent && t.text == "exists" {
+		p.pos++
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		t, ok := p.peek()
+		if !ok || t.typ != tokenIdent {
+			return nil, fmt.Errorf("exists requires a field name")
+		}
+		p.pos++
+		return &existsNode{keys: GetKeys(t.text)}, p.expect(")")
+	}
+	return p.parseComparison()
+}
+
+func (p *condParser) parseComparison() (condNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	t, ok := p.peek()
+	if !ok || t.typ != tokenOp {
+		return &truthNode{operand: left}, nil
+	}
+	switch t.text {
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.pos++
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return &compareNode{op: t.text, left: left, right: right}, nil
+	case "=~", "!~":
+		p.pos++
+		pt, ok := p.peek()
+		if !ok || pt.typ != tokenString {
+			return nil, fmt.Errorf("%v requires a string pattern", t.text)
+		}
+		p.pos++
+		re, err := regexp.Compile(pt.text)
+		if err != nil {
+			return nil, fmt.Errorf("compile regex %q error %v", pt.text, err)
+		}
+		return &regexNode{not: t.text == "!~", operand: left, re: re}, nil
+	}
+	return &truthNode{operand: left}, nil
+}
+
+func (p *condParser) parseOperand() (condOperand, error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	p.pos++
+	switch t.typ {
+	case tokenString:
+		return &literalOperand{v: t.text}, nil
+	case tokenNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", t.text)
+		}
+		return &literalOperand{v: f}, nil
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return &literalOperand{v: true}, nil
+		case "false":
+			return &literalOperand{v: false}, nil
+		case "null":
+			return &literalOperand{v: nil}, nil
+		case "and", "or", "not", "exists":
+			return nil, fmt.Errorf("unexpected %q", t.text)
+		}
+		return &fieldOperand{keys: GetKeys(t.text)}, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
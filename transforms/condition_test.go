@@ -0,0 +1,86 @@
+package transforms
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestCondition(t *testing.T) {
+	data := Data{
+		"type":   "access",
+		"status": int64(404),
+		"code":   "200",
+		"rate":   0.5,
+		"ok":     false,
+		"empty":  "",
+		"path":   `C:\logs\app.log`,
+		"req":    map[string]interface{}{"method": "GET", "size": 10},
+	}
+	tests := []struct {
+		expr  string
+		match bool
+	}{
+		{`type == "access"`, true},
+		{`type == 'error'`, false},
+		{`type != "error"`, true},
+		{`status >= 400`, true},
+		{`status < 400`, false},
+		{`status == "404"`, true},
+		{`code == 200`, true},
+		{`code > 100 && rate <= 0.5`, true},
+		{`rate > -1`, true},
+		{`type > "a"`, true},
+		{`type < 1`, false},
+		{`ok == false`, true},
+		{`ok`, false},
+		{`empty`, false},
+		{`type`, true},
+		{`not ok`, true},
+		{`!ok`, true},
+		{`missing == null`, true},
+		{`missing != "a"`, true},
+		{`missing > 1`, false},
+		{`exists(req.method)`, true},
+		{`exists(req.missing)`, false},
+		{`not exists(missing)`, true},
+		{`req.method == "GET" and req.size > 5`, true},
+		{`req.method =~ "^G"`, true},
+		{`req.method !~ "^P"`, true},
+		{`missing =~ "."`, false},
+		{`missing !~ "."`, true},
+		{`status =~ '^4\d\d$'`, true},
+		{`path =~ '^C:\\logs'`, true},
+		{`type == "error" or status == 404`, true},
+		{`type == "error" or status == 404 and ok`, false},
+		{`(type == "error" or status == 404) and not ok`, true},
+		{`type == "it\"s"`, false},
+	}
+	for _, test := range tests {
+		cond, err := NewCondition(test.expr)
+		if !assert.NoError(t, err, test.expr) {
+			continue
+		}
+		assert.Equal(t, test.match, cond.Match(data), test.expr)
+		assert.Equal(t, test.expr, cond.String())
+	}
+
+	for _, expr := range []string{
+		``,
+		`type ==`,
+		`type == "access`,
+		`(type == "access"`,
+		`type == "access")`,
+		`status =~ 1`,
+		`status =~ "("`,
+		`exists("type")`,
+		`type = "access"`,
+		`type == "access" and`,
+		`1.2.3 == 1`,
+	} {
+		_, err := NewCondition(expr)
+		assert.Error(t, err, expr)
+	}
+}
@@ -25,6 +25,8 @@ type Transformer struct {
 	keys  []string
 
 	numRoutine int
+
+	transforms.ErrorIndexRecorder
 }
 
 func (t *Transformer) Init() error {
@@ -94,6 +96,7 @@ func (t *Transformer) Transform(datas []Data) ([]Data, error) {
 		datas[transformResult.Index] = transformResult.CurData
 	}
 
+	t.RecordErrorIndex(transformResultSlice)
 	t.stats, fmtErr = transforms.SetStatsInfo(err, t.stats, int64(errNum), int64(dataLen), t.Type())
 	return datas, fmtErr
 }
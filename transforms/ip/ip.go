@@ -56,6 +56,8 @@ type Transformer struct {
 	keysDistrictCode []string
 
 	numRoutine int
+
+	transforms.ErrorIndexRecorder
 }
 
 func (t *Transformer) Init() error {
@@ -173,6 +175,7 @@ func (t *Transformer) Transform(datas []Data) ([]Data, error) {
 		datas[transformResult.Index] = transformResult.CurData
 	}
 
+	t.RecordErrorIndex(transformResultSlice)
 	t.stats, fmtErr = transforms.SetStatsInfo(err, t.stats, int64(errNum), int64(dataLen), t.Type())
 	return datas, fmtErr
 }
@@ -25,6 +25,8 @@ type Number2Ip struct {
 	stats StatsInfo
 
 	numRoutine int
+
+	transforms.ErrorIndexRecorder
 }
 
 func (g *Number2Ip) Init() error {
@@ -92,6 +94,7 @@ func (g *Number2Ip) Transform(datas []Data) ([]Data, error) {
 		datas[transformResult.Index] = transformResult.CurData
 	}
 
+	g.RecordErrorIndex(transformResultSlice)
 	g.stats, fmtErr = transforms.SetStatsInfo(err, g.stats, int64(errNum), int64(dataLen), g.Type())
 	return datas, fmtErr
 }
@@ -0,0 +1,58 @@
+package lookup
+
+import (
+	"container/list"
+	"time"
+)
+
+type cacheEntry struct {
+	key     string
+	row     map[string]interface{}
+	expires time.Time
+}
+
+// lookupCache 为带过期时间的 LRU 缓存，没有查到的 key 也会缓存，避免重复查询
+type lookupCache struct {
+	size  int
+	ttl   time.Duration
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+func newLookupCache(size int, ttl time.Duration) *lookupCache {
+	return &lookupCache{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *lookupCache) get(key string, now time.Time) (map[string]interface{}, bool) {
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*cacheEntry)
+	if c.ttl > 0 && !now.Before(entry.expires) {
+		c.remove(e)
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return entry.row, true
+}
+
+func (c *lookupCache) set(key string, row map[string]interface{}, now time.Time) {
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+	c.items[key] = c.ll.PushFront(&cacheEntry{key: key, row: row, expires: now.Add(c.ttl)})
+	for c.ll.Len() > c.size {
+		c.remove(c.ll.Back())
+	}
+}
+
+func (c *lookupCache) remove(e *list.Element) {
+	c.ll.Remove(e)
+	delete(c.items, e.Value.(*cacheEntry).key)
+}
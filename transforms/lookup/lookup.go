@@ -0,0 +1,701 @@
+package lookup
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/json-iterator/go"
+	_ "github.com/lib/pq"
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/transforms"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	SourceCSV      = "csv"
+	SourceJSON     = "json"
+	SourceMySQL    = "mysql"
+	SourcePostgres = "postgres"
+
+	DefaultFileReloadInterval = 10 * time.Second
+	DefaultSQLReloadInterval  = 10 * time.Minute
+	DefaultCacheSize          = 10000
+	DefaultCacheTTL           = 10 * time.Minute
+
+	// JSON 文件中 key 对应的值不是对象时使用的列名
+	jsonValueColumn = "value"
+
+	statsHit  = "hit"
+	statsMiss = "miss"
+)
+
+var (
+	_ transforms.StatsTransformer = &Lookup{}
+	_ transforms.Transformer      = &Lookup{}
+	_ transforms.Initializer      = &Lookup{}
+)
+
+// Lookup 用记录中的字段在外部的对照表中查找，把查到的列写入记录
+// 对照表可以是 csv、json 文件或 mysql、postgres 的表，默认全部加载到内存中，文件变化或到达刷新间隔时重新加载
+// 对于无法全部加载的大表，可以开启 lazy，每个 key 按需查询并缓存在带过期时间的 LRU 中
+type Lookup struct {
+	Key        string `json:"key"`
+	SourceType string `json:"source_type"`
+	// Path 为 csv 或 json 文件的路径
+	Path      string `json:"path"`
+	Delimiter string `json:"delimiter"`
+	// DataSource 和 Table 为 mysql、postgres 的连接串和表名
+	DataSource string `json:"datasource"`
+	Table      string `json:"table"`
+	// KeyColumn 为对照表中与 Key 对应的列，csv 默认为第一列
+	KeyColumn string `json:"key_column"`
+	// Columns 为逗号分隔的需要写入记录的列，可以用 "列 as 字段" 指定写入的字段，为空时写入除 KeyColumn 外的所有列
+	Columns string `json:"columns"`
+	// Default 为没有查到时写入各列的值，为空时不写入
+	Default string `json:"default"`
+	// ReloadInterval 对于文件为检查文件是否变化的间隔，对于数据库为重新加载整张表的间隔
+	ReloadInterval string `json:"reload_interval"`
+	Lazy           bool   `json:"lazy"`
+	CacheSize      int    `json:"cache_size"`
+	CacheTTL       string `json:"cache_ttl"`
+	stats          StatsInfo
+
+	keys           []string
+	columns        []lookupColumn
+	reloadInterval time.Duration
+	now            func() time.Time
+
+	lock sync.Mutex
+	// table 为预加载的对照表，header 为对照表中的列
+	table      map[string]map[string]interface{}
+	header     []string
+	lastLoad   time.Time
+	lastCheck  time.Time
+	fileMod    time.Time
+	fileSize   int64
+	cache      *lookupCache
+	db         *sql.DB
+	query      func(key string) (map[string]interface{}, error)
+	loadSQLAll func() (map[string]map[string]interface{}, []string, error)
+}
+
+// lookupColumn 为对照表中的列和写入记录的字段
+type lookupColumn struct {
+	name   string
+	target []string
+}
+
+func (g *Lookup) Init() error {
+	if g.Key == "" {
+		return errors.New("lookup transformer key is empty")
+	}
+	g.keys = GetKeys(g.Key)
+	columns, err := parseColumns(g.Columns)
+	if err != nil {
+		return err
+	}
+	g.columns = columns
+	if g.now == nil {
+		g.now = time.Now
+	}
+
+	switch g.SourceType {
+	case SourceCSV, SourceJSON:
+		if g.Path == "" {
+			return fmt.Errorf("lookup transformer path is empty for source type %v", g.SourceType)
+		}
+		if g.Lazy {
+			return fmt.Errorf("lookup transformer lazy is only supported by %v and %v", SourceMySQL, SourcePostgres)
+		}
+		if g.SourceType == SourceJSON && g.Delimiter != "" {
+			return errors.New("lookup transformer delimiter is only supported by csv")
+		}
+		if g.reloadInterval, err = parseInterval(g.ReloadInterval, DefaultFileReloadInterval); err != nil {
+			return err
+		}
+	case SourceMySQL, SourcePostgres:
+		if g.DataSource == "" || g.Table == "" || g.KeyColumn == "" {
+			return fmt.Errorf("lookup transformer datasource, table and key_column are required for source type %v", g.SourceType)
+		}
+		if g.reloadInterval, err = parseInterval(g.ReloadInterval, DefaultSQLReloadInterval); err != nil {
+			return err
+		}
+		if g.Lazy {
+			if g.CacheSize <= 0 {
+				g.CacheSize = DefaultCacheSize
+			}
+			ttl, err := parseInterval(g.CacheTTL, DefaultCacheTTL)
+			if err != nil {
+				return err
+			}
+			g.cache = newLookupCache(g.CacheSize, ttl)
+		}
+		if g.db, err = sql.Open(g.SourceType, g.DataSource); err != nil {
+			return fmt.Errorf("lookup transformer open %v error %v", g.SourceType, err)
+		}
+		if g.query == nil {
+			g.query = g.queryDB
+		}
+		if g.loadSQLAll == nil {
+			g.loadSQLAll = g.loadDB
+		}
+		if g.Lazy {
+			return nil
+		}
+	default:
+		return fmt.Errorf("lookup transformer source type %v is not supported", g.SourceType)
+	}
+	return g.reload()
+}
+
+func parseInterval(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("lookup transformer parse duration %v error %v", s, err)
+	}
+	return d, nil
+}
+
+// parseColumns 解析 "team, owner as owner_name" 形式的列配置
+func parseColumns(s string) ([]lookupColumn, error) {
+	var columns []lookupColumn
+	for _, item := range strings.Split(s, ",") {
+		fields := strings.Fields(item)
+		switch {
+		case len(fields) == 0:
+			continue
+		case len(fields) == 1:
+			columns = append(columns, lookupColumn{name: fields[0], target: GetKeys(fields[0])})
+		case len(fields) == 3 && strings.EqualFold(fields[1], "as"):
+			columns = append(columns, lookupColumn{name: fields[0], target: GetKeys(fields[2])})
+		default:
+			return nil, fmt.Errorf("lookup transformer column %q is invalid, should be column or column as field", strings.TrimSpace(item))
+		}
+	}
+	return columns, nil
+}
+
+// reload 重新加载整张对照表，加载失败时保留原来的数据
+func (g *Lookup) reload() error {
+	var (
+		table  map[string]map[string]interface{}
+		header []string
+		err    error
+	)
+	switch g.SourceType {
+	case SourceCSV, SourceJSON:
+		var info os.FileInfo
+		if info, err = os.Stat(g.Path); err != nil {
+			return err
+		}
+		var content []byte
+		if content, err = ioutil.ReadFile(g.Path); err != nil {
+			return err
+		}
+		if g.SourceType == SourceCSV {
+			table, header, err = g.parseCSV(content)
+		} else {
+			table, header, err = g.parseJSON(content)
+		}
+		if err != nil {
+			return fmt.Errorf("lookup transformer load %v error %v", g.Path, err)
+		}
+		g.fileMod, g.fileSize = info.ModTime(), info.Size()
+	default:
+		if table, header, err = g.loadSQLAll(); err != nil {
+			return fmt.Errorf("lookup transformer load table %v error %v", g.Table, err)
+		}
+	}
+	g.table, g.header = table, header
+	g.lastLoad = g.now()
+	return nil
+}
+
+func (g *Lookup) parseCSV(content []byte) (map[string]map[string]interface{}, []string, error) {
+	r := csv.NewReader(strings.NewReader(string(content)))
+	if g.Delimiter != "" {
+		delimiter := []rune(g.Delimiter)
+		if len(delimiter) != 1 {
+			return nil, nil, fmt.Errorf("delimiter %q must be one character", g.Delimiter)
+		}
+		r.Comma = delimiter[0]
+	}
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read csv header error %v", err)
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+	keyIdx := 0
+	if g.KeyColumn != "" {
+		keyIdx = -1
+		for i, name := range header {
+			if name == g.KeyColumn {
+				keyIdx = i
+				break
+			}
+		}
+		if keyIdx < 0 {
+			return nil, nil, fmt.Errorf("key column %v is not in csv header %v", g.KeyColumn, header)
+		}
+	}
+	table := make(map[string]map[string]interface{})
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if keyIdx >= len(record) {
+			continue
+		}
+		row := make(map[string]interface{}, len(header))
+		for i, name := range header {
+			if i != keyIdx && i < len(record) {
+				row[name] = record[i]
+			}
+		}
+		table[record[keyIdx]] = row
+	}
+	return table, removeColumn(header, header[keyIdx]), nil
+}
+
+// parseJSON 支持 key 到对象或值的映射，或者包含 KeyColumn 的对象数组
+func (g *Lookup) parseJSON(content []byte) (map[string]map[string]interface{}, []string, error) {
+	var v interface{}
+	if err := jsoniter.Unmarshal(content, &v); err != nil {
+		return nil, nil, err
+	}
+	var (
+		table   = make(map[string]map[string]interface{})
+		columns = make(map[string]bool)
+		header  []string
+	)
+	addRow := func(key string, row map[string]interface{}) {
+		table[key] = row
+		for name := range row {
+			if !columns[name] {
+				columns[name] = true
+				header = append(header, name)
+			}
+		}
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for key, item := range val {
+			if row, ok := item.(map[string]interface{}); ok {
+				addRow(key, row)
+			} else {
+				addRow(key, map[string]interface{}{jsonValueColumn: item})
+			}
+		}
+	case []interface{}:
+		if g.KeyColumn == "" {
+			return nil, nil, errors.New("key_column is required when json file is an array")
+		}
+		for _, item := range val {
+			row, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, nil, fmt.Errorf("json array item %v is not an object", item)
+			}
+			key, ok := row[g.KeyColumn]
+			if !ok || key == nil {
+				continue
+			}
+			delete(row, g.KeyColumn)
+			addRow(fmt.Sprint(key), row)
+		}
+	default:
+		return nil, nil, errors.New("json file must be an object or an array of objects")
+	}
+	return table, header, nil
+}
+
+func removeColumn(header []string, column string) []string {
+	ret := make([]string, 0, len(header))
+	for _, name := range header {
+		if name != column {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
+func (g *Lookup) selectColumns() string {
+	if len(g.columns) == 0 {
+		return "*"
+	}
+	names := make([]string, 0, len(g.columns)+1)
+	names = append(names, g.KeyColumn)
+	for _, c := range g.columns {
+		names = append(names, c.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func (g *Lookup) loadDB() (map[string]map[string]interface{}, []string, error) {
+	rows, err := g.db.Query(fmt.Sprintf("SELECT %s FROM %s", g.selectColumns(), g.Table))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	header, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	table := make(map[string]map[string]interface{})
+	for rows.Next() {
+		row, err := scanRow(rows, header)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, ok := row[g.KeyColumn]
+		if !ok || key == nil {
+			continue
+		}
+		delete(row, g.KeyColumn)
+		table[fmt.Sprint(key)] = row
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	return table, removeColumn(header, g.KeyColumn), nil
+}
+
+// queryDB 按需查询一个 key，没有查到时返回 nil
+func (g *Lookup) queryDB(key string) (map[string]interface{}, error) {
+	placeholder := "?"
+	if g.SourceType == SourcePostgres {
+		placeholder = "$1"
+	}
+	rows, err := g.db.Query(fmt.Sprintf("SELECT %s FROM %s WHERE %s = %s LIMIT 1", g.selectColumns(), g.Table, g.KeyColumn, placeholder), key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	header, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	row, err := scanRow(rows, header)
+	if err != nil {
+		return nil, err
+	}
+	delete(row, g.KeyColumn)
+	return row, nil
+}
+
+func scanRow(rows *sql.Rows, header []string) (map[string]interface{}, error) {
+	values := make([]interface{}, len(header))
+	ptrs := make([]interface{}, len(header))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return nil, err
+	}
+	row := make(map[string]interface{}, len(header))
+	for i, name := range header {
+		if b, ok := values[i].([]byte); ok {
+			row[name] = string(b)
+		} else {
+			row[name] = values[i]
+		}
+	}
+	return row, nil
+}
+
+// checkReload 在文件变化或到达刷新间隔时重新加载对照表
+func (g *Lookup) checkReload(now time.Time) error {
+	if g.Lazy || g.reloadInterval <= 0 {
+		return nil
+	}
+	switch g.SourceType {
+	case SourceCSV, SourceJSON:
+		if now.Sub(g.lastCheck) < g.reloadInterval {
+			return nil
+		}
+		g.lastCheck = now
+		info, err := os.Stat(g.Path)
+		if err != nil {
+			return err
+		}
+		if info.ModTime().Equal(g.fileMod) && info.Size() == g.fileSize {
+			return nil
+		}
+	default:
+		if now.Sub(g.lastLoad) < g.reloadInterval {
+			return nil
+		}
+	}
+	return g.reload()
+}
+
+func (g *Lookup) find(key string) (map[string]interface{}, error) {
+	if !g.Lazy {
+		return g.table[key], nil
+	}
+	now := g.now()
+	if row, ok := g.cache.get(key, now); ok {
+		return row, nil
+	}
+	row, err := g.query(key)
+	if err != nil {
+		return nil, err
+	}
+	g.cache.set(key, row, now)
+	return row, nil
+}
+
+func (g *Lookup) Transform(datas []Data) ([]Data, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	var (
+		err        error
+		errNum     int
+		hit, miss  int64
+		reloadErr  = g.checkReload(g.now())
+		defaultVal interface{}
+	)
+	if reloadErr != nil {
+		// 重新加载失败时继续使用原来的数据
+		log.Warnf("lookup transformer reload error %v", reloadErr)
+		g.stats.LastError = reloadErr.Error()
+	}
+	if g.Default != "" {
+		defaultVal = g.Default
+	}
+	for i := range datas {
+		v, e := GetMapValue(datas[i], g.keys...)
+		if e != nil || v == nil {
+			continue
+		}
+		row, e := g.find(fmt.Sprint(v))
+		if e != nil {
+			err = e
+			errNum++
+			continue
+		}
+		if row == nil {
+			miss++
+			if defaultVal != nil {
+				g.setColumns(datas[i], nil, defaultVal)
+			}
+			continue
+		}
+		hit++
+		g.setColumns(datas[i], row, nil)
+	}
+
+	details := make(map[string]int64, 2)
+	for k, v := range g.stats.Details {
+		details[k] = v
+	}
+	details[statsHit] += hit
+	details[statsMiss] += miss
+	g.stats.Details = details
+	g.stats, err = transforms.SetStatsInfo(err, g.stats, int64(errNum), int64(len(datas)), g.Type())
+	return datas, err
+}
+
+// setColumns 写入查到的列，row 为空时所有列写入 defaultVal
+func (g *Lookup) setColumns(data Data, row map[string]interface{}, defaultVal interface{}) {
+	if len(g.columns) > 0 {
+		for _, c := range g.columns {
+			if row == nil {
+				SetMapValue(data, defaultVal, false, c.target...)
+			} else if val, ok := row[c.name]; ok {
+				SetMapValue(data, val, false, c.target...)
+			}
+		}
+		return
+	}
+	if row == nil {
+		for _, name := range g.header {
+			data[name] = defaultVal
+		}
+		return
+	}
+	for name, val := range row {
+		data[name] = val
+	}
+}
+
+func (g *Lookup) RawTransform(datas []string) ([]string, error) {
+	return datas, errors.New("lookup transformer not support rawTransform")
+}
+
+func (g *Lookup) Close() error {
+	if g.db != nil {
+		return g.db.Close()
+	}
+	return nil
+}
+
+func (g *Lookup) Description() string {
+	return "用字段在 csv、json 文件或数据库表中查找，将对应的列写入数据"
+}
+
+func (g *Lookup) Type() string {
+	return "lookup"
+}
+
+func (g *Lookup) SampleConfig() string {
+	return `{
+       "type":"lookup",
+       "key":"host",
+       "source_type":"csv",
+       "path":"/path/to/hosts.csv",
+       "columns":"team, owner as owner_name",
+       "default":"unknown"
+    }`
+}
+
+func (g *Lookup) ConfigOptions() []Option {
+	return []Option{
+		transforms.KeyFieldName,
+		{
+			KeyName:       "source_type",
+			Element:       Radio,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{SourceCSV, SourceJSON, SourceMySQL, SourcePostgres},
+			Default:       SourceCSV,
+			DefaultNoUse:  false,
+			Description:   "对照表类型(source_type)",
+			Type:          transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "path",
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "对照表文件路径(path)",
+			ToolTip:      "csv 文件第一行为列名，json 文件为 key 到对象的映射或对象数组",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "datasource",
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "数据库连接串(datasource)",
+			ToolTip:      "source_type 为 mysql 或 postgres 时填写",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "table",
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "数据库表名(table)",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "key_column",
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "对照表中的 key 列(key_column)",
+			ToolTip:      "csv 默认为第一列，数据库必填",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "columns",
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "写入数据的列(columns)",
+			ToolTip:      "逗号分隔，可以用 \"列 as 字段\" 指定写入的字段，不填表示所有列",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "default",
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "没有查到时的默认值(default)",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "reload_interval",
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "刷新间隔(reload_interval)",
+			ToolTip:      "文件默认每 10s 检查是否变化，数据库默认每 10m 重新加载",
+			Advance:      true,
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:       "lazy",
+			Element:       Radio,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{false, true},
+			Default:       false,
+			DefaultNoUse:  false,
+			Description:   "按需查询数据库(lazy)",
+			ToolTip:       "表太大无法全部加载时开启，查询结果缓存在 LRU 中",
+			Advance:       true,
+			Type:          transforms.TransformTypeBoolean,
+		},
+		{
+			KeyName:      "cache_size",
+			ChooseOnly:   false,
+			Default:      DefaultCacheSize,
+			DefaultNoUse: false,
+			Description:  "缓存数量(cache_size)",
+			Advance:      true,
+			Type:         transforms.TransformTypeLong,
+		},
+		{
+			KeyName:      "cache_ttl",
+			ChooseOnly:   false,
+			Default:      DefaultCacheTTL.String(),
+			DefaultNoUse: false,
+			Description:  "缓存过期时间(cache_ttl)",
+			Advance:      true,
+			Type:         transforms.TransformTypeString,
+		},
+	}
+}
+
+func (g *Lookup) Stage() string {
+	return transforms.StageAfterParser
+}
+
+// Stats 返回的 Details 为查到和没有查到的次数
+func (g *Lookup) Stats() StatsInfo {
+	return g.stats
+}
+
+func (g *Lookup) SetStats(err string) StatsInfo {
+	g.stats.LastError = err
+	return g.stats
+}
+
+func init() {
+	transforms.Add("lookup", func() transforms.Transformer {
+		return &Lookup{}
+	})
+}
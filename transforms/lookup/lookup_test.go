@@ -0,0 +1,184 @@
+package lookup
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestLookupCSV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lookup_csv")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hosts.csv")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("host,team,owner\nweb1,infra,alice\nweb2,search,bob\n"), 0644))
+
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	g := &Lookup{
+		Key:        "host",
+		SourceType: SourceCSV,
+		Path:       path,
+		Columns:    "team, owner as meta.owner",
+		Default:    "unknown",
+		now:        func() time.Time { return now },
+	}
+	assert.NoError(t, g.Init())
+	res, err := g.Transform([]Data{{"host": "web1"}, {"host": "web3"}, {"a": 1}})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{
+		{"host": "web1", "team": "infra", "meta": map[string]interface{}{"owner": "alice"}},
+		{"host": "web3", "team": "unknown", "meta": map[string]interface{}{"owner": "unknown"}},
+		{"a": 1},
+	}, res)
+	assert.Equal(t, map[string]int64{"hit": 1, "miss": 1}, g.Stats().Details)
+
+	// 文件变化后在下一次检查时重新加载
+	assert.NoError(t, ioutil.WriteFile(path, []byte("host,team,owner\nweb1,core,carol\nweb3,ops,dave\n"), 0644))
+	res, err = g.Transform([]Data{{"host": "web1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "infra", res[0]["team"])
+	now = now.Add(DefaultFileReloadInterval)
+	res, err = g.Transform([]Data{{"host": "web1"}, {"host": "web3"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "core", res[0]["team"])
+	assert.Equal(t, "ops", res[1]["team"])
+
+	// 重新加载失败时继续使用原来的数据
+	assert.NoError(t, os.Remove(path))
+	now = now.Add(DefaultFileReloadInterval)
+	res, err = g.Transform([]Data{{"host": "web1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "core", res[0]["team"])
+	assert.NotEmpty(t, g.Stats().LastError)
+
+	path = filepath.Join(dir, "hosts.tsv")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("team\thost\ninfra\tweb1\n"), 0644))
+	g = &Lookup{Key: "h", SourceType: SourceCSV, Path: path, Delimiter: "\t", KeyColumn: "host", Default: "none"}
+	assert.NoError(t, g.Init())
+	res, err = g.Transform([]Data{{"h": "web1"}, {"h": "web2"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{{"h": "web1", "team": "infra"}, {"h": "web2", "team": "none"}}, res)
+
+	for _, l := range []*Lookup{
+		{SourceType: SourceCSV, Path: path},
+		{Key: "h", SourceType: "x"},
+		{Key: "h", SourceType: SourceCSV},
+		{Key: "h", SourceType: SourceCSV, Path: path, KeyColumn: "missing"},
+		{Key: "h", SourceType: SourceCSV, Path: path, Lazy: true},
+		{Key: "h", SourceType: SourceCSV, Path: path, Columns: "a b"},
+		{Key: "h", SourceType: SourceMySQL, DataSource: "root@tcp(127.0.0.1:3306)/db"},
+	} {
+		assert.Error(t, l.Init())
+	}
+}
+
+func TestLookupJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lookup_json")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "codes.json")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`{"500": "internal error", "404": "not found"}`), 0644))
+
+	g := &Lookup{Key: "code", SourceType: SourceJSON, Path: path, Columns: "value as desc"}
+	assert.NoError(t, g.Init())
+	res, err := g.Transform([]Data{{"code": float64(404)}, {"code": "500"}, {"code": 200}})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{{"code": float64(404), "desc": "not found"}, {"code": "500", "desc": "internal error"}, {"code": 200}}, res)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`[{"id": 1, "tenant": "t1", "plan": "pro"}, {"id": 2, "tenant": "t2"}]`), 0644))
+	g = &Lookup{Key: "user_id", SourceType: SourceJSON, Path: path, KeyColumn: "id"}
+	assert.NoError(t, g.Init())
+	res, err = g.Transform([]Data{{"user_id": 1}, {"user_id": 2}})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{{"user_id": 1, "tenant": "t1", "plan": "pro"}, {"user_id": 2, "tenant": "t2"}}, res)
+
+	assert.Error(t, (&Lookup{Key: "user_id", SourceType: SourceJSON, Path: path}).Init())
+}
+
+func TestLookupSQLLazy(t *testing.T) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	queries := 0
+	tenants := map[string]string{"1": "t1", "2": "t2"}
+	g := &Lookup{
+		Key:        "user_id",
+		SourceType: SourceMySQL,
+		DataSource: "root@tcp(127.0.0.1:3306)/db",
+		Table:      "users",
+		KeyColumn:  "id",
+		Columns:    "tenant",
+		Lazy:       true,
+		CacheSize:  2,
+		CacheTTL:   "1m",
+		now:        func() time.Time { return now },
+		query: func(key string) (map[string]interface{}, error) {
+			queries++
+			if key == "bad" {
+				return nil, errors.New("query error")
+			}
+			if tenant, ok := tenants[key]; ok {
+				return map[string]interface{}{"tenant": tenant}, nil
+			}
+			return nil, nil
+		},
+	}
+	assert.NoError(t, g.Init())
+	defer g.Close()
+	assert.Equal(t, "SELECT id, tenant FROM users", "SELECT "+g.selectColumns()+" FROM users")
+
+	res, err := g.Transform([]Data{{"user_id": 1}, {"user_id": 1}, {"user_id": 3}, {"user_id": 3}})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{{"user_id": 1, "tenant": "t1"}, {"user_id": 1, "tenant": "t1"}, {"user_id": 3}, {"user_id": 3}}, res)
+	// 没有查到的 key 也被缓存
+	assert.Equal(t, 2, queries)
+
+	tenants["1"] = "t9"
+	now = now.Add(time.Minute)
+	res, err = g.Transform([]Data{{"user_id": 1}, {"user_id": "bad"}})
+	assert.Error(t, err)
+	assert.Equal(t, "t9", res[0]["tenant"])
+	assert.Equal(t, 4, queries)
+	assert.Equal(t, int64(1), g.Stats().Errors)
+
+	// 超过缓存数量时淘汰最久没有使用的 key
+	_, err = g.Transform([]Data{{"user_id": 2}, {"user_id": 3}, {"user_id": 1}})
+	assert.NoError(t, err)
+	assert.Equal(t, 7, queries)
+	_, err = g.Transform([]Data{{"user_id": 3}, {"user_id": 1}})
+	assert.NoError(t, err)
+	assert.Equal(t, 7, queries)
+}
+
+func TestLookupSQLPreload(t *testing.T) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	loads := 0
+	g := &Lookup{
+		Key:        "user_id",
+		SourceType: SourcePostgres,
+		DataSource: "postgres://127.0.0.1/db",
+		Table:      "users",
+		KeyColumn:  "id",
+		Default:    "none",
+		now:        func() time.Time { return now },
+		loadSQLAll: func() (map[string]map[string]interface{}, []string, error) {
+			loads++
+			return map[string]map[string]interface{}{"1": {"tenant": "t1", "plan": int64(loads)}}, []string{"tenant", "plan"}, nil
+		},
+	}
+	assert.NoError(t, g.Init())
+	defer g.Close()
+	res, err := g.Transform([]Data{{"user_id": 1}, {"user_id": 2}})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{{"user_id": 1, "tenant": "t1", "plan": int64(1)}, {"user_id": 2, "tenant": "none", "plan": "none"}}, res)
+
+	now = now.Add(DefaultSQLReloadInterval)
+	res, err = g.Transform([]Data{{"user_id": 1}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), res[0]["plan"])
+}
@@ -23,6 +23,8 @@ type ArrayExpand struct {
 	stats      StatsInfo
 	keys       []string
 	numRoutine int
+
+	transforms.ErrorIndexRecorder
 }
 
 func (p *ArrayExpand) Init() error {
@@ -202,6 +204,7 @@ func (p *ArrayExpand) Transform(datas []Data) ([]Data, error) {
 		datas[transformResult.Index] = transformResult.CurData
 	}
 
+	p.RecordErrorIndex(transformResultSlice)
 	p.stats, fmtErr = transforms.SetStatsInfo(err, p.stats, int64(errNum), int64(dataLen), p.Type())
 	return datas, fmtErr
 }
@@ -52,6 +52,8 @@ type Case struct {
 	stats      StatsInfo
 	keys       []string
 	numRoutine int
+
+	transforms.ErrorIndexRecorder
 }
 
 func (c *Case) Init() error {
@@ -213,6 +215,7 @@ func (c *Case) Transform(datas []Data) ([]Data, error) {
 		datas[transformResult.Index] = transformResult.CurData
 	}
 
+	c.RecordErrorIndex(transformResultSlice)
 	c.stats, fmtErr = transforms.SetStatsInfo(err, c.stats, int64(errNum), int64(dataLen), c.Type())
 	return datas, fmtErr
 }
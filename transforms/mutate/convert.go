@@ -31,6 +31,8 @@ type Converter struct {
 	keysMap map[int][]string
 
 	numRoutine int
+
+	transforms.ErrorIndexRecorder
 }
 
 func (g *Converter) Init() error {
@@ -114,6 +116,7 @@ func (g *Converter) Transform(datas []Data) ([]Data, error) {
 		datas[transformResult.Index] = transformResult.CurData
 	}
 
+	g.RecordErrorIndex(transformResultSlice)
 	g.stats, fmtErr = transforms.SetStatsInfo(err, g.stats, int64(errNum), int64(dataLen), g.Type())
 	return datas, fmtErr
 }
@@ -0,0 +1,606 @@
+package mutate
+
+import (
+	"container/list"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qiniu/log"
+
+	"github.com/qiniu/logkit/transforms"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	DedupModeExact = "exact"
+	DedupModeBloom = "bloom"
+
+	DefaultDedupCapacity          = 100000
+	DefaultDedupFalsePositiveRate = 0.001
+	DefaultDedupSyncInterval      = 10 * time.Second
+
+	dedupStateFile = "dedup.state"
+)
+
+var (
+	_ transforms.StatsTransformer      = &Dedup{}
+	_ transforms.Transformer           = &Dedup{}
+	_ transforms.Initializer           = &Dedup{}
+	_ transforms.StatefulTransformer   = &Dedup{}
+	_ transforms.CheckpointTransformer = &Dedup{}
+)
+
+// Dedup 按指定字段或整条数据计算 key，丢弃在时间窗口内或最近 capacity 个 key 中已经出现过的数据
+// 在 runner 中运行时，状态在 runner 保存读取位置之后保存在 runner 的 meta 目录中，重启后继续生效
+type Dedup struct {
+	// Keys 为逗号分隔的字段，支持 a.b 形式的嵌套字段，为空时使用整条数据
+	Keys string `json:"keys"`
+	// Window 为去重的时间窗口，从 key 第一次出现开始计算，为空表示只按容量淘汰
+	Window string `json:"window"`
+	// Capacity 在 exact 模式下为最多记录的 key 数量，按 LRU 淘汰；在 bloom 模式下为每个过滤器的预期容量
+	Capacity int `json:"capacity"`
+	// Mode 为 exact 或 bloom，bloom 模式占用固定内存，但会按误判率误丢少量不重复的数据
+	Mode              string  `json:"mode"`
+	FalsePositiveRate float64 `json:"false_positive_rate"`
+	// SyncInterval 为 runner 保存读取位置时状态写入磁盘的最小间隔
+	SyncInterval string `json:"sync_interval"`
+	stats        StatsInfo
+
+	lock         sync.Mutex
+	keys         [][]string
+	window       time.Duration
+	syncInterval time.Duration
+	set          dedupSet
+	stateDir     string
+	lastSync     time.Time
+	now          func() time.Time
+}
+
+// dedupSet 记录出现过的 key
+type dedupSet interface {
+	// seenBefore 返回 key 是否已经出现过，没有出现过时记录下来
+	seenBefore(key uint64, now time.Time) bool
+	state() *dedupState
+	restore(state *dedupState, now time.Time) bool
+}
+
+// dedupState 为持久化到磁盘的状态
+type dedupState struct {
+	Mode string
+	// exact 模式下的 key 和第一次出现的时间，按从旧到新的顺序保存
+	Hashes []uint64
+	Times  []int64
+	// bloom 模式下的过滤器，依次为当前和上一个过滤器
+	Filters []bloomFilterState
+}
+
+type bloomFilterState struct {
+	Bits  []uint64
+	Count int
+	Start int64
+}
+
+func (g *Dedup) Init() error {
+	g.keys = g.keys[:0]
+	for _, key := range strings.Split(g.Keys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			g.keys = append(g.keys, GetKeys(key))
+		}
+	}
+	g.window = 0
+	if g.Window != "" {
+		window, err := time.ParseDuration(g.Window)
+		if err != nil {
+			return fmt.Errorf("dedup transformer parse window %v error %v", g.Window, err)
+		}
+		if window < 0 {
+			return fmt.Errorf("dedup transformer window %v must not be negative", g.Window)
+		}
+		g.window = window
+	}
+	g.syncInterval = DefaultDedupSyncInterval
+	if g.SyncInterval != "" {
+		interval, err := time.ParseDuration(g.SyncInterval)
+		if err != nil {
+			return fmt.Errorf("dedup transformer parse sync_interval %v error %v", g.SyncInterval, err)
+		}
+		g.syncInterval = interval
+	}
+	if g.Capacity <= 0 {
+		g.Capacity = DefaultDedupCapacity
+	}
+	if g.now == nil {
+		g.now = time.Now
+	}
+	switch g.Mode {
+	case "", DedupModeExact:
+		g.Mode = DedupModeExact
+		g.set = newLRUSet(g.window, g.Capacity)
+	case DedupModeBloom:
+		if g.FalsePositiveRate == 0 {
+			g.FalsePositiveRate = DefaultDedupFalsePositiveRate
+		}
+		if g.FalsePositiveRate < 0 || g.FalsePositiveRate >= 1 {
+			return fmt.Errorf("dedup transformer false_positive_rate %v must be in (0, 1)", g.FalsePositiveRate)
+		}
+		g.set = newBloomSet(g.window, g.Capacity, g.FalsePositiveRate, g.now())
+	default:
+		return fmt.Errorf("dedup transformer mode %v is not supported, must be %v or %v", g.Mode, DedupModeExact, DedupModeBloom)
+	}
+	return nil
+}
+
+// SetStateDir 指定状态保存的目录，并从目录中恢复上次保存的状态
+func (g *Dedup) SetStateDir(dir string) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.set == nil {
+		if err := g.Init(); err != nil {
+			return err
+		}
+	}
+	g.stateDir = dir
+	g.lastSync = g.now()
+	path := filepath.Join(dir, dedupStateFile)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var state dedupState
+	if err = gob.NewDecoder(f).Decode(&state); err != nil {
+		log.Warnf("dedup transformer decode state %v error %v, ignore it", path, err)
+		return nil
+	}
+	if state.Mode != g.Mode || !g.set.restore(&state, g.now()) {
+		log.Warnf("dedup transformer state %v does not match current config, ignore it", path)
+	}
+	return nil
+}
+
+func (g *Dedup) saveState() error {
+	if g.stateDir == "" || g.set == nil {
+		return nil
+	}
+	if err := os.MkdirAll(g.stateDir, DefaultDirPerm); err != nil {
+		return err
+	}
+	path := filepath.Join(g.stateDir, dedupStateFile)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, DefaultFilePerm)
+	if err != nil {
+		return err
+	}
+	state := g.set.state()
+	state.Mode = g.Mode
+	if err = gob.NewEncoder(f).Encode(state); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (g *Dedup) Transform(datas []Data) ([]Data, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.set == nil {
+		if err := g.Init(); err != nil {
+			return datas, err
+		}
+	}
+	var (
+		now    = g.now()
+		result = make([]Data, 0, len(datas))
+	)
+	for _, data := range datas {
+		if g.set.seenBefore(g.hash(data), now) {
+			continue
+		}
+		result = append(result, data)
+	}
+	g.stats.Dropped += int64(len(datas) - len(result))
+	g.stats, _ = transforms.SetStatsInfo(nil, g.stats, 0, int64(len(datas)), g.Type())
+	return result, nil
+}
+
+// hash 计算数据的 key，字段不存在和字段值为空是不同的 key
+func (g *Dedup) hash(data Data) uint64 {
+	h := fnv.New64a()
+	if len(g.keys) == 0 {
+		hashValue(h, map[string]interface{}(data))
+		return h.Sum64()
+	}
+	for _, keys := range g.keys {
+		v, err := GetMapValue(data, keys...)
+		if err != nil {
+			h.Write([]byte{0})
+			continue
+		}
+		h.Write([]byte{1})
+		hashValue(h, v)
+	}
+	return h.Sum64()
+}
+
+// hashValue 按确定的顺序写入值，map 按 key 排序，整数和整数值的浮点数视为相同的值
+func hashValue(h hash.Hash64, v interface{}) {
+	var buf [binary.MaxVarintLen64]byte
+	writeString := func(tag byte, s string) {
+		h.Write([]byte{tag})
+		h.Write(buf[:binary.PutUvarint(buf[:], uint64(len(s)))])
+		h.Write([]byte(s))
+	}
+	switch val := v.(type) {
+	case nil:
+		h.Write([]byte{'z'})
+		return
+	case string:
+		writeString('s', val)
+		return
+	case Data:
+		v = map[string]interface{}(val)
+	}
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Bool:
+		writeString('b', strconv.FormatBool(value.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeString('i', strconv.FormatInt(value.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		writeString('i', strconv.FormatUint(value.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		f := value.Float()
+		if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+			writeString('i', strconv.FormatInt(int64(f), 10))
+		} else {
+			writeString('f', strconv.FormatFloat(f, 'g', -1, 64))
+		}
+	case reflect.String:
+		writeString('s', value.String())
+	case reflect.Slice, reflect.Array:
+		h.Write([]byte{'a'})
+		h.Write(buf[:binary.PutUvarint(buf[:], uint64(value.Len()))])
+		for i := 0; i < value.Len(); i++ {
+			hashValue(h, value.Index(i).Interface())
+		}
+	case reflect.Map:
+		keys := value.MapKeys()
+		names := make([]string, len(keys))
+		values := make(map[string]interface{}, len(keys))
+		for i, k := range keys {
+			names[i] = fmt.Sprint(k.Interface())
+			values[names[i]] = value.MapIndex(k).Interface()
+		}
+		sort.Strings(names)
+		h.Write([]byte{'m'})
+		h.Write(buf[:binary.PutUvarint(buf[:], uint64(len(names)))])
+		for _, name := range names {
+			writeString('s', name)
+			hashValue(h, values[name])
+		}
+	default:
+		writeString('v', fmt.Sprint(v))
+	}
+}
+
+func (g *Dedup) RawTransform(datas []string) ([]string, error) {
+	return datas, errors.New("dedup transformer not support rawTransform")
+}
+
+// SaveState 将状态写入磁盘，force 为 false 时距离上次保存不足 sync_interval 则跳过
+// 只能在已经转换的数据都发送成功并保存读取位置之后调用，否则重启后重放的数据会被当作重复数据丢弃
+func (g *Dedup) SaveState(force bool) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.stateDir == "" {
+		return nil
+	}
+	now := g.now()
+	if !force && now.Sub(g.lastSync) < g.syncInterval {
+		return nil
+	}
+	g.lastSync = now
+	return g.saveState()
+}
+
+func (g *Dedup) Description() string {
+	return "按指定字段或整条数据去重，丢弃时间窗口内或最近记录中重复出现的数据"
+}
+
+func (g *Dedup) Type() string {
+	return "dedup"
+}
+
+func (g *Dedup) SampleConfig() string {
+	return `{
+       "type":"dedup",
+       "keys":"request_id",
+       "window":"10m",
+       "capacity":100000
+    }`
+}
+
+func (g *Dedup) ConfigOptions() []Option {
+	return []Option{
+		{
+			KeyName:      "keys",
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "去重字段(keys)",
+			ToolTip:      "逗号分隔的字段，嵌套字段用.连接，不填表示按整条数据去重",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "window",
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "去重时间窗口(window)",
+			ToolTip:      "如 10m、1h，不填表示只按容量淘汰",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "capacity",
+			ChooseOnly:   false,
+			Default:      DefaultDedupCapacity,
+			DefaultNoUse: false,
+			Description:  "最多记录的数据条数(capacity)",
+			ToolTip:      "exact 模式下超过时淘汰最久没有出现的记录，bloom 模式下为每个过滤器的预期容量",
+			Type:         transforms.TransformTypeLong,
+		},
+		{
+			KeyName:       "mode",
+			Element:       Radio,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{DedupModeExact, DedupModeBloom},
+			Default:       DedupModeExact,
+			DefaultNoUse:  false,
+			Description:   "去重方式(mode)",
+			ToolTip:       "bloom 使用布隆过滤器，占用固定内存，适合数量非常大的场景，但会误丢少量数据",
+			Advance:       true,
+			Type:          transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "false_positive_rate",
+			ChooseOnly:   false,
+			Default:      DefaultDedupFalsePositiveRate,
+			DefaultNoUse: false,
+			Description:  "bloom 模式的误判率(false_positive_rate)",
+			Advance:      true,
+			Type:         transforms.TransformTypeFloat,
+		},
+		{
+			KeyName:      "sync_interval",
+			ChooseOnly:   false,
+			Default:      DefaultDedupSyncInterval.String(),
+			DefaultNoUse: false,
+			Description:  "状态保存间隔(sync_interval)",
+			Advance:      true,
+			Type:         transforms.TransformTypeString,
+		},
+	}
+}
+
+func (g *Dedup) Stage() string {
+	return transforms.StageAfterParser
+}
+
+func (g *Dedup) Stats() StatsInfo {
+	return g.stats
+}
+
+func (g *Dedup) SetStats(err string) StatsInfo {
+	g.stats.LastError = err
+	return g.stats
+}
+
+func init() {
+	transforms.Add("dedup", func() transforms.Transformer {
+		return &Dedup{}
+	})
+}
+
+type lruEntry struct {
+	key uint64
+	at  time.Time
+}
+
+// lruSet 精确记录 key，超过容量时淘汰最久没有出现的 key
+type lruSet struct {
+	window   time.Duration
+	capacity int
+	ll       *list.List
+	items    map[uint64]*list.Element
+}
+
+func newLRUSet(window time.Duration, capacity int) *lruSet {
+	return &lruSet{
+		window:   window,
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[uint64]*list.Element),
+	}
+}
+
+func (s *lruSet) expired(entry *lruEntry, now time.Time) bool {
+	return s.window > 0 && now.Sub(entry.at) >= s.window
+}
+
+func (s *lruSet) seenBefore(key uint64, now time.Time) bool {
+	if e, ok := s.items[key]; ok {
+		entry := e.Value.(*lruEntry)
+		s.ll.MoveToFront(e)
+		if !s.expired(entry, now) {
+			return true
+		}
+		// 已经超过时间窗口，视为新出现的 key
+		entry.at = now
+		return false
+	}
+	s.items[key] = s.ll.PushFront(&lruEntry{key: key, at: now})
+	for s.ll.Len() > s.capacity || (s.ll.Len() > 0 && s.expired(s.ll.Back().Value.(*lruEntry), now)) {
+		s.remove(s.ll.Back())
+	}
+	return false
+}
+
+func (s *lruSet) remove(e *list.Element) {
+	s.ll.Remove(e)
+	delete(s.items, e.Value.(*lruEntry).key)
+}
+
+func (s *lruSet) state() *dedupState {
+	state := &dedupState{
+		Hashes: make([]uint64, 0, s.ll.Len()),
+		Times:  make([]int64, 0, s.ll.Len()),
+	}
+	for e := s.ll.Back(); e != nil; e = e.Prev() {
+		entry := e.Value.(*lruEntry)
+		state.Hashes = append(state.Hashes, entry.key)
+		state.Times = append(state.Times, entry.at.UnixNano())
+	}
+	return state
+}
+
+func (s *lruSet) restore(state *dedupState, now time.Time) bool {
+	if len(state.Hashes) != len(state.Times) {
+		return false
+	}
+	for i, key := range state.Hashes {
+		entry := &lruEntry{key: key, at: time.Unix(0, state.Times[i])}
+		if s.expired(entry, now) {
+			continue
+		}
+		if e, ok := s.items[key]; ok {
+			s.remove(e)
+		}
+		s.items[key] = s.ll.PushFront(entry)
+		if s.ll.Len() > s.capacity {
+			s.remove(s.ll.Back())
+		}
+	}
+	return true
+}
+
+// bloomSet 使用两个轮换的布隆过滤器记录 key，当前过滤器达到容量或时间窗口时轮换
+// 因此 key 被记录的时间在一到两个时间窗口之间，记录的数量在一到两倍容量之间
+type bloomSet struct {
+	window    time.Duration
+	capacity  int
+	m         uint64
+	k         uint64
+	cur, prev *bloomFilter
+}
+
+type bloomFilter struct {
+	bits  []uint64
+	count int
+	start time.Time
+}
+
+func newBloomSet(window time.Duration, capacity int, rate float64, now time.Time) *bloomSet {
+	// m = -n*ln(p)/ln(2)^2, k = m/n*ln(2)
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(rate) / (math.Ln2 * math.Ln2)))
+	m = (m + 63) / 64 * 64
+	k := uint64(math.Ceil(float64(m) / float64(capacity) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	s := &bloomSet{window: window, capacity: capacity, m: m, k: k}
+	s.cur = s.newFilter(now)
+	return s
+}
+
+func (s *bloomSet) newFilter(now time.Time) *bloomFilter {
+	return &bloomFilter{bits: make([]uint64, s.m/64), start: now}
+}
+
+func (s *bloomSet) rotate(now time.Time) {
+	if s.window > 0 && now.Sub(s.cur.start) >= 2*s.window {
+		s.prev, s.cur = nil, s.newFilter(now)
+		return
+	}
+	if s.cur.count >= s.capacity || (s.window > 0 && now.Sub(s.cur.start) >= s.window) {
+		s.prev, s.cur = s.cur, s.newFilter(now)
+	}
+}
+
+// locations 使用 key 的高低 32 位做双重哈希得到 k 个位置
+func (s *bloomSet) locations(key uint64, fn func(loc uint64) bool) bool {
+	h1, h2 := key&math.MaxUint32, key>>32|1
+	for i := uint64(0); i < s.k; i++ {
+		if !fn((h1 + i*h2) % s.m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *bloomSet) has(f *bloomFilter, key uint64) bool {
+	return s.locations(key, func(loc uint64) bool {
+		return f.bits[loc/64]&(1<<(loc%64)) != 0
+	})
+}
+
+func (s *bloomSet) seenBefore(key uint64, now time.Time) bool {
+	s.rotate(now)
+	if s.has(s.cur, key) || (s.prev != nil && s.has(s.prev, key)) {
+		return true
+	}
+	s.locations(key, func(loc uint64) bool {
+		s.cur.bits[loc/64] |= 1 << (loc % 64)
+		return true
+	})
+	s.cur.count++
+	return false
+}
+
+func (s *bloomSet) state() *dedupState {
+	state := &dedupState{}
+	for _, f := range []*bloomFilter{s.cur, s.prev} {
+		if f == nil {
+			break
+		}
+		state.Filters = append(state.Filters, bloomFilterState{Bits: f.bits, Count: f.count, Start: f.start.UnixNano()})
+	}
+	return state
+}
+
+func (s *bloomSet) restore(state *dedupState, now time.Time) bool {
+	if len(state.Filters) == 0 || len(state.Filters) > 2 {
+		return false
+	}
+	filters := make([]*bloomFilter, len(state.Filters))
+	for i, fs := range state.Filters {
+		if uint64(len(fs.Bits))*64 != s.m {
+			return false
+		}
+		filters[i] = &bloomFilter{bits: fs.Bits, count: fs.Count, start: time.Unix(0, fs.Start)}
+	}
+	s.cur, s.prev = filters[0], nil
+	if len(filters) > 1 {
+		s.prev = filters[1]
+	}
+	s.rotate(now)
+	return true
+}
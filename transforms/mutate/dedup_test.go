@@ -0,0 +1,164 @@
+package mutate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestDedupExact(t *testing.T) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	g := &Dedup{Keys: "id, req.host", Window: "1m", Capacity: 2, now: func() time.Time { return now }}
+	assert.NoError(t, g.Init())
+
+	res, err := g.Transform([]Data{
+		{"id": 1, "req": map[string]interface{}{"host": "a"}, "seq": 1},
+		{"id": 1, "req": map[string]interface{}{"host": "a"}, "seq": 2},
+		{"id": int64(1), "req": map[string]interface{}{"host": "b"}, "seq": 3},
+		{"id": float64(1), "req": map[string]interface{}{"host": "b"}, "seq": 4},
+		{"id": "1", "seq": 5},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{
+		{"id": 1, "req": map[string]interface{}{"host": "a"}, "seq": 1},
+		{"id": int64(1), "req": map[string]interface{}{"host": "b"}, "seq": 3},
+		{"id": "1", "seq": 5},
+	}, res)
+	assert.Equal(t, int64(2), g.Stats().Dropped)
+	assert.Equal(t, int64(5), g.Stats().Success)
+
+	// 容量为 2，id=1,host=a 已经被淘汰
+	res, err = g.Transform([]Data{{"id": 1, "req": map[string]interface{}{"host": "a"}}, {"id": "1"}})
+	assert.NoError(t, err)
+	assert.Len(t, res, 1)
+
+	// 超过时间窗口后不再视为重复
+	now = now.Add(time.Minute)
+	res, err = g.Transform([]Data{{"id": "1"}})
+	assert.NoError(t, err)
+	assert.Len(t, res, 1)
+	assert.Equal(t, int64(3), g.Stats().Dropped)
+
+	for _, d := range []*Dedup{{Window: "x"}, {Mode: "x"}, {Mode: DedupModeBloom, FalsePositiveRate: 1}} {
+		assert.Error(t, d.Init())
+	}
+}
+
+func TestDedupWholeRecord(t *testing.T) {
+	g := &Dedup{}
+	assert.NoError(t, g.Init())
+	res, err := g.Transform([]Data{
+		{"a": 1, "b": map[string]interface{}{"c": []interface{}{"x", 2}}},
+		{"b": map[string]interface{}{"c": []interface{}{"x", 2}}, "a": 1},
+		{"a": 1, "b": map[string]interface{}{"c": []interface{}{2, "x"}}},
+		{"a": "1", "b": map[string]interface{}{"c": []interface{}{"x", 2}}},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, res, 3)
+	assert.Equal(t, int64(1), g.Stats().Dropped)
+}
+
+func TestDedupBloom(t *testing.T) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	g := &Dedup{Keys: "id", Window: "1m", Capacity: 1000, Mode: DedupModeBloom, now: func() time.Time { return now }}
+	assert.NoError(t, g.Init())
+
+	var datas []Data
+	for i := 0; i < 500; i++ {
+		datas = append(datas, Data{"id": i})
+	}
+	res, err := g.Transform(datas)
+	assert.NoError(t, err)
+	// 误判率为 0.001
+	assert.True(t, len(res) >= 495, len(res))
+	res, err = g.Transform(datas)
+	assert.NoError(t, err)
+	assert.Len(t, res, 0)
+
+	// 轮换后上一个过滤器仍然生效，两个窗口后全部过期
+	now = now.Add(time.Minute)
+	res, err = g.Transform(datas[:10])
+	assert.NoError(t, err)
+	assert.Len(t, res, 0)
+	now = now.Add(2 * time.Minute)
+	res, err = g.Transform(datas[:10])
+	assert.NoError(t, err)
+	assert.Len(t, res, 10)
+}
+
+func TestDedupState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup_state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	stateDir := filepath.Join(dir, "dedup-0")
+
+	for _, mode := range []string{DedupModeExact, DedupModeBloom} {
+		os.RemoveAll(stateDir)
+		g := &Dedup{Keys: "id", Mode: mode}
+		assert.NoError(t, g.Init())
+		assert.NoError(t, g.SetStateDir(stateDir))
+		res, err := g.Transform([]Data{{"id": 1}, {"id": 2}})
+		assert.NoError(t, err)
+		assert.Len(t, res, 2)
+		assert.NoError(t, g.SaveState(true))
+
+		// 重启后仍然认为是重复数据
+		g = &Dedup{Keys: "id", Mode: mode}
+		assert.NoError(t, g.Init())
+		assert.NoError(t, g.SetStateDir(stateDir))
+		res, err = g.Transform([]Data{{"id": 1}, {"id": 2}, {"id": 3}})
+		assert.NoError(t, err)
+		assert.Equal(t, []Data{{"id": 3}}, res, mode)
+		assert.NoError(t, g.SaveState(true))
+	}
+
+	// 配置变化时忽略保存的状态
+	g := &Dedup{Keys: "id", Mode: DedupModeExact}
+	assert.NoError(t, g.Init())
+	assert.NoError(t, g.SetStateDir(stateDir))
+	res, err := g.Transform([]Data{{"id": 1}})
+	assert.NoError(t, err)
+	assert.Len(t, res, 1)
+
+	// 状态文件损坏时忽略
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(stateDir, dedupStateFile), []byte("bad"), 0600))
+	g = &Dedup{Keys: "id"}
+	assert.NoError(t, g.Init())
+	assert.NoError(t, g.SetStateDir(stateDir))
+}
+
+func TestDedupSaveState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup_save_state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, dedupStateFile)
+
+	now := time.Now()
+	g := &Dedup{Keys: "id", SyncInterval: "10s", now: func() time.Time { return now }}
+	assert.NoError(t, g.Init())
+	assert.NoError(t, g.SetStateDir(dir))
+	now = now.Add(time.Minute)
+	_, err = g.Transform([]Data{{"id": 1}})
+	assert.NoError(t, err)
+	// 转换时不保存状态，由 runner 在保存读取位置之后保存
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, g.SaveState(false))
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Remove(path))
+	// 不足 sync_interval 时跳过，force 时总是保存
+	assert.NoError(t, g.SaveState(false))
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, g.SaveState(true))
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+}
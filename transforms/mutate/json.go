@@ -28,6 +28,8 @@ type Json struct {
 	news []string
 
 	numRoutine int
+
+	transforms.ErrorIndexRecorder
 }
 
 func (g *Json) Init() error {
@@ -94,6 +96,7 @@ func (g *Json) Transform(datas []Data) ([]Data, error) {
 		datas[transformResult.Index] = transformResult.CurData
 	}
 
+	g.RecordErrorIndex(transformResultSlice)
 	g.stats, fmtErr = transforms.SetStatsInfo(err, g.stats, int64(errNum), int64(dataLen), g.Type())
 	return datas, fmtErr
 }
@@ -40,6 +40,8 @@ type KV struct {
 	keys       []string
 	news       []string
 	numRoutine int
+
+	transforms.ErrorIndexRecorder
 }
 
 func (k *KV) Init() error {
@@ -156,6 +158,7 @@ func (k *KV) Transform(datas []Data) ([]Data, error) {
 		datas[transformResult.Index] = transformResult.CurData
 	}
 
+	k.RecordErrorIndex(transformResultSlice)
 	k.stats, fmtErr = transforms.SetStatsInfo(err, k.stats, int64(errNum), int64(dataLen), k.Type())
 	return datas, fmtErr
 }
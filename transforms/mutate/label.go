@@ -23,6 +23,8 @@ type Label struct {
 	stats StatsInfo
 
 	numRoutine int
+
+	transforms.ErrorIndexRecorder
 }
 
 func (g *Label) Init() error {
@@ -92,6 +94,7 @@ func (g *Label) Transform(datas []Data) ([]Data, error) {
 		datas[transformResult.Index] = transformResult.CurData
 	}
 
+	g.RecordErrorIndex(transformResultSlice)
 	g.stats, fmtErr = transforms.SetStatsInfo(err, g.stats, int64(errNum), int64(dataLen), g.Type())
 	return datas, fmtErr
 }
@@ -0,0 +1,606 @@
+package mutate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qiniu/log"
+	"github.com/yuin/gopher-lua"
+
+	"github.com/qiniu/logkit/transforms"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	DefaultLuaFunction      = "process"
+	DefaultLuaTimeout       = 100 * time.Millisecond
+	DefaultLuaCallStackSize = 256
+	DefaultLuaRegistrySize  = 256 * 20
+)
+
+var (
+	_ transforms.StatsTransformer = &Lua{}
+	_ transforms.Transformer      = &Lua{}
+	_ transforms.Initializer      = &Lua{}
+)
+
+// Lua 在进程内的 lua 虚拟机中对每条数据调用指定的函数
+// 函数的参数为数据对应的 table，返回 table 表示处理后的数据，返回 table 的数组表示拆分为多条数据，返回 nil 表示丢弃这条数据
+// 所有数据在同一个虚拟机中依次处理，全局变量在多次调用之间共享
+type Lua struct {
+	Script     string `json:"script"`
+	ScriptPath string `json:"scriptpath"`
+	Function   string `json:"function"`
+	// Timeout 限制每次调用的执行时间
+	Timeout string `json:"timeout"`
+	// CallStackSize 限制 lua 函数嵌套调用的深度，超过时调用出错
+	CallStackSize int `json:"call_stack_size"`
+	// RegistrySize 限制虚拟机数据栈的槽位数，即局部变量和函数参数的个数，不限制 table 和字符串的大小
+	RegistrySize int `json:"registry_size"`
+	// MemoryLimit 限制虚拟机中全局变量可达的 table 和字符串占用的内存，如 64MB，不填表示不限制
+	// 每次调用后检查，超过时这条数据出错，并丢弃虚拟机重新执行脚本，单次调用中的临时分配仍由 timeout 约束
+	MemoryLimit string `json:"memory_limit"`
+	stats       StatsInfo
+
+	lock        sync.Mutex
+	script      string
+	state       *lua.LState
+	fn          lua.LValue
+	timeout     time.Duration
+	memoryLimit int64
+}
+
+func (g *Lua) Init() error {
+	script := g.Script
+	if script == "" && g.ScriptPath != "" {
+		path, err := checkPath(g.ScriptPath)
+		if err != nil {
+			return err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		script = string(content)
+	}
+	if script == "" {
+		return errors.New("lua transformer script and scriptpath are both empty")
+	}
+	if g.MemoryLimit != "" {
+		limit, err := parseMemoryLimit(g.MemoryLimit)
+		if err != nil {
+			return fmt.Errorf("lua transformer parse memory_limit %v error %v", g.MemoryLimit, err)
+		}
+		g.memoryLimit = limit
+	}
+	if g.Function == "" {
+		g.Function = DefaultLuaFunction
+	}
+	g.timeout = DefaultLuaTimeout
+	if g.Timeout != "" {
+		timeout, err := time.ParseDuration(g.Timeout)
+		if err != nil {
+			return fmt.Errorf("lua transformer parse timeout %v error %v", g.Timeout, err)
+		}
+		g.timeout = timeout
+	}
+	if g.CallStackSize <= 0 {
+		g.CallStackSize = DefaultLuaCallStackSize
+	}
+	if g.RegistrySize <= 0 {
+		g.RegistrySize = DefaultLuaRegistrySize
+	}
+
+	state, fn, err := g.load(script)
+	if err != nil {
+		return err
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.state != nil {
+		g.state.Close()
+	}
+	g.script = script
+	g.state = state
+	g.fn = fn
+	return nil
+}
+
+// load 创建虚拟机并执行脚本，返回虚拟机和处理函数
+func (g *Lua) load(script string) (*lua.LState, lua.LValue, error) {
+	state := newLuaState(g.CallStackSize, g.RegistrySize)
+	chunk, err := state.LoadString(script)
+	if err != nil {
+		state.Close()
+		return nil, nil, fmt.Errorf("lua transformer compile script error %v", err)
+	}
+	// 脚本的顶层代码只在创建虚拟机时执行一次，用于定义函数和共享的全局变量
+	if err = g.call(state, chunk); err != nil {
+		state.Close()
+		return nil, nil, fmt.Errorf("lua transformer run script error %v", err)
+	}
+	state.Pop(1)
+	if err = g.checkMemory(state); err != nil {
+		state.Close()
+		return nil, nil, fmt.Errorf("lua transformer run script error %v", err)
+	}
+	fn := state.GetGlobal(g.Function)
+	if fn.Type() != lua.LTFunction {
+		state.Close()
+		return nil, nil, fmt.Errorf("lua transformer function %v is not defined in script", g.Function)
+	}
+	return state, fn, nil
+}
+
+// checkMemory 在配置了 memory_limit 时检查虚拟机占用的内存，extra 为栈上还未取出的值
+func (g *Lua) checkMemory(state *lua.LState, extra ...lua.LValue) error {
+	if g.memoryLimit <= 0 {
+		return nil
+	}
+	if used := luaMemory(state, extra...); used > g.memoryLimit {
+		return fmt.Errorf("lua vm uses %d bytes, exceeds memory_limit %v", used, g.MemoryLimit)
+	}
+	return nil
+}
+
+// reset 丢弃超出内存限制的虚拟机，重新执行脚本，全局变量恢复为初始值
+func (g *Lua) reset() {
+	g.state.Close()
+	g.state, g.fn = nil, nil
+	state, fn, err := g.load(g.script)
+	if err != nil {
+		log.Errorf("lua transformer reload script error %v", err)
+		return
+	}
+	g.state, g.fn = state, fn
+}
+
+// newLuaState 创建只包含 base、table、string、math 库的虚拟机，不能访问文件、执行命令或加载模块
+// callStackSize 和 registrySize 只限制调用深度和数据栈的槽位数，不限制内存分配
+func newLuaState(callStackSize, registrySize int) *lua.LState {
+	state := lua.NewState(lua.Options{
+		CallStackSize: callStackSize,
+		RegistrySize:  registrySize,
+		SkipOpenLibs:  true,
+	})
+	for name, open := range map[string]lua.LGFunction{
+		lua.BaseLibName:   lua.OpenBase,
+		lua.TabLibName:    lua.OpenTable,
+		lua.StringLibName: lua.OpenString,
+		lua.MathLibName:   lua.OpenMath,
+	} {
+		state.Push(state.NewFunction(open))
+		state.Push(lua.LString(name))
+		state.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "module", "require"} {
+		state.SetGlobal(name, lua.LNil)
+	}
+	return state
+}
+
+// call 在超时限制内调用 fn，返回值保留在栈顶
+func (g *Lua) call(state *lua.LState, fn lua.LValue, args ...lua.LValue) error {
+	ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+	defer cancel()
+	state.SetContext(ctx)
+	defer state.RemoveContext()
+	return state.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}, args...)
+}
+
+func (g *Lua) Transform(datas []Data) ([]Data, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.state == nil {
+		return datas, errors.New("lua transformer is not initialized")
+	}
+
+	var (
+		err, fmtErr error
+		errNum      int
+		ret         = make([]Data, 0, len(datas))
+	)
+	for _, data := range datas {
+		if g.state == nil {
+			// 超出内存限制后重新执行脚本失败
+			errNum, err = transforms.SetError(errNum, errors.New("lua transformer is not initialized"), transforms.General, "")
+			ret = append(ret, data)
+			continue
+		}
+		results, processErr := g.process(data)
+		if processErr != nil {
+			// 出错时保留原始数据
+			errNum, err = transforms.SetError(errNum, processErr, transforms.General, "")
+			ret = append(ret, data)
+			continue
+		}
+		ret = append(ret, results...)
+	}
+
+	g.stats, fmtErr = transforms.SetStatsInfo(err, g.stats, int64(errNum), int64(len(datas)), g.Type())
+	return ret, fmtErr
+}
+
+func (g *Lua) process(data Data) ([]Data, error) {
+	arg, err := toLuaValue(g.state, map[string]interface{}(data))
+	if err != nil {
+		return nil, err
+	}
+	if err = g.call(g.state, g.fn, arg); err != nil {
+		return nil, err
+	}
+	ret := g.state.Get(-1)
+	if err = g.checkMemory(g.state, ret); err != nil {
+		g.reset()
+		return nil, err
+	}
+	g.state.Pop(1)
+
+	switch ret.Type() {
+	case lua.LTNil:
+		return nil, nil
+	case lua.LTBool:
+		if !lua.LVAsBool(ret) {
+			return nil, nil
+		}
+	case lua.LTTable:
+		tb := ret.(*lua.LTable)
+		if tb.MaxN() == 0 {
+			record, err := fromLuaTable(tb)
+			if err != nil {
+				return nil, err
+			}
+			return []Data{record}, nil
+		}
+		records := make([]Data, 0, tb.MaxN())
+		for i := 1; i <= tb.MaxN(); i++ {
+			item, ok := tb.RawGetInt(i).(*lua.LTable)
+			if !ok {
+				return nil, fmt.Errorf("lua function %v returned a non-table record at index %d", g.Function, i)
+			}
+			record, err := fromLuaTable(item)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, record)
+		}
+		return records, nil
+	}
+	return nil, fmt.Errorf("lua function %v returned %v, expect table or nil", g.Function, ret.Type())
+}
+
+// fromLuaTable 将返回的 table 转换为一条数据，数据的 key 必须为字符串
+func fromLuaTable(tb *lua.LTable) (Data, error) {
+	record := make(Data)
+	var err error
+	tb.ForEach(func(k, v lua.LValue) {
+		if err != nil {
+			return
+		}
+		key, ok := k.(lua.LString)
+		if !ok {
+			err = fmt.Errorf("lua record key %v is not string", k)
+			return
+		}
+		record[string(key)], err = fromLuaValue(v)
+	})
+	return record, err
+}
+
+func toLuaValue(state *lua.LState, v interface{}) (lua.LValue, error) {
+	switch val := v.(type) {
+	case nil:
+		return lua.LNil, nil
+	case string:
+		return lua.LString(val), nil
+	case []byte:
+		return lua.LString(val), nil
+	case bool:
+		return lua.LBool(val), nil
+	case int:
+		return lua.LNumber(val), nil
+	case int8:
+		return lua.LNumber(val), nil
+	case int16:
+		return lua.LNumber(val), nil
+	case int32:
+		return lua.LNumber(val), nil
+	case int64:
+		return lua.LNumber(val), nil
+	case uint:
+		return lua.LNumber(val), nil
+	case uint8:
+		return lua.LNumber(val), nil
+	case uint16:
+		return lua.LNumber(val), nil
+	case uint32:
+		return lua.LNumber(val), nil
+	case uint64:
+		return lua.LNumber(val), nil
+	case float32:
+		return lua.LNumber(val), nil
+	case float64:
+		return lua.LNumber(val), nil
+	case json.Number:
+		f, err := val.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return lua.LNumber(f), nil
+	case Data:
+		return toLuaValue(state, map[string]interface{}(val))
+	case map[string]interface{}:
+		tb := state.CreateTable(0, len(val))
+		for k, item := range val {
+			lv, err := toLuaValue(state, item)
+			if err != nil {
+				return nil, err
+			}
+			tb.RawSetString(k, lv)
+		}
+		return tb, nil
+	case []interface{}:
+		tb := state.CreateTable(len(val), 0)
+		for _, item := range val {
+			lv, err := toLuaValue(state, item)
+			if err != nil {
+				return nil, err
+			}
+			tb.Append(lv)
+		}
+		return tb, nil
+	case []string:
+		tb := state.CreateTable(len(val), 0)
+		for _, item := range val {
+			tb.Append(lua.LString(item))
+		}
+		return tb, nil
+	case time.Time:
+		return lua.LString(val.Format(time.RFC3339Nano)), nil
+	case fmt.Stringer:
+		return lua.LString(val.String()), nil
+	}
+	return nil, fmt.Errorf("type %T can not be converted to lua value", v)
+}
+
+// fromLuaValue 将 lua 的值转换为数据中的值，整数转换为 int64，数组转换为 []interface{}
+func fromLuaValue(v lua.LValue) (interface{}, error) {
+	switch val := v.(type) {
+	case *lua.LNilType:
+		return nil, nil
+	case lua.LBool:
+		return bool(val), nil
+	case lua.LString:
+		return string(val), nil
+	case lua.LNumber:
+		f := float64(val)
+		if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+			return int64(f), nil
+		}
+		return f, nil
+	case *lua.LTable:
+		if val.MaxN() == 0 {
+			record, err := fromLuaTable(val)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}(record), nil
+		}
+		arr := make([]interface{}, 0, val.MaxN())
+		for i := 1; i <= val.MaxN(); i++ {
+			item, err := fromLuaValue(val.RawGetInt(i))
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, item)
+		}
+		return arr, nil
+	}
+	return nil, fmt.Errorf("lua type %v can not be converted to data value", v.Type())
+}
+
+const (
+	luaStringOverhead = 16
+	luaTableOverhead  = 64
+	luaEntrySize      = 32
+	luaFuncOverhead   = 64
+)
+
+// luaMemory 估算从全局变量、registry 和 extra 可达的 table、字符串和函数占用的内存
+func luaMemory(state *lua.LState, extra ...lua.LValue) int64 {
+	var (
+		size    int64
+		visited = make(map[lua.LValue]bool)
+		pending = append([]lua.LValue{state.G.Global, state.G.Registry}, extra...)
+	)
+	push := func(v lua.LValue) {
+		switch v.(type) {
+		case *lua.LTable, *lua.LFunction, *lua.LUserData:
+			if visited[v] {
+				return
+			}
+			visited[v] = true
+		}
+		pending = append(pending, v)
+	}
+	for len(pending) > 0 {
+		v := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		switch val := v.(type) {
+		case lua.LString:
+			size += int64(len(val)) + luaStringOverhead
+		case *lua.LTable:
+			size += luaTableOverhead
+			val.ForEach(func(k, item lua.LValue) {
+				size += luaEntrySize
+				push(k)
+				push(item)
+			})
+			push(val.Metatable)
+		case *lua.LFunction:
+			size += luaFuncOverhead + int64(len(val.Upvalues))*luaEntrySize
+			for _, uv := range val.Upvalues {
+				push(uv.Value())
+			}
+			if val.Env != nil {
+				push(val.Env)
+			}
+		case *lua.LUserData:
+			size += luaTableOverhead
+			push(val.Metatable)
+		}
+	}
+	return size
+}
+
+// parseMemoryLimit 解析如 64MB、512KB 或字节数的内存大小
+func parseMemoryLimit(limit string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(limit))
+	unit := int64(1)
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(str, u.suffix) {
+			str, unit = strings.TrimSpace(strings.TrimSuffix(str, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, errors.New("memory limit must be positive")
+	}
+	return n * unit, nil
+}
+
+func (g *Lua) RawTransform(datas []string) ([]string, error) {
+	return datas, errors.New("lua transformer not support rawTransform")
+}
+
+func (g *Lua) Close() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.state != nil {
+		g.state.Close()
+		g.state = nil
+	}
+	return nil
+}
+
+func (g *Lua) Description() string {
+	return "在内置的 lua 虚拟机中执行脚本，可以增删改字段、丢弃数据或拆分为多条数据"
+}
+
+func (g *Lua) Type() string {
+	return "lua"
+}
+
+func (g *Lua) SampleConfig() string {
+	return `{
+       "type":"lua",
+       "script":"count = 0\nfunction process(data)\n  count = count + 1\n  data.seq = count\n  return data\nend"
+    }`
+}
+
+func (g *Lua) ConfigOptions() []Option {
+	return []Option{
+		{
+			KeyName:      "script",
+			Element:      Text,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "lua 脚本内容(script)",
+			ToolTip:      "脚本需要定义处理函数，参数为数据对应的 table，返回 table、table 的数组或 nil(丢弃数据)",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "scriptpath",
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "lua 脚本路径(scriptpath)",
+			ToolTip:      "没有填写脚本内容时从该路径读取脚本",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "function",
+			ChooseOnly:   false,
+			Default:      DefaultLuaFunction,
+			DefaultNoUse: false,
+			Description:  "处理函数名称(function)",
+			Advance:      true,
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "timeout",
+			ChooseOnly:   false,
+			Default:      DefaultLuaTimeout.String(),
+			DefaultNoUse: false,
+			Description:  "每条数据的执行超时时间(timeout)",
+			Advance:      true,
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "call_stack_size",
+			ChooseOnly:   false,
+			Default:      DefaultLuaCallStackSize,
+			DefaultNoUse: false,
+			Description:  "调用栈深度限制(call_stack_size)",
+			ToolTip:      "限制 lua 函数嵌套调用的深度，超过时调用出错",
+			Advance:      true,
+			Type:         transforms.TransformTypeLong,
+		},
+		{
+			KeyName:      "registry_size",
+			ChooseOnly:   false,
+			Default:      DefaultLuaRegistrySize,
+			DefaultNoUse: false,
+			Description:  "数据栈大小限制(registry_size)",
+			ToolTip:      "限制虚拟机数据栈的槽位数(局部变量和参数的个数)，超过时调用出错，不限制 table 和字符串占用的内存",
+			Advance:      true,
+			Type:         transforms.TransformTypeLong,
+		},
+		{
+			KeyName:      "memory_limit",
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "内存限制(memory_limit)",
+			ToolTip:      "如 64MB，每次调用后检查全局变量可达的 table 和字符串占用的内存，超过时这条数据出错并重新执行脚本，不填表示不限制",
+			Advance:      true,
+			Type:         transforms.TransformTypeString,
+		},
+	}
+}
+
+func (g *Lua) Stage() string {
+	return transforms.StageAfterParser
+}
+
+func (g *Lua) Stats() StatsInfo {
+	return g.stats
+}
+
+func (g *Lua) SetStats(err string) StatsInfo {
+	g.stats.LastError = err
+	return g.stats
+}
+
+func init() {
+	transforms.Add("lua", func() transforms.Transformer {
+		return &Lua{}
+	})
+}
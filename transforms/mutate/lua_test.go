@@ -0,0 +1,166 @@
+package mutate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestLuaTransformer(t *testing.T) {
+	l := &Lua{
+		Script: `
+count = 0
+function process(data)
+  count = count + 1
+  if data.action == "drop" then
+    return nil
+  end
+  if data.action == "split" then
+    local ret = {}
+    for i, item in ipairs(data.items) do
+      ret[i] = {item = item, seq = count}
+    end
+    return ret
+  end
+  data.seq = count
+  data.level = string.upper(data.level)
+  data.detail.code = data.detail.code + 1
+  data.tmp = nil
+  return data
+end`,
+	}
+	assert.NoError(t, l.Init())
+	defer l.Close()
+
+	datas := []Data{
+		{"level": "info", "tmp": "x", "detail": map[string]interface{}{"code": 1, "ok": true}, "rate": 0.5},
+		{"action": "drop"},
+		{"action": "split", "items": []interface{}{"a", "b"}},
+	}
+	res, err := l.Transform(datas)
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{
+		{"level": "INFO", "seq": int64(1), "detail": map[string]interface{}{"code": int64(2), "ok": true}, "rate": 0.5},
+		{"item": "a", "seq": int64(3)},
+		{"item": "b", "seq": int64(3)},
+	}, res)
+
+	// 全局变量在多次调用之间共享
+	res, err = l.Transform([]Data{{"level": "warn", "detail": map[string]interface{}{"code": 0}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{{"level": "WARN", "seq": int64(4), "detail": map[string]interface{}{"code": int64(1)}}}, res)
+
+	// 出错时保留原始数据
+	res, err = l.Transform([]Data{{"level": 1}, {"action": "drop"}})
+	assert.Error(t, err)
+	assert.Equal(t, []Data{{"level": 1}}, res)
+	assert.Equal(t, int64(1), l.Stats().Errors)
+}
+
+func TestLuaTransformerLimits(t *testing.T) {
+	l := &Lua{
+		Script: `
+function process(data)
+  if data.loop then
+    while true do end
+  end
+  if data.recurse then
+    local function f(n) return f(n + 1) + 1 end
+    f(1)
+  end
+  return data
+end`,
+		Timeout: "50ms",
+	}
+	assert.NoError(t, l.Init())
+	defer l.Close()
+	res, err := l.Transform([]Data{{"loop": true}, {"recurse": true}, {"a": "b"}})
+	assert.Error(t, err)
+	assert.Equal(t, []Data{{"loop": true}, {"recurse": true}, {"a": "b"}}, res)
+	assert.Equal(t, int64(2), l.Stats().Errors)
+
+	// 沙箱中不能访问文件和执行命令
+	l = &Lua{Script: `function process(data) return os.execute("ls") end`}
+	assert.NoError(t, l.Init())
+	_, err = l.Transform([]Data{{"a": "b"}})
+	assert.Error(t, err)
+	l.Close()
+	l = &Lua{Script: `function process(data) dofile("/etc/passwd") end`}
+	assert.NoError(t, l.Init())
+	_, err = l.Transform([]Data{{"a": "b"}})
+	assert.Error(t, err)
+	l.Close()
+}
+
+func TestLuaTransformerMemoryLimit(t *testing.T) {
+	l := &Lua{
+		Script: `
+count = 0
+cache = {}
+function process(data)
+  count = count + 1
+  if data.grow then
+    for i = 1, 1000 do
+      cache[#cache + 1] = string.rep("x", 1024) .. i
+    end
+  end
+  if data.huge then
+    data.payload = string.rep("x", 2 * 1024 * 1024)
+  end
+  data.seq = count
+  return data
+end`,
+		MemoryLimit: "512KB",
+	}
+	assert.NoError(t, l.Init())
+	defer l.Close()
+	res, err := l.Transform([]Data{{"a": "b"}, {"a": "c"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{{"a": "b", "seq": int64(1)}, {"a": "c", "seq": int64(2)}}, res)
+
+	// 全局变量超出限制时这条数据出错，虚拟机重新执行脚本，全局变量恢复为初始值
+	res, err = l.Transform([]Data{{"grow": true}, {"a": "b"}})
+	assert.Error(t, err)
+	assert.Equal(t, []Data{{"grow": true}, {"a": "b", "seq": int64(1)}}, res)
+
+	// 返回值超出限制同样出错
+	res, err = l.Transform([]Data{{"huge": true}, {"a": "b"}})
+	assert.Error(t, err)
+	assert.Equal(t, []Data{{"huge": true}, {"a": "b", "seq": int64(1)}}, res)
+
+	limit, err := parseMemoryLimit(" 64mb ")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(64<<20), limit)
+	limit, err = parseMemoryLimit("1024")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1024), limit)
+}
+
+func TestLuaTransformerInit(t *testing.T) {
+	assert.Error(t, (&Lua{}).Init())
+	assert.Error(t, (&Lua{Script: `function process(data`}).Init())
+	assert.Error(t, (&Lua{Script: `error("boom")`}).Init())
+	assert.Error(t, (&Lua{Script: `function process(data) return data end`, Function: "handle"}).Init())
+	assert.Error(t, (&Lua{Script: `function process(data) return data end`, Timeout: "1"}).Init())
+	assert.Error(t, (&Lua{Script: `function process(data) return data end`, MemoryLimit: "64XB"}).Init())
+	assert.Error(t, (&Lua{Script: `function process(data) return data end`, MemoryLimit: "0"}).Init())
+	// 脚本的顶层代码超出内存限制
+	assert.Error(t, (&Lua{Script: `big = string.rep("x", 2048) function process(data) return data end`, MemoryLimit: "1KB"}).Init())
+
+	dir, err := ioutil.TempDir("", "TestLuaTransformerInit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	scriptPath := filepath.Join(dir, "handle.lua")
+	assert.NoError(t, ioutil.WriteFile(scriptPath, []byte(`function handle(data) data.a = "c" return data end`), DefaultFilePerm))
+	l := &Lua{ScriptPath: scriptPath, Function: "handle"}
+	assert.NoError(t, l.Init())
+	defer l.Close()
+	res, err := l.Transform([]Data{{"a": "b"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{{"a": "c"}}, res)
+}
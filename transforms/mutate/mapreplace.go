@@ -33,6 +33,8 @@ type MapReplacer struct {
 	news  []string
 
 	numRoutine int
+
+	transforms.ErrorIndexRecorder
 }
 
 func (g *MapReplacer) Init() error {
@@ -130,6 +132,7 @@ func (g *MapReplacer) Transform(datas []Data) ([]Data, error) {
 		datas[transformResult.Index] = transformResult.CurData
 	}
 
+	g.RecordErrorIndex(transformResultSlice)
 	g.stats, fmtErr = transforms.SetStatsInfo(err, g.stats, int64(errNum), int64(dataLen), g.Type())
 	return datas, fmtErr
 }
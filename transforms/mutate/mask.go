@@ -0,0 +1,669 @@
+package mutate
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/qiniu/logkit/transforms"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	MaskActionMask    = "mask"
+	MaskActionRedact  = "redact"
+	MaskActionHash    = "hash"
+	MaskActionEncrypt = "encrypt"
+
+	DetectorPhone    = "phone"
+	DetectorIDCard   = "idcard"
+	DetectorEmail    = "email"
+	DetectorBankCard = "bankcard"
+	DetectorIP       = "ip"
+
+	DefaultMaskChar   = "*"
+	DefaultRedactText = "[REDACTED]"
+
+	// MaskEncryptPrefix 为 encrypt 输出的前缀，后面是 base64(nonce+密文)
+	MaskEncryptPrefix = "enc:"
+
+	maskKeyInfoHash    = "logkit mask hash"
+	maskKeyInfoEncrypt = "logkit mask encrypt"
+)
+
+var (
+	_ transforms.StatsTransformer = &Mask{}
+	_ transforms.Transformer      = &Mask{}
+	_ transforms.Initializer      = &Mask{}
+)
+
+// Mask 检测字段中的手机号、身份证号、邮箱、银行卡号、IP 等敏感信息，并按指定方式脱敏
+type Mask struct {
+	// Keys 为逗号分隔的字段，为空时扫描所有字符串字段
+	Keys string `json:"keys"`
+	// Detectors 为逗号分隔的内置检测器，为空时使用全部内置检测器
+	Detectors string `json:"detectors"`
+	// Custom 为自定义检测器，每行一个，格式为 name:regex
+	Custom string `json:"custom"`
+	// Action 为 mask(部分遮盖)、redact(整体替换)、hash(HMAC-SHA256)、encrypt(AES-GCM 加密)
+	Action   string `json:"action"`
+	MaskChar string `json:"mask_char"`
+	// KeepPrefix 和 KeepSuffix 覆盖部分遮盖时保留的首尾字符数，对 email 和 ip 无效
+	KeepPrefix *int   `json:"keep_prefix"`
+	KeepSuffix *int   `json:"keep_suffix"`
+	RedactText string `json:"redact_text"`
+	// KeyPath 为 hash 和 encrypt 使用的密钥文件，encrypt 的密钥为 16、24、32 字节，可以用 hex 或 base64 编码
+	// hash 和 encrypt 分别使用从密钥派生的不同密钥，同一个密钥文件用于两种方式时也不会共用密钥
+	KeyPath string `json:"key_path"`
+	// Key 会随 runner 配置通过接口返回，不再支持，配置时报错
+	Key   string `json:"key,omitempty"`
+	stats StatsInfo
+
+	keys      [][]string
+	detectors []*piiDetector
+	key       []byte
+	aead      cipher.AEAD
+}
+
+// piiDetector 为一种敏感信息的检测器，validate 用于进一步校验正则匹配的结果
+type piiDetector struct {
+	name     string
+	regex    *regexp.Regexp
+	validate func(string) bool
+	// mask 为部分遮盖的方法，为空时按 prefix 和 suffix 遮盖中间的字母和数字
+	mask           func(m *Mask, s string) string
+	prefix, suffix int
+}
+
+var builtinDetectors = []*piiDetector{
+	{
+		name:   DetectorEmail,
+		regex:  regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+		mask:   maskEmail,
+		prefix: 1,
+	},
+	{
+		name:   DetectorIDCard,
+		regex:  regexp.MustCompile(`\b[1-9]\d{5}(?:18|19|20)\d{2}(?:0[1-9]|1[0-2])(?:0[1-9]|[12]\d|3[01])\d{3}[\dXx]\b`),
+		prefix: 3,
+		suffix: 4,
+	},
+	{
+		name:     DetectorBankCard,
+		regex:    regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+		validate: luhnValid,
+		prefix:   6,
+		suffix:   4,
+	},
+	{
+		name:   DetectorPhone,
+		regex:  regexp.MustCompile(`\b1[3-9]\d{9}\b`),
+		prefix: 3,
+		suffix: 4,
+	},
+	{
+		name: DetectorIP,
+		regex: regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\.){3}(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\b` +
+			`|(?:[0-9A-Fa-f]{1,4}|:)(?::[0-9A-Fa-f]{0,4}){2,7}`),
+		validate: func(s string) bool { return net.ParseIP(s) != nil },
+		mask:     maskIP,
+	},
+}
+
+func (g *Mask) Init() error {
+	g.keys = g.keys[:0]
+	for _, key := range strings.Split(g.Keys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			g.keys = append(g.keys, GetKeys(key))
+		}
+	}
+
+	g.detectors = g.detectors[:0]
+	names := make(map[string]bool)
+	if strings.TrimSpace(g.Detectors) == "" {
+		g.detectors = append(g.detectors, builtinDetectors...)
+	} else {
+		for _, name := range strings.Split(g.Detectors, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" || names[name] {
+				continue
+			}
+			d := findBuiltinDetector(name)
+			if d == nil {
+				return fmt.Errorf("mask transformer detector %v is not supported", name)
+			}
+			names[name] = true
+			g.detectors = append(g.detectors, d)
+		}
+	}
+	for _, line := range strings.Split(g.Custom, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		idx := strings.Index(line, ":")
+		if idx <= 0 || idx == len(line)-1 {
+			return fmt.Errorf("mask transformer custom detector %q must be name:regex", line)
+		}
+		name := strings.TrimSpace(line[:idx])
+		if findBuiltinDetector(name) != nil || names[name] {
+			return fmt.Errorf("mask transformer custom detector name %v is duplicated", name)
+		}
+		regex, err := regexp.Compile(line[idx+1:])
+		if err != nil {
+			return fmt.Errorf("mask transformer compile custom detector %v error %v", name, err)
+		}
+		names[name] = true
+		g.detectors = append(g.detectors, &piiDetector{name: name, regex: regex})
+	}
+	if len(g.detectors) == 0 {
+		return errors.New("mask transformer has no detector")
+	}
+
+	if g.MaskChar == "" {
+		g.MaskChar = DefaultMaskChar
+	}
+	if g.RedactText == "" {
+		g.RedactText = DefaultRedactText
+	}
+	if g.Key != "" {
+		return errors.New("mask transformer key is not supported, write the key to a file and use key_path")
+	}
+	switch g.Action {
+	case "":
+		g.Action = MaskActionMask
+	case MaskActionMask, MaskActionRedact:
+	case MaskActionHash, MaskActionEncrypt:
+		key, err := g.loadKey()
+		if err != nil {
+			return err
+		}
+		if g.Action == MaskActionHash {
+			g.key = deriveMaskKey(key, maskKeyInfoHash, sha256.Size)
+			break
+		}
+		if g.aead, err = newMaskAEAD(key); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("mask transformer action %v is not supported", g.Action)
+	}
+	return nil
+}
+
+func findBuiltinDetector(name string) *piiDetector {
+	for _, d := range builtinDetectors {
+		if d.name == name {
+			return d
+		}
+	}
+	return nil
+}
+
+func (g *Mask) loadKey() ([]byte, error) {
+	if g.KeyPath == "" {
+		return nil, fmt.Errorf("mask transformer action %v requires key_path", g.Action)
+	}
+	path, err := checkPath(g.KeyPath)
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key := strings.TrimSpace(string(content))
+	if key == "" {
+		return nil, fmt.Errorf("mask transformer key file %v is empty", g.KeyPath)
+	}
+	return []byte(key), nil
+}
+
+// decodeAESKey 依次尝试按 hex、base64 和原始字节解析 AES 密钥
+func decodeAESKey(key []byte) ([]byte, error) {
+	validLen := func(b []byte) bool { return len(b) == 16 || len(b) == 24 || len(b) == 32 }
+	if b, err := hex.DecodeString(string(key)); err == nil && validLen(b) {
+		return b, nil
+	}
+	if b, err := base64.StdEncoding.DecodeString(string(key)); err == nil && validLen(b) {
+		return b, nil
+	}
+	if validLen(key) {
+		return key, nil
+	}
+	return nil, errors.New("mask transformer encrypt key must be 16, 24 or 32 bytes, optionally hex or base64 encoded")
+}
+
+// deriveMaskKey 使用 HMAC-SHA256(key, info) 为 hash 和 encrypt 派生不同的密钥，size 不超过 sha256.Size
+func deriveMaskKey(key []byte, info string, size int) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(info))
+	return mac.Sum(nil)[:size]
+}
+
+// newMaskAEAD 解析密钥文件的内容，使用派生出的同样长度的密钥创建 AES-GCM
+func newMaskAEAD(key []byte) (cipher.AEAD, error) {
+	key, err := decodeAESKey(key)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(deriveMaskKey(key, maskKeyInfoEncrypt, len(key)))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// DecryptMaskValue 解密 mask transformer 以 encrypt 方式输出的值，key 为密钥文件的内容
+func DecryptMaskValue(key []byte, value string) (string, error) {
+	aead, err := newMaskAEAD([]byte(strings.TrimSpace(string(key))))
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(value, MaskEncryptPrefix))
+	if err != nil {
+		return "", err
+	}
+	if len(data) < aead.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func (g *Mask) Transform(datas []Data) ([]Data, error) {
+	if len(g.detectors) == 0 {
+		if err := g.Init(); err != nil {
+			return datas, err
+		}
+	}
+	var (
+		err    error
+		errNum int
+		hits   = make(map[string]int64)
+	)
+	for i := range datas {
+		if len(g.keys) == 0 {
+			if e := g.scanValue(map[string]interface{}(datas[i]), hits); e != nil {
+				err = e
+				errNum++
+			}
+			continue
+		}
+		var dataErr error
+		for _, keys := range g.keys {
+			v, e := GetMapValue(datas[i], keys...)
+			if e != nil {
+				continue
+			}
+			switch val := v.(type) {
+			case string:
+				masked, e := g.maskString(val, hits)
+				if e != nil {
+					dataErr = e
+					continue
+				}
+				SetMapValue(datas[i], masked, false, keys...)
+			default:
+				if e := g.scanValue(v, hits); e != nil {
+					dataErr = e
+				}
+			}
+		}
+		if dataErr != nil {
+			err = dataErr
+			errNum++
+		}
+	}
+
+	if len(hits) > 0 {
+		details := make(map[string]int64, len(g.stats.Details)+len(hits))
+		for k, v := range g.stats.Details {
+			details[k] = v
+		}
+		for k, v := range hits {
+			details[k] += v
+		}
+		g.stats.Details = details
+	}
+	g.stats, err = transforms.SetStatsInfo(err, g.stats, int64(errNum), int64(len(datas)), g.Type())
+	return datas, err
+}
+
+// scanValue 递归处理 map 和数组中的字符串
+func (g *Mask) scanValue(v interface{}, hits map[string]int64) error {
+	var lastErr error
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			if s, ok := item.(string); ok {
+				masked, err := g.maskString(s, hits)
+				if err != nil {
+					lastErr = err
+					continue
+				}
+				val[k] = masked
+				continue
+			}
+			if err := g.scanValue(item, hits); err != nil {
+				lastErr = err
+			}
+		}
+	case Data:
+		return g.scanValue(map[string]interface{}(val), hits)
+	case []interface{}:
+		for i, item := range val {
+			if s, ok := item.(string); ok {
+				masked, err := g.maskString(s, hits)
+				if err != nil {
+					lastErr = err
+					continue
+				}
+				val[i] = masked
+				continue
+			}
+			if err := g.scanValue(item, hits); err != nil {
+				lastErr = err
+			}
+		}
+	case []string:
+		for i, s := range val {
+			masked, err := g.maskString(s, hits)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			val[i] = masked
+		}
+	}
+	return lastErr
+}
+
+type piiMatch struct {
+	start, end int
+	detector   *piiDetector
+}
+
+// maskString 找出所有检测器的匹配，重叠时保留先配置的检测器和靠前的匹配，然后一次替换
+func (g *Mask) maskString(s string, hits map[string]int64) (string, error) {
+	var matches []piiMatch
+	for _, d := range g.detectors {
+		for _, loc := range d.regex.FindAllStringIndex(s, -1) {
+			if loc[0] == loc[1] || (d.validate != nil && !d.validate(s[loc[0]:loc[1]])) {
+				continue
+			}
+			overlap := false
+			for _, m := range matches {
+				if loc[0] < m.end && m.start < loc[1] {
+					overlap = true
+					break
+				}
+			}
+			if !overlap {
+				matches = append(matches, piiMatch{start: loc[0], end: loc[1], detector: d})
+			}
+		}
+	}
+	if len(matches) == 0 {
+		return s, nil
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].start < matches[j].start })
+	var (
+		buf  strings.Builder
+		last int
+	)
+	for _, m := range matches {
+		replaced, err := g.replace(m.detector, s[m.start:m.end])
+		if err != nil {
+			return s, err
+		}
+		buf.WriteString(s[last:m.start])
+		buf.WriteString(replaced)
+		last = m.end
+		hits[m.detector.name]++
+	}
+	buf.WriteString(s[last:])
+	return buf.String(), nil
+}
+
+func (g *Mask) replace(d *piiDetector, s string) (string, error) {
+	switch g.Action {
+	case MaskActionRedact:
+		return g.RedactText, nil
+	case MaskActionHash:
+		mac := hmac.New(sha256.New, g.key)
+		mac.Write([]byte(s))
+		return hex.EncodeToString(mac.Sum(nil)), nil
+	case MaskActionEncrypt:
+		nonce := make([]byte, g.aead.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return "", err
+		}
+		return MaskEncryptPrefix + base64.RawURLEncoding.EncodeToString(g.aead.Seal(nonce, nonce, []byte(s), nil)), nil
+	}
+	if d.mask != nil {
+		return d.mask(g, s), nil
+	}
+	prefix, suffix := d.prefix, d.suffix
+	if g.KeepPrefix != nil {
+		prefix = *g.KeepPrefix
+	}
+	if g.KeepSuffix != nil {
+		suffix = *g.KeepSuffix
+	}
+	return g.maskMiddle(s, prefix, suffix), nil
+}
+
+// maskMiddle 保留前 prefix 个和后 suffix 个字母或数字，遮盖其余的字母和数字，分隔符保持不变
+func (g *Mask) maskMiddle(s string, prefix, suffix int) string {
+	runes := []rune(s)
+	total := 0
+	for _, r := range runes {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			total++
+		}
+	}
+	// 至少遮盖一个字符
+	for prefix+suffix >= total && (prefix > 0 || suffix > 0) {
+		if prefix >= suffix {
+			prefix--
+		} else {
+			suffix--
+		}
+	}
+	var (
+		buf strings.Builder
+		idx int
+	)
+	for _, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			buf.WriteRune(r)
+			continue
+		}
+		if idx < prefix || idx >= total-suffix {
+			buf.WriteRune(r)
+		} else {
+			buf.WriteString(g.MaskChar)
+		}
+		idx++
+	}
+	return buf.String()
+}
+
+// maskEmail 只遮盖 @ 之前的部分
+func maskEmail(g *Mask, s string) string {
+	idx := strings.LastIndex(s, "@")
+	local := []rune(s[:idx])
+	if len(local) <= 1 {
+		return strings.Repeat(g.MaskChar, len(local)) + s[idx:]
+	}
+	return string(local[:1]) + strings.Repeat(g.MaskChar, len(local)-1) + s[idx:]
+}
+
+// maskIP 保留前一半的段，遮盖后一半的段，如 192.168.*.*
+func maskIP(g *Mask, s string) string {
+	sep := "."
+	if strings.Contains(s, ":") {
+		sep = ":"
+	}
+	parts := strings.Split(s, sep)
+	for i := len(parts) / 2; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = g.MaskChar
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+// luhnValid 校验银行卡号，忽略空格和 -
+func luhnValid(s string) bool {
+	var (
+		sum    int
+		digits int
+		double bool
+	)
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		n := int(c - '0')
+		if double {
+			if n *= 2; n > 9 {
+				n -= 9
+			}
+		}
+		sum += n
+		double = !double
+		digits++
+	}
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
+
+func (g *Mask) RawTransform(datas []string) ([]string, error) {
+	return datas, errors.New("mask transformer not support rawTransform")
+}
+
+func (g *Mask) Description() string {
+	return "检测手机号、身份证号、邮箱、银行卡号、IP 等敏感信息，并进行遮盖、替换、哈希或加密"
+}
+
+func (g *Mask) Type() string {
+	return "mask"
+}
+
+func (g *Mask) SampleConfig() string {
+	return `{
+       "type":"mask",
+       "keys":"message",
+       "detectors":"phone,idcard,email",
+       "action":"mask"
+    }`
+}
+
+func (g *Mask) ConfigOptions() []Option {
+	return []Option{
+		{
+			KeyName:      "keys",
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "需要脱敏的字段(keys)",
+			ToolTip:      "逗号分隔的字段，嵌套字段用.连接，不填表示扫描所有字符串字段",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "detectors",
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "内置检测器(detectors)",
+			ToolTip:      "逗号分隔，可选 phone,idcard,email,bankcard,ip，不填表示全部",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "custom",
+			Element:      Text,
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "自定义检测器(custom)",
+			ToolTip:      "每行一个，格式为 name:regex",
+			Advance:      true,
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:       "action",
+			Element:       Radio,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{MaskActionMask, MaskActionRedact, MaskActionHash, MaskActionEncrypt},
+			Default:       MaskActionMask,
+			DefaultNoUse:  false,
+			Description:   "脱敏方式(action)",
+			ToolTip:       "mask 部分遮盖，redact 整体替换，hash 使用 HMAC-SHA256 哈希，encrypt 使用 AES-GCM 加密",
+			Type:          transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "mask_char",
+			ChooseOnly:   false,
+			Default:      DefaultMaskChar,
+			DefaultNoUse: false,
+			Description:  "遮盖字符(mask_char)",
+			Advance:      true,
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "redact_text",
+			ChooseOnly:   false,
+			Default:      DefaultRedactText,
+			DefaultNoUse: false,
+			Description:  "替换文本(redact_text)",
+			Advance:      true,
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "key_path",
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "密钥文件路径(key_path)",
+			ToolTip:      "hash 和 encrypt 使用的密钥文件，encrypt 的密钥为 16、24 或 32 字节，可以用 hex 或 base64 编码，两种方式使用从中派生的不同密钥",
+			Type:         transforms.TransformTypeString,
+		},
+	}
+}
+
+func (g *Mask) Stage() string {
+	return transforms.StageAfterParser
+}
+
+// Stats 返回的 Details 为每种检测器的命中次数
+func (g *Mask) Stats() StatsInfo {
+	return g.stats
+}
+
+func (g *Mask) SetStats(err string) StatsInfo {
+	g.stats.LastError = err
+	return g.stats
+}
+
+func init() {
+	transforms.Add("mask", func() transforms.Transformer {
+		return &Mask{}
+	})
+}
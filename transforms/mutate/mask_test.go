@@ -0,0 +1,123 @@
+package mutate
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestMask(t *testing.T) {
+	g := &Mask{}
+	assert.NoError(t, g.Init())
+	datas := []Data{
+		{
+			"msg":  "用户13812345678的邮箱是alice@example.com，身份证110101199003071234",
+			"card": "6222 0212 3456 7894",
+			"nested": map[string]interface{}{
+				"ips":  []interface{}{"192.168.10.20", "fe80::1:2:3"},
+				"code": 13812345678,
+			},
+			"order": "1234567890123",
+		},
+	}
+	res, err := g.Transform(datas)
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{
+		{
+			"msg":  "用户138****5678的邮箱是a****@example.com，身份证110***********1234",
+			"card": "6222 02** **** 7894",
+			"nested": map[string]interface{}{
+				"ips":  []interface{}{"192.168.*.*", "fe80::*:*:*"},
+				"code": 13812345678,
+			},
+			// 不满足 luhn 校验
+			"order": "1234567890123",
+		},
+	}, res)
+	assert.Equal(t, map[string]int64{"phone": 1, "email": 1, "idcard": 1, "bankcard": 1, "ip": 2}, g.Stats().Details)
+
+	prefix, suffix := 0, 2
+	g = &Mask{Keys: "a, b.c", Detectors: "phone", Custom: "token:tk_[a-z0-9]+", KeepPrefix: &prefix, KeepSuffix: &suffix, MaskChar: "#"}
+	assert.NoError(t, g.Init())
+	res, err = g.Transform([]Data{{
+		"a": "13812345678 tk_abc123",
+		"b": map[string]interface{}{"c": []interface{}{"13900001111"}},
+		"d": "13812345678",
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{{
+		"a": "#########78 ##_####23",
+		"b": map[string]interface{}{"c": []interface{}{"#########11"}},
+		"d": "13812345678",
+	}}, res)
+	assert.Equal(t, map[string]int64{"phone": 2, "token": 1}, g.Stats().Details)
+
+	g = &Mask{Keys: "a", Action: MaskActionRedact}
+	assert.NoError(t, g.Init())
+	res, err = g.Transform([]Data{{"a": "mail bob@example.org now"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "mail [REDACTED] now", res[0]["a"])
+
+	for _, m := range []*Mask{
+		{Detectors: "phone,unknown"},
+		{Custom: "bad"},
+		{Custom: "phone:\\d+"},
+		{Custom: "x:("},
+		{Action: "x"},
+		{Action: MaskActionHash},
+		// 配置中的密钥会通过接口返回，只支持密钥文件
+		{Action: MaskActionHash, Key: "secret"},
+		{Key: "secret"},
+	} {
+		assert.Error(t, m.Init())
+	}
+}
+
+func TestMaskHashAndEncrypt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mask_key")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	key := "000102030405060708090a0b0c0d0e0f"
+	keyPath := filepath.Join(dir, "key")
+	assert.NoError(t, ioutil.WriteFile(keyPath, []byte(key+"\n"), 0600))
+	shortPath := filepath.Join(dir, "short")
+	assert.NoError(t, ioutil.WriteFile(shortPath, []byte("short"), 0600))
+	assert.Error(t, (&Mask{Action: MaskActionEncrypt, KeyPath: shortPath}).Init())
+
+	g := &Mask{Keys: "a", Detectors: "email", Action: MaskActionHash, KeyPath: keyPath}
+	assert.NoError(t, g.Init())
+	res, err := g.Transform([]Data{{"a": "alice@example.com"}, {"a": "alice@example.com"}})
+	assert.NoError(t, err)
+	// hash 使用派生的密钥，与 encrypt 的密钥不同
+	hashKey := deriveMaskKey([]byte(key), maskKeyInfoHash, sha256.Size)
+	raw, err := hex.DecodeString(key)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hashKey[:len(raw)], deriveMaskKey(raw, maskKeyInfoEncrypt, len(raw)))
+	mac := hmac.New(sha256.New, hashKey)
+	mac.Write([]byte("alice@example.com"))
+	assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), res[0]["a"])
+	assert.Equal(t, res[0]["a"], res[1]["a"])
+
+	g = &Mask{Keys: "a", Detectors: "phone", Action: MaskActionEncrypt, KeyPath: keyPath}
+	assert.NoError(t, g.Init())
+	res, err = g.Transform([]Data{{"a": "tel:13812345678."}})
+	assert.NoError(t, err)
+	value := res[0]["a"].(string)
+	assert.True(t, strings.HasPrefix(value, "tel:"+MaskEncryptPrefix))
+	assert.True(t, strings.HasSuffix(value, "."))
+	plain, err := DecryptMaskValue([]byte(key), strings.TrimSuffix(strings.TrimPrefix(value, "tel:"), "."))
+	assert.NoError(t, err)
+	assert.Equal(t, "13812345678", plain)
+
+	_, err = DecryptMaskValue([]byte("0f0e0d0c0b0a09080706050403020100"), strings.TrimSuffix(strings.TrimPrefix(value, "tel:"), "."))
+	assert.Error(t, err)
+}
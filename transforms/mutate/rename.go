@@ -23,6 +23,8 @@ type Rename struct {
 	keys       []string
 	news       []string
 	numRoutine int
+
+	transforms.ErrorIndexRecorder
 }
 
 func (g *Rename) Init() error {
@@ -95,6 +97,7 @@ func (g *Rename) Transform(datas []Data) ([]Data, error) {
 		datas[transformResult.Index] = transformResult.CurData
 	}
 
+	g.RecordErrorIndex(transformResultSlice)
 	g.stats, fmtErr = transforms.SetStatsInfo(err, g.stats, int64(errNum), int64(dataLen), g.Type())
 	return datas, fmtErr
 }
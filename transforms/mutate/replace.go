@@ -27,6 +27,8 @@ type Replacer struct {
 
 	keys       []string
 	numRoutine int
+
+	transforms.ErrorIndexRecorder
 }
 
 func (g *Replacer) Init() error {
@@ -100,6 +102,7 @@ func (g *Replacer) Transform(datas []Data) ([]Data, error) {
 		datas[transformResult.Index] = transformResult.CurData
 	}
 
+	g.RecordErrorIndex(transformResultSlice)
 	g.stats, fmtErr = transforms.SetStatsInfo(err, g.stats, int64(errNum), int64(dataLen), g.Type())
 	return datas, fmtErr
 }
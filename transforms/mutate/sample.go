@@ -0,0 +1,355 @@
+package mutate
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qiniu/logkit/transforms"
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+const (
+	SampleModeCount  = "count"
+	SampleModeRandom = "random"
+	SampleModeHash   = "hash"
+
+	DefaultSampleMaxKeys = 10000
+
+	sampleStatsKept        = "kept"
+	sampleStatsPriority    = "priority"
+	sampleStatsSampledOut  = "sampled_out"
+	sampleStatsRateLimited = "rate_limited"
+)
+
+var (
+	_ transforms.StatsTransformer = &Sampler{}
+	_ transforms.Transformer      = &Sampler{}
+	_ transforms.Initializer      = &Sampler{}
+)
+
+// Sampler 按 key 对数据进行采样和限速，满足 priority 条件的数据总是保留
+// 采样方式 count 为每个 key 每 every 条保留 1 条，random 为按 ratio 的概率保留，hash 为按 key 的哈希值保留 ratio 比例的 key
+// rate_limit 大于 0 时对每个 key 使用令牌桶限速，对采样后保留的数据生效
+type Sampler struct {
+	// Keys 为逗号分隔的分组字段，为空时所有数据属于同一个 key
+	Keys     string  `json:"keys"`
+	Mode     string  `json:"mode"`
+	Every    int64   `json:"every"`
+	Ratio    float64 `json:"ratio"`
+	Priority string  `json:"priority"`
+	// RateLimit 为每个 key 每秒最多保留的条数，Burst 为令牌桶的容量，默认为 RateLimit 向上取整
+	RateLimit float64 `json:"rate_limit"`
+	Burst     int     `json:"burst"`
+	// MaxKeys 为最多保存状态的 key 数量，超过时淘汰最久没有出现的 key
+	MaxKeys int `json:"max_keys"`
+	stats   StatsInfo
+
+	lock     sync.Mutex
+	keys     [][]string
+	priority *transforms.Condition
+	states   map[string]*list.Element
+	ll       *list.List
+	now      func() time.Time
+	random   func() float64
+}
+
+// sampleState 为一个 key 的采样计数和令牌桶
+type sampleState struct {
+	key    string
+	count  int64
+	tokens float64
+	last   time.Time
+}
+
+func (g *Sampler) Init() error {
+	g.keys = g.keys[:0]
+	for _, key := range strings.Split(g.Keys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			g.keys = append(g.keys, GetKeys(key))
+		}
+	}
+	switch g.Mode {
+	case "":
+	case SampleModeCount:
+		if g.Every < 1 {
+			return fmt.Errorf("sample transformer every %v must be greater than 0", g.Every)
+		}
+	case SampleModeRandom, SampleModeHash:
+		if g.Ratio <= 0 || g.Ratio > 1 {
+			return fmt.Errorf("sample transformer ratio %v must be in (0, 1]", g.Ratio)
+		}
+		if g.Mode == SampleModeHash && len(g.keys) == 0 {
+			return errors.New("sample transformer keys is required in hash mode")
+		}
+	default:
+		return fmt.Errorf("sample transformer mode %v is not supported", g.Mode)
+	}
+	if g.RateLimit < 0 {
+		return fmt.Errorf("sample transformer rate_limit %v must not be negative", g.RateLimit)
+	}
+	if g.Mode == "" && g.RateLimit == 0 {
+		return errors.New("sample transformer requires mode or rate_limit")
+	}
+	if g.Burst <= 0 {
+		g.Burst = int(math.Ceil(g.RateLimit))
+	}
+	if g.MaxKeys <= 0 {
+		g.MaxKeys = DefaultSampleMaxKeys
+	}
+	g.priority = nil
+	if strings.TrimSpace(g.Priority) != "" {
+		cond, err := transforms.NewCondition(g.Priority)
+		if err != nil {
+			return fmt.Errorf("sample transformer parse priority error %v", err)
+		}
+		g.priority = cond
+	}
+	if g.now == nil {
+		g.now = time.Now
+	}
+	if g.random == nil {
+		g.random = rand.New(rand.NewSource(time.Now().UnixNano())).Float64
+	}
+	g.states = make(map[string]*list.Element)
+	g.ll = list.New()
+	return nil
+}
+
+func (g *Sampler) key(data Data) string {
+	if len(g.keys) == 0 {
+		return ""
+	}
+	values := make([]string, len(g.keys))
+	for i, keys := range g.keys {
+		if v, err := GetMapValue(data, keys...); err == nil && v != nil {
+			values[i] = fmt.Sprint(v)
+		}
+	}
+	return strings.Join(values, "\x00")
+}
+
+func (g *Sampler) state(key string, now time.Time) *sampleState {
+	if e, ok := g.states[key]; ok {
+		g.ll.MoveToFront(e)
+		return e.Value.(*sampleState)
+	}
+	s := &sampleState{key: key, tokens: float64(g.Burst), last: now}
+	g.states[key] = g.ll.PushFront(s)
+	for g.ll.Len() > g.MaxKeys {
+		back := g.ll.Back()
+		g.ll.Remove(back)
+		delete(g.states, back.Value.(*sampleState).key)
+	}
+	return s
+}
+
+// sampled 返回数据是否被采样保留
+func (g *Sampler) sampled(s *sampleState) bool {
+	switch g.Mode {
+	case SampleModeCount:
+		s.count++
+		return (s.count-1)%g.Every == 0
+	case SampleModeRandom:
+		return g.random() < g.Ratio
+	case SampleModeHash:
+		h := fnv.New64a()
+		h.Write([]byte(s.key))
+		// fnv 对相近的短字符串分布不均匀，先用 splitmix64 的混合函数打散，再取高 53 位转换为 [0, 1) 的浮点数
+		x := h.Sum64()
+		x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+		x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+		x ^= x >> 31
+		return float64(x>>11)/(1<<53) < g.Ratio
+	}
+	return true
+}
+
+// allow 按令牌桶判断是否保留数据
+func (g *Sampler) allow(s *sampleState, now time.Time) bool {
+	if g.RateLimit <= 0 {
+		return true
+	}
+	if elapsed := now.Sub(s.last).Seconds(); elapsed > 0 {
+		s.tokens = math.Min(float64(g.Burst), s.tokens+elapsed*g.RateLimit)
+	}
+	s.last = now
+	if s.tokens < 1 {
+		return false
+	}
+	s.tokens--
+	return true
+}
+
+func (g *Sampler) Transform(datas []Data) ([]Data, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.states == nil {
+		if err := g.Init(); err != nil {
+			return datas, err
+		}
+	}
+	var (
+		now                                     = g.now()
+		result                                  = make([]Data, 0, len(datas))
+		priority, sampledOut, rateLimited, kept int64
+	)
+	for _, data := range datas {
+		if g.priority != nil && g.priority.Match(data) {
+			priority++
+			result = append(result, data)
+			continue
+		}
+		s := g.state(g.key(data), now)
+		if !g.sampled(s) {
+			sampledOut++
+			continue
+		}
+		if !g.allow(s, now) {
+			rateLimited++
+			continue
+		}
+		result = append(result, data)
+	}
+	kept = int64(len(result))
+
+	details := make(map[string]int64, 4)
+	for k, v := range g.stats.Details {
+		details[k] = v
+	}
+	details[sampleStatsKept] += kept
+	details[sampleStatsPriority] += priority
+	details[sampleStatsSampledOut] += sampledOut
+	details[sampleStatsRateLimited] += rateLimited
+	g.stats.Details = details
+	g.stats.Dropped += sampledOut + rateLimited
+	g.stats, _ = transforms.SetStatsInfo(nil, g.stats, 0, int64(len(datas)), g.Type())
+	return result, nil
+}
+
+func (g *Sampler) RawTransform(datas []string) ([]string, error) {
+	return datas, errors.New("sample transformer not support rawTransform")
+}
+
+func (g *Sampler) Description() string {
+	return "按 key 对数据采样和限速，满足优先条件的数据总是保留"
+}
+
+func (g *Sampler) Type() string {
+	return "sample"
+}
+
+func (g *Sampler) SampleConfig() string {
+	return `{
+       "type":"sample",
+       "keys":"host",
+       "mode":"hash",
+       "ratio":0.1,
+       "rate_limit":100,
+       "priority":"level == \"ERROR\""
+    }`
+}
+
+func (g *Sampler) ConfigOptions() []Option {
+	return []Option{
+		{
+			KeyName:      "keys",
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "分组字段(keys)",
+			ToolTip:      "逗号分隔，每个 key 单独采样和限速，hash 模式下按这些字段的值决定是否保留",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:       "mode",
+			Element:       Radio,
+			ChooseOnly:    true,
+			ChooseOptions: []interface{}{"", SampleModeCount, SampleModeRandom, SampleModeHash},
+			Default:       "",
+			DefaultNoUse:  false,
+			Description:   "采样方式(mode)",
+			ToolTip:       "count 每 every 条保留 1 条，random 按比例随机保留，hash 按 key 的哈希值保留固定比例的 key，不填表示不采样",
+			Type:          transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "every",
+			ChooseOnly:   false,
+			Default:      10,
+			DefaultNoUse: false,
+			Description:  "count 模式的采样间隔(every)",
+			Type:         transforms.TransformTypeLong,
+		},
+		{
+			KeyName:      "ratio",
+			ChooseOnly:   false,
+			Default:      0.1,
+			DefaultNoUse: false,
+			Description:  "random 和 hash 模式的保留比例(ratio)",
+			Type:         transforms.TransformTypeFloat,
+		},
+		{
+			KeyName:      "rate_limit",
+			ChooseOnly:   false,
+			Default:      0,
+			DefaultNoUse: false,
+			Description:  "每个 key 每秒最多保留的条数(rate_limit)",
+			ToolTip:      "0 表示不限速",
+			Type:         transforms.TransformTypeFloat,
+		},
+		{
+			KeyName:      "burst",
+			ChooseOnly:   false,
+			Default:      0,
+			DefaultNoUse: false,
+			Description:  "限速的突发容量(burst)",
+			ToolTip:      "默认为 rate_limit 向上取整",
+			Advance:      true,
+			Type:         transforms.TransformTypeLong,
+		},
+		{
+			KeyName:      "priority",
+			ChooseOnly:   false,
+			Default:      "",
+			DefaultNoUse: false,
+			Description:  "总是保留的条件(priority)",
+			ToolTip:      "如 level == \"ERROR\"，满足条件的数据不参与采样和限速",
+			Type:         transforms.TransformTypeString,
+		},
+		{
+			KeyName:      "max_keys",
+			ChooseOnly:   false,
+			Default:      DefaultSampleMaxKeys,
+			DefaultNoUse: false,
+			Description:  "最多记录的 key 数量(max_keys)",
+			Advance:      true,
+			Type:         transforms.TransformTypeLong,
+		},
+	}
+}
+
+func (g *Sampler) Stage() string {
+	return transforms.StageAfterParser
+}
+
+// Stats 返回的 Details 为保留、优先保留、采样丢弃和限速丢弃的条数
+func (g *Sampler) Stats() StatsInfo {
+	return g.stats
+}
+
+func (g *Sampler) SetStats(err string) StatsInfo {
+	g.stats.LastError = err
+	return g.stats
+}
+
+func init() {
+	transforms.Add("sample", func() transforms.Transformer {
+		return &Sampler{}
+	})
+}
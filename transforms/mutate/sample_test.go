@@ -0,0 +1,104 @@
+package mutate
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/qiniu/logkit/utils/models"
+)
+
+func TestSamplerCount(t *testing.T) {
+	g := &Sampler{Keys: "host", Mode: SampleModeCount, Every: 3, Priority: `level == "ERROR"`}
+	assert.NoError(t, g.Init())
+	var datas []Data
+	for i := 0; i < 6; i++ {
+		datas = append(datas, Data{"host": "a", "seq": i}, Data{"host": "b", "seq": i})
+	}
+	datas = append(datas, Data{"host": "a", "seq": 6, "level": "ERROR"})
+	res, err := g.Transform(datas)
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{
+		{"host": "a", "seq": 0}, {"host": "b", "seq": 0},
+		{"host": "a", "seq": 3}, {"host": "b", "seq": 3},
+		{"host": "a", "seq": 6, "level": "ERROR"},
+	}, res)
+	stats := g.Stats()
+	assert.Equal(t, int64(8), stats.Dropped)
+	assert.Equal(t, map[string]int64{"kept": 5, "priority": 1, "sampled_out": 8, "rate_limited": 0}, stats.Details)
+}
+
+func TestSamplerRandomAndHash(t *testing.T) {
+	values := []float64{0.1, 0.6, 0.4, 0.9}
+	g := &Sampler{Mode: SampleModeRandom, Ratio: 0.5, random: func() float64 {
+		v := values[0]
+		values = values[1:]
+		return v
+	}}
+	assert.NoError(t, g.Init())
+	res, err := g.Transform([]Data{{"a": 1}, {"a": 2}, {"a": 3}, {"a": 4}})
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{{"a": 1}, {"a": 3}}, res)
+
+	// 同一个 trace_id 总是同时保留或同时丢弃
+	g = &Sampler{Keys: "trace_id", Mode: SampleModeHash, Ratio: 0.3}
+	assert.NoError(t, g.Init())
+	var datas []Data
+	for i := 0; i < 1000; i++ {
+		datas = append(datas, Data{"trace_id": fmt.Sprint(i % 500)})
+	}
+	res, err = g.Transform(datas)
+	assert.NoError(t, err)
+	kept := make(map[interface{}]int)
+	for _, data := range res {
+		kept[data["trace_id"]]++
+	}
+	for _, n := range kept {
+		assert.Equal(t, 2, n)
+	}
+	assert.True(t, len(kept) > 100 && len(kept) < 200, fmt.Sprint(len(kept)))
+
+	other := &Sampler{Keys: "trace_id", Mode: SampleModeHash, Ratio: 0.3}
+	assert.NoError(t, other.Init())
+	res2, err := other.Transform(datas[:500])
+	assert.NoError(t, err)
+	assert.Len(t, res2, len(kept))
+
+	for _, s := range []*Sampler{
+		{},
+		{Mode: "x"},
+		{Mode: SampleModeCount},
+		{Mode: SampleModeRandom, Ratio: 2},
+		{Mode: SampleModeHash, Ratio: 0.5},
+		{RateLimit: -1},
+		{RateLimit: 1, Priority: "a =="},
+	} {
+		assert.Error(t, s.Init())
+	}
+}
+
+func TestSamplerRateLimit(t *testing.T) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	g := &Sampler{Keys: "host", RateLimit: 2, Priority: `level == "ERROR"`, MaxKeys: 2, now: func() time.Time { return now }}
+	assert.NoError(t, g.Init())
+	datas := []Data{{"host": "a"}, {"host": "a"}, {"host": "a"}, {"host": "b"}, {"host": "a", "level": "ERROR"}}
+	res, err := g.Transform(datas)
+	assert.NoError(t, err)
+	assert.Equal(t, []Data{{"host": "a"}, {"host": "a"}, {"host": "b"}, {"host": "a", "level": "ERROR"}}, res)
+
+	now = now.Add(500 * time.Millisecond)
+	res, err = g.Transform([]Data{{"host": "a"}, {"host": "a"}})
+	assert.NoError(t, err)
+	assert.Len(t, res, 1)
+	assert.Equal(t, int64(2), g.Stats().Dropped)
+	assert.Equal(t, int64(2), g.Stats().Details["rate_limited"])
+
+	// key c 淘汰了最久没有出现的 key b
+	_, err = g.Transform([]Data{{"host": "c"}})
+	assert.NoError(t, err)
+	assert.Len(t, g.states, 2)
+	_, ok := g.states["b"]
+	assert.False(t, ok)
+}
@@ -36,6 +36,8 @@ type Script struct {
 	news       []string
 	recordErrs []string
 	numRoutine int
+
+	transforms.ErrorIndexRecorder
 }
 
 func (g *Script) Init() error {
@@ -145,6 +147,7 @@ func (g *Script) Transform(datas []Data) ([]Data, error) {
 		datas[transformResult.Index] = transformResult.CurData
 	}
 
+	g.RecordErrorIndex(transformResultSlice)
 	g.stats, fmtErr = transforms.SetStatsInfo(err, g.stats, int64(errNum), int64(dataLen), g.Type())
 	return datas, fmtErr
 }
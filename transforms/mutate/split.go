@@ -24,6 +24,8 @@ type Spliter struct {
 	stats      StatsInfo
 	keys       []string
 	numRoutine int
+
+	transforms.ErrorIndexRecorder
 }
 
 func (g *Spliter) Init() error {
@@ -102,6 +104,7 @@ func (g *Spliter) Transform(datas []Data) ([]Data, error) {
 		datas[transformResult.Index] = transformResult.CurData
 	}
 
+	g.RecordErrorIndex(transformResultSlice)
 	g.stats, fmtErr = transforms.SetStatsInfo(err, g.stats, int64(errNum), int64(dataLen), g.Type())
 	return datas, fmtErr
 }
@@ -56,6 +56,8 @@ type Sub struct {
 	newKeys []string
 
 	numRoutine int
+
+	transforms.ErrorIndexRecorder
 }
 
 func (s *Sub) Init() error {
@@ -231,6 +233,7 @@ func (s *Sub) Transform(datas []Data) ([]Data, error) {
 		datas[transformResult.Index] = transformResult.CurData
 	}
 
+	s.RecordErrorIndex(transformResultSlice)
 	s.stats, fmtErr = transforms.SetStatsInfo(err, s.stats, int64(errNum), int64(dataLen), s.Type())
 	return datas, fmtErr
 }
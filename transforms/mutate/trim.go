@@ -31,6 +31,8 @@ type Trim struct {
 	keys  []string
 
 	numRoutine int
+
+	transforms.ErrorIndexRecorder
 }
 
 func (g *Trim) Init() error {
@@ -95,6 +97,7 @@ func (g *Trim) Transform(datas []Data) ([]Data, error) {
 		datas[transformResult.Index] = transformResult.CurData
 	}
 
+	g.RecordErrorIndex(transformResultSlice)
 	g.stats, fmtErr = transforms.SetStatsInfo(err, g.stats, int64(errNum), int64(dataLen), g.Type())
 	return datas, fmtErr
 }
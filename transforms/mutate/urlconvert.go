@@ -38,6 +38,8 @@ type URLConvert struct {
 	stats      StatsInfo
 	keys       []string
 	numRoutine int
+
+	transforms.ErrorIndexRecorder
 }
 
 func (u *URLConvert) Init() error {
@@ -199,6 +201,7 @@ func (u *URLConvert) Transform(datas []Data) ([]Data, error) {
 		datas[transformResult.Index] = transformResult.CurData
 	}
 
+	u.RecordErrorIndex(transformResultSlice)
 	u.stats, fmtErr = transforms.SetStatsInfo(err, u.stats, int64(errNum), int64(dataLen), u.Type())
 	return datas, fmtErr
 }
@@ -32,6 +32,8 @@ type UrlParam struct {
 	stats         StatsInfo
 
 	numRoutine int
+
+	transforms.ErrorIndexRecorder
 }
 
 func (p *UrlParam) Init() error {
@@ -170,6 +172,7 @@ func (p *UrlParam) Transform(datas []Data) ([]Data, error) {
 		datas[transformResult.Index] = transformResult.CurData
 	}
 
+	p.RecordErrorIndex(transformResultSlice)
 	p.stats, fmtErr = transforms.SetStatsInfo(err, p.stats, int64(errNum), int64(dataLen), p.Type())
 	return datas, fmtErr
 }
@@ -31,6 +31,8 @@ type Xml struct {
 	cacheNews     map[string][]string
 	cacheNewsLock sync.RWMutex
 	numRoutine    int
+
+	transforms.ErrorIndexRecorder
 }
 
 func (g *Xml) Init() error {
@@ -119,6 +121,7 @@ func (g *Xml) Transform(datas []Data) ([]Data, error) {
 		datas[transformResult.Index] = transformResult.CurData
 	}
 
+	g.RecordErrorIndex(transformResultSlice)
 	g.stats, fmtErr = transforms.SetStatsInfo(err, g.stats, int64(errNum), int64(dataLen), g.Type())
 	return datas, fmtErr
 }
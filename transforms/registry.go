@@ -36,6 +36,11 @@ type Transformer interface {
 	Stats() StatsInfo
 }
 
+// ErrorIndexTransformer 能够给出最近一次 Transform 出错数据下标的转换器，返回后清空记录
+type ErrorIndexTransformer interface {
+	TakeErrorIndex() []int
+}
+
 type ServerTansformer interface {
 	ServerConfig() map[string]interface{}
 }
@@ -50,6 +55,23 @@ type Initializer interface {
 	Init() error
 }
 
+// StatefulTransformer 需要在重启后恢复状态的转换器，runner 创建时通过 SetStateDir 指定状态文件保存的目录
+type StatefulTransformer interface {
+	SetStateDir(dir string) error
+}
+
+// FlushableTransformer 会缓存数据并延后输出的转换器，runner 没有读取到数据时以 final 为 false 调用 Flush 输出已经到期的数据，
+// 退出时以 final 为 true 调用 Flush 输出全部缓存的数据，输出的数据继续经过之后的转换器并发送
+type FlushableTransformer interface {
+	Flush(final bool) ([]Data, error)
+}
+
+// CheckpointTransformer 的状态需要与 reader 的读取位置保持一致，runner 在已经转换的数据都发送成功并保存读取位置后调用 SaveState，
+// 避免状态中包含读取位置之后的数据，重启重放时被误处理；force 为 false 时转换器可以按自己的间隔跳过保存
+type CheckpointTransformer interface {
+	SaveState(force bool) error
+}
+
 type Creator func() Transformer
 
 var Transformers = map[string]Creator{}
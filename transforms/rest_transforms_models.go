@@ -32,7 +32,9 @@ func GetTransformerOptions() map[string][]Option {
 	ModeKeyOptions := make(map[string][]Option)
 	for _, v := range Transformers {
 		cr := v()
-		ModeKeyOptions[cr.Type()] = cr.ConfigOptions()
+		// 所有 transformer 都支持配置执行条件
+		options := append([]Option{}, cr.ConfigOptions()...)
+		ModeKeyOptions[cr.Type()] = append(options, KeyCondition)
 	}
 	return ModeKeyOptions
 }
@@ -48,6 +50,28 @@ func SetStatsInfo(err error, stats StatsInfo, errNum, dataLen int64, transformTy
 	return stats, fmtErr
 }
 
+// ErrorIndexRecorder 嵌入到返回结果与输入一一对应的 transformer 中，记录最近一次 Transform 出错数据的下标
+type ErrorIndexRecorder struct {
+	errorIndex []int
+}
+
+// RecordErrorIndex 记录 slice 中出错数据的下标
+func (r *ErrorIndexRecorder) RecordErrorIndex(slice TransformResultSlice) {
+	r.errorIndex = r.errorIndex[:0]
+	for _, transformResult := range slice {
+		if transformResult.Err != nil {
+			r.errorIndex = append(r.errorIndex, transformResult.Index)
+		}
+	}
+}
+
+// TakeErrorIndex 返回并清空记录的下标
+func (r *ErrorIndexRecorder) TakeErrorIndex() []int {
+	errorIndex := r.errorIndex
+	r.errorIndex = nil
+	return errorIndex
+}
+
 func SetError(errNum int, currentErr error, errType int, key string) (int, error) {
 	errNum++
 	switch errType {
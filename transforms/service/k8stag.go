@@ -29,6 +29,8 @@ type K8sTag struct {
 	SourceFileKey string `json:"sourcefilefield"`
 	stats         StatsInfo
 	numRoutine    int
+
+	transforms.ErrorIndexRecorder
 }
 
 func (g *K8sTag) Init() error {
@@ -96,6 +98,7 @@ func (g *K8sTag) Transform(datas []Data) ([]Data, error) {
 		datas[transformResult.Index] = transformResult.CurData
 	}
 
+	g.RecordErrorIndex(transformResultSlice)
 	g.stats, fmtErr = transforms.SetStatsInfo(err, g.stats, int64(errNum), int64(dataLen), g.Type())
 	return datas, fmtErr
 }
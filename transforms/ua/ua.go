@@ -41,6 +41,8 @@ type UATransformer struct {
 	keys     []string
 
 	numRoutine int
+
+	transforms.ErrorIndexRecorder
 }
 
 func (it *UATransformer) Init() (err error) {
@@ -176,6 +178,7 @@ func (it *UATransformer) Transform(datas []Data) ([]Data, error) {
 		datas[transformResult.Index] = transformResult.CurData
 	}
 
+	it.RecordErrorIndex(transformResultSlice)
 	it.stats, fmtErr = transforms.SetStatsInfo(err, it.stats, int64(errNum), int64(len(datas)), it.Type())
 	return datas, fmtErr
 }
@@ -21,6 +21,7 @@ const (
 	ErrRunnerReset    = "L1006"
 	ErrRunnerUpdate   = "L1007"
 	ErrRunnerErrorGet = "L1008"
+	ErrRunnerReplay   = "L1009"
 
 	// read 相关
 	ErrReadRead = "L1101"
@@ -50,6 +51,10 @@ const (
 	ErrClusterRunnerUpdate = "L2011"
 	ErrClusterSlavesDelete = "L2012"
 	ErrClusterSlavesTag    = "L2013"
+
+	// 认证相关
+	ErrUnauthorized = "L3001"
+	ErrForbidden    = "L3002"
 )
 
 var ErrorCodeHumanize = map[string]string{
@@ -62,6 +67,7 @@ var ErrorCodeHumanize = map[string]string{
 	ErrRunnerStop:   "关闭 Runner 出现错误",
 	ErrRunnerReset:  "重置 Runner 出现错误",
 	ErrRunnerUpdate: "更新 Runner 出现错误",
+	ErrRunnerReplay: "重放 Runner 死信数据出现错误",
 
 	ErrParseParse: "解析字符串失败",
 
@@ -83,6 +89,9 @@ var ErrorCodeHumanize = map[string]string{
 	ErrClusterRunnerUpdate: "Slaves 更新 Runner 出现错误",
 	ErrClusterSlavesDelete: "Slaves 从列表中移除时出现错误",
 	ErrClusterSlavesTag:    "Slaves 更改 Tag 出现错误",
+
+	ErrUnauthorized: "认证失败",
+	ErrForbidden:    "没有权限执行该操作",
 }
 
 func IsNotExist(err error) bool {
@@ -142,6 +142,8 @@ type LagInfo struct {
 	SizeUnit string `json:"sizeunit"`
 	Ftlags   int64  `json:"ftlags"`
 	Total    int64  `json:"total"`
+	// Details 为按分区等维度细分的 lag，key 的含义由 reader 决定，例如 kafka 为 topic:partition
+	Details map[string]int64 `json:"details,omitempty"`
 }
 
 type StatsError struct {
@@ -160,6 +162,10 @@ type StatsInfo struct {
 	Trend      string `json:"trend"`
 	LastError  string `json:"last_error"`
 	FtQueueLag int64  `json:"-"`
+	// Dropped 为按规则丢弃的数据条数，如 dedup 丢弃的重复数据
+	Dropped int64 `json:"dropped,omitempty"`
+	// Details 为按类别细分的计数，如 mask 中每种检测器的命中次数
+	Details map[string]int64 `json:"details,omitempty"`
 }
 
 type ErrorStatistic struct {